@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	topUploadersLimit = 10
+	// statsCollectTimeout bounds the queries of a /metrics scrape.
+	statsCollectTimeout = 5 * time.Second
+)
+
+// storageTotals is the storage of all videos, video bytes by the prefix of
+// their key.
+type storageTotals struct {
+	VideoBytes         int64            `json:"video_bytes"`
+	VideoBytesByPrefix map[string]int64 `json:"video_bytes_by_prefix"`
+	ThumbnailBytes     int64            `json:"thumbnail_bytes"`
+}
+
+type queueDepth struct {
+	Queued  int `json:"queued"`
+	Running int `json:"running"`
+}
+
+// statsTotals are the current totals the stats endpoint returns and
+// /metrics exports.
+type statsTotals struct {
+	VideosByStatus map[database.VideoStatus]int `json:"videos_by_status"`
+	Storage        storageTotals                `json:"storage"`
+	Queue          queueDepth                   `json:"queue"`
+	QueueByKind    map[string]queueDepth        `json:"queue_by_kind"`
+}
+
+func getStatsTotals(db database.Client) (statsTotals, error) {
+	totals := statsTotals{
+		VideosByStatus: map[database.VideoStatus]int{},
+		QueueByKind:    map[string]queueDepth{},
+	}
+	counts, err := db.CountVideosByStatus()
+	if err != nil {
+		return totals, err
+	}
+	for _, status := range videoStatuses {
+		totals.VideosByStatus[status] = 0
+	}
+	for status, n := range counts {
+		totals.VideosByStatus[status] = n
+	}
+
+	usage, err := db.GetStorageUsage()
+	if err != nil {
+		return totals, err
+	}
+	totals.Storage = storageTotals{
+		VideoBytesByPrefix: map[string]int64{"landscape": 0, "portrait": 0, "other": 0},
+		ThumbnailBytes:     usage.ThumbnailBytes,
+	}
+	for prefix, n := range usage.VideoBytesByPrefix {
+		totals.Storage.VideoBytesByPrefix[prefix] = n
+		totals.Storage.VideoBytes += n
+	}
+
+	jobCounts, err := db.CountUnfinishedJobs()
+	if err != nil {
+		return totals, err
+	}
+	for _, c := range jobCounts {
+		depth := totals.QueueByKind[c.Kind]
+		if c.Status == database.JobStatusRunning {
+			depth.Running += c.Count
+			totals.Queue.Running += c.Count
+		} else {
+			depth.Queued += c.Count
+			totals.Queue.Queued += c.Count
+		}
+		totals.QueueByKind[c.Kind] = depth
+	}
+	return totals, nil
+}
+
+// jobOutcomes counts the jobs that finished.
+type jobOutcomes struct {
+	Done        int     `json:"done"`
+	Failed      int     `json:"failed"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+func (o *jobOutcomes) add(status database.JobStatus, n int) {
+	if status == database.JobStatusFailed {
+		o.Failed += n
+	} else {
+		o.Done += n
+	}
+	o.FailureRate = float64(o.Failed) / float64(o.Done+o.Failed)
+}
+
+type statsBucket struct {
+	Start   time.Time `json:"start"`
+	Uploads int       `json:"uploads"`
+	// Processing counts the processing jobs that finished.
+	Processing jobOutcomes `json:"processing"`
+}
+
+type topUploader struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	VideoCount int       `json:"video_count"`
+	Bytes      int64     `json:"bytes"`
+}
+
+// handlerAdminStats returns the totals over all videos and the job queue,
+// how the jobs that finished in the timeBuckets of the request went, by
+// kind, the videos created and processing jobs finished in each bucket and
+// the users consuming the most storage.
+func (cfg *apiConfig) handlerAdminStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+		Interval string    `json:"interval"`
+		statsTotals
+		Jobs         map[string]jobOutcomes `json:"jobs"`
+		Buckets      []statsBucket          `json:"buckets"`
+		TopUploaders []topUploader          `json:"top_uploaders"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	buckets, err := parseTimeBuckets(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid time range", err)
+		return
+	}
+	db := cfg.db.WithContext(r.Context())
+
+	totals, err := getStatsTotals(db)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get totals", err)
+		return
+	}
+	resp := response{
+		From:         buckets.From,
+		To:           buckets.To,
+		Interval:     buckets.IntervalName,
+		statsTotals:  totals,
+		Jobs:         map[string]jobOutcomes{},
+		Buckets:      make([]statsBucket, buckets.N),
+		TopUploaders: []topUploader{},
+	}
+
+	finished, err := db.CountFinishedJobs(buckets.From, buckets.To)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count jobs", err)
+		return
+	}
+	for _, c := range finished {
+		outcomes := resp.Jobs[c.Kind]
+		outcomes.add(c.Status, c.Count)
+		resp.Jobs[c.Kind] = outcomes
+	}
+
+	for i := range resp.Buckets {
+		resp.Buckets[i].Start = buckets.start(i)
+	}
+	created, err := db.GetVideoCreationTimes(buckets.From, buckets.To)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	for _, t := range created {
+		if i := buckets.index(t); i >= 0 {
+			resp.Buckets[i].Uploads++
+		}
+	}
+	processed, err := db.GetFinishedJobs(jobKindProcessVideo, buckets.From, buckets.To)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve jobs", err)
+		return
+	}
+	for _, job := range processed {
+		if i := buckets.index(job.FinishedAt); i >= 0 {
+			resp.Buckets[i].Processing.add(job.Status, 1)
+		}
+	}
+
+	uploaders, err := db.GetTopUploaders(topUploadersLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve uploaders", err)
+		return
+	}
+	for _, u := range uploaders {
+		resp.TopUploaders = append(resp.TopUploaders, topUploader(u))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+var (
+	videosDesc = prometheus.NewDesc("tubely_videos",
+		"Videos by status, trashed ones included.", []string{"status"}, nil)
+	storedBytesDesc = prometheus.NewDesc("tubely_stored_bytes",
+		"Bytes of stored videos by key prefix, and of thumbnails with the prefix thumbnails.", []string{"prefix"}, nil)
+	jobQueueDesc = prometheus.NewDesc("tubely_jobs_unfinished",
+		"Queued and running jobs by kind.", []string{"kind", "status"}, nil)
+)
+
+// statsCollector exports the statsTotals on /metrics, queried from the
+// database on each scrape. Every server exports the same totals.
+type statsCollector struct {
+	db database.Client
+}
+
+func (c statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- videosDesc
+	ch <- storedBytesDesc
+	ch <- jobQueueDesc
+}
+
+func (c statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsCollectTimeout)
+	defer cancel()
+	totals, err := getStatsTotals(c.db.WithContext(ctx))
+	if err != nil {
+		slog.Warn("cannot collect stats", "err", err)
+		ch <- prometheus.NewInvalidMetric(videosDesc, err)
+		return
+	}
+	for status, n := range totals.VideosByStatus {
+		ch <- prometheus.MustNewConstMetric(videosDesc, prometheus.GaugeValue, float64(n), string(status))
+	}
+	for prefix, n := range totals.Storage.VideoBytesByPrefix {
+		ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(n), prefix)
+	}
+	ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(totals.Storage.ThumbnailBytes), "thumbnails")
+	for kind, depth := range totals.QueueByKind {
+		ch <- prometheus.MustNewConstMetric(jobQueueDesc, prometheus.GaugeValue, float64(depth.Queued), kind, string(database.JobStatusQueued))
+		ch <- prometheus.MustNewConstMetric(jobQueueDesc, prometheus.GaugeValue, float64(depth.Running), kind, string(database.JobStatusRunning))
+	}
+}
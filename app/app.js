@@ -26,17 +26,16 @@ async function createVideoDraft() {
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch('/api/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
       body: JSON.stringify({ title, description }),
     });
     const data = await res.json();
     if (!res.ok) {
-      throw new Error(`Failed to create video draft: ${data.error}`);
+      throw new Error(`Failed to create video draft: ${data.message}`);
     }
 
     const videoID = data.id;
@@ -63,11 +62,12 @@ async function login() {
     });
     const data = await res.json();
     if (!res.ok) {
-      throw new Error(`Failed to login: ${data.error}`);
+      throw new Error(`Failed to login: ${data.message}`);
     }
 
     if (data.token) {
       localStorage.setItem('token', data.token);
+      localStorage.setItem('refresh_token', data.refresh_token);
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -93,7 +93,7 @@ async function signup() {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to create user: ${data.error}`);
+      throw new Error(`Failed to create user: ${data.message}`);
     }
     console.log('User created!');
     await login();
@@ -103,11 +103,77 @@ async function signup() {
 }
 
 function logout() {
+  const refreshToken = localStorage.getItem('refresh_token');
+  if (refreshToken) {
+    fetch('/api/revoke', {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${refreshToken}`,
+      },
+    }).catch(() => {});
+  }
+  endSession();
+}
+
+function endSession() {
   localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
 
+// authFetch sends a request with the access token, refreshing it once if
+// it has expired.
+async function authFetch(url, options = {}) {
+  const send = () =>
+    fetch(url, {
+      ...options,
+      headers: {
+        ...options.headers,
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+  let res = await send();
+  if (res.status === 401 && (await refreshSession())) {
+    res = await send();
+  }
+  return res;
+}
+
+let refreshing = null;
+
+// refreshSession swaps the refresh token for new tokens. Concurrent callers
+// share one request, a refresh token can only be used once.
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = rotateTokens().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+async function rotateTokens() {
+  const refreshToken = localStorage.getItem('refresh_token');
+  if (!refreshToken) {
+    return false;
+  }
+  const res = await fetch('/api/refresh', {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${refreshToken}`,
+    },
+  });
+  if (!res.ok) {
+    endSession();
+    return false;
+  }
+  const data = await res.json();
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('refresh_token', data.refresh_token);
+  return true;
+}
+
 function setUploadButtonState(uploading, selector) {
   const uploadBtn = document.getElementById(selector);
   if (uploading) {
@@ -130,16 +196,13 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await authFetch(`/api/thumbnail_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to upload thumbnail. Error: ${data.error}`);
+      throw new Error(`Failed to upload thumbnail. Error: ${data.message}`);
     }
 
     await res.json();
@@ -163,16 +226,13 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await authFetch(`/api/video_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to upload video file. Error: ${data.error}`);
+      throw new Error(`Failed to upload video file. Error: ${data.message}`);
     }
 
     console.log('Video uploaded!');
@@ -188,18 +248,25 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
-    if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
-    }
-
-    const videos = await res.json();
+    const videos = [];
+    let cursor = '';
+    do {
+      const query = new URLSearchParams({ limit: '100' });
+      if (cursor) {
+        query.set('cursor', cursor);
+      }
+      const res = await authFetch(`/api/videos?${query}`, {
+        method: 'GET',
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to get videos. Error: ${data.message}`);
+      }
+
+      const page = await res.json();
+      videos.push(...page.videos);
+      cursor = page.next_cursor;
+    } while (cursor);
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -231,11 +298,8 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await authFetch(`/api/videos/${videoID}`, {
       method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to get video.');
@@ -274,8 +338,54 @@ function viewVideo(video) {
       videoPlayer.load();
     }
   }
+  playback = null;
 }
 
+// playback is the viewing of currentVideo being reported, started by the
+// first play.
+let playback = null;
+let lastProgressReport = 0;
+
+// progressReportInterval is how often, in milliseconds, a playing video
+// reports how far it got.
+const progressReportInterval = 15000;
+
+function reportPlayback(event) {
+  const videoPlayer = document.getElementById('video-player');
+  if (!currentVideo || !videoPlayer) {
+    return;
+  }
+  if (!playback) {
+    playback = { videoID: currentVideo.id, id: crypto.randomUUID() };
+  }
+  lastProgressReport = Date.now();
+  authFetch(`/api/videos/${playback.videoID}/playback`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({
+      playback_id: playback.id,
+      event,
+      position: videoPlayer.currentTime,
+    }),
+  }).catch(() => {});
+}
+
+document.getElementById('video-player').addEventListener('play', () => {
+  if (!playback) {
+    reportPlayback('start');
+  }
+});
+document.getElementById('video-player').addEventListener('timeupdate', () => {
+  if (playback && Date.now() - lastProgressReport >= progressReportInterval) {
+    reportPlayback('progress');
+  }
+});
+document.getElementById('video-player').addEventListener('ended', () => {
+  reportPlayback('complete');
+  // playing it again is another view
+  playback = null;
+});
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
@@ -283,11 +393,8 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await authFetch(`/api/videos/${currentVideo.id}`, {
       method: 'DELETE',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to delete video.');
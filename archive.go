@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Owners can archive videos they want to keep but rarely watch: the video,
+// its renditions and HLS segments move to the cold cfg.archiveClass, the
+// thumbnail stays so listings keep showing it. Archived videos aren't
+// presigned until they are restored, which takes hours: restoring requests
+// it and runArchiveRestorer finishes it once S3 is done.
+
+// archiveRestoreBatch is how many restoring videos are checked per query.
+const archiveRestoreBatch = 100
+
+// handlerVideoArchive moves the objects of a video to cold storage.
+// Archiving an archived video again finishes moving objects an earlier
+// attempt failed on.
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	if cfg.archiveStorage == nil {
+		respondWithError(w, http.StatusNotImplemented, "Archiving needs the s3 storage provider", nil)
+		return
+	}
+	if video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+		respondWithError(w, http.StatusConflict, "Video is being uploaded or processed", errVideoBusy)
+		return
+	}
+	if video.VideoObject == nil {
+		respondWithError(w, http.StatusConflict, "Video has nothing stored to archive", errVideoNotUploaded)
+		return
+	}
+	if video.StorageTier == database.StorageTierRestoring {
+		respondWithError(w, http.StatusConflict, "Video is being restored from the archive", nil)
+		return
+	}
+	// a deduplicated video would take the videos it shares objects with
+	// into the archive too
+	refs, err := cfg.db.WithContext(r.Context()).CountVideosWithObject(*video.VideoObject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check shared objects", err)
+		return
+	}
+	if refs > 1 {
+		respondWithError(w, http.StatusConflict, "Video shares its file with another video and can't be archived", nil)
+		return
+	}
+
+	if _, err := cfg.db.WithContext(r.Context()).SetVideoStorageTier(video.ID, database.StorageTierHot, database.StorageTierArchived); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't archive video", err)
+		return
+	}
+	// the video is marked first, so nothing is presigned from objects
+	// that are already cold; moving them finishes even if the client hangs up
+	ctx, cancel := detach(r.Context(), cleanupTimeout)
+	defer cancel()
+	if err := cfg.dropReplica(ctx, video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete the replica", err)
+		return
+	}
+	keys, err := cfg.mediaKeys(ctx, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list video objects", err)
+		return
+	}
+	for _, key := range keys {
+		if err := cfg.archiveStorage.Archive(ctx, key, cfg.archiveClass); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't archive video objects, try again", err)
+			return
+		}
+	}
+	cfg.respondWithStoredVideo(w, r, http.StatusOK, video.ID)
+}
+
+// handlerVideoArchiveRestore starts restoring an archived video. It
+// answers 202 while S3 restores the objects, and 200 once the video can be
+// played again.
+func (cfg *apiConfig) handlerVideoArchiveRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	if video.StorageTier == database.StorageTierHot {
+		respondWithError(w, http.StatusConflict, "Video isn't archived", nil)
+		return
+	}
+	if cfg.archiveStorage == nil {
+		respondWithError(w, http.StatusNotImplemented, "Restoring needs the s3 storage provider", nil)
+		return
+	}
+
+	ctx, cancel := detach(r.Context(), cleanupTimeout)
+	defer cancel()
+	done, err := cfg.restoreVideoObjects(ctx, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video objects", err)
+		return
+	}
+	status, tier := http.StatusAccepted, database.StorageTierRestoring
+	if done {
+		status, tier = http.StatusOK, database.StorageTierHot
+	}
+	if _, err := cfg.db.WithContext(r.Context()).SetVideoStorageTier(video.ID, video.StorageTier, tier); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	if done {
+		cfg.queueReplication(r.Context(), video)
+	}
+	cfg.respondWithStoredVideo(w, r, status, video.ID)
+}
+
+// respondWithStoredVideo answers with the current state of a video, signed
+// for its owner.
+func (cfg *apiConfig) respondWithStoredVideo(w http.ResponseWriter, r *http.Request, status int, id uuid.UUID) {
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(id)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+	respondWithJSON(w, status, video)
+}
+
+// restoreVideoObjects takes the next restore step for every archived
+// object of a video and reports whether all of them can be read again.
+func (cfg *apiConfig) restoreVideoObjects(ctx context.Context, video database.Video) (bool, error) {
+	keys, err := cfg.mediaKeys(ctx, video)
+	if err != nil {
+		return false, err
+	}
+	done := true
+	for _, key := range keys {
+		restored, err := cfg.archiveStorage.Restore(ctx, key, cfg.archiveRestoreTier)
+		if err != nil {
+			return false, err
+		}
+		done = done && restored
+	}
+	return done, nil
+}
+
+// unarchiveReplaced marks a video hot again after its objects were
+// replaced by new, hot ones.
+func (cfg *apiConfig) unarchiveReplaced(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.StorageTier == database.StorageTierHot {
+		return video, nil
+	}
+	ok, err := cfg.db.WithContext(ctx).SetVideoStorageTier(video.ID, video.StorageTier, database.StorageTierHot)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if ok {
+		video.StorageTier = database.StorageTierHot
+		video.Version++
+	}
+	return video, nil
+}
+
+// finishArchiveRestores checks on every video being restored and makes
+// the ones whose objects are readable again hot, returning how many it
+// made hot. A video failing to restore doesn't hold up the others.
+func (cfg *apiConfig) finishArchiveRestores(ctx context.Context) (int, error) {
+	restored := 0
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.WithContext(ctx).GetVideosInStorageTier(database.StorageTierRestoring, after, archiveRestoreBatch)
+		if err != nil {
+			return restored, err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return restored, err
+			}
+			after = video.ID
+			done, err := cfg.restoreVideoObjects(ctx, video)
+			if err != nil {
+				log.Printf("cannot restore video %s: %v", video.ID, err)
+				continue
+			}
+			if !done {
+				continue
+			}
+			ok, err := cfg.db.WithContext(ctx).SetVideoStorageTier(video.ID, database.StorageTierRestoring, database.StorageTierHot)
+			if err != nil {
+				return restored, err
+			}
+			if ok {
+				cfg.queueReplication(ctx, video)
+				restored++
+			}
+		}
+		if len(videos) < archiveRestoreBatch {
+			return restored, nil
+		}
+	}
+}
+
+// runArchiveRestorer runs finishArchiveRestores every interval until ctx
+// is done.
+func (cfg *apiConfig) runArchiveRestorer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		restored, err := cfg.finishArchiveRestores(ctx)
+		if err != nil {
+			log.Printf("restoring archived videos failed after %d videos: %v", restored, err)
+			continue
+		}
+		if restored > 0 {
+			log.Printf("restored %d videos from the archive", restored)
+		}
+	}
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +20,100 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// publicURL is the absolute URL clients reach path of the server at,
+// PUBLIC_BASE_URL followed by path.
+func (cfg *apiConfig) publicURL(path string) string {
+	return cfg.publicBaseURL + path
+}
+
+// assetURL is the URL clients fetch the file at name below the assets
+// directory from, through the CDN with ASSETS_CDN_URL.
+func (cfg *apiConfig) assetURL(name string) string {
+	return cfg.assetsBaseURL + "/" + name
+}
+
+// handlerAssets serves the files of the assets directory: objects of the
+// local storage provider, spilled over uploads, captions and thumbnails
+// from before they were stored. Range and conditional requests are
+// answered by http.ServeContent, directories aren't listed.
+//
+// With ASSETS_PRIVATE_THUMBNAILS, thumbnails are looked up and only served
+// to those who may view their video, who have to send their token like
+// for the API.
+func (cfg *apiConfig) handlerAssets(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/assets/")
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+	f, err := os.Open(filepath.Join(cfg.assetsRoot, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Asset not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	if info.IsDir() {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+
+	cacheControl := "public"
+	if key, ok := thumbnailAssetKey(name); ok && cfg.assetsPrivate {
+		viewerID, err := cfg.optionalViewerID(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		video, err := cfg.db.WithContext(r.Context()).GetVideoByThumbnail(key, name)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID != uuid.Nil && !canViewThumbnail(video, viewerID) {
+			respondWithError(w, http.StatusNotFound, "Asset not found", errVideoPrivate)
+			return
+		}
+		cacheControl = "private"
+		w.Header().Set("Vary", "Authorization, X-API-Key")
+	}
+	if cfg.assetsMaxAge > 0 {
+		cacheControl += fmt.Sprintf(", max-age=%d", int(cfg.assetsMaxAge.Seconds()))
+	} else {
+		cacheControl += ", no-cache"
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// thumbnailAssetKey reports whether the asset at name may be a thumbnail:
+// a stored one, whose storage key it returns, or one from before thumbnails
+// were stored, which sit at the top of the assets directory.
+func thumbnailAssetKey(name string) (string, bool) {
+	for _, dir := range []string{localObjectsDir, spilloverDir} {
+		if rel, ok := strings.CutPrefix(name, dir+"/"); ok {
+			key, _ := storage.UnshardKey(rel)
+			return key, strings.HasPrefix(key, "thumbnails/")
+		}
+	}
+	return "", !strings.Contains(name, "/")
+}
+
+// canViewThumbnail is canViewVideo, also hiding taken down videos from
+// everyone but their owner.
+func canViewThumbnail(video database.Video, viewerID uuid.UUID) bool {
+	if video.ModerationStatus == database.ModerationStatusTakenDown && viewerID != video.UserID {
+		return false
+	}
+	return canViewVideo(video, viewerID)
+}
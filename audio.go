@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// audioFormat is how AUDIO_RENDITION stores the audio of videos.
+type audioFormat struct {
+	Ext       string
+	MediaType string
+	// Codec is what the audio is encoded with, copied when the video's
+	// audio already is in it.
+	Codec     string
+	Encoder   string
+	Container []string
+}
+
+var audioFormats = map[string]audioFormat{
+	"aac": {
+		Ext:       "m4a",
+		MediaType: "audio/mp4",
+		Codec:     "aac",
+		Encoder:   "aac",
+		Container: []string{"-movflags", "faststart", "-f", "mp4"},
+	},
+	"mp3": {
+		Ext:       "mp3",
+		MediaType: "audio/mpeg",
+		Codec:     "mp3",
+		Encoder:   "libmp3lame",
+		Container: []string{"-f", "mp3"},
+	},
+}
+
+const (
+	audioBitRate = "128k"
+	// podcastFeedItems is how many of the newest episodes a feed lists.
+	podcastFeedItems = 100
+)
+
+func audioKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("audio/%s/", videoID)
+}
+
+// extractAudioArgs builds the ffmpeg arguments writing the first audio
+// stream of input to outPath, through audioFilter unless it is empty.
+func extractAudioArgs(input, outPath string, format audioFormat, codec, audioFilter string) []string {
+	args := []string{"-y", "-i", input, "-map", "0:a:0", "-vn"}
+	switch {
+	case audioFilter != "":
+		args = append(args,
+			"-af", audioFilter,
+			"-ar", loudnormSampleRate,
+			"-c:a", format.Encoder, "-b:a", audioBitRate,
+		)
+	case codec == format.Codec:
+		args = append(args, "-c:a", "copy")
+	default:
+		args = append(args, "-c:a", format.Encoder, "-b:a", audioBitRate)
+	}
+	args = append(args, format.Container...)
+	return append(args, outPath)
+}
+
+// extractAudio stores the audio rendition of the video at filePath when
+// AUDIO_RENDITION is on. Videos without sound get none.
+func (cfg *apiConfig) extractAudio(ctx context.Context, videoID uuid.UUID, filePath string) (*database.AudioRendition, error) {
+	format, ok := audioFormats[cfg.audioRendition]
+	if !ok {
+		return nil, nil
+	}
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.hasAudio() {
+		return nil, nil
+	}
+
+	// normalized like the stored video
+	audioFilter := ""
+	if cfg.audioNormalize {
+		audioFilter, err = normalizationFilter(ctx, filePath, cfg.audioTargetLUFS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workDir, err := mkdirTemp(ctx, "audio-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "audio."+format.Ext)
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, ffmpegBin, extractAudioArgs(filePath, outPath, format, info.AudioCodec, audioFilter)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("ffmpeg audio extraction failed: %w\nstderr: %s", err, stderr.String())
+	}
+	stat, err := os.Stat(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg produced no audio: %w", err)
+	}
+	log.Printf("extracted audio of %s in %s", videoID, time.Since(start).Round(time.Millisecond))
+
+	key := audioKeyPrefix(videoID) + "audio." + format.Ext
+	if err := cfg.putObjectFile(ctx, key, outPath, format.MediaType); err != nil {
+		return nil, fmt.Errorf("cannot upload audio rendition: %w", err)
+	}
+	return &database.AudioRendition{Key: key, MediaType: format.MediaType, Size: stat.Size()}, nil
+}
+
+type podcastFeed struct {
+	XMLName xml.Name       `xml:"rss"`
+	Version string         `xml:"version,attr"`
+	ITunes  string         `xml:"xmlns:itunes,attr"`
+	Channel podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	Image       *itunesImage  `xml:"itunes:image,omitempty"`
+	Items       []podcastItem `xml:"item"`
+}
+
+type podcastItem struct {
+	Title       string           `xml:"title"`
+	Description string           `xml:"description"`
+	GUID        podcastGUID      `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Enclosure   podcastEnclosure `xml:"enclosure"`
+	Duration    string           `xml:"itunes:duration,omitempty"`
+	Image       *itunesImage     `xml:"itunes:image,omitempty"`
+}
+
+type podcastGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// respondWithPodcast writes a podcast feed of the videos that can be
+// listened to anonymously, see inPodcast. Media URLs are signed like for any anonymous
+// viewer, so feeds should be refreshed within PUBLIC_URL_EXPIRY.
+func (cfg *apiConfig) respondWithPodcast(w http.ResponseWriter, r *http.Request, title, description string, videos []database.Video) {
+	now := time.Now()
+	feed := podcastFeed{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: podcastChannel{
+			Title:       title,
+			Link:        cfg.publicURL(r.URL.RequestURI()),
+			Description: description,
+			Items:       []podcastItem{},
+		},
+	}
+	for _, video := range videos {
+		if !inPodcast(video, now) {
+			continue
+		}
+		if len(feed.Channel.Items) == podcastFeedItems {
+			break
+		}
+		signed, err := cfg.dbVideoToSignedVideo(r.Context(), video, uuid.Nil)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+			return
+		}
+		published := video.CreatedAt
+		if video.PublishAt != nil {
+			published = *video.PublishAt
+		}
+		item := podcastItem{
+			Title:       video.Title,
+			Description: video.Description,
+			GUID:        podcastGUID{Value: video.ID.String()},
+			PubDate:     published.UTC().Format(time.RFC1123Z),
+			Enclosure: podcastEnclosure{
+				URL:    *signed.Audio.URL,
+				Length: signed.Audio.Size,
+				Type:   signed.Audio.MediaType,
+			},
+		}
+		if video.Metadata != nil && video.Metadata.Duration > 0 {
+			item.Duration = strconv.Itoa(int(video.Metadata.Duration))
+		}
+		if signed.ThumbnailURL != nil {
+			item.Image = &itunesImage{Href: *signed.ThumbnailURL}
+			if feed.Channel.Image == nil {
+				feed.Channel.Image = item.Image
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	dat, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
+
+// inPodcast reports whether a video is an episode of public feeds at now.
+// Unlisted videos are left out, feeds are meant to be found.
+func inPodcast(video database.Video, now time.Time) bool {
+	return video.Audio != nil &&
+		video.Status == database.VideoStatusReady &&
+		video.Visibility == database.VideoVisibilityPublic &&
+		video.ModerationStatus == database.ModerationStatusActive &&
+		video.StorageTier == database.StorageTierHot &&
+		video.DeletedAt == nil &&
+		isLive(video, now)
+}
+
+// handlerUserPodcast serves the public videos of a user with audio as a
+// podcast feed. It needs no authentication.
+func (cfg *apiConfig) handlerUserPodcast(w http.ResponseWriter, r *http.Request) {
+	userID, err := pathUUID(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	// scheduled and expired videos are skipped, ask for enough to fill the
+	// feed anyway
+	videos, err := cfg.db.WithContext(r.Context()).GetPodcastVideos(userID, 2*podcastFeedItems)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	cfg.respondWithPodcast(w, r, "Tubely", "Public videos of user "+userID.String(), videos)
+}
+
+// handlerPlaylistPodcast serves the public videos of a playlist with audio
+// as a podcast feed, in playlist order. It needs no authentication; the ID
+// of the playlist is the secret.
+func (cfg *apiConfig) handlerPlaylistPodcast(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := pathUUID(r, "playlistID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+	playlist, err := cfg.db.WithContext(r.Context()).GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+	videos, err := cfg.db.WithContext(r.Context()).GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlist videos", err)
+		return
+	}
+	cfg.respondWithPodcast(w, r, playlist.Title, playlist.Description, videos)
+}
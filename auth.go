@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// errNotOwner is the cause of 403s for resources of another user.
+var errNotOwner = errors.New("resource belongs to another user")
+
+// apiKeyTouchInterval is how stale the last use of an API key may get.
+const apiKeyTouchInterval = time.Minute
+
+// resolveAPIKey is the auth.APIKeyResolver of the server.
+func (cfg *apiConfig) resolveAPIKey(ctx context.Context, key string) (auth.Principal, error) {
+	apiKey, err := cfg.db.WithContext(ctx).GetAPIKeyByHash(auth.HashAPIKey(key))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	now := time.Now()
+	if apiKey.ID == uuid.Nil || !apiKey.Active(now) {
+		return auth.Principal{}, auth.ErrInvalidAPIKey
+	}
+	if err := cfg.db.WithContext(ctx).TouchAPIKey(apiKey.ID, now.Add(-apiKeyTouchInterval)); err != nil {
+		requestLogger(ctx).Warn("cannot record API key use", "api_key_id", apiKey.ID, "err", err)
+	}
+	p := auth.Principal{UserID: apiKey.UserID, APIKeyID: apiKey.ID}
+	for _, scope := range apiKey.Scopes {
+		p.Scopes = append(p.Scopes, auth.Scope(scope))
+	}
+	return p, nil
+}
+
+// checkSession is the auth.SessionChecker of the server. It reads the
+// session on every request so that revoking it takes effect at once.
+func (cfg *apiConfig) checkSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	session, err := cfg.db.WithContext(ctx).GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if !session.Active(time.Now()) || session.UserID != userID {
+		return auth.ErrSessionRevoked
+	}
+	return nil
+}
+
+// authenticate returns the user a request is made by, from its JWT or an
+// API key granting scope. An empty scope only accepts a JWT. It writes the
+// error response itself.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope auth.Scope) (uuid.UUID, bool) {
+	p, err := auth.Authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+		return uuid.Nil, false
+	}
+	if !p.Allows(scope) {
+		msg := fmt.Sprintf("API key doesn't have the %s scope", scope)
+		if scope == "" {
+			msg = "API keys can't be used for this, log in instead"
+		}
+		respondWithError(w, http.StatusForbidden, msg, nil)
+		return uuid.Nil, false
+	}
+	return p.UserID, true
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Videos have one caption track per language. SRT uploads are converted,
+// every track is stored as WebVTT below captions/{videoID}/ and handed out
+// with the video and as subtitle renditions of its HLS manifest.
+
+const (
+	captionFormField   = "caption"
+	maxCaptionSize     = 1 << 20
+	maxCaptionLabelLen = 64
+)
+
+type captionFormat string
+
+const (
+	captionFormatSRT captionFormat = "srt"
+	captionFormatVTT captionFormat = "vtt"
+)
+
+// languageTagPattern matches BCP 47 tags like en, pt-BR or zh-Hant-TW.
+var languageTagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// normalizeLanguage checks a language tag and writes it the usual way:
+// the language lowercase and a region uppercase.
+func normalizeLanguage(tag string) (string, error) {
+	if !languageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%q isn't a language tag like en or pt-BR", tag)
+	}
+	subtags := strings.Split(tag, "-")
+	for i, subtag := range subtags {
+		if i > 0 && len(subtag) == 2 {
+			subtags[i] = strings.ToUpper(subtag)
+		} else {
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// validateCaptionLabel checks the name players show for a track.
+func validateCaptionLabel(label string) error {
+	if utf8.RuneCountInString(label) > maxCaptionLabelLen {
+		return fmt.Errorf("label is longer than %d characters", maxCaptionLabelLen)
+	}
+	for _, r := range label {
+		if unicode.IsControl(r) || r == '"' {
+			return fmt.Errorf("label may not contain control characters or double quotes")
+		}
+	}
+	return nil
+}
+
+// detectCaptionFormat tells SRT from WebVTT by file name, then by media
+// type and last by the WEBVTT signature.
+func detectCaptionFormat(filename, mediaType string, data []byte) (captionFormat, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".srt":
+		return captionFormatSRT, nil
+	case ".vtt":
+		return captionFormatVTT, nil
+	}
+	if m, _, err := mime.ParseMediaType(mediaType); err == nil {
+		switch m {
+		case "application/x-subrip", "text/srt":
+			return captionFormatSRT, nil
+		case "text/vtt":
+			return captionFormatVTT, nil
+		}
+	}
+	if bytes.HasPrefix(bytes.TrimPrefix(data, []byte("\ufeff")), []byte("WEBVTT")) {
+		return captionFormatVTT, nil
+	}
+	return "", errors.New("captions have to be SRT or WebVTT files")
+}
+
+// cueTimingPattern matches the timing line of a cue. SRT separates the
+// milliseconds with a comma, WebVTT with a dot and may leave out the hours
+// and add cue settings.
+var cueTimingPattern = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[.,]\d{3}) +--> +((?:\d+:)?\d{2}:\d{2}[.,]\d{3})( .*)?$`)
+
+// parseCueTimestamp returns a timestamp in milliseconds.
+func parseCueTimestamp(ts string) (int64, error) {
+	var h, m, s, ms int64
+	ts = strings.Replace(ts, ",", ".", 1)
+	if strings.Count(ts, ":") == 1 {
+		ts = "0:" + ts
+	}
+	if _, err := fmt.Sscanf(ts, "%d:%d:%d.%d", &h, &m, &s, &ms); err != nil {
+		return 0, err
+	}
+	if m > 59 || s > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	return ((h*60+m)*60+s)*1000 + ms, nil
+}
+
+// captionToVTT validates a caption file and returns it as WebVTT. Every
+// cue needs a timing line with its end after its start, and there has to
+// be at least one.
+func captionToVTT(data []byte, format captionFormat) (string, error) {
+	if !utf8.Valid(data) {
+		return "", errors.New("captions have to be UTF-8 text")
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	blocks := strings.Split(strings.TrimSpace(text), "\n\n")
+	var out strings.Builder
+	if format == captionFormatVTT {
+		if header := strings.SplitN(blocks[0], "\n", 2)[0]; header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") {
+			return "", errors.New("WebVTT captions have to start with WEBVTT")
+		}
+		out.WriteString(blocks[0])
+		blocks = blocks[1:]
+	} else {
+		out.WriteString("WEBVTT")
+	}
+
+	cues := 0
+	for _, block := range blocks {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		timing := -1
+		for i, line := range lines[:min(len(lines), 2)] {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing == -1 {
+			if format == captionFormatVTT && isVTTMetadataBlock(lines[0]) {
+				out.WriteString("\n\n" + block)
+				continue
+			}
+			return "", fmt.Errorf("cue %d has no timing line", cues+1)
+		}
+		match := cueTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[timing]))
+		if match == nil {
+			return "", fmt.Errorf("cue %d has an invalid timing line %q", cues+1, lines[timing])
+		}
+		start, err := parseCueTimestamp(match[1])
+		if err != nil {
+			return "", fmt.Errorf("cue %d: %w", cues+1, err)
+		}
+		end, err := parseCueTimestamp(match[2])
+		if err != nil {
+			return "", fmt.Errorf("cue %d: %w", cues+1, err)
+		}
+		if end <= start {
+			return "", fmt.Errorf("cue %d ends before it starts", cues+1)
+		}
+		cues++
+
+		out.WriteString("\n\n")
+		if format == captionFormatVTT {
+			out.WriteString(block)
+			continue
+		}
+		// the SRT index line is dropped, WebVTT cue ids are optional
+		out.WriteString(formatVTTTimestamp(float64(start)/1000) + " --> " + formatVTTTimestamp(float64(end)/1000))
+		for _, line := range lines[timing+1:] {
+			out.WriteString("\n" + line)
+		}
+	}
+	if cues == 0 {
+		return "", errors.New("captions have no cues")
+	}
+	out.WriteString("\n")
+	return out.String(), nil
+}
+
+// isVTTMetadataBlock reports whether a WebVTT block without timing is a
+// note, style or region block.
+func isVTTMetadataBlock(firstLine string) bool {
+	for _, keyword := range []string{"NOTE", "STYLE", "REGION"} {
+		if firstLine == keyword || strings.HasPrefix(firstLine, keyword+" ") || strings.HasPrefix(firstLine, keyword+"\t") {
+			return true
+		}
+	}
+	return false
+}
+
+func captionKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("captions/%s/", videoID)
+}
+
+// newCaptionKey returns a fresh key for a track, so a replaced track never
+// shares its key and URLs with the new one.
+func newCaptionKey(videoID uuid.UUID, language string) string {
+	randKey := make([]byte, 16)
+	rand.Read(randKey)
+	return fmt.Sprintf("%s%s-%s.vtt", captionKeyPrefix(videoID), language, base64.RawURLEncoding.EncodeToString(randKey))
+}
+
+// handlerVideoCaptionPut adds or replaces the caption track of a language
+// from an SRT or WebVTT file in the caption form field. The optional label
+// field names the track in players and defaults to the language.
+func (cfg *apiConfig) handlerVideoCaptionPut(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionSize+64<<10)
+	file, header, err := formFile(r, captionFormField, maxCaptionSize)
+	if isBodyTooLarge(err) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Captions can be at most %d bytes", maxCaptionSize), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxCaptionSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Captions can be at most %d bytes", maxCaptionSize), nil)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = language
+	}
+	if err := validateCaptionLabel(label); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read captions", err)
+		return
+	}
+	format, err := detectCaptionFormat(header.Filename, header.Header.Get("Content-Type"), data)
+	if err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
+	vtt, err := captionToVTT(data, format)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid captions: "+err.Error(), err)
+		return
+	}
+
+	key := newCaptionKey(video.ID, language)
+	if _, err := cfg.storage.Put(r.Context(), key, strings.NewReader(vtt), "text/vtt"); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store captions", err)
+		return
+	}
+	replaced, err := cfg.db.WithContext(r.Context()).PutVideoCaption(video.ID, database.Caption{
+		Language:  language,
+		Label:     label,
+		Source:    database.CaptionSourceUpload,
+		ObjectKey: key,
+	})
+	if err != nil {
+		if err := cfg.storage.Delete(r.Context(), key); err != nil {
+			requestLogger(r.Context()).Warn("cannot delete unused captions", "key", key, "err", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+		return
+	}
+	if replaced != "" {
+		if err := cfg.storage.Delete(r.Context(), replaced); err != nil {
+			requestLogger(r.Context()).Warn("cannot delete replaced captions", "key", replaced, "err", err)
+		}
+	}
+	cfg.respondWithCaptionedVideo(w, r, video)
+}
+
+// handlerVideoCaptionDelete removes the caption track of a language.
+func (cfg *apiConfig) handlerVideoCaptionDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	key, err := cfg.db.WithContext(r.Context()).DeleteVideoCaption(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete captions", err)
+		return
+	}
+	if key == "" {
+		respondWithError(w, http.StatusNotFound, "Captions not found", nil)
+		return
+	}
+	if err := cfg.storage.Delete(r.Context(), key); err != nil {
+		requestLogger(r.Context()).Warn("cannot delete captions", "key", key, "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithCaptionedVideo answers with the video as it is after its
+// captions changed.
+func (cfg *apiConfig) respondWithCaptionedVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoCaptionPlaylist serves the HLS subtitle playlist of a track,
+// the whole WebVTT file as its one segment.
+func (cfg *apiConfig) handlerVideoCaptionPlaylist(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguage(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var caption *database.Caption
+	for i := range video.Captions {
+		if video.Captions[i].Language == language {
+			caption = &video.Captions[i]
+		}
+	}
+	if caption == nil {
+		respondWithError(w, http.StatusNotFound, "Captions not found", nil)
+		return
+	}
+	url, err := cfg.videoObjectURL(r.Context(), video, caption.ObjectKey, cfg.hlsURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign captions", err)
+		return
+	}
+
+	duration := 0.0
+	if video.Metadata != nil {
+		duration = video.Metadata.Duration
+	}
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", max(1, int(math.Ceil(duration))))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", duration, url)
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(playlist.String()))
+}
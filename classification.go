@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With CLASSIFIER set, a job samples frames evenly across every stored
+// video and has them scored for explicit content. The highest score is
+// kept on the video; past the thresholds the video is reported to the
+// moderators, or taken down.
+
+const (
+	jobKindClassifyVideo = "classify_video"
+
+	// reportReasonClassifier is the reason of reports the classifier files.
+	reportReasonClassifier = "classifier"
+)
+
+type classifyVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+}
+
+// contentClassifier scores image files from 0, harmless, to 1, explicit,
+// returning one score per file.
+type contentClassifier interface {
+	Classify(ctx context.Context, framePaths []string) ([]float64, error)
+}
+
+func newContentClassifier(conf config.Config) contentClassifier {
+	switch conf.Classifier {
+	case "http":
+		return httpClassifier{url: conf.ClassifierURL, key: conf.ClassifierAPIKey, client: &http.Client{}}
+	case "command":
+		return commandClassifier{bin: conf.ClassifierCommand}
+	}
+	return nil
+}
+
+// decodeClassifierScores reads the {"scores": [...]} both classifiers
+// answer with.
+func decodeClassifierScores(r io.Reader, frames int) ([]float64, error) {
+	var out struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, fmt.Errorf("cannot decode classifier scores: %w", err)
+	}
+	if len(out.Scores) != frames {
+		return nil, fmt.Errorf("classifier returned %d scores for %d frames", len(out.Scores), frames)
+	}
+	for _, score := range out.Scores {
+		if score < 0 || score > 1 {
+			return nil, fmt.Errorf("classifier score %g is not between 0 and 1", score)
+		}
+	}
+	return out.Scores, nil
+}
+
+// httpClassifier posts the frames as the "frames" files of a multipart
+// form.
+type httpClassifier struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+func (c httpClassifier) Classify(ctx context.Context, framePaths []string) ([]float64, error) {
+	body, w := io.Pipe()
+	form := multipart.NewWriter(w)
+	go func() {
+		for _, framePath := range framePaths {
+			part, err := form.CreateFormFile("frames", filepath.Base(framePath))
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			f, err := os.Open(framePath)
+			if err == nil {
+				_, err = io.Copy(part, f)
+				f.Close()
+			}
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.CloseWithError(form.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("classifier responded with %s: %s", resp.Status, msg)
+	}
+	return decodeClassifierScores(resp.Body, len(framePaths))
+}
+
+// commandClassifier runs a command with the frame paths as arguments, which
+// prints the scores to stdout.
+type commandClassifier struct {
+	bin string
+}
+
+func (c commandClassifier) Classify(ctx context.Context, framePaths []string) ([]float64, error) {
+	cmd := exec.CommandContext(ctx, c.bin, framePaths...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("classifier failed: %w\nstderr: %s", err, stderr.String())
+	}
+	return decodeClassifierScores(&stdout, len(framePaths))
+}
+
+// queueClassification queues classifying a stored video, if a classifier is
+// configured.
+func (cfg *apiConfig) queueClassification(ctx context.Context, video database.Video) {
+	if cfg.classifier == nil {
+		return
+	}
+	if _, err := cfg.jobs.Enqueue(jobKindClassifyVideo, classifyVideoPayload{VideoID: video.ID}); err != nil {
+		requestLogger(ctx).Warn("cannot queue classification", "video_id", video.ID, "err", err)
+	}
+}
+
+// classifyVideoJob scores frames of the stored file of a video and acts on
+// the highest score. Videos archived in the meantime are skipped.
+func (cfg *apiConfig) classifyVideoJob(ctx context.Context, job database.Job) error {
+	ctx, cancel := cfg.withMediaTimeout(ctx)
+	defer cancel()
+
+	var payload classifyVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoObject == nil || video.StorageTier != database.StorageTierHot {
+		return nil
+	}
+
+	ctx, removeWorkspace, err := newWorkspace(ctx, "classify-"+video.ID.String())
+	if err != nil {
+		return err
+	}
+	defer removeWorkspace()
+	localPath, err := cfg.downloadObjectToTemp(ctx, video.VideoObject.Key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localPath)
+	dir, err := workspaceDir(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := probeVideoInfo(ctx, localPath)
+	if err != nil {
+		return err
+	}
+
+	// the middle of each of equal stretches of the video
+	frames := make([]string, cfg.classifierFrames)
+	for i := range frames {
+		timestamp := info.Duration * (float64(i) + 0.5) / float64(len(frames))
+		frames[i] = filepath.Join(dir, fmt.Sprintf("frame-%02d.jpg", i))
+		if err := extractFrame(ctx, localPath, frames[i], &timestamp); err != nil {
+			return err
+		}
+	}
+	scores, err := cfg.classifier.Classify(ctx, frames)
+	if err != nil {
+		return err
+	}
+	score := 0.0
+	for _, s := range scores {
+		score = max(score, s)
+	}
+	if err := cfg.db.WithContext(ctx).SetVideoContentScore(video.ID, score); err != nil {
+		return err
+	}
+	log.Printf("classified video %s with score %.2f", video.ID, score)
+
+	if score < cfg.classifierFlagAt && (cfg.classifierTakedownAt == 0 || score < cfg.classifierTakedownAt) {
+		return nil
+	}
+	_, err = cfg.db.WithContext(ctx).CreateVideoReport(database.CreateVideoReportParams{
+		VideoID: video.ID,
+		Reason:  reportReasonClassifier,
+		Details: fmt.Sprintf("The content classifier scored the video %.2f", score),
+	})
+	if err != nil && !errors.Is(err, database.ErrDuplicateReport) {
+		return err
+	}
+	if cfg.classifierTakedownAt > 0 && score >= cfg.classifierTakedownAt {
+		if err := cfg.db.WithContext(ctx).TakeDownVideo(video.ID, nil); err != nil {
+			return err
+		}
+		log.Printf("took down video %s, classified with score %.2f", video.ID, score)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const jobKindClipVideo = "clip_video"
+
+// minClipLength is the shortest clip in seconds. Stream copy cuts at
+// keyframes, shorter clips could come out empty.
+const minClipLength = 0.5
+
+var errClipSourceGone = errors.New("clip source was deleted")
+
+// clipVideoPayload shares video_id with processVideoPayload, failed clips
+// are handled by failVideoJob.
+type clipVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Trace identifies the request that queued the job.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// handlerVideoClipCreate queues a clip of the part of a ready video between
+// start and end. The clip is a new video of the owner linked to its source;
+// it is uploading until the cut has gone through processing.
+func (cfg *apiConfig) handlerVideoClipCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Title *string `json:"title"`
+	}
+
+	source, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if source.ModerationStatus == database.ModerationStatusTakenDown {
+		respondWithError(w, http.StatusUnavailableForLegalReasons, "Video was taken down by a moderator", errVideoTakenDown)
+		return
+	}
+	if source.Status != database.VideoStatusReady || source.VideoObject == nil || source.Metadata == nil {
+		respondWithError(w, http.StatusConflict, "Video is not ready", errVideoNotUploaded)
+		return
+	}
+	if source.StorageTier != database.StorageTierHot {
+		respondWithError(w, http.StatusConflict, "Video is archived, restore it first", errVideoArchived)
+		return
+	}
+
+	title := strings.TrimSpace(source.Title) + " (clip)"
+	if params.Title != nil {
+		title = *params.Title
+	}
+	if invalid := validateClip(title, params.Start, params.End, source.Metadata.Duration); len(invalid) > 0 {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusBadRequest,
+			Code:    apierror.CodeInvalidRequest,
+			Message: "Some fields are invalid",
+			Details: map[string]map[string]string{"fields": invalid},
+		})
+		return
+	}
+
+	// stream copy keeps the bitrate, the clip takes about its share of
+	// the source
+	estimate := int64(math.Ceil(float64(source.VideoBytes) * (params.End - params.Start) / source.Metadata.Duration))
+	if !cfg.checkQuota(w, r, source.UserID, 0, estimate) {
+		return
+	}
+
+	clip, err := cfg.db.WithContext(r.Context()).CreateClip(database.CreateVideoParams{
+		Title:       strings.TrimSpace(title),
+		Description: source.Description,
+		UserID:      source.UserID,
+	}, database.VideoClip{
+		SourceVideoID: &source.ID,
+		Start:         params.Start,
+		End:           params.End,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create clip", err)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(clip.ID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+	_, err = cfg.jobs.Enqueue(jobKindClipVideo, clipVideoPayload{
+		VideoID: clip.ID,
+		Trace:   traceCarrier(r.Context()),
+	})
+	if err != nil {
+		if err := cfg.db.WithContext(r.Context()).SetVideoStatus(clip.ID, database.VideoStatusFailed); err != nil {
+			requestLogger(r.Context()).Warn("cannot mark clip as failed", "video_id", clip.ID, "err", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue clip", err)
+		return
+	}
+	clip.Status = database.VideoStatusUploading
+	respondWithJSON(w, http.StatusAccepted, clip)
+}
+
+// validateClip checks the title and range of a clip of a video lasting
+// duration seconds, returning what is wrong by field name.
+func validateClip(title string, start, end, duration float64) map[string]string {
+	invalid := map[string]string{}
+	switch t := strings.TrimSpace(title); {
+	case t == "":
+		invalid["title"] = "title must not be empty"
+	case len([]rune(t)) > maxVideoTitleLength:
+		invalid["title"] = fmt.Sprintf("title is longer than %d characters", maxVideoTitleLength)
+	}
+	switch {
+	case start < 0 || math.IsNaN(start):
+		invalid["start"] = "start must not be negative"
+	case start >= duration:
+		invalid["start"] = fmt.Sprintf("start must be before the end of the video at %.3f", duration)
+	}
+	switch {
+	case end > duration || math.IsNaN(end):
+		invalid["end"] = fmt.Sprintf("end must not be after the end of the video at %.3f", duration)
+	case end-start < minClipLength:
+		invalid["end"] = fmt.Sprintf("end must be at least %g seconds after start", minClipLength)
+	}
+	return invalid
+}
+
+// handlerVideoClips lists the clips cut from a video.
+func (cfg *apiConfig) handlerVideoClips(w http.ResponseWriter, r *http.Request) {
+	source, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	clips, err := cfg.db.WithContext(r.Context()).GetVideoClips(source.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clips", err)
+		return
+	}
+	for i := range clips {
+		clips[i], err = cfg.dbVideoToSignedVideo(r.Context(), clips[i], source.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, clips)
+}
+
+// clipVideoJob cuts a clip out of its source with stream copy and hands
+// the cut to the processing job. Clips whose source was deleted or
+// archived in the meantime fail without retrying.
+func (cfg *apiConfig) clipVideoJob(ctx context.Context, job database.Job) (err error) {
+	var payload clipVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "video.clip", linkedSpanOptions(payload.Trace,
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.String("job.id", job.ID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	)...)
+	defer func() { endSpan(span, err) }()
+	clip, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if clip.ID == uuid.Nil || clip.Clip == nil {
+		// the clip was deleted in the meantime, nothing left to do
+		return nil
+	}
+	if clip.Clip.SourceVideoID == nil {
+		return jobs.Permanent(errClipSourceGone)
+	}
+	source, err := cfg.db.WithContext(ctx).GetVideo(*clip.Clip.SourceVideoID)
+	if err != nil {
+		return err
+	}
+	if source.ID == uuid.Nil {
+		return jobs.Permanent(errClipSourceGone)
+	}
+	key, err := videoKey(source)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	ctx, cancel := cfg.withMediaTimeout(ctx)
+	defer cancel()
+	ctx, removeWorkspace, err := newWorkspace(ctx, "clip-"+clip.ID.String())
+	if err != nil {
+		return err
+	}
+	defer removeWorkspace()
+	input, err := cfg.downloadObjectToTemp(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(input)
+
+	output, err := cutClip(ctx, input, clip.Clip.Start, clip.Clip.End)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output)
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	upload, err := cfg.stageVideo(ctx, clip.ID, f, "video/mp4")
+	if err != nil {
+		return err
+	}
+	upload.Watermark = source.Watermark
+	_, err = cfg.enqueueVideoProcessing(ctx, clip, upload)
+	return err
+}
+
+// cutClip writes the part of input between start and end to a new mp4 in
+// the workspace of ctx without re-encoding. The cut starts at the keyframe
+// before start.
+func cutClip(ctx context.Context, input string, start, end float64) (string, error) {
+	out, err := createTemp(ctx, "clip-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+
+	cmd := exec.CommandContext(ctx, ffmpegBin,
+		"-y",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-to", strconv.FormatFloat(end, 'f', 3, 64),
+		"-i", input,
+		"-map", "0:v",
+		"-map", "0:a?",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		"-movflags", "+faststart",
+		out.Name(),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("ffmpeg clip failed: %w\nstderr: %s", err, stderr.String())
+	}
+	stat, err := os.Stat(out.Name())
+	if err != nil || stat.Size() == 0 {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("ffmpeg produced an empty clip\nstderr: %s", stderr.String())
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// dedupScope decides whose videos an upload is compared against when
+// looking for one with the same content.
+type dedupScope string
+
+const (
+	dedupOff dedupScope = "off"
+	// dedupUser only matches the uploader's own videos, so an upload never
+	// reveals what other users have stored.
+	dedupUser   dedupScope = "user"
+	dedupGlobal dedupScope = "global"
+)
+
+// findDuplicateVideo returns a ready video in the configured scope whose
+// upload had the same checksum and that got the watermark video would get.
+func (cfg *apiConfig) findDuplicateVideo(ctx context.Context, video database.Video, checksum string) (database.Video, bool, error) {
+	if cfg.dedupScope == dedupOff || checksum == "" {
+		return database.Video{}, false, nil
+	}
+	var userID *uuid.UUID
+	if cfg.dedupScope == dedupUser {
+		userID = &video.UserID
+	}
+	mark, err := cfg.watermarkFor(ctx, video.UserID)
+	if err != nil {
+		return database.Video{}, false, err
+	}
+	dup, err := cfg.db.WithContext(ctx).FindVideoByChecksum(checksum, mark.ID, userID, video.ID)
+	if err != nil {
+		return database.Video{}, false, err
+	}
+	return dup, dup.ID != uuid.Nil, nil
+}
+
+// shareVideoObjects points video at the stored video, renditions, audio and
+// hover preview of dup instead of processing and storing the same bytes again.
+// deleteVideoObjects keeps shared objects until the last video referencing
+// them is gone.
+func (cfg *apiConfig) shareVideoObjects(ctx context.Context, video, dup database.Video, mediaType string) (database.Video, error) {
+	video, err := cfg.updateVideo(ctx, video.ID, func(video *database.Video) {
+		video.VideoObject = dup.VideoObject
+		video.Renditions = dup.Renditions
+		video.Audio = dup.Audio
+		video.PreviewKey = dup.PreviewKey
+		video.VideoBytes = dup.VideoBytes
+		video.Checksum = dup.Checksum
+		video.AspectRatio = dup.AspectRatio
+		video.Metadata = dup.Metadata
+		video.Watermark = dup.Watermark
+		video.OriginalFormat = &mediaType
+		video.Status = database.VideoStatusReady
+	})
+	if err != nil {
+		return database.Video{}, err
+	}
+	video, err = cfg.unarchiveReplaced(ctx, video)
+	if err != nil {
+		return database.Video{}, err
+	}
+	cfg.queueReplication(ctx, video)
+	cfg.queueTranscription(ctx, video)
+	cfg.queueClassification(ctx, video)
+	requestLogger(ctx).Info("video has the same content as another, sharing its objects", "video_id", video.ID, "duplicate_of", dup.ID)
+	cfg.emitVideoEvent(eventVideoReady, video)
+	return video, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// testMP4 returns bytes that pass for an mp4 upload, ftyp then mdat with
+// the payload.
+func testMP4(payload string) []byte {
+	ftyp := mp4Box("ftyp", 8)
+	copy(ftyp[8:], "isom")
+	mdat := mp4Box("mdat", len(payload))
+	copy(mdat[8:], payload)
+	return append(ftyp, mdat...)
+}
+
+// uploadTestVideo posts data as the video of videoID and returns the
+// response status.
+func uploadTestVideo(t *testing.T, cfg *apiConfig, userID, videoID uuid.UUID, data []byte) int {
+	t.Helper()
+	r := multipartUpload(t, "/api/video_upload/"+videoID.String(), cfg.videoFormField, "video/mp4", data)
+	w := serveAs(t, cfg, userID, "POST /api/video_upload/{videoID}", withWorkspace(cfg.handlerUploadVideo), r)
+	if w.Code != http.StatusOK && w.Code != http.StatusAccepted {
+		t.Fatalf("upload status = %d: %s", w.Code, w.Body)
+	}
+	return w.Code
+}
+
+// finishTestProcessing does what the queued processing job of videoID
+// would: it stores the staged upload as the video, with the checksum the
+// upload computed.
+func finishTestProcessing(t *testing.T, cfg *apiConfig, videoID uuid.UUID) database.Video {
+	t.Helper()
+	jobs, err := cfg.db.GetUnfinishedJobs(jobKindProcessVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		var payload processVideoPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.VideoID != videoID {
+			continue
+		}
+		if payload.Checksum == "" {
+			t.Fatal("upload queued without a checksum")
+		}
+		body, err := cfg.storage.Get(context.Background(), payload.StagingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		var data bytes.Buffer
+		data.ReadFrom(body)
+		loc := putTestObject(t, cfg, "landscape/"+videoID.String()+".mp4", data.String(), "video/mp4")
+		video, err := cfg.updateVideo(context.Background(), videoID, func(v *database.Video) {
+			v.VideoObject = loc
+			v.VideoBytes = int64(data.Len())
+			v.Checksum = &payload.Checksum
+			v.Status = database.VideoStatusReady
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video
+	}
+	t.Fatalf("no processing job queued for %s", videoID)
+	return database.Video{}
+}
+
+func TestDedupScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scope  dedupScope
+		hide   func(cfg *apiConfig, first database.Video) error
+		shared bool
+	}{
+		{name: "user", scope: dedupUser},
+		{name: "global", scope: dedupGlobal, shared: true},
+		{
+			name:  "global, first trashed",
+			scope: dedupGlobal,
+			hide: func(cfg *apiConfig, first database.Video) error {
+				_, err := cfg.db.TrashVideo(first.ID)
+				return err
+			},
+		},
+		{
+			name:  "global, first taken down",
+			scope: dedupGlobal,
+			hide: func(cfg *apiConfig, first database.Video) error {
+				return cfg.db.TakeDownVideo(first.ID, nil)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.dedupScope = tt.scope
+			ctx := context.Background()
+			data := testMP4("the same bytes from two users")
+
+			alice := createTestUser(t, cfg, "a@example.com")
+			first := createTestVideo(t, cfg, alice, "first")
+			if status := uploadTestVideo(t, cfg, alice, first.ID, data); status != http.StatusAccepted {
+				t.Fatalf("first upload status = %d, want 202", status)
+			}
+			first = finishTestProcessing(t, cfg, first.ID)
+			if tt.hide != nil {
+				if err := tt.hide(cfg, first); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			bob := createTestUser(t, cfg, "b@example.com")
+			second := createTestVideo(t, cfg, bob, "second")
+			status := uploadTestVideo(t, cfg, bob, second.ID, data)
+			if !tt.shared {
+				// processed on its own
+				if status != http.StatusAccepted {
+					t.Fatalf("second upload status = %d, want 202", status)
+				}
+				second = finishTestProcessing(t, cfg, second.ID)
+				if *second.VideoObject == *first.VideoObject {
+					t.Fatal("second upload shares the first object")
+				}
+				if err := cfg.deleteVideo(ctx, first); err != nil {
+					t.Fatal(err)
+				}
+				if objectExists(t, cfg, first.VideoObject.Key) {
+					t.Fatal("object of the deleted video wasn't deleted")
+				}
+				if !objectExists(t, cfg, second.VideoObject.Key) {
+					t.Fatal("object of the other video was deleted")
+				}
+				return
+			}
+
+			if status != http.StatusOK {
+				t.Fatalf("second upload status = %d, want 200", status)
+			}
+			second, err := cfg.db.GetVideo(second.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if second.VideoObject == nil || *second.VideoObject != *first.VideoObject {
+				t.Fatalf("second video object = %v, want %v", second.VideoObject, first.VideoObject)
+			}
+			if second.Status != database.VideoStatusReady {
+				t.Errorf("second video status = %s, want ready", second.Status)
+			}
+
+			key := first.VideoObject.Key
+			if err := cfg.deleteVideo(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			if !objectExists(t, cfg, key) {
+				t.Fatal("shared object was deleted with the first video")
+			}
+			if err := cfg.deleteVideo(ctx, second); err != nil {
+				t.Fatal(err)
+			}
+			if objectExists(t, cfg, key) {
+				t.Fatal("shared object wasn't deleted with the last video")
+			}
+		})
+	}
+}
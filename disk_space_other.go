@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// freeDiskSpace isn't implemented here, free space checks are skipped.
+func freeDiskSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
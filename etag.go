@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// computeETag hashes the stored representation of a payload sent to
+// viewerID at now. Callers pass the database rows before presigning, since
+// presigned URLs differ on every request even when nothing changed. The
+// viewer is part of the hash because owners see more than others, and so
+// is the presignWindow now falls into: a client told its copy is current
+// keeps the URLs it was sent, which must not have expired yet.
+func (cfg *apiConfig) computeETag(payload interface{}, viewerID uuid.UUID, now time.Time) (string, error) {
+	dat, err := json.Marshal(struct {
+		Payload interface{} `json:"payload"`
+		Viewer  uuid.UUID   `json:"viewer"`
+		Window  int64       `json:"window"`
+	}{payload, viewerID, now.UnixNano() / int64(cfg.presignWindow())})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(dat)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// presignWindow is how long an ETag stays the same, a tenth of the
+// shortest presign expiry. The presign cache hands out URLs until 80% of
+// their lifetime passed, so a client revalidating within the window still
+// has a tenth of it left.
+func (cfg *apiConfig) presignWindow() time.Duration {
+	shortest := cfg.videoURLExpiry
+	for _, expiry := range []time.Duration{cfg.hlsURLExpiry, cfg.previewURLExpiry, cfg.publicURLExpiry} {
+		if expiry > 0 && (shortest <= 0 || expiry < shortest) {
+			shortest = expiry
+		}
+	}
+	return max(shortest/10, time.Second)
+}
+
+// storedVideo is the representation of a video ETags are computed from. It
+// adds the object locations the API doesn't expose, so replacing a stored
+// object changes the ETag.
+type storedVideo struct {
+	database.Video
+	VideoObject     *database.ObjectLocation
+	ThumbnailObject *database.ObjectLocation
+}
+
+func newStoredVideo(video database.Video) storedVideo {
+	return storedVideo{video, video.VideoObject, video.ThumbnailObject}
+}
+
+func storedVideos(videos []database.Video) []storedVideo {
+	stored := make([]storedVideo, len(videos))
+	for i, video := range videos {
+		stored[i] = newStoredVideo(video)
+	}
+	return stored
+}
+
+// etagMatches is the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatchesStrong is the strong comparison If-Match calls for, weak
+// ETags never match.
+func etagMatchesStrong(ifMatch string, etags ...string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || slices.Contains(etags, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// setETag sets the ETag of a response for one viewer, which shared caches
+// must not keep.
+func setETag(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Add("Vary", "Authorization, X-API-Key")
+}
+
+// respondNotModified sets the ETag header and, if the client already holds
+// that version, writes a 304 and reports true.
+func respondNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	setETag(w, etag)
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
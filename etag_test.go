@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideoGetNotModified(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID := createTestUser(t, cfg, "a@example.com")
+	video := createTestVideo(t, cfg, userID, "cached")
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/videos/"+video.ID.String(), nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serveAs(t, cfg, userID, "GET /api/videos/{videoID}", cfg.handlerVideoGet, r)
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if got := w.Header().Get("Cache-Control"); got != "private" {
+		t.Errorf("Cache-Control = %q, want private", got)
+	}
+
+	w = get(etag)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("304 has a body: %s", w.Body)
+	}
+	if w = get(`"other"`); w.Code != http.StatusOK {
+		t.Errorf("status for another ETag = %d, want 200", w.Code)
+	}
+}
+
+func TestComputeETag(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      apiConfig
+		shortest time.Duration
+	}{
+		{name: "video only", cfg: apiConfig{videoURLExpiry: 15 * time.Minute}, shortest: 15 * time.Minute},
+		{
+			name:     "shorter hls",
+			cfg:      apiConfig{videoURLExpiry: 15 * time.Minute, hlsURLExpiry: 5 * time.Minute},
+			shortest: 5 * time.Minute,
+		},
+		{
+			name:     "shorter public",
+			cfg:      apiConfig{videoURLExpiry: 15 * time.Minute, publicURLExpiry: time.Minute},
+			shortest: time.Minute,
+		},
+	}
+	video := newStoredVideo(database.Video{ID: uuid.New()})
+	owner, other := uuid.New(), uuid.New()
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			etag := func(viewer uuid.UUID, at time.Time) string {
+				t.Helper()
+				etag, err := tt.cfg.computeETag(video, viewer, at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return etag
+			}
+
+			if etag(owner, now) == etag(other, now) {
+				t.Error("owner and other viewers share an ETag")
+			}
+			window := tt.cfg.presignWindow()
+			if window > tt.shortest/10 {
+				t.Errorf("window = %s, longer than a tenth of %s", window, tt.shortest)
+			}
+			if etag(owner, now) == etag(owner, now.Add(window)) {
+				t.Error("ETag outlives its presign window")
+			}
+		})
+	}
+}
+
+func TestETagMatchesStrong(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{`"a"`, true},
+		{`"b", "a"`, true},
+		{`*`, true},
+		{`W/"a"`, false},
+		{`"b"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatchesStrong(tt.ifMatch, `"a"`); got != tt.want {
+			t.Errorf("etagMatchesStrong(%s) = %v, want %v", tt.ifMatch, got, tt.want)
+		}
+	}
+	if !etagMatches(`W/"a"`, `"a"`) {
+		t.Error(`If-None-Match W/"a" doesn't match "a"`)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// localObjectsDir is where the local storage provider keeps its objects
+// inside the assets directory.
+const localObjectsDir = "objects"
+
+// orphanReport lists everything the collector found without an owning
+// record.
+type orphanReport struct {
+	Objects   []string `json:"objects"`
+	Assets    []string `json:"assets"`
+	TempFiles []string `json:"temp_files"`
+	Deleted   bool     `json:"deleted"`
+}
+
+// objectOwnerID returns the video a key belongs to for the per video
+// prefixes, which aren't referenced key by key from the database.
+func objectOwnerID(key string) (uuid.UUID, string, bool) {
+	prefix, rest, ok := strings.Cut(key, "/")
+	if !ok {
+		return uuid.Nil, "", false
+	}
+	var idPart string
+	switch prefix {
+	case "hls", "renditions", "staging", "audio", "hover":
+		idPart, _, _ = strings.Cut(rest, "/")
+	case "previews":
+		// previews/{id}-{seconds}s.mp4
+		idPart = rest[:min(len(rest), 36)]
+	default:
+		return uuid.Nil, "", false
+	}
+	id, err := uuid.Parse(idPart)
+	if err != nil {
+		return uuid.Nil, "", false
+	}
+	return id, prefix, true
+}
+
+// assetName returns the path below the assets directory a URL is served
+// from, if it is one of ours.
+func assetName(assetURL string) (string, bool) {
+	u, err := url.Parse(assetURL)
+	if err != nil {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(u.Path, "/assets/")
+	if !ok || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", false
+	}
+	return name, true
+}
+
+// collectOrphans cross references the video storage, the assets directory
+// and the temp directory with the database. Anything unreferenced and
+// older than cfg.orphanMinAge is reported, and removed when remove is set.
+// That covers objects of deleted videos, staging uploads that never got
+// processed, abandoned tus and chunked uploads and the workspaces ffmpeg
+// runs leave behind when the server dies mid-job.
+func (cfg *apiConfig) collectOrphans(ctx context.Context, remove bool) (orphanReport, error) {
+	report := orphanReport{
+		Objects:   []string{},
+		Assets:    []string{},
+		TempFiles: []string{},
+		Deleted:   remove,
+	}
+	db := cfg.db.WithContext(ctx)
+
+	videos, err := db.GetAllVideos()
+	if err != nil {
+		return report, err
+	}
+	live := map[uuid.UUID]database.Video{}
+	keys := map[string]bool{}
+	assets := map[string]bool{}
+	for _, video := range videos {
+		live[video.ID] = video
+		if video.VideoObject != nil {
+			keys[video.VideoObject.Key] = true
+		}
+		for _, rendition := range video.Renditions {
+			keys[rendition.Key] = true
+		}
+		if video.Audio != nil {
+			keys[video.Audio.Key] = true
+		}
+		if video.PreviewKey != nil {
+			keys[*video.PreviewKey] = true
+		}
+		if video.ThumbnailObject != nil {
+			keys[video.ThumbnailObject.Key] = true
+		} else if video.ThumbnailURL != nil {
+			if name, ok := assetName(*video.ThumbnailURL); ok {
+				assets[name] = true
+			}
+		}
+	}
+	captionKeys, err := db.GetCaptionObjectKeys()
+	if err != nil {
+		return report, err
+	}
+	for _, key := range captionKeys {
+		keys[key] = true
+	}
+	watermarkKeys, err := db.GetWatermarkObjectKeys()
+	if err != nil {
+		return report, err
+	}
+	for _, key := range watermarkKeys {
+		keys[key] = true
+	}
+	quarantineKeys, err := db.GetQuarantineObjectKeys()
+	if err != nil {
+		return report, err
+	}
+	for _, key := range quarantineKeys {
+		keys[key] = true
+	}
+	// exports are kept until they expire
+	exports, err := db.GetLiveUserExports(time.Now())
+	if err != nil {
+		return report, err
+	}
+	for _, export := range exports {
+		keys[*export.ObjectKey] = true
+	}
+	cutoff := time.Now().Add(-cfg.orphanMinAge)
+
+	objects, err := cfg.storage.List(ctx, "")
+	if err != nil {
+		return report, err
+	}
+	for _, object := range objects {
+		if keys[object.Key] || object.LastModified.After(cutoff) {
+			continue
+		}
+		if id, prefix, ok := objectOwnerID(object.Key); ok {
+			if video, ok := live[id]; ok {
+				// staged uploads only matter until processing is done
+				if prefix != "staging" || video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+					continue
+				}
+			}
+		}
+		report.Objects = append(report.Objects, object.Key)
+		if remove {
+			if err := cfg.storage.Delete(ctx, object.Key); err != nil {
+				log.Printf("cannot delete orphaned object %s: %v", object.Key, err)
+			}
+		}
+	}
+
+	err = filepath.WalkDir(cfg.assetsRoot, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(cfg.assetsRoot, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if name == localObjectsDir || name == spilloverDir {
+				// listed through the storage above
+				return filepath.SkipDir
+			}
+			if strings.HasPrefix(name, "vtt/") {
+				key, _ := storage.UnshardKey(name)
+				id, err := uuid.Parse(strings.TrimPrefix(key, "vtt/"))
+				if err != nil && strings.Count(name, "/") < 3 {
+					// a shard directory
+					return nil
+				}
+				if _, isLive := live[id]; err == nil && isLive {
+					return filepath.SkipDir
+				}
+				if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+					report.Assets = append(report.Assets, name)
+					if remove {
+						if err := os.RemoveAll(filePath); err != nil {
+							log.Printf("cannot delete orphaned asset %s: %v", name, err)
+						}
+					}
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if assets[name] || path.Dir(name) != "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return err
+		}
+		report.Assets = append(report.Assets, name)
+		if remove {
+			if err := os.Remove(filePath); err != nil {
+				log.Printf("cannot delete orphaned asset %s: %v", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	uploadPaths, err := db.GetUploadPaths()
+	if err != nil {
+		return report, err
+	}
+	activeUploads := map[string]bool{}
+	for _, uploadPath := range uploadPaths {
+		activeUploads[uploadPath] = true
+	}
+	collectTemp := func(dir string, match func(name string) bool) error {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fullPath := filepath.Join(dir, entry.Name())
+			if !match(entry.Name()) || activeUploads[fullPath] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			report.TempFiles = append(report.TempFiles, fullPath)
+			if remove {
+				if err := os.RemoveAll(fullPath); err != nil {
+					log.Printf("cannot delete orphaned temp file %s: %v", fullPath, err)
+				}
+			}
+		}
+		return nil
+	}
+	err = collectTemp(filepath.Join(scratchRoot, workspacesDir), func(name string) bool {
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+	err = collectTemp(filepath.Join(scratchRoot, tusUploadsDir), func(name string) bool {
+		return true
+	})
+	return report, err
+}
+
+// runOrphanCollector runs collectOrphans every interval until ctx is done.
+func (cfg *apiConfig) runOrphanCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := cfg.collectOrphans(ctx, cfg.orphanGCDelete)
+		if err != nil {
+			log.Printf("orphan collection failed: %v", err)
+			continue
+		}
+		action := "found"
+		if report.Deleted {
+			action = "deleted"
+		}
+		log.Printf("orphan collection %s %d objects, %d assets, %d temp files",
+			action, len(report.Objects), len(report.Assets), len(report.TempFiles))
+	}
+}
+
+// handlerAdminOrphans reports orphans without deleting anything.
+func (cfg *apiConfig) handlerAdminOrphans(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	report, err := cfg.collectOrphans(r.Context(), false)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't collect orphans", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
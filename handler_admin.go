@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var errNotAdmin = errors.New("admin access required")
+
+var roles = map[string]database.Role{
+	string(database.RoleUser):  database.RoleUser,
+	string(database.RoleAdmin): database.RoleAdmin,
+}
+
+// requireAdmin checks the request is made by a user with the admin role,
+// logged in with a JWT, or carries the configured admin API key as
+// "Authorization: ApiKey <key>". It writes the error response itself.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		if cfg.adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminAPIKey)) != 1 {
+			respondWithError(w, http.StatusForbidden, "Admin access required", errNotAdmin)
+			return false
+		}
+		return true
+	}
+
+	p, err := auth.Authenticate(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+		return false
+	}
+	// API keys are scoped to the user's own videos
+	if !p.Allows("") {
+		respondWithError(w, http.StatusForbidden, "Admin access required", errNotAdmin)
+		return false
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return false
+	}
+	if user == nil || user.Role != database.RoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Admin access required", errNotAdmin)
+		return false
+	}
+	return true
+}
+
+// encodeRecentCursor keeps the full precision of created_at, Postgres
+// stores microseconds and a truncated cursor would skip the videos created
+// in the same second.
+func encodeRecentCursor(video database.Video) string {
+	raw := fmt.Sprintf("%s,%s", video.CreatedAt.UTC().Format(time.RFC3339Nano), video.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeRecentCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	parts := strings.Split(string(raw), ",")
+	if len(parts) != 2 {
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor format")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	return createdAt, id, nil
+}
+
+func (cfg *apiConfig) handlerAdminRecentVideos(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+			return
+		}
+		limit = n
+	}
+
+	var beforeCreatedAt time.Time
+	beforeID := uuid.Nil
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		beforeCreatedAt, beforeID, err = decodeRecentCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).GetRecentVideos(beforeCreatedAt, beforeID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	resp := response{}
+	if len(videos) == limit {
+		resp.NextCursor = encodeRecentCursor(videos[len(videos)-1])
+	}
+	resp.Videos, err = cfg.signVideos(r.Context(), videos, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	type user struct {
+		ID    uuid.UUID     `json:"id"`
+		Email string        `json:"email"`
+		Role  database.Role `json:"role"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	users, err := cfg.db.WithContext(r.Context()).GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	resp := make([]user, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, user{ID: u.ID, Email: u.Email, Role: u.Role})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminUserRole makes a user an admin or takes the role away.
+// Admins can't demote themselves, so there is always one left.
+func (cfg *apiConfig) handlerAdminUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, err := pathUUID(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	role, ok := roles[params.Role]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "role must be user or admin", nil)
+		return
+	}
+	if p, err := auth.Authenticate(r); err == nil && p.UserID == userID && role != database.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "You can't take the admin role from yourself", nil)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).SetUserRole(user.ID, role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin changed user role", "target_user_id", user.ID, "role", role)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUserVideos lists the videos of any user, with the query
+// parameters of GET /api/videos.
+func (cfg *apiConfig) handlerAdminUserVideos(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, err := pathUUID(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	cfg.respondWithVideoPage(w, r, userID)
+}
+
+// adminVideoFromPath loads the video in the path for an admin, whoever
+// owns it. It writes the error response itself.
+func (cfg *apiConfig) adminVideoFromPath(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	if !cfg.requireAdmin(w, r) {
+		return database.Video{}, false
+	}
+	videoID, err := pathUUID(r, "videoID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.adminVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.deleteVideo(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin deleted video", "video_id", video.ID, "owner_id", video.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminVideoReprocess runs a video through processing again, e.g.
+// after a failure or a change to the transcode settings.
+func (cfg *apiConfig) handlerAdminVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.adminVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	video, err := cfg.reprocessVideo(r.Context(), video)
+	if errors.Is(err, errVideoBusy) {
+		respondWithError(w, http.StatusConflict, "Video is being uploaded or processed", err)
+		return
+	}
+	if errors.Is(err, errNothingStaged) {
+		respondWithError(w, http.StatusConflict, "Video has nothing to process", err)
+		return
+	}
+	if errors.Is(err, errVideoArchived) {
+		respondWithError(w, http.StatusConflict, "Video is archived, restore it first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin queued video for processing", "video_id", video.ID, "owner_id", video.UserID)
+	respondWithJSON(w, http.StatusAccepted, video)
+}
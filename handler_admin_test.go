@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestAdminRecentVideos(t *testing.T) {
+	cfg := newTestConfig(t)
+	admin := createTestUser(t, cfg, "admin@example.com")
+	if err := cfg.db.SetUserRole(admin, database.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	users := []uuid.UUID{
+		createTestUser(t, cfg, "a@example.com"),
+		createTestUser(t, cfg, "b@example.com"),
+		createTestUser(t, cfg, "c@example.com"),
+	}
+
+	// two videos share a second across the page boundary, so the ID
+	// breaks the tie
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	type seeded struct {
+		id    uuid.UUID
+		owner uuid.UUID
+		at    time.Time
+	}
+	var want []seeded
+	for i := 0; i < 7; i++ {
+		owner := users[i%len(users)]
+		video := createTestVideo(t, cfg, owner, "video")
+		at := base.Add(time.Duration(min(i, 3)+max(i-4, 0)) * time.Minute)
+		setTestCreatedAt(t, cfg, video.ID, at)
+		want = append(want, seeded{video.ID, owner, at})
+	}
+	trashed := createTestVideo(t, cfg, users[0], "trashed")
+	if _, err := cfg.db.TrashVideo(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if !want[i].at.Equal(want[j].at) {
+			return want[i].at.After(want[j].at)
+		}
+		return want[i].id.String() > want[j].id.String()
+	})
+	if !want[2].at.Equal(want[3].at) {
+		t.Fatal("tie doesn't straddle the first page boundary")
+	}
+
+	get := func(userID uuid.UUID, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/admin/recent?"+query, nil)
+		return serveAs(t, cfg, userID, "GET /api/admin/recent", cfg.handlerAdminRecentVideos, r)
+	}
+	if w := get(users[0], ""); w.Code != http.StatusForbidden {
+		t.Fatalf("status for a user = %d, want 403", w.Code)
+	}
+
+	var got []database.Video
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("pagination doesn't end")
+		}
+		w := get(admin, "limit=3&cursor="+cursor)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Videos     []database.Video `json:"videos"`
+			NextCursor string           `json:"next_cursor"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp.Videos...)
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("%d videos, want %d", len(got), len(want))
+	}
+	for i, video := range got {
+		if video.ID != want[i].id {
+			t.Errorf("video %d = %s, want %s", i, video.ID, want[i].id)
+		}
+		if video.UserID != want[i].owner {
+			t.Errorf("video %d owned by %s, want %s", i, video.UserID, want[i].owner)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeysPerUser  = 25
+	maxAPIKeyNameLen   = 100
+	maxAPIKeyExpiresIn = 365 * 24 * time.Hour
+)
+
+// handlerAPIKeyCreate mints an API key for the user with the given scopes,
+// all of them when left out. Keys can only be managed with a JWT, and the
+// key itself is only returned here.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name             string   `json:"name"`
+		Scopes           []string `json:"scopes"`
+		ExpiresInSeconds *int     `json:"expires_in_seconds"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyNameLen), nil)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range auth.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	if len(params.Scopes) > 0 {
+		requested := []string{}
+		for _, scope := range params.Scopes {
+			if !slices.Contains(scopes, scope) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope), nil)
+				return
+			}
+			if !slices.Contains(requested, scope) {
+				requested = append(requested, scope)
+			}
+		}
+		scopes = requested
+	}
+	var expiresAt *time.Time
+	if params.ExpiresInSeconds != nil {
+		expiresIn := time.Duration(*params.ExpiresInSeconds) * time.Second
+		if expiresIn <= 0 || expiresIn > maxAPIKeyExpiresIn {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(maxAPIKeyExpiresIn.Seconds())), nil)
+			return
+		}
+		t := time.Now().Add(expiresIn)
+		expiresAt = &t
+	}
+
+	existing, err := cfg.db.WithContext(r.Context()).GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	active := 0
+	for _, k := range existing {
+		if k.Active(time.Now()) {
+			active++
+		}
+	}
+	if active >= maxAPIKeysPerUser {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("a user can have at most %d active API keys", maxAPIKeysPerUser), nil)
+		return
+	}
+
+	key, prefix, hash, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate API key", err)
+		return
+	}
+	apiKey, err := cfg.db.WithContext(r.Context()).CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+// handlerAPIKeysList returns the user's API keys, revoked and expired ones
+// included, without the keys themselves.
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.WithContext(r.Context()).GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := pathUUID(r, "keyID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	apiKey, err := cfg.db.WithContext(r.Context()).GetAPIKey(keyID)
+	if err != nil || apiKey.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get API key", err)
+		return
+	}
+	if apiKey.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this API key", errNotOwner)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).RevokeAPIKey(apiKey.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
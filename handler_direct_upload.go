@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const directUploadExpiry = 15 * time.Minute
+
+func stagingKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("staging/%s/", videoID)
+}
+
+// ownedVideoFromPath loads the video in the path and checks the requesting
+// user owns it, API keys must grant scope. It writes the error response
+// itself.
+func (cfg *apiConfig) ownedVideoFromPath(w http.ResponseWriter, r *http.Request, scope auth.Scope) (database.Video, bool) {
+	return cfg.ownedVideoFromPathWith(w, r, scope, cfg.db.WithContext(r.Context()).GetVideo)
+}
+
+// ownedVideoFromPathWith is ownedVideoFromPath loading the video with get,
+// which returns a zero video when there is none.
+func (cfg *apiConfig) ownedVideoFromPathWith(w http.ResponseWriter, r *http.Request, scope auth.Scope, get func(uuid.UUID) (database.Video, error)) (database.Video, bool) {
+	videoID, err := pathUUID(r, "videoID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return database.Video{}, false
+	}
+
+	video, err := get(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", errNotOwner)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string            `json:"url"`
+		Fields    map[string]string `json:"fields"`
+		Key       string            `json:"key"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+	if cfg.s3Client == nil {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads need an S3 compatible storage provider", nil)
+		return
+	}
+
+	limits, err := cfg.uploadLimitsFor(r.Context(), video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
+		return
+	}
+	remaining, used, err := cfg.remainingQuota(r.Context(), video.UserID, video.VideoBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+	if remaining < 1 {
+		cfg.respondQuotaExceeded(w, used, 1)
+		return
+	}
+
+	const mediaType = "video/mp4"
+	prefix := stagingKeyPrefix(video.ID)
+	key := prefix + uuid.New().String() + ".mp4"
+
+	encryptionFields := cfg.s3Encryption.PostFields()
+	presignClient := s3.NewPresignClient(cfg.s3Client)
+	presigned, err := presignClient.PresignPostObject(r.Context(), &s3.PutObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}, func(opts *s3.PresignPostOptions) {
+		opts.Expires = directUploadExpiry
+		opts.Conditions = []interface{}{
+			[]interface{}{"starts-with", "$key", prefix},
+			[]interface{}{"eq", "$Content-Type", mediaType},
+			[]interface{}{"content-length-range", 1, min(limits.Video, remaining)},
+		}
+		for k, v := range encryptionFields {
+			opts.Conditions = append(opts.Conditions, []interface{}{"eq", "$" + k, v})
+		}
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(video.ID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	fields := map[string]string{}
+	for k, v := range presigned.Values {
+		fields[k] = v
+	}
+	fields["Content-Type"] = mediaType
+	for k, v := range encryptionFields {
+		fields[k] = v
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       presigned.URL,
+		Fields:    fields,
+		Key:       key,
+		ExpiresAt: time.Now().UTC().Add(directUploadExpiry),
+	})
+}
+
+// verifyStoredVideo sniffs the first bytes of an object the client uploaded
+// on its own.
+func (cfg *apiConfig) verifyStoredVideo(ctx context.Context, key, mediaType string) error {
+	body, err := cfg.storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	return verifyVideoContent(head[:n], mediaType)
+}
+
+func (cfg *apiConfig) handlerVideoFinalize(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !strings.HasPrefix(params.Key, stagingKeyPrefix(video.ID)) {
+		respondWithError(w, http.StatusBadRequest, "Key doesn't belong to this video", nil)
+		return
+	}
+
+	head, err := cfg.storage.Head(r.Context(), params.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Uploaded object not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded object", err)
+		return
+	}
+	mediaType := head.ContentType
+	if err := mimeCheckVideo(mediaType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", err)
+		return
+	}
+	if !cfg.checkQuota(w, r, video.UserID, video.VideoBytes, head.Size) {
+		return
+	}
+	if err := cfg.verifyStoredVideo(r.Context(), params.Key, mediaType); err != nil {
+		if errors.Is(err, errContentMismatch) {
+			respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded object", err)
+		return
+	}
+
+	video, err = cfg.enqueueVideoProcessing(r.Context(), video, videoUpload{
+		Key:       params.Key,
+		MediaType: mediaType,
+		Staged:    true,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot queue video processing", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+	recordUpload("direct", head.Size)
+	respondWithJSON(w, http.StatusAccepted, video)
+}
@@ -3,19 +3,15 @@ package main
 import (
 	"fmt"
 	"net/http"
-
-	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	tn, ok := videoThumbnails[videoID]
+	tn, ok := videoThumbnails[video.ID]
 	if !ok {
 		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
 		return
@@ -24,7 +20,7 @@ func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Content-Type", tn.mediaType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(tn.data)))
 
-	_, err = w.Write(tn.data)
+	_, err := w.Write(tn.data)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error writing response", err)
 		return
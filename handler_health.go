@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+type dependencyCheck func(ctx context.Context) error
+
+type dependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyStatus `json:"checks"`
+}
+
+// runHealthChecks runs the checks in parallel, each bounded by
+// healthCheckTimeout.
+func runHealthChecks(ctx context.Context, checks map[string]dependencyCheck) (healthResponse, bool) {
+	resp := healthResponse{
+		Status: "ok",
+		Checks: make(map[string]dependencyStatus, len(checks)),
+	}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			status := dependencyStatus{Status: "ok"}
+			if err := check(ctx); err != nil {
+				status = dependencyStatus{Status: "fail", Error: err.Error()}
+			}
+			mu.Lock()
+			resp.Checks[name] = status
+			if status.Status != "ok" {
+				resp.Status = "fail"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return resp, resp.Status == "ok"
+}
+
+// checkWritableDir creates and removes a file in dir.
+func checkWritableDir(dir string) dependencyCheck {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".tubely-health-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+func checkBinary(name string) dependencyCheck {
+	return func(ctx context.Context) error {
+		_, err := exec.LookPath(name)
+		return err
+	}
+}
+
+// localChecks cover what this instance needs on its own host.
+func (cfg *apiConfig) localChecks() map[string]dependencyCheck {
+	return map[string]dependencyCheck{
+		"assets_root":  checkWritableDir(cfg.assetsRoot),
+		"scratch_root": checkWritableDir(scratchRoot),
+		"ffmpeg":       checkBinary(ffmpegBin),
+		"ffprobe":      checkBinary(ffprobeBin),
+	}
+}
+
+func respondHealth(w http.ResponseWriter, resp healthResponse, ok bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, code, resp)
+}
+
+// handlerHealthz is the liveness check. It only looks at the local host, so
+// an outage of the database or the bucket doesn't get instances restarted.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	resp, ok := runHealthChecks(r.Context(), cfg.localChecks())
+	respondHealth(w, resp, ok)
+}
+
+// handlerReadyz is the readiness check, which also needs the database and
+// the video storage to answer before traffic is sent here.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	if cfg.uploads.isDraining() {
+		respondHealth(w, healthResponse{Status: "draining"}, false)
+		return
+	}
+	checks := cfg.localChecks()
+	checks["database"] = cfg.db.WithContext(r.Context()).Ping
+	checks["storage"] = cfg.storage.Check
+	resp, ok := runHealthChecks(r.Context(), checks)
+	respondHealth(w, resp, ok)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPlaylistTitleLength       = 200
+	maxPlaylistDescriptionLength = 5000
+)
+
+// validatePlaylistFields checks the user supplied fields of a playlist.
+func validatePlaylistFields(p *database.Playlist) error {
+	p.Title = strings.TrimSpace(p.Title)
+	if p.Title == "" {
+		return errors.New("title must not be empty")
+	}
+	if len([]rune(p.Title)) > maxPlaylistTitleLength {
+		return fmt.Errorf("title is longer than %d characters", maxPlaylistTitleLength)
+	}
+	if len([]rune(p.Description)) > maxPlaylistDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", maxPlaylistDescriptionLength)
+	}
+	return nil
+}
+
+// ownedPlaylistFromPath loads the playlist in the path and checks the
+// requesting user owns it, API keys must grant scope. It writes the error
+// response itself.
+func (cfg *apiConfig) ownedPlaylistFromPath(w http.ResponseWriter, r *http.Request, scope auth.Scope) (database.Playlist, bool) {
+	playlistID, err := pathUUID(r, "playlistID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.WithContext(r.Context()).GetPlaylist(playlistID)
+	if err != nil || playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this playlist", errNotOwner)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	playlist := database.Playlist{}
+	playlist.Title = params.Title
+	playlist.Description = params.Description
+	if err := validatePlaylistFields(&playlist); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	playlist.UserID = userID
+
+	playlist, err := cfg.db.WithContext(r.Context()).CreatePlaylist(playlist.CreatePlaylistParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	playlists, err := cfg.db.WithContext(r.Context()).GetPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+// handlerPlaylistGet returns a playlist with its videos in order, their URLs
+// presigned.
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
+
+// respondWithPlaylist writes the current state of a playlist and its
+// videos.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, r *http.Request, playlistID uuid.UUID) {
+	type response struct {
+		database.Playlist
+		Videos []database.Video `json:"videos"`
+	}
+
+	playlist, err := cfg.db.WithContext(r.Context()).GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	videos, err := cfg.db.WithContext(r.Context()).GetPlaylistVideos(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlist videos", err)
+		return
+	}
+	videos, err = cfg.signVideos(r.Context(), videos, playlist.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the videos", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Playlist: playlist, Videos: videos})
+}
+
+// handlerPlaylistUpdate changes the title and description of a playlist,
+// whichever are present in the request body.
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil {
+		playlist.Title = *params.Title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+	if err := validatePlaylistFields(&playlist); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).UpdatePlaylist(playlist); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	playlist, err := cfg.db.WithContext(r.Context()).GetPlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).DeletePlaylist(playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideoAdd puts one of the user's videos into a playlist, at
+// the end unless a position counted from 0 is given. Adding a video that is
+// already in the playlist moves it.
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Position != nil && *params.Position < 0 {
+		respondWithError(w, http.StatusBadRequest, "position must not be negative", nil)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// other users' videos are reported as missing, like unknown ones
+	if video.ID == uuid.Nil || video.UserID != playlist.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).AddPlaylistVideo(playlist.ID, video.ID, params.Position); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	videoID, err := pathUUID(r, "videoID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	removed, err := cfg.db.WithContext(r.Context()).RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video is not in the playlist", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistReorder sets the order of a playlist's videos. The body
+// lists the ids of all of them, each once.
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.ownedPlaylistFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).ReorderPlaylist(playlist.ID, params.VideoIDs)
+	if errors.Is(err, database.ErrPlaylistOrder) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// handlerRefresh exchanges a refresh token for an access token and a new
+// refresh token. Each refresh token works once; using one again revokes
+// its session, since either it or its successor was stolen.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,27 +25,45 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	session, err := cfg.db.WithContext(r.Context()).RotateRefreshToken(refreshToken, newRefreshToken, time.Now().UTC().Add(cfg.refreshTokenTTL))
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		requestLogger(r.Context()).Warn("refresh token reused, session revoked")
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used, log in again", err)
+		return
+	}
+	if errors.Is(err, database.ErrRefreshTokenInvalid) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid, expired or revoked refresh token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't refresh session", err)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
-		user.ID,
+		session.UserID,
+		session.ID,
 		cfg.jwtSecret,
-		time.Hour,
+		cfg.accessTokenTTL,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// handlerRevoke logs out the session of a refresh token, its access tokens
+// included.
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -46,11 +71,73 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	rt, err := cfg.db.WithContext(r.Context()).GetRefreshToken(refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	// tokens from before sessions were all revoked when sessions came in
+	if rt.SessionID != nil {
+		if err := cfg.db.WithContext(r.Context()).RevokeSession(*rt.SessionID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsList returns the user's active sessions, the one the
+// request is made with marked as current.
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		database.Session
+		Current bool `json:"current"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	p, _ := auth.Authenticate(r)
+
+	sessions, err := cfg.db.WithContext(r.Context()).GetActiveSessions(userID, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+	resp := make([]session, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, session{Session: s, Current: s.ID == p.SessionID})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerSessionRevoke logs out one of the user's sessions, e.g. on a lost
+// device. Its refresh and access tokens stop working at once.
+func (cfg *apiConfig) handlerSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := pathUUID(r, "sessionID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
 		return
 	}
 
+	session, err := cfg.db.WithContext(r.Context()).GetSession(sessionID)
+	if err != nil || session.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get session", err)
+		return
+	}
+	if session.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this session", errNotOwner)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).RevokeSession(session.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
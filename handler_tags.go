@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	maxTagLength    = 32
+	maxTagsPerVideo = 20
+)
+
+// normalizeTag lowercases a tag and joins its words with dashes. Tags are
+// made of letters, digits, dashes and underscores.
+func normalizeTag(tag string) (string, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if normalized == "" {
+		return "", fmt.Errorf("tags must not be empty")
+	}
+	if len([]rune(normalized)) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+	}
+	for _, r := range normalized {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "", fmt.Errorf("tag %q may only contain letters, digits, dashes and underscores", tag)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeTags normalizes a list of tags, dropping repeats, and sorts it.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		n, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n] {
+			seen[n] = true
+			normalized = append(normalized, n)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// parseTagsQuery reads the comma separated tags filter of list and search
+// requests.
+func parseTagsQuery(r *http.Request) ([]string, error) {
+	v := r.URL.Query().Get("tags")
+	if v == "" {
+		return nil, nil
+	}
+	return normalizeTags(strings.Split(v, ","))
+}
+
+// handlerTagsList returns the tags on the user's videos with how many
+// videos carry each.
+func (cfg *apiConfig) handlerTagsList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	tags, err := cfg.db.WithContext(r.Context()).GetUserTags(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads following the tus 1.0.0 core protocol with the creation
+// extension, see https://tus.io/protocols/resumable-upload.
+
+const (
+	tusVersion    = "1.0.0"
+	tusUploadsDir = "tubely-uploads"
+)
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q: %w", parts[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// authorizedUpload loads the tus or chunked upload in the path and checks
+// it belongs to the requesting user. It writes the error response itself.
+func (cfg *apiConfig) authorizedUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := pathUUID(r, "uploadID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.Upload{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.WithContext(r.Context()).GetUpload(uploadID)
+	if err != nil || upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't access this upload", errNotOwner)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+// createUploadFile creates the file an upload is assembled in, where the
+// orphan collector knows to look for abandoned ones.
+func createUploadFile() (*os.File, error) {
+	uploadsDir := filepath.Join(scratchRoot, tusUploadsDir)
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(uploadsDir, "upload-*")
+}
+
+// rejectChunkedUpload answers tus requests for chunked uploads, which only
+// take parts.
+func rejectChunkedUpload(w http.ResponseWriter, upload database.Upload) bool {
+	if upload.Chunked() {
+		respondWithError(w, http.StatusConflict, "Upload is a chunked upload, send its parts instead", nil)
+		return true
+	}
+	return false
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation")
+	// users on a plan may have another limit, creating an upload says
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.maxVideoSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	limits, err := cfg.uploadLimitsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
+		return
+	}
+	if length > limits.Video {
+		respondUploadTooLarge(w, uploadKindVideo, limits.Video, length)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", invalidInput("header.Upload-Metadata", err.Error(), err))
+		return
+	}
+	videoID, err := uuid.Parse(metadata["video_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video_id in Upload-Metadata", invalidInput("header.Upload-Metadata", "video_id must be a UUID", err))
+		return
+	}
+	mediaType := metadata["filetype"]
+	if err := mimeCheckVideo(mediaType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", unsupportedInput("header.Upload-Metadata", mediaType, err))
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't upload to this video", errNotOwner)
+		return
+	}
+	if !cfg.checkQuota(w, r, userID, video.VideoBytes, length) {
+		return
+	}
+	if !cfg.checkScratchSpace(w, length) {
+		return
+	}
+
+	f, err := createUploadFile()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	f.Close()
+
+	upload, err := cfg.db.WithContext(r.Context()).CreateUpload(database.CreateUploadParams{
+		VideoID:   videoID,
+		UserID:    userID,
+		MediaType: mediaType,
+		Length:    length,
+		Path:      f.Name(),
+	})
+	if err != nil {
+		os.Remove(f.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(videoID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+upload.ID.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	upload, ok := cfg.authorizedUpload(w, r)
+	if !ok || rejectChunkedUpload(w, upload) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	upload, ok := cfg.authorizedUpload(w, r)
+	if !ok || rejectChunkedUpload(w, upload) {
+		return
+	}
+	r, done := cfg.trackUpload(r, upload.ID)
+	defer done()
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match", nil)
+		return
+	}
+
+	f, err := os.OpenFile(upload.Path, os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek upload file", err)
+		return
+	}
+	// keep whatever arrived before the connection dropped, that is the
+	// point of resuming
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	f.Close()
+
+	newOffset := offset + written
+	if err := cfg.db.WithContext(r.Context()).UpdateUploadOffset(upload.ID, newOffset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write upload", copyErr)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+
+	if newOffset == upload.Length {
+		_, err := cfg.finishUpload(r.Context(), upload)
+		if errors.Is(err, errContentMismatch) {
+			respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't finalize upload", err)
+			return
+		}
+		recordUpload("tus", upload.Length)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUpload stages the assembled file of a tus or chunked upload and
+// queues it for processing like any other upload. The video is ready
+// already when it is a duplicate.
+func (cfg *apiConfig) finishUpload(ctx context.Context, upload database.Upload) (database.Video, error) {
+	video, err := cfg.db.WithContext(ctx).GetVideo(upload.VideoID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, errors.New("video no longer exists")
+	}
+
+	f, err := os.Open(upload.Path)
+	if err != nil {
+		return database.Video{}, err
+	}
+	head, err := readHeadAt(f)
+	if err != nil {
+		f.Close()
+		return database.Video{}, err
+	}
+	if err := verifyVideoContent(head, upload.MediaType); err != nil {
+		// the whole upload is there and it is no video, don't keep it
+		f.Close()
+		os.Remove(upload.Path)
+		if dbErr := cfg.db.WithContext(ctx).DeleteUpload(upload.ID); dbErr != nil {
+			requestLogger(ctx).Warn("cannot delete upload", "upload_id", upload.ID, "err", dbErr)
+		}
+		return database.Video{}, err
+	}
+	// the upload is hashed while it is staged
+	staged, err := cfg.stageVideo(ctx, video.ID, f, upload.MediaType)
+	f.Close()
+	if err != nil {
+		return database.Video{}, err
+	}
+	staged.UploadID = upload.ID
+	dup, ok, err := cfg.findDuplicateVideo(ctx, video, staged.Checksum)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if ok {
+		cfg.deleteStagingObject(ctx, staged.Key)
+		cfg.emitVideoEvent(eventVideoUploaded, video)
+		video, err = cfg.shareVideoObjects(ctx, video, dup, upload.MediaType)
+		if err != nil {
+			return database.Video{}, err
+		}
+		os.Remove(upload.Path)
+		return video, cfg.db.WithContext(ctx).DeleteUpload(upload.ID)
+	}
+	video, err = cfg.enqueueVideoProcessing(ctx, video, staged)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	os.Remove(upload.Path)
+	return video, cfg.db.WithContext(ctx).DeleteUpload(upload.ID)
+}
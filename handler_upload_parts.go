@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Chunked uploads send a video in fixed-size parts, in any order and in
+// parallel, each with its SHA-256. The parts are assembled on local disk like
+// tus uploads and the complete call finalizes the upload.
+
+const (
+	defaultUploadPartSize = 8 << 20
+	minUploadPartSize     = 1 << 20
+	maxUploadPartSize     = 64 << 20
+	maxUploadParts        = 10000
+	// partChecksumHeader carries the hex SHA-256 of a part.
+	partChecksumHeader = "X-Part-SHA256"
+	// maxMissingPartsListed bounds the part numbers listed when completing
+	// too early.
+	maxMissingPartsListed = 100
+)
+
+// handlerUploadCreate starts a tus upload for tus clients, which always
+// send Tus-Resumable, and a chunked upload otherwise.
+func (cfg *apiConfig) handlerUploadCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != "" {
+		cfg.handlerTusCreate(w, r)
+		return
+	}
+	cfg.handlerChunkedUploadCreate(w, r)
+}
+
+func (cfg *apiConfig) handlerChunkedUploadCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		MediaType string    `json:"media_type"`
+		Length    int64     `json:"length"`
+		PartSize  int64     `json:"part_size"`
+	}
+	type response struct {
+		database.Upload
+		PartCount int `json:"part_count"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Length < 1 {
+		respondWithError(w, http.StatusBadRequest, "length must be at least 1", nil)
+		return
+	}
+	limits, err := cfg.uploadLimitsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
+		return
+	}
+	if params.Length > limits.Video {
+		respondUploadTooLarge(w, uploadKindVideo, limits.Video, params.Length)
+		return
+	}
+	if params.PartSize == 0 {
+		params.PartSize = defaultUploadPartSize
+	}
+	if params.PartSize < minUploadPartSize || params.PartSize > maxUploadPartSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("part_size must be between %d and %d", minUploadPartSize, maxUploadPartSize), nil)
+		return
+	}
+	if (params.Length+params.PartSize-1)/params.PartSize > maxUploadParts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Upload would take more than %d parts, use a bigger part_size", maxUploadParts), nil)
+		return
+	}
+	if err := mimeCheckVideo(params.MediaType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", unsupportedInput("body.media_type", params.MediaType, err))
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(params.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't upload to this video", errNotOwner)
+		return
+	}
+	if !cfg.checkQuota(w, r, userID, video.VideoBytes, params.Length) {
+		return
+	}
+	if !cfg.checkScratchSpace(w, params.Length) {
+		return
+	}
+
+	f, err := createUploadFile()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	// parts are written at their offsets as they arrive
+	err = f.Truncate(params.Length)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+
+	upload, err := cfg.db.WithContext(r.Context()).CreateUpload(database.CreateUploadParams{
+		VideoID:   video.ID,
+		UserID:    userID,
+		MediaType: params.MediaType,
+		Length:    params.Length,
+		PartSize:  params.PartSize,
+		Path:      f.Name(),
+	})
+	if err != nil {
+		os.Remove(f.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(video.ID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+upload.ID.String())
+	respondWithJSON(w, http.StatusCreated, response{
+		Upload:    upload,
+		PartCount: upload.PartCount(),
+	})
+}
+
+// chunkedUploadFromPath is authorizedUpload for chunked uploads only.
+func (cfg *apiConfig) chunkedUploadFromPath(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	upload, ok := cfg.authorizedUpload(w, r)
+	if !ok {
+		return database.Upload{}, false
+	}
+	if !upload.Chunked() {
+		respondWithError(w, http.StatusConflict, "Upload is a tus upload, it has no parts", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+// handlerUploadPartPut writes a part of a chunked upload. Every part but
+// the last must be part_size bytes; sending a part again replaces it.
+func (cfg *apiConfig) handlerUploadPartPut(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.chunkedUploadFromPath(w, r)
+	if !ok {
+		return
+	}
+	r, done := cfg.trackUpload(r, upload.ID)
+	defer done()
+
+	number, err := strconv.Atoi(r.PathValue("partNumber"))
+	if err != nil || number < 1 || number > upload.PartCount() {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part number must be between 1 and %d", upload.PartCount()), err)
+		return
+	}
+	checksum := strings.ToLower(r.Header.Get(partChecksumHeader))
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+		respondWithError(w, http.StatusBadRequest, partChecksumHeader+" must be the hex SHA-256 of the part", err)
+		return
+	}
+	offset := int64(number-1) * upload.PartSize
+	size := min(upload.PartSize, upload.Length-offset)
+	if r.ContentLength >= 0 && r.ContentLength != size {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part %d must be %d bytes", number, size), nil)
+		return
+	}
+
+	f, err := os.OpenFile(upload.Path, os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(f, offset), hash), io.LimitReader(r.Body, size))
+	f.Close()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read part", err)
+		return
+	}
+	if extra, _ := r.Body.Read(make([]byte, 1)); written != size || extra > 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part %d must be %d bytes", number, size), nil)
+		return
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != checksum {
+		respondWithError(w, http.StatusBadRequest, "Part doesn't match its "+partChecksumHeader, nil)
+		return
+	}
+
+	part, err := cfg.db.WithContext(r.Context()).PutUploadPart(upload.ID, database.UploadPart{Number: number, Size: size, SHA256: checksum})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save part", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, part)
+}
+
+// handlerUploadComplete finalizes a chunked upload once all its parts are
+// in, the same way a form upload is: 202 while the video is processed, 200
+// when it was a duplicate of a ready one.
+func (cfg *apiConfig) handlerUploadComplete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.chunkedUploadFromPath(w, r)
+	if !ok {
+		return
+	}
+	r, done := cfg.trackUpload(r, upload.ID)
+	defer done()
+
+	parts, err := cfg.db.WithContext(r.Context()).GetUploadParts(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get parts", err)
+		return
+	}
+	received := make(map[int]bool, len(parts))
+	for _, part := range parts {
+		received[part.Number] = true
+	}
+	missing := []int{}
+	for number := 1; number <= upload.PartCount() && len(missing) < maxMissingPartsListed; number++ {
+		if !received[number] {
+			missing = append(missing, number)
+		}
+	}
+	if len(missing) > 0 {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusConflict,
+			Code:    apierror.CodeConflict,
+			Message: "Upload has missing parts",
+			Details: map[string][]int{"missing_parts": missing},
+		})
+		return
+	}
+
+	video, err := cfg.finishUpload(r.Context(), upload)
+	if errors.Is(err, errContentMismatch) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finalize upload", err)
+		return
+	}
+	recordUpload("chunked", upload.Length)
+
+	status := http.StatusAccepted
+	if video.Status == database.VideoStatusReady {
+		status = http.StatusOK
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video", err)
+		return
+	}
+	respondWithJSON(w, status, video)
+}
@@ -1,18 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
-	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -24,90 +26,158 @@ func mimeCheckImage(mimeType string) error {
 	switch m {
 	case "image/jpeg":
 	case "image/png":
+	case "image/webp":
+	case "image/avif":
 	default:
 		return fmt.Errorf("not supported mimetype")
 	}
 	return nil
 }
 
+// mimeExtensions lists the media types whose subtype isn't the usual file
+// extension.
+var mimeExtensions = map[string]string{
+	"video/quicktime":  "mov",
+	"video/x-matroska": "mkv",
+}
+
 func mimeToExt(mimeType string) string {
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return ext
+	}
 	parts := strings.Split(mimeType, "/")
 	return parts[len(parts)-1]
 }
 
-func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+// extToMime is the inverse of mimeToExt for video files.
+func extToMime(ext string) string {
+	for mimeType, e := range mimeExtensions {
+		if e == ext {
+			return mimeType
+		}
+	}
+	return "video/" + ext
+}
+
+// saveThumbnail normalizes the image and stores it under a random key below
+// thumbnails/, returning its location to keep in the video record along
+// with the stored size. Like video URLs it is only presigned when the video
+// is served.
+func (cfg *apiConfig) saveThumbnail(ctx context.Context, src io.Reader, mediaType string) (*database.ObjectLocation, int64, error) {
+	data, err := normalizeThumbnail(ctx, src, mediaType, cfg.thumbnailOptions())
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return nil, 0, err
+	}
+	ext := mimeToExt(thumbnailMediaType)
+
+	randKey := make([]byte, 32)
+	rand.Read(randKey)
+	randFileName := base64.RawURLEncoding.EncodeToString(randKey)
+
+	key := fmt.Sprintf("thumbnails/%s.%s", randFileName, ext)
+	if _, err := cfg.storage.Put(ctx, key, bytes.NewReader(data), thumbnailMediaType); err != nil {
+		return nil, 0, fmt.Errorf("cannot store thumbnail: %w", err)
+	}
+	return cfg.objectLocation(ctx, key), int64(len(data)), nil
+}
+
+// deleteReplacedThumbnail removes the thumbnail a video had before a new
+// one was stored, unless other videos still point at it.
+func (cfg *apiConfig) deleteReplacedThumbnail(ctx context.Context, previous database.Video) {
+	if previous.ThumbnailObject != nil {
+		refs, err := cfg.db.WithContext(ctx).CountVideosWithObject(*previous.ThumbnailObject)
+		if err != nil || refs > 0 {
+			return
+		}
+		if err := cfg.storage.Delete(ctx, previous.ThumbnailObject.Key); err != nil {
+			requestLogger(ctx).Warn("cannot delete replaced thumbnail", "video_id", previous.ID, "key", path.Base(previous.ThumbnailObject.Key), "err", err)
+		}
 		return
 	}
+	if previous.ThumbnailURL == nil {
+		return
+	}
+	name, ok := assetName(*previous.ThumbnailURL)
+	if !ok {
+		return
+	}
+	if other, err := cfg.db.WithContext(ctx).GetVideoByThumbnail("", name); err != nil || other.ID != uuid.Nil {
+		return
+	}
+	if err := cfg.removeLocalAsset(*previous.ThumbnailURL); err != nil {
+		requestLogger(ctx).Warn("cannot delete replaced thumbnail", "video_id", previous.ID, "asset", name, "err", err)
+	}
+}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
 		return
 	}
+	userID := video.UserID
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	limits, err := cfg.uploadLimitsFor(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
 		return
 	}
-
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// TODO: implement the upload here
+	r.Body = http.MaxBytesReader(w, r.Body, limits.Thumbnail+multipartOverhead)
 	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
-
-	file, header, err := r.FormFile("thumbnail")
+	file, header, err := formFile(r, cfg.thumbnailFormField, maxMemory)
 	if err != nil {
+		if isBodyTooLarge(err) {
+			respondUploadTooLarge(w, uploadKindThumbnail, limits.Thumbnail, max(r.ContentLength, 0))
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse from file", err)
 		return
 	}
 	defer file.Close()
-	video, err := cfg.db.GetVideo(videoID)
+	if header.Size > limits.Thumbnail {
+		respondUploadTooLarge(w, uploadKindThumbnail, limits.Thumbnail, header.Size)
+		return
+	}
+	mediaType, err := formFileMediaType(header, cfg.thumbnailFormField, mimeCheckImage)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to get video", err)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "not authorized", nil)
+	if err := verifyImageContent(io.NewSectionReader(file, 0, header.Size), mediaType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
 		return
 	}
-	mediaType := header.Header.Get("Content-Type")
-	if err = mimeCheckImage(mediaType); err != nil {
-		respondWithError(w, http.StatusBadRequest, "", err)
+	if !cfg.checkQuota(w, r, userID, video.ThumbnailBytes, header.Size) {
+		return
 	}
-	ext := mimeToExt(mediaType)
-
-	randKey := make([]byte, 32)
-	rand.Read(randKey)
-	randFileName := base64.RawURLEncoding.EncodeToString(randKey)
-
-	assetPath := fmt.Sprintf("%s.%s", randFileName, ext)
-	assetDiskPath := filepath.Join(cfg.assetsRoot, assetPath)
-	osFile, err := os.Create(assetDiskPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "cannot create file", err)
+	ctx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	loc, size, err := cfg.saveThumbnail(ctx, file, mediaType)
+	if errors.Is(err, errThumbnailTooSmall) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-	_, err = io.Copy(osFile, file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "cannot write to file", err)
+		respondMediaError(w, "cannot save thumbnail", err)
+		return
 	}
-	url := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
-	video.ThumbnailURL = &url
-	video.UpdatedAt = time.Now()
-
-	if err := cfg.db.UpdateVideo(video); err != nil {
+	recordUpload("thumbnail", header.Size)
+	var previous database.Video
+	video, err = cfg.updateVideo(r.Context(), video.ID, func(video *database.Video) {
+		previous = *video
+		video.ThumbnailURL = nil
+		video.ThumbnailObject = loc
+		video.ThumbnailBytes = size
+	})
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "cant update video thumbnail", err)
 		return
 	}
-	video, err = cfg.db.GetVideo(videoID)
+	cleanupCtx, cancelCleanup := detach(r.Context(), cleanupTimeout)
+	defer cancelCleanup()
+	cfg.deleteReplacedThumbnail(cleanupCtx, previous)
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to get video", err)
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
 		return
 	}
 
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// testPNG returns a generated 640x360 PNG.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 640, 360))); err != nil {
+		t.Fatal(err)
+	}
+	return img.Bytes()
+}
+
+func TestUploadThumbnailDeletesReplaced(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID := createTestUser(t, cfg, "a@example.com")
+	video := createTestVideo(t, cfg, userID, "thumbs")
+	upload := func() *database.ObjectLocation {
+		t.Helper()
+		r := multipartUpload(t, "/api/thumbnail_upload/"+video.ID.String(), cfg.thumbnailFormField, "image/png", testPNG(t))
+		w := serveAs(t, cfg, userID, "POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		stored, err := cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ThumbnailObject == nil {
+			t.Fatal("no thumbnail object stored")
+		}
+		return stored.ThumbnailObject
+	}
+
+	first := upload()
+	// another video shares the first thumbnail, so it must survive the
+	// first replacement
+	other := createTestVideo(t, cfg, userID, "other")
+	if _, err := cfg.updateVideo(context.Background(), other.ID, func(v *database.Video) { v.ThumbnailObject = first }); err != nil {
+		t.Fatal(err)
+	}
+	second := upload()
+	if !objectExists(t, cfg, first.Key) {
+		t.Fatal("thumbnail still used by another video was deleted")
+	}
+	if !objectExists(t, cfg, second.Key) {
+		t.Fatal("new thumbnail wasn't stored")
+	}
+
+	third := upload()
+	if objectExists(t, cfg, second.Key) {
+		t.Error("replaced thumbnail wasn't deleted")
+	}
+	if !objectExists(t, cfg, third.Key) {
+		t.Error("new thumbnail wasn't stored")
+	}
+}
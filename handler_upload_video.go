@@ -5,42 +5,87 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"math"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
-	"path"
-	"strings"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
-func processVideoForFastStart(filePath string) (string, error) {
-	workFile := fmt.Sprintf("%s.processing", filePath)
+type processingOptions struct {
+	NormalizeAudio bool
+	TargetLUFS     float64
+}
+
+func (cfg *apiConfig) processingOptions() processingOptions {
+	return processingOptions{
+		NormalizeAudio: cfg.audioNormalize,
+		TargetLUFS:     cfg.audioTargetLUFS,
+	}
+}
 
-	cmd := exec.Command(
-		"ffmpeg",
+// fastStartArgs builds the ffmpeg arguments for the faststart pass. Video is
+// always stream copied; audio is only re-encoded when there is an audio
+// filter, the loudness normalization, and resampled to loudnormSampleRate.
+func fastStartArgs(filePath, workFile, audioFilter string) []string {
+	args := []string{
 		"-y",
 		"-i", filePath,
-		"-c", "copy",
+	}
+	if audioFilter != "" {
+		args = append(args,
+			"-c:v", "copy",
+			"-af", audioFilter,
+			"-ar", loudnormSampleRate,
+			"-c:a", "aac",
+		)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	return append(args,
 		"-movflags", "faststart",
 		"-f", "mp4",
 		workFile,
 	)
+}
+
+// fastStartAudioFilter returns the audio filter of the faststart pass, the
+// loudness normalization when it is on and the file has sound.
+func fastStartAudioFilter(ctx context.Context, filePath string, opts processingOptions) (string, error) {
+	if !opts.NormalizeAudio {
+		return "", nil
+	}
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	if !info.hasAudio() {
+		return "", nil
+	}
+	return normalizationFilter(ctx, filePath, opts.TargetLUFS)
+}
+
+func processVideoForFastStart(ctx context.Context, filePath string, opts processingOptions) (string, error) {
+	workFile := fmt.Sprintf("%s.processing", filePath)
+
+	audioFilter, err := fastStartAudioFilter(ctx, filePath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpegBin, fastStartArgs(filePath, workFile, audioFilter)...)
+	trackFFmpegProgress(ctx, cmd, filePath)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := runMediaCommand(ctx, cmd); err != nil {
 		os.Remove(workFile)
 		return "", fmt.Errorf("ffmpeg faststart failed: %w\nstderr: %s", err, stderr.String())
 	}
@@ -64,190 +109,377 @@ func mimeCheckVideo(mimeType string) error {
 	}
 	switch m {
 	case "video/mp4":
+	case "video/webm":
+	case "video/quicktime":
+	case "video/x-matroska":
 	default:
 		return fmt.Errorf("not supported mimetype")
 	}
 	return nil
 }
 
-func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-	presignClient := s3.NewPresignClient(s3Client)
-	resp, err := presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	}, s3.WithPresignExpires(expireTime))
-	if err != nil {
-		return "", err
-	}
-	return resp.URL, nil
+// isMP4 reports whether a video can be stored without transcoding.
+func isMP4(mediaType string) bool {
+	m, _, _ := mime.ParseMediaType(mediaType)
+	return m == "video/mp4"
 }
 
-func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-	if video.VideoURL == nil {
-		return database.Video{}, fmt.Errorf("video URL is nil")
+// dbVideoToSignedVideo fills in the URLs of a video from the locations of
+// its stored objects, presigned. Videos that haven't been uploaded yet only get their
+// thumbnail signed. Private videos are only signed for their owner, for
+// anyone else errVideoPrivate is returned.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video, viewerID uuid.UUID) (database.Video, error) {
+	if !canViewVideo(video, viewerID) {
+		return database.Video{}, errVideoPrivate
+	}
+	if video.ModerationStatus == database.ModerationStatusTakenDown || video.DeletedAt != nil {
+		// nothing of a taken down or trashed video is handed out, its
+		// owner sees why
+		video.ThumbnailURL = nil
+		return video, nil
+	}
+	// archived videos keep their thumbnail, the rest can't be read until
+	// they are restored
+	archived := video.StorageTier != database.StorageTierHot
+	expireTime := cfg.videoURLExpiry
+	if video.ThumbnailObject != nil {
+		thumbnailURL, err := cfg.videoObjectURL(ctx, video, video.ThumbnailObject.Key, expireTime)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.ThumbnailURL = &thumbnailURL
+	}
+	// hover previews and captions aren't archived either
+	if video.PreviewKey != nil {
+		previewURL, err := cfg.videoObjectURL(ctx, video, *video.PreviewKey, expireTime)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.PreviewURL = &previewURL
+	}
+	captions := make([]database.Caption, len(video.Captions))
+	for i, caption := range video.Captions {
+		url, err := cfg.videoObjectURL(ctx, video, caption.ObjectKey, expireTime)
+		if err != nil {
+			return database.Video{}, err
+		}
+		caption.URL = &url
+		captions[i] = caption
+	}
+	video.Captions = captions
+	if video.VideoObject == nil || archived {
+		return video, nil
 	}
-	urlParts := strings.Split(*video.VideoURL, ",")
-	if len(urlParts) != 2 {
-		return database.Video{}, fmt.Errorf("invalid video URL format")
+
+	key := video.VideoObject.Key
+	if cfg.presignHeadCheck {
+		info, err := cfg.storage.Head(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return database.Video{}, errVideoObjectNotFound
+			}
+			return database.Video{}, err
+		}
+		video.VideoSize = &info.Size
+		if info.ContentType != "" {
+			video.VideoContentType = &info.ContentType
+		}
 	}
-	expireTime := 15 * time.Minute
-	presignedURL, err := generatePresignedURL(cfg.s3Client, urlParts[0], urlParts[1], expireTime)
+	presignedURL, err := cfg.mediaObjectURL(ctx, video, key, expireTime)
 	if err != nil {
 		return database.Video{}, err
 	}
 	video.VideoURL = &presignedURL
+
+	renditions := make(database.Renditions, len(video.Renditions))
+	for i, rendition := range video.Renditions {
+		url, err := cfg.mediaObjectURL(ctx, video, rendition.Key, expireTime)
+		if err != nil {
+			return database.Video{}, err
+		}
+		rendition.URL = &url
+		renditions[i] = rendition
+	}
+	video.Renditions = renditions
+	if video.Audio != nil {
+		audio := *video.Audio
+		url, err := cfg.mediaObjectURL(ctx, video, audio.Key, expireTime)
+		if err != nil {
+			return database.Video{}, err
+		}
+		audio.URL = &url
+		video.Audio = &audio
+	}
 	return video, nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	type FFProbeOutput struct {
-		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
+var (
+	errUnsupportedMediaType = errors.New("not supported mimetype")
+	errMissingUploadPart    = errors.New("upload form part is missing")
+)
+
+func newVideoKey(aspectRatio, mediaType string) string {
+	randKey := make([]byte, 32)
+	rand.Read(randKey)
+	randFileName := base64.RawURLEncoding.EncodeToString(randKey)
+
+	switch aspectRatio {
+	case "16:9":
+		return fmt.Sprintf("landscape/%s.%s", randFileName, mimeToExt(mediaType))
+	case "9:16":
+		return fmt.Sprintf("portrait/%s.%s", randFileName, mimeToExt(mediaType))
+	default:
+		return fmt.Sprintf("other/%s.%s", randFileName, mimeToExt(mediaType))
 	}
+}
 
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
+// stageUploadedVideoForm reads the video part of a parsed multipart form and
+// stages it for processing. The staged bytes are hashed as they are stored,
+// a duplicate of a stored video is deleted from staging again. Videos larger
+// than maxSize fail with an *http.MaxBytesError.
+func (cfg *apiConfig) stageUploadedVideoForm(r *http.Request, video database.Video, maxSize int64) (videoUpload, error) {
+	file, header, err := r.FormFile(cfg.videoFormField)
+	if isBodyTooLarge(err) {
+		return videoUpload{}, err
+	}
+	if err != nil {
+		return videoUpload{}, fmt.Errorf("%w: %v", errMissingUploadPart, err)
+	}
+	defer file.Close()
+	if header.Size > maxSize {
+		return videoUpload{}, &http.MaxBytesError{Limit: maxSize}
+	}
+	mediaType := header.Header.Get("Content-Type")
+	if err := mimeCheckVideo(mediaType); err != nil {
+		return videoUpload{}, unsupportedInput("form."+cfg.videoFormField, mediaType, fmt.Errorf("%w: %v", errUnsupportedMediaType, err))
+	}
+	head, err := readHeadAt(file)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	if err := verifyVideoContent(head, mediaType); err != nil {
+		return videoUpload{}, err
+	}
+	upload, err := cfg.stageVideo(r.Context(), video.ID, file, mediaType)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	dup, ok, err := cfg.findDuplicateVideo(r.Context(), video, upload.Checksum)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	if ok {
+		cfg.deleteStagingObject(r.Context(), upload.Key)
+		return videoUpload{
+			MediaType: mediaType,
+			Size:      upload.Size,
+			Checksum:  upload.Checksum,
+			Duplicate: &dup,
+		}, nil
+	}
+	return upload, nil
+}
 
-	var out, stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("unable to run ffprobe %w %s", err, stderr.String())
-	}
-	var jsonFFP FFProbeOutput
-	if err := json.Unmarshal(out.Bytes(), &jsonFFP); err != nil {
-		return "", fmt.Errorf("unmarshal error %w", err)
-	}
-	var result string
-	if len(jsonFFP.Streams) > 0 {
-		w := float64(jsonFFP.Streams[0].Width)
-		h := float64(jsonFFP.Streams[0].Height)
-
-		ratio := w / h
-
-		const epsilon = 0.02
-
-		switch {
-		case math.Abs(ratio-(16.0/9.0)) < epsilon:
-			result = "16:9"
-		case math.Abs(ratio-(9.0/16.0)) < epsilon:
-			result = "9:16"
-		default:
-			a, b := int(w), int(h)
-			for b != 0 {
-				a, b = b, a%b
-			}
-			g := a
-			result = fmt.Sprintf("%d:%d", int(w)/g, int(h)/g)
-		}
+// storeVideo faststarts the video at filePath and puts the result into the
+// video storage, returning the key and size of the stored object. The file
+// is probed through probeVideoInfo, so a probe carried by ctx is reused.
+func (cfg *apiConfig) storeVideo(ctx context.Context, filePath, mediaType string) (string, int64, error) {
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return "", 0, err
 	}
+	fileKey := newVideoKey(info.aspectRatio(), mediaType)
 
-	return result, nil
+	fsVideo, err := processVideoForFastStart(ctx, filePath, cfg.processingOptions())
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(fsVideo)
+
+	stat, err := os.Stat(fsVideo)
+	if err != nil {
+		return "", 0, err
+	}
+	// storing finishes even if the job is interrupted
+	storeCtx, cancel := detach(ctx, cfg.mediaTimeout)
+	defer cancel()
+	err = cfg.putObjectFile(withStoreProgress(storeCtx, stat.Size()), fileKey, fsVideo, mediaType)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot put to storage: %w", err)
+	}
+	return fileKey, stat.Size(), nil
 }
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
-	videoID := path.Base(r.URL.String())
-	token, err := auth.GetBearerToken(r.Header)
+// setVideoObject points the video record at a stored, playable object and
+// updates how much storage it takes up. change sets what else was learned
+// while storing it.
+func (cfg *apiConfig) setVideoObject(ctx context.Context, videoID uuid.UUID, fileKey string, change func(*database.Video)) (database.Video, error) {
+	videoBytes, measureErr := cfg.measureVideoBytes(ctx, videoID, fileKey)
+	if measureErr != nil {
+		requestLogger(ctx).Warn("cannot measure stored size of video", "video_id", videoID, "err", measureErr)
+	}
+	video, err := cfg.updateVideo(ctx, videoID, func(video *database.Video) {
+		change(video)
+		if measureErr == nil {
+			video.VideoBytes = videoBytes
+		}
+		video.VideoObject = cfg.objectLocation(ctx, fileKey)
+		video.Status = database.VideoStatusReady
+	})
+	if err != nil {
+		return database.Video{}, err
+	}
+	video, err = cfg.unarchiveReplaced(ctx, video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, err
+	}
+	cfg.queueReplication(ctx, video)
+	cfg.queueTranscription(ctx, video)
+	cfg.queueClassification(ctx, video)
+	cfg.emitVideoEvent(eventVideoReady, video)
+	return video, nil
+}
+
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID := video.UserID
+	limits, err := cfg.uploadLimitsFor(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
 		return
 	}
-	video, err := cfg.db.GetVideo(uuid.MustParse(videoID))
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "not video owner", err)
+	maxUploadSize := limits.Video + multipartOverhead
+	if r.ContentLength > maxUploadSize {
+		respondUploadTooLarge(w, uploadKindVideo, limits.Video, r.ContentLength)
 		return
 	}
+	remaining, used, err := cfg.remainingQuota(r.Context(), userID, video.VideoBytes)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "cant find video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
 		return
 	}
-	file, header, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error loading file", err)
+	if r.ContentLength > remaining {
+		cfg.respondQuotaExceeded(w, used, r.ContentLength)
 		return
 	}
-	defer file.Close()
-	mediaType := header.Header.Get("Content-Type")
-	if err := mimeCheckVideo(mediaType); err != nil {
-		respondWithError(w, http.StatusBadRequest, "not supported mimetype", err)
+	if !cfg.checkScratchSpace(w, r.ContentLength) {
 		return
 	}
-	tempFile, _ := os.CreateTemp("", "tubely-temp-upload.mp4")
-	defer os.Remove(tempFile.Name())
+	r = r.WithContext(cfg.withVideoProgress(r.Context(), video.ID))
+	r.Body = progressBody(r.Context(), http.MaxBytesReader(w, r.Body, min(maxUploadSize, remaining)), r.ContentLength)
 
-	io.Copy(tempFile, file)
-	log.Println("finished copy", err)
-	tempFile.Seek(0, io.SeekStart)
-	randKey := make([]byte, 32)
-	rand.Read(randKey)
-	randFileName := base64.RawURLEncoding.EncodeToString(randKey)
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "aspectRatio error", err)
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(video.ID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot update video status", err)
 		return
 	}
+	uploaded := false
+	defer func() {
+		if !uploaded {
+			// put the video back the way it was, the upload never happened;
+			// the client hanging up is the most likely reason
+			ctx, cancel := detach(r.Context(), cleanupTimeout)
+			defer cancel()
+			if err := cfg.db.WithContext(ctx).SetVideoStatus(video.ID, video.Status); err != nil {
+				requestLogger(r.Context()).Warn("cannot restore video status", "video_id", video.ID, "err", err)
+			}
+			cfg.progress.forget(video.ID)
+		}
+	}()
 
-	var fileKey string
-	switch aspectRatio {
-	case "16:9":
-		fileKey = fmt.Sprintf("landscape/%s.%s", randFileName, mimeToExt(mediaType))
-	case "9:16":
-		fileKey = fmt.Sprintf("portrait/%s.%s", randFileName, mimeToExt(mediaType))
-	default:
-		fileKey = fmt.Sprintf("other/%s.%s", randFileName, mimeToExt(mediaType))
+	var upload videoUpload
+	receiveCtx, span := tracer.Start(r.Context(), "upload.receive")
+	if cfg.uploadStreaming {
+		upload, err = cfg.streamUploadedVideo(r.WithContext(receiveCtx), video, limits.Video)
+	} else {
+		upload, err = cfg.stageUploadedVideoForm(r.WithContext(receiveCtx), video, limits.Video)
 	}
-	///preprocessing
-	tempFile.Sync()
-
-	tempFile.Close()
-	fsVideo, err := processVideoForFastStart(tempFile.Name())
-	log.Println("finished ffmpeg", err)
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "", err)
+	endSpan(span, err)
+	if errors.Is(err, errUnsupportedMediaType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", err)
 		return
 	}
-	f, err := os.Open(fsVideo)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "", err)
+	if errors.Is(err, errContentMismatch) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
+	if errors.Is(err, errMediaLimit) {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+	if isBodyTooLarge(err) && remaining < maxUploadSize {
+		cfg.respondQuotaExceeded(w, used, r.ContentLength)
+		return
+	}
+	if isBodyTooLarge(err) {
+		respondUploadTooLarge(w, uploadKindVideo, limits.Video, max(r.ContentLength, 0))
 		return
 	}
-	defer f.Close()
-	s3Params := s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &fileKey,
-		Body:        f,
-		ContentType: &mediaType,
+	if errors.Is(err, errMissingUploadPart) {
+		respondWithError(w, http.StatusBadRequest, "error loading file", invalidInput("form."+cfg.videoFormField, "must be a file", err))
+		return
 	}
-	_, err = cfg.s3Client.PutObject(context.Background(), &s3Params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "cannot put to s3", err)
+		respondMediaError(w, "cannot store video", err)
+		return
+	}
+	recordUpload("form", upload.Size)
+
+	if upload.Duplicate != nil {
+		cfg.emitVideoEvent(eventVideoUploaded, video)
+		video, err = cfg.shareVideoObjects(context.WithoutCancel(r.Context()), video, *upload.Duplicate, upload.MediaType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot load video to db", err)
+			return
+		}
+		uploaded = true
+		video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+
+	if upload.Staged {
+		upload.UploadID = uuid.New()
+		video, err = cfg.enqueueVideoProcessing(r.Context(), video, upload)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot queue video processing", err)
+			return
+		}
+		uploaded = true
+		// processing is cancelled through the upload
+		w.Header().Set("Location", "/api/uploads/"+upload.UploadID.String())
+		video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, video)
 		return
 	}
-	newURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, fileKey)
-	video.UpdatedAt = time.Now()
-	video.VideoURL = &newURL
-	err = cfg.db.UpdateVideo(video)
+
+	cfg.emitVideoEvent(eventVideoUploaded, video)
+	// the object is stored, recording it finishes even if the client hangs up
+	video, err = cfg.setVideoObject(context.WithoutCancel(r.Context()), video.ID, upload.Key, func(video *database.Video) {
+		video.OriginalFormat = &upload.MediaType
+		video.Checksum = &upload.Checksum
+		video.AspectRatio = &upload.AspectRatio
+		video.Metadata = upload.Metadata
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "cannot load video to db", err)
 		return
 	}
-	presignedVideo, err := cfg.dbVideoToSignedVideo(video)
+	uploaded = true
+	presignedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, presignedVideo)
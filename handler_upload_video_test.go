@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestUploadVideoOwnership(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestUser(t, cfg, "owner@example.com")
+	other := createTestUser(t, cfg, "other@example.com")
+	video := createTestVideo(t, cfg, owner, "owned")
+	trashed := createTestVideo(t, cfg, owner, "trashed")
+	if _, err := cfg.db.TrashVideo(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"POST /api/video_upload/{videoID}", cfg.handlerUploadVideo},
+		{"POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail},
+	}
+	tests := []struct {
+		name     string
+		userID   uuid.UUID
+		videoID  string
+		status   int
+		code     apierror.Code
+		location string
+	}{
+		{"foreign", other, video.ID.String(), http.StatusForbidden, apierror.CodeNotOwner, ""},
+		{"missing", owner, uuid.NewString(), http.StatusNotFound, apierror.CodeNotFound, ""},
+		{"trashed", owner, trashed.ID.String(), http.StatusNotFound, apierror.CodeNotFound, ""},
+		{"invalid id", owner, "not-a-uuid", http.StatusBadRequest, apierror.CodeInvalidRequest, "path.videoID"},
+	}
+	for _, e := range endpoints {
+		for _, tt := range tests {
+			t.Run(e.pattern+"/"+tt.name, func(t *testing.T) {
+				path := strings.Replace(strings.TrimPrefix(e.pattern, "POST "), "{videoID}", tt.videoID, 1)
+				r := httptest.NewRequest("POST", path, strings.NewReader(""))
+				r.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+				w := serveAs(t, cfg, tt.userID, e.pattern, e.handler, r)
+				if w.Code != tt.status {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+				code, location := errorLocation(t, w)
+				if code != tt.code {
+					t.Errorf("code = %s, want %s", code, tt.code)
+				}
+				if location != tt.location {
+					t.Errorf("location = %q, want %q", location, tt.location)
+				}
+			})
+		}
+	}
+}
+
+// fakeStorage knows the objects it was given and presigns anything. Every
+// other method panics.
+type fakeStorage struct {
+	storage.Storage
+	objects map[string]storage.ObjectInfo
+	heads   atomic.Int32
+}
+
+func (s *fakeStorage) Head(_ context.Context, key string) (storage.ObjectInfo, error) {
+	s.heads.Add(1)
+	info, ok := s.objects[key]
+	if !ok {
+		return storage.ObjectInfo{}, fmt.Errorf("%w: %s", storage.ErrNotFound, key)
+	}
+	return info, nil
+}
+
+func (s *fakeStorage) Presign(_ context.Context, key string, _ time.Duration) (string, error) {
+	return "https://storage.example.com/" + key + "?X-Amz-Signature=fake", nil
+}
+
+func TestPresignHeadCheck(t *testing.T) {
+	const (
+		storedKey  = "landscape/stored.mp4"
+		missingKey = "landscape/missing.mp4"
+	)
+	tests := []struct {
+		name      string
+		headCheck bool
+		key       string
+		status    int
+		size      int64
+	}{
+		{"stored", true, storedKey, http.StatusOK, 1234},
+		{"missing", true, missingKey, http.StatusNotFound, 0},
+		{"missing unchecked", false, missingKey, http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			fake := &fakeStorage{objects: map[string]storage.ObjectInfo{
+				storedKey: {Key: storedKey, Size: 1234, ContentType: "video/mp4"},
+			}}
+			cfg.storage = fake
+			cfg.presignHeadCheck = tt.headCheck
+			userID := createTestUser(t, cfg, "a@example.com")
+			video := createTestVideo(t, cfg, userID, tt.name)
+			if _, err := cfg.updateVideo(context.Background(), video.ID, func(v *database.Video) { v.VideoObject = cfg.objectLocation(context.Background(), tt.key) }); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", "/api/videos/"+video.ID.String(), nil)
+			w := serveAs(t, cfg, userID, "GET /api/videos/{videoID}", cfg.handlerVideoGet, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if heads := fake.heads.Load(); (heads > 0) != tt.headCheck {
+				t.Errorf("%d HEAD requests with the check %v", heads, tt.headCheck)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got database.Video
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.VideoURL == nil {
+				t.Error("no video URL")
+			}
+			if tt.size == 0 {
+				if got.VideoSize != nil {
+					t.Errorf("video size = %d without a HEAD", *got.VideoSize)
+				}
+			} else if got.VideoSize == nil || *got.VideoSize != tt.size {
+				t.Errorf("video size = %v, want %d", got.VideoSize, tt.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxPlaybackPosition bounds the positions players report, no video
+	// runs longer than a day.
+	maxPlaybackPosition   = 24 * 60 * 60
+	defaultAnalyticsRange = 30 * 24 * time.Hour
+	maxAnalyticsBuckets   = 1000
+)
+
+var playbackEvents = map[string]database.PlaybackEvent{
+	string(database.PlaybackEventStart):    database.PlaybackEventStart,
+	string(database.PlaybackEventProgress): database.PlaybackEventProgress,
+	string(database.PlaybackEventComplete): database.PlaybackEventComplete,
+}
+
+var analyticsIntervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+}
+
+// handlerVideoPlayback records an event of the player. Each playback has
+// an ID of its own, made up by the player, and counts as one view however
+// many events it reports. Anonymous players may send a client ID that
+// stays the same across playbacks, so they are counted as one viewer.
+func (cfg *apiConfig) handlerVideoPlayback(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PlaybackID uuid.UUID  `json:"playback_id"`
+		Event      string     `json:"event"`
+		Position   float64    `json:"position"`
+		ClientID   *uuid.UUID `json:"client_id"`
+	}
+
+	video, viewerID, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if video.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusConflict, "Video isn't ready to play", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.PlaybackID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "playback_id is required", nil)
+		return
+	}
+	event, ok := playbackEvents[params.Event]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "event must be start, progress or complete", nil)
+		return
+	}
+	if params.Position < 0 || params.Position > maxPlaybackPosition {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("position must be between 0 and %d seconds", maxPlaybackPosition), nil)
+		return
+	}
+
+	viewer := "playback:" + params.PlaybackID.String()
+	switch {
+	case viewerID != uuid.Nil:
+		viewer = userViewer(viewerID)
+	case params.ClientID != nil && *params.ClientID != uuid.Nil:
+		viewer = "client:" + params.ClientID.String()
+	}
+
+	err := cfg.db.WithContext(r.Context()).RecordPlaybackEvent(database.RecordPlaybackEventParams{
+		PlaybackID: params.PlaybackID,
+		VideoID:    video.ID,
+		Viewer:     viewer,
+		Event:      event,
+		Position:   int64(params.Position),
+	})
+	if errors.Is(err, database.ErrPlaybackOfOtherVideo) {
+		respondWithError(w, http.StatusConflict, "playback_id is used for another video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record playback", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userViewer is the viewer of playbacks by a logged in user.
+func userViewer(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// analyticsTotals sums up playbacks.
+type analyticsTotals struct {
+	Views         int   `json:"views"`
+	UniqueViewers int   `json:"unique_viewers"`
+	Completions   int   `json:"completions"`
+	WatchSeconds  int64 `json:"watch_seconds"`
+	viewers       map[string]bool
+}
+
+func (t *analyticsTotals) add(p database.Playback) {
+	t.Views++
+	if p.CompletedAt != nil {
+		t.Completions++
+	}
+	t.WatchSeconds += p.WatchedSeconds
+	if t.viewers == nil {
+		t.viewers = map[string]bool{}
+	}
+	if !t.viewers[p.Viewer] {
+		t.viewers[p.Viewer] = true
+		t.UniqueViewers++
+	}
+}
+
+type analyticsBucket struct {
+	Start time.Time `json:"start"`
+	analyticsTotals
+}
+
+// timeBuckets is the time range between ?from= and ?to= (RFC 3339, the
+// last 30 days by default) split into buckets of an ?interval= of hour or
+// day.
+type timeBuckets struct {
+	From         time.Time
+	To           time.Time
+	Interval     time.Duration
+	IntervalName string
+	N            int
+}
+
+func parseTimeBuckets(query url.Values) (timeBuckets, error) {
+	b := timeBuckets{IntervalName: query.Get("interval")}
+	if b.IntervalName == "" {
+		b.IntervalName = "day"
+	}
+	interval, ok := analyticsIntervals[b.IntervalName]
+	if !ok {
+		return b, invalidInput("query.interval", "must be hour or day", nil)
+	}
+	b.Interval = interval
+	b.To = time.Now().UTC()
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return b, invalidInput("query.to", "must be an RFC 3339 time", err)
+		}
+		b.To = t.UTC()
+	}
+	// rows are stamped with whole seconds, the one in progress is included
+	b.To = b.To.Add(time.Second - 1).Truncate(time.Second)
+	b.From = b.To.Add(-defaultAnalyticsRange)
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return b, invalidInput("query.from", "must be an RFC 3339 time", err)
+		}
+		b.From = t.UTC()
+	}
+	// buckets start on whole hours and days, UTC
+	b.From = b.From.Truncate(interval)
+	if !b.To.After(b.From) {
+		return b, invalidInput("query.from", "must be before to", nil)
+	}
+	b.N = int((b.To.Sub(b.From) + interval - 1) / interval)
+	if b.N > maxAnalyticsBuckets {
+		return b, invalidInput("query.from", fmt.Sprintf("time range spans more than %d %ss", maxAnalyticsBuckets, b.IntervalName), nil)
+	}
+	return b, nil
+}
+
+// start returns when bucket i starts.
+func (b timeBuckets) start(i int) time.Time {
+	return b.From.Add(time.Duration(i) * b.Interval)
+}
+
+// index returns the bucket t falls into, -1 when it is out of range.
+func (b timeBuckets) index(t time.Time) int {
+	if t.Before(b.From) {
+		return -1
+	}
+	i := int(t.Sub(b.From) / b.Interval)
+	if i >= b.N {
+		return -1
+	}
+	return i
+}
+
+// handlerVideoAnalytics returns the views of a video to its owner, for the
+// playbacks started in the timeBuckets of the request. Watch time is how
+// far into the video each playback got.
+func (cfg *apiConfig) handlerVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		ViewCount int64     `json:"view_count"`
+		From      time.Time `json:"from"`
+		To        time.Time `json:"to"`
+		Interval  string    `json:"interval"`
+		analyticsTotals
+		Buckets []analyticsBucket `json:"buckets"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	buckets, err := parseTimeBuckets(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid time range", err)
+		return
+	}
+
+	playbacks, err := cfg.db.WithContext(r.Context()).GetPlaybacks(video.ID, buckets.From, buckets.To)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playbacks", err)
+		return
+	}
+
+	resp := response{
+		VideoID:   video.ID,
+		ViewCount: video.ViewCount,
+		From:      buckets.From,
+		To:        buckets.To,
+		Interval:  buckets.IntervalName,
+		Buckets:   make([]analyticsBucket, buckets.N),
+	}
+	for i := range resp.Buckets {
+		resp.Buckets[i].Start = buckets.start(i)
+	}
+	for _, p := range playbacks {
+		i := buckets.index(p.StartedAt)
+		if i < 0 {
+			continue
+		}
+		resp.Buckets[i].add(p)
+		resp.analyticsTotals.add(p)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
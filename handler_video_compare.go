@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type videoComparison struct {
+	ID       uuid.UUID `json:"id"`
+	Title    string    `json:"title"`
+	Duration float64   `json:"duration"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Size     int64     `json:"size"`
+	Bitrate  int64     `json:"bitrate"`
+}
+
+// computeBitrate returns the average bitrate in bits per second.
+func computeBitrate(size int64, duration float64) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(float64(size*8) / duration)
+}
+
+// compareVideoMeta reads the metadata recorded when the video was stored,
+// only videos stored before it had their size recorded are probed.
+func (cfg *apiConfig) compareVideoMeta(ctx context.Context, video database.Video) (videoComparison, error) {
+	if m := video.Metadata; m != nil && m.Size > 0 {
+		return videoComparison{
+			ID:       video.ID,
+			Title:    video.Title,
+			Duration: m.Duration,
+			Width:    m.Width,
+			Height:   m.Height,
+			Size:     m.Size,
+			Bitrate:  computeBitrate(m.Size, m.Duration),
+		}, nil
+	}
+	key, err := videoKey(video)
+	if err != nil {
+		return videoComparison{}, err
+	}
+	head, err := cfg.storage.Head(ctx, key)
+	if err != nil {
+		return videoComparison{}, fmt.Errorf("cannot head object: %w", err)
+	}
+	size := head.Size
+
+	// ffprobe only reads the container headers over the presigned URL
+	presignedURL, err := cfg.storage.Presign(ctx, key, sourceURLExpiry)
+	if err != nil {
+		return videoComparison{}, err
+	}
+	info, err := probeVideoInfo(ctx, presignedURL)
+	if err != nil {
+		return videoComparison{}, err
+	}
+
+	return videoComparison{
+		ID:       video.ID,
+		Title:    video.Title,
+		Duration: info.Duration,
+		Width:    info.Width,
+		Height:   info.Height,
+		Size:     size,
+		Bitrate:  computeBitrate(size, info.Duration),
+	}, nil
+}
+
+func (cfg *apiConfig) handlerVideosCompare(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		A videoComparison `json:"a"`
+		B videoComparison `json:"b"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	var videos [2]database.Video
+	for i, param := range []string{"a", "b"} {
+		videoID, err := queryUUID(r, param)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid video ID in %q", param), err)
+			return
+		}
+		video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+		if err != nil || video.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't access this video", errNotOwner)
+			return
+		}
+		if video.VideoObject == nil {
+			respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", nil)
+			return
+		}
+		videos[i] = video
+	}
+
+	var (
+		resp response
+		err  error
+	)
+	resp.A, err = cfg.compareVideoMeta(r.Context(), videos[0])
+	if err != nil {
+		respondMediaError(w, "Couldn't read video metadata", err)
+		return
+	}
+	resp.B, err = cfg.compareVideoMeta(r.Context(), videos[1])
+	if err != nil {
+		respondMediaError(w, "Couldn't read video metadata", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideosCompare(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID := createTestUser(t, cfg, "a@example.com")
+	stored := func(title string, m database.VideoMetadata) database.Video {
+		t.Helper()
+		video := createTestVideo(t, cfg, userID, title)
+		loc := putTestObject(t, cfg, "landscape/"+video.ID.String()+".mp4", "video", "video/mp4")
+		video, err := cfg.updateVideo(context.Background(), video.ID, func(v *database.Video) {
+			v.VideoObject = loc
+			v.Metadata = &m
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video
+	}
+
+	tests := []struct {
+		name string
+		meta database.VideoMetadata
+		want videoComparison
+	}{
+		{
+			name: "landscape",
+			meta: database.VideoMetadata{Width: 1920, Height: 1080, Duration: 10, Size: 2_500_000},
+			want: videoComparison{Title: "landscape", Duration: 10, Width: 1920, Height: 1080, Size: 2_500_000, Bitrate: 2_000_000},
+		},
+		{
+			name: "portrait",
+			meta: database.VideoMetadata{Width: 720, Height: 1280, Duration: 4, Size: 1_000_000},
+			want: videoComparison{Title: "portrait", Duration: 4, Width: 720, Height: 1280, Size: 1_000_000, Bitrate: 2_000_000},
+		},
+		{
+			name: "fractional duration",
+			meta: database.VideoMetadata{Width: 640, Height: 360, Duration: 2.5, Size: 100_000},
+			want: videoComparison{Title: "fractional duration", Duration: 2.5, Width: 640, Height: 360, Size: 100_000, Bitrate: 320_000},
+		},
+		{
+			name: "zero duration",
+			meta: database.VideoMetadata{Width: 640, Height: 360, Size: 100_000},
+			want: videoComparison{Title: "zero duration", Width: 640, Height: 360, Size: 100_000},
+		},
+	}
+	reference := stored("reference", database.VideoMetadata{Width: 1280, Height: 720, Duration: 1, Size: 1000})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := stored(tt.name, tt.meta)
+			r := httptest.NewRequest("GET", "/api/videos/compare?a="+video.ID.String()+"&b="+reference.ID.String(), nil)
+			w := serveAs(t, cfg, userID, "GET /api/videos/compare", cfg.handlerVideosCompare, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				A videoComparison `json:"a"`
+				B videoComparison `json:"b"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.ID = video.ID
+			if resp.A != tt.want {
+				t.Errorf("a = %+v, want %+v", resp.A, tt.want)
+			}
+			if resp.B.ID != reference.ID || resp.B.Bitrate != 8000 {
+				t.Errorf("b = %+v, want the reference at 8000 bit/s", resp.B)
+			}
+		})
+	}
+}
+
+func TestVideosCompareOwnership(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestUser(t, cfg, "a@example.com")
+	video := createTestVideo(t, cfg, owner, "mine")
+	other := createTestUser(t, cfg, "b@example.com")
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		query  string
+		status int
+	}{
+		{"foreign", other, "a=" + video.ID.String() + "&b=" + video.ID.String(), http.StatusForbidden},
+		{"missing", owner, "a=" + uuid.NewString() + "&b=" + video.ID.String(), http.StatusNotFound},
+		{"not uploaded", owner, "a=" + video.ID.String() + "&b=" + video.ID.String(), http.StatusBadRequest},
+		{"invalid id", owner, "a=x&b=" + video.ID.String(), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/videos/compare?"+tt.query, nil)
+			w := serveAs(t, cfg, tt.userID, "GET /api/videos/compare", cfg.handlerVideosCompare, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment, so proxies
+// don't time it out.
+const sseHeartbeat = 15 * time.Second
+
+// handlerVideoEvents streams the progress of one of the user's videos as
+// server-sent events: "status" with the status when the stream opens,
+// "progress" while the upload is received, processed and stored, and the
+// lifecycle events sent to webhooks. The stream ends once the video is
+// ready, failed or deleted.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	events, last, unsubscribe := cfg.progress.subscribe(video.ID)
+	defer unsubscribe()
+	// read the status again now that no event can be missed
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(video.ID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// streams outlive any write timeout
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e progressEvent) error {
+		dat, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, dat); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(progressEvent{Event: "status", Status: video.Status}); err != nil {
+		return
+	}
+	if video.Status == database.VideoStatusReady || video.Status == database.VideoStatusFailed || video.Status == database.VideoStatusRejected {
+		return
+	}
+	if last != nil {
+		if err := send(*last); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// the server is shutting down
+				return
+			}
+			if err := send(e); err != nil || e.final() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
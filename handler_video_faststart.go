@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// isFastStart walks the top level mp4 boxes and reports whether the moov
+// box comes before mdat, i.e. whether playback can start before the whole
+// file has been downloaded.
+func isFastStart(filePath string) (bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(f, header[:8]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, fmt.Errorf("no moov box found")
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(f, header[8:16]); err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+
+		switch boxType {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		if size == 0 {
+			return false, fmt.Errorf("no moov box found")
+		}
+		if size < headerLen {
+			return false, fmt.Errorf("invalid size %d for box %q", size, boxType)
+		}
+		if _, err := f.Seek(size-headerLen, io.SeekCurrent); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerVideoFastStart(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Video
+		Reprocessed bool `json:"reprocessed"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	key, err := videoKey(video)
+	if errors.Is(err, errVideoArchived) {
+		respondWithError(w, http.StatusConflict, "Video is archived, restore it first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", err)
+		return
+	}
+
+	ctx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	localPath, err := cfg.downloadVideoToTemp(ctx, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(localPath)
+
+	fastStart, err := isFastStart(localPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video", err)
+		return
+	}
+
+	reprocessed := false
+	if !fastStart {
+		// the stored video was processed on upload already, only move its
+		// moov box instead of normalizing the audio a second time
+		fsVideo, err := processVideoForFastStart(ctx, localPath, processingOptions{})
+		if err != nil {
+			respondMediaError(w, "Couldn't process video", err)
+			return
+		}
+		defer os.Remove(fsVideo)
+
+		err = cfg.putObjectFile(ctx, key, fsVideo, "video/mp4")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot put to storage", err)
+			return
+		}
+		reprocessed = true
+
+		videoBytes, err := cfg.measureVideoBytes(ctx, video.ID, key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't measure video", err)
+			return
+		}
+		video, err = cfg.updateVideo(r.Context(), video.ID, func(v *database.Video) {
+			v.VideoBytes = videoBytes
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+
+	presignedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Video:       presignedVideo,
+		Reprocessed: reprocessed,
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// mp4Box returns a box of the given type with size bytes of payload.
+func mp4Box(boxType string, size int) []byte {
+	box := make([]byte, 8+size)
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	copy(box[4:], boxType)
+	return box
+}
+
+func TestIsFastStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		boxes   []string
+		want    bool
+		wantErr bool
+	}{
+		{"faststart", []string{"ftyp", "moov", "mdat"}, true, false},
+		{"moov last", []string{"ftyp", "mdat", "moov"}, false, false},
+		{"free before moov", []string{"ftyp", "free", "moov", "mdat"}, true, false},
+		{"no moov", []string{"ftyp", "free"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var file bytes.Buffer
+			for _, boxType := range tt.boxes {
+				file.Write(mp4Box(boxType, 24))
+			}
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := isFastStart(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isFastStart = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoFastStartHandler(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "a@example.com")
+	bob := createTestUser(t, cfg, "b@example.com")
+	var fastStart bytes.Buffer
+	for _, boxType := range []string{"ftyp", "moov", "mdat"} {
+		fastStart.Write(mp4Box(boxType, 24))
+	}
+	uploaded := createTestVideo(t, cfg, alice, "uploaded")
+	loc := putTestObject(t, cfg, "landscape/"+uploaded.ID.String()+".mp4", fastStart.String(), "video/mp4")
+	if _, err := cfg.updateVideo(context.Background(), uploaded.ID, func(v *database.Video) { v.VideoObject = loc }); err != nil {
+		t.Fatal(err)
+	}
+	notUploaded := createTestVideo(t, cfg, alice, "not uploaded")
+
+	tests := []struct {
+		name    string
+		userID  uuid.UUID
+		videoID uuid.UUID
+		want    int
+	}{
+		{"missing", alice, uuid.New(), http.StatusNotFound},
+		{"not owner", bob, uploaded.ID, http.StatusForbidden},
+		{"not uploaded", alice, notUploaded.ID, http.StatusBadRequest},
+		{"already faststart", alice, uploaded.ID, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/videos/"+tt.videoID.String()+"/faststart", nil)
+			w := serveAs(t, cfg, tt.userID, "POST /api/videos/{videoID}/faststart", withWorkspace(cfg.handlerVideoFastStart), r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Reprocessed bool `json:"reprocessed"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Reprocessed {
+				t.Error("faststart video was reprocessed")
+			}
+		})
+	}
+}
+
+func TestVideoFastStartCorrectsFixture(t *testing.T) {
+	// the mp4 muxer writes moov after the media unless asked not to
+	fixture := writeTestVideo(t, 2)
+	if fastStart, err := isFastStart(fixture); err != nil || fastStart {
+		t.Fatalf("fixture is already faststart (err %v)", err)
+	}
+
+	cfg := newTestConfig(t)
+	userID := createTestUser(t, cfg, "a@example.com")
+	video := createTestVideo(t, cfg, userID, "slow start")
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := putTestObject(t, cfg, "landscape/"+video.ID.String()+".mp4", string(data), "video/mp4")
+	if _, err := cfg.updateVideo(context.Background(), video.ID, func(v *database.Video) { v.VideoObject = loc }); err != nil {
+		t.Fatal(err)
+	}
+
+	faststart := func() bool {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/videos/"+video.ID.String()+"/faststart", nil)
+		w := serveAs(t, cfg, userID, "POST /api/videos/{videoID}/faststart", withWorkspace(cfg.handlerVideoFastStart), r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Reprocessed bool `json:"reprocessed"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Reprocessed
+	}
+	if !faststart() {
+		t.Fatal("non-faststart video wasn't reprocessed")
+	}
+
+	body, err := cfg.storage.Get(context.Background(), loc.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	stored := filepath.Join(t.TempDir(), "stored.mp4")
+	f, err := os.Create(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(f, body)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fastStart, err := isFastStart(stored); err != nil || !fastStart {
+		t.Fatalf("stored video isn't faststart (err %v)", err)
+	}
+	info, err := os.Stat(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.VideoBytes != info.Size() {
+		t.Errorf("video bytes = %d, want the rewritten size %d", updated.VideoBytes, info.Size())
+	}
+
+	if faststart() {
+		t.Error("faststart video was reprocessed again")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const jobKindImportVideo = "import_video"
+
+const (
+	// maxImportRedirects is how many redirects an import follows, enough
+	// for CDNs and signed download links.
+	maxImportRedirects = 5
+	importDialTimeout  = 30 * time.Second
+)
+
+var (
+	errImportSource   = errors.New("import source refused the download")
+	errImportTooLarge = errors.New("import source is too large")
+)
+
+// importVideoPayload shares video_id with processVideoPayload, failed imports
+// are handled by failVideoJob.
+type importVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+	URL     string    `json:"url"`
+	// Trace identifies the request that queued the job.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// newImportClient returns the client remote videos are downloaded with, see
+// newOutboundDialer. How long a download may take is up to the job's
+// context.
+func newImportClient(allowPrivate bool) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:       http.ProxyFromEnvironment,
+			DialContext: newOutboundDialer(importDialTimeout, allowPrivate).DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxImportRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxImportRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// parseImportURL accepts absolute http and https URLs.
+func parseImportURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("url must be http or https")
+	}
+	if u.Host == "" {
+		return nil, errors.New("url has no host")
+	}
+	return u, nil
+}
+
+// handlerVideoImport queues a download of the video from a URL. The
+// download goes through the same checks and processing as an upload; the
+// video is uploading until it is done.
+func (cfg *apiConfig) handlerVideoImport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	source, err := parseImportURL(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid url: "+err.Error(), err)
+		return
+	}
+	if video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+		respondWithError(w, http.StatusConflict, "Video is being uploaded or processed", errVideoBusy)
+		return
+	}
+	remaining, used, err := cfg.remainingQuota(r.Context(), video.UserID, video.VideoBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+	if remaining < 1 {
+		cfg.respondQuotaExceeded(w, used, 1)
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).SetVideoStatus(video.ID, database.VideoStatusUploading); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+	_, err = cfg.jobs.Enqueue(jobKindImportVideo, importVideoPayload{
+		VideoID: video.ID,
+		URL:     source.String(),
+		Trace:   traceCarrier(r.Context()),
+	})
+	if err != nil {
+		if err := cfg.db.WithContext(r.Context()).SetVideoStatus(video.ID, video.Status); err != nil {
+			requestLogger(r.Context()).Warn("cannot restore video status", "video_id", video.ID, "err", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue import", err)
+		return
+	}
+	video.Status = database.VideoStatusUploading
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// importVideoJob downloads the source of an import into staging and hands
+// it to the processing job. Sources that are refused, too large or not a
+// supported video fail without retrying.
+func (cfg *apiConfig) importVideoJob(ctx context.Context, job database.Job) (err error) {
+	var payload importVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "video.import", linkedSpanOptions(payload.Trace,
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.String("job.id", job.ID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	)...)
+	defer func() { endSpan(span, err) }()
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		// the video was deleted in the meantime, nothing left to do
+		return nil
+	}
+
+	remaining, _, err := cfg.remainingQuota(ctx, video.UserID, video.VideoBytes)
+	if err != nil {
+		return err
+	}
+	limits, err := cfg.uploadLimitsFor(ctx, video.UserID)
+	if err != nil {
+		return err
+	}
+	limit := min(cfg.importMaxSize, limits.Video, remaining)
+	ctx, cancel := context.WithTimeout(cfg.withVideoProgress(ctx, video.ID), cfg.importTimeout)
+	defer cancel()
+
+	upload, err := cfg.downloadImport(ctx, video.ID, payload.URL, limit)
+	if err != nil {
+		return err
+	}
+	recordUpload("import", upload.Size)
+	_, err = cfg.enqueueVideoProcessing(ctx, video, upload)
+	return err
+}
+
+// downloadImport stages the video at rawURL, refusing more than limit
+// bytes.
+func (cfg *apiConfig) downloadImport(ctx context.Context, videoID uuid.UUID, rawURL string, limit int64) (videoUpload, error) {
+	source, err := parseImportURL(rawURL)
+	if err != nil {
+		return videoUpload{}, jobs.Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return videoUpload{}, jobs.Permanent(err)
+	}
+	resp, err := cfg.importClient.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return videoUpload{}, jobs.Permanent(err)
+	}
+	if err != nil {
+		return videoUpload{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%w: %s", errImportSource, resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return videoUpload{}, err
+		}
+		return videoUpload{}, jobs.Permanent(err)
+	}
+	if resp.ContentLength > limit {
+		return videoUpload{}, jobs.Permanent(fmt.Errorf("%w: %d bytes, at most %d allowed", errImportTooLarge, resp.ContentLength, limit))
+	}
+	mediaType := importMediaType(resp.Header.Get("Content-Type"), source)
+	if err := mimeCheckVideo(mediaType); err != nil {
+		return videoUpload{}, jobs.Permanent(fmt.Errorf("%w: %v", errUnsupportedMediaType, err))
+	}
+
+	body := progressBody(ctx, http.MaxBytesReader(nil, resp.Body, limit), resp.ContentLength)
+	src, err := peekVideoContent(body, mediaType)
+	if errors.Is(err, errContentMismatch) || isBodyTooLarge(err) {
+		return videoUpload{}, jobs.Permanent(err)
+	}
+	if err != nil {
+		return videoUpload{}, err
+	}
+	upload, err := cfg.stageVideo(ctx, videoID, src, mediaType)
+	if isBodyTooLarge(err) {
+		return videoUpload{}, jobs.Permanent(fmt.Errorf("%w: more than %d bytes", errImportTooLarge, limit))
+	}
+	return upload, err
+}
+
+// importMediaType is the media type a source is served with. Generic types,
+// which object stores often serve videos with, are replaced by the one of
+// the file extension in the URL.
+func importMediaType(contentType string, source *url.URL) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return mediaType
+	}
+	ext := strings.TrimPrefix(path.Ext(source.Path), ".")
+	if ext == "" {
+		return mediaType
+	}
+	return extToMime(strings.ToLower(ext))
+}
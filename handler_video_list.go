@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+	// presignConcurrency bounds how many videos of a page are signed at
+	// once. Presigning itself is local, but with PRESIGN_HEAD_CHECK every
+	// video costs a request to the bucket.
+	presignConcurrency = 8
+)
+
+var errListOthersVideos = errors.New("you can only list your own videos")
+
+var videoSorts = map[string]database.VideoSort{
+	"created": database.VideoSortCreated,
+	"updated": database.VideoSortUpdated,
+	"title":   database.VideoSortTitle,
+}
+
+var videoStatuses = map[string]database.VideoStatus{
+	string(database.VideoStatusPending):    database.VideoStatusPending,
+	string(database.VideoStatusUploading):  database.VideoStatusUploading,
+	string(database.VideoStatusProcessing): database.VideoStatusProcessing,
+	string(database.VideoStatusReady):      database.VideoStatusReady,
+	string(database.VideoStatusFailed):     database.VideoStatusFailed,
+	string(database.VideoStatusRejected):   database.VideoStatusRejected,
+}
+
+// listCursor is the position after the last video of a page. It records
+// the sort it was made for, so it can't be replayed against another one.
+type listCursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d,omitempty"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func encodeListCursor(sort string, desc bool, video database.Video) string {
+	cursor := listCursor{Sort: sort, Desc: desc, ID: video.ID}
+	switch videoSorts[sort] {
+	case database.VideoSortCreated:
+		cursor.Value = video.CreatedAt.UTC().Format(time.RFC3339)
+	case database.VideoSortUpdated:
+		cursor.Value = video.UpdatedAt.UTC().Format(time.RFC3339)
+	case database.VideoSortTitle:
+		cursor.Value = video.Title
+	}
+	dat, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+// decodeListCursor returns the video a cursor continues after, with only
+// its ID and the sorted column set.
+func decodeListCursor(raw, sort string, desc bool) (*database.Video, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(dat, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort || cursor.Desc != desc {
+		return nil, errors.New("cursor belongs to a different sort order")
+	}
+	after := &database.Video{ID: cursor.ID}
+	switch videoSorts[sort] {
+	case database.VideoSortCreated, database.VideoSortUpdated:
+		t, err := time.Parse(time.RFC3339, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		after.CreatedAt, after.UpdatedAt = t, t
+	case database.VideoSortTitle:
+		after.Title = cursor.Value
+	}
+	return after, nil
+}
+
+// parseListVideosParams reads the query of GET /api/videos: limit, cursor,
+// sort (created, updated or title), order (asc or desc, newest or A-Z
+// first by default), owner, status and tags (comma separated) and
+// aspect_ratio.
+func parseListVideosParams(r *http.Request, userID uuid.UUID) (database.ListVideosParams, string, error) {
+	q := r.URL.Query()
+	params := database.ListVideosParams{UserID: userID, Limit: defaultListLimit}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			return params, "", invalidInput("query.limit", fmt.Sprintf("must be between 1 and %d", maxListLimit), err)
+		}
+		params.Limit = n
+	}
+
+	sortName := q.Get("sort")
+	if sortName == "" {
+		sortName = "created"
+	}
+	if err := checkOneOf("query.sort", sortName, "created", "updated", "title"); err != nil {
+		return params, "", err
+	}
+	sort := videoSorts[sortName]
+	params.Sort = sort
+	switch order := q.Get("order"); order {
+	case "":
+		params.Descending = sort != database.VideoSortTitle
+	default:
+		if err := checkOneOf("query.order", order, "asc", "desc"); err != nil {
+			return params, "", err
+		}
+		params.Descending = order == "desc"
+	}
+
+	if owner := q.Get("owner"); owner != "" && owner != "me" {
+		ownerID, err := uuid.Parse(owner)
+		if err != nil {
+			return params, "", invalidInput("query.owner", "must be a user ID or me", err)
+		}
+		if ownerID != userID {
+			return params, "", errListOthersVideos
+		}
+	}
+
+	if v := q.Get("status"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			status, ok := videoStatuses[strings.TrimSpace(name)]
+			if !ok {
+				return params, "", invalidInput("query.status", fmt.Sprintf("has unknown status %q", name), nil)
+			}
+			params.Statuses = append(params.Statuses, status)
+		}
+	}
+
+	if v := q.Get("aspect_ratio"); v != "" {
+		if err := checkOneOf("query.aspect_ratio", v, "16:9", "9:16", "other"); err != nil {
+			return params, "", err
+		}
+		params.AspectRatio = v
+	}
+
+	tags, err := parseTagsQuery(r)
+	if err != nil {
+		return params, "", err
+	}
+	params.Tags = tags
+
+	if cursor := q.Get("cursor"); cursor != "" {
+		after, err := decodeListCursor(cursor, sortName, params.Descending)
+		if err != nil {
+			return params, "", invalidInput("query.cursor", "is invalid", err)
+		}
+		params.After = after
+	}
+	return params, sortName, nil
+}
+
+func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	cfg.respondWithVideoPage(w, r, userID)
+}
+
+// respondWithVideoPage writes the page of the user's videos the query of
+// the request asks for, see parseListVideosParams.
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
+	params, sortName, err := parseListVideosParams(r, userID)
+	if errors.Is(err, errListOthersVideos) {
+		respondWithError(w, http.StatusForbidden, "You can only list your own videos", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// one extra row tells whether there is a next page
+	limit := params.Limit
+	params.Limit++
+	videos, err := cfg.db.WithContext(r.Context()).ListVideos(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	resp := response{}
+	if len(videos) > limit {
+		videos = videos[:limit]
+		resp.NextCursor = encodeListCursor(sortName, params.Descending, videos[limit-1])
+	}
+
+	etag, err := cfg.computeETag(storedVideos(videos), userID, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't compute ETag", err)
+		return
+	}
+	if respondNotModified(w, r, etag) {
+		return
+	}
+
+	resp.Videos, err = cfg.signVideos(r.Context(), videos, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// signVideos presigns the video and thumbnail URLs of all videos in the
+// list for viewerID, several at a time.
+func (cfg *apiConfig) signVideos(ctx context.Context, videos []database.Video, viewerID uuid.UUID) ([]database.Video, error) {
+	signed := make([]database.Video, len(videos))
+	errs := make([]error, len(videos))
+	sem := make(chan struct{}, presignConcurrency)
+	var wg sync.WaitGroup
+	for i, video := range videos {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			signed[i], errs[i] = cfg.signListedVideo(ctx, video, viewerID)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func (cfg *apiConfig) signListedVideo(ctx context.Context, video database.Video, viewerID uuid.UUID) (database.Video, error) {
+	signed, err := cfg.dbVideoToSignedVideo(ctx, video, viewerID)
+	if errors.Is(err, errVideoObjectNotFound) {
+		// the row outlived its object, list it as not uploaded
+		video.VideoObject = nil
+		signed, err = cfg.dbVideoToSignedVideo(ctx, video, viewerID)
+	}
+	if errors.Is(err, errVideoPrivate) {
+		// listed without its URLs
+		video.ThumbnailURL = nil
+		return video, nil
+	}
+	return signed, err
+}
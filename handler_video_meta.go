@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
@@ -14,27 +20,31 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" {
+		if _, err := parseVisibility(string(params.Visibility)); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if err := validateSchedule(params.PublishAt, params.ExpiresAt); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.db.WithContext(r.Context()).CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
@@ -43,97 +53,272 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+// optionalTime is a JSON time field that tells null, which clears the
+// field, from leaving it out, which leaves it alone.
+type optionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (o *optionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+// update returns the database update for the field, nil when it was left
+// out.
+func (o optionalTime) update() *sql.NullTime {
+	if !o.Set {
+		return nil
+	}
+	if o.Value == nil {
+		return &sql.NullTime{}
+	}
+	return &sql.NullTime{Time: *o.Value, Valid: true}
+}
+
+// videoMetaUpdate is the body of a video update.
+type videoMetaUpdate struct {
+	Title       *string      `json:"title"`
+	Description *string      `json:"description"`
+	Visibility  *string      `json:"visibility"`
+	Tags        *[]string    `json:"tags"`
+	PublishAt   optionalTime `json:"publish_at"`
+	ExpiresAt   optionalTime `json:"expires_at"`
+	Version     *int64       `json:"version"`
+}
+
+// handlerVideoMetaUpdate changes the fields of a video present in the
+// request body: title, description, visibility, tags, which replace the
+// current ones, and the publish_at and expires_at schedule, which null
+// clears. An If-Match header or a version in the body makes the edit
+// conditional: it fails with 412 when the video was changed since, instead
+// of overwriting that change.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	decoder := json.NewDecoder(r.Body)
+	params := videoMetaUpdate{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	update, invalid := validateVideoMetaUpdate(video, params)
+	if len(invalid) > 0 {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusBadRequest,
+			Code:    apierror.CodeInvalidRequest,
+			Message: "Some fields are invalid",
+			Details: map[string]map[string]string{"fields": invalid},
+		})
+		return
+	}
+
+	// with a precondition, the update only applies to the version it was
+	// checked against
+	var expected *int64
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		// the ETag may have been sent in the window before
+		now := time.Now()
+		etag, err := cfg.computeETag(newStoredVideo(video), video.UserID, now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't compute ETag", err)
+			return
+		}
+		previous, err := cfg.computeETag(newStoredVideo(video), video.UserID, now.Add(-cfg.presignWindow()))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't compute ETag", err)
+			return
+		}
+		if !etagMatchesStrong(ifMatch, etag, previous) {
+			respondWithError(w, http.StatusPreconditionFailed, "Video was changed since the version in If-Match", database.ErrVersionMismatch)
+			return
+		}
+		expected = &video.Version
+	}
+	if params.Version != nil {
+		if *params.Version != video.Version {
+			respondWithError(w, http.StatusPreconditionFailed, fmt.Sprintf("Video is at version %d, not %d", video.Version, *params.Version), database.ErrVersionMismatch)
+			return
+		}
+		expected = &video.Version
+	}
+
+	err := cfg.db.WithContext(r.Context()).UpdateVideoMeta(video.ID, expected, update)
+	if errors.Is(err, database.ErrVersionMismatch) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was changed while updating it, try again", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err = cfg.db.WithContext(r.Context()).GetVideo(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	etag, err := cfg.computeETag(newStoredVideo(video), video.UserID, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't compute ETag", err)
 		return
 	}
-
-	err = cfg.db.DeleteVideo(videoID)
+	setETag(w, etag)
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, video)
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// validateVideoMetaUpdate checks and normalizes the fields of an update to
+// video, returning what is wrong with each invalid one by its name.
+func validateVideoMetaUpdate(video database.Video, params videoMetaUpdate) (database.UpdateVideoMetaParams, map[string]string) {
+	update := database.UpdateVideoMetaParams{}
+	invalid := map[string]string{}
+	if title := params.Title; title != nil {
+		t := strings.TrimSpace(*title)
+		switch {
+		case t == "":
+			invalid["title"] = "title must not be empty"
+		case len([]rune(t)) > maxVideoTitleLength:
+			invalid["title"] = fmt.Sprintf("title is longer than %d characters", maxVideoTitleLength)
+		}
+		update.Title = &t
+	}
+	if description := params.Description; description != nil {
+		if len([]rune(*description)) > maxVideoDescriptionLength {
+			invalid["description"] = fmt.Sprintf("description is longer than %d characters", maxVideoDescriptionLength)
+		}
+		update.Description = description
+	}
+	if visibility := params.Visibility; visibility != nil {
+		v, err := parseVisibility(*visibility)
+		if err != nil {
+			invalid["visibility"] = err.Error()
+		}
+		update.Visibility = &v
+	}
+	if tags := params.Tags; tags != nil {
+		normalized, err := normalizeTags(*tags)
+		switch {
+		case err != nil:
+			invalid["tags"] = err.Error()
+		case len(normalized) > maxTagsPerVideo:
+			invalid["tags"] = fmt.Sprintf("a video can have at most %d tags", maxTagsPerVideo)
+		}
+		update.Tags = &normalized
+	}
+	publishAt, expiresAt := video.PublishAt, video.ExpiresAt
+	if params.PublishAt.Set {
+		publishAt = params.PublishAt.Value
+		update.PublishAt = params.PublishAt.update()
+	}
+	if params.ExpiresAt.Set {
+		expiresAt = params.ExpiresAt.Value
+		update.ExpiresAt = params.ExpiresAt.update()
+	}
+	if err := validateSchedule(publishAt, expiresAt); err != nil {
+		invalid["expires_at"] = err.Error()
+	}
+	return update, invalid
 }
 
-func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
-		return
+// validateSchedule checks a video doesn't expire before it is published.
+func validateSchedule(publishAt, expiresAt *time.Time) error {
+	if publishAt != nil && expiresAt != nil && !expiresAt.After(*publishAt) {
+		return errors.New("expires_at must be after publish_at")
 	}
+	return nil
+}
 
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+// handlerVideoMetaDelete moves a video to the trash, or deletes it for good
+// when the trash is turned off.
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
 		return
 	}
-	if video.VideoURL != nil && *video.VideoURL != "" {
-		presignedVideo, err := cfg.dbVideoToSignedVideo(video)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+
+	if cfg.trashRetention == 0 {
+		if err := cfg.deleteVideo(r.Context(), video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 			return
 		}
-		video = presignedVideo
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
-	respondWithJSON(w, http.StatusOK, video)
+	// processing a trashed video would have nowhere to store its results
+	if video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+		respondWithError(w, http.StatusConflict, "Video is being uploaded or processed", errVideoBusy)
+		return
+	}
+	if err := cfg.trashVideo(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+// deleteVideo removes a video and then everything stored for it.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	if err := cfg.purgeVideo(ctx, video); err != nil {
+		return err
+	}
+	cfg.emitVideoEvent(eventVideoDeleted, video)
+	return nil
+}
+
+// purgeVideo is deleteVideo without telling anyone, for videos clients were
+// told about when they went to the trash.
+func (cfg *apiConfig) purgeVideo(ctx context.Context, video database.Video) error {
+	// drop the row first: once nothing references the objects a failed
+	// cleanup only leaves orphans behind, never a video without its file
+	if err := cfg.db.WithContext(ctx).DeleteVideo(video.ID); err != nil {
+		return err
+	}
+	// the row is gone, finish the cleanup even if the client hangs up
+	cleanupCtx, cancel := detach(ctx, cleanupTimeout)
+	defer cancel()
+	if err := cfg.deleteVideoObjects(cleanupCtx, video); err != nil {
+		requestLogger(ctx).Warn("cannot clean up video objects", "video_id", video.ID, "err", err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
+	video, viewerID, ok := cfg.viewableVideoFromPath(w, r)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	etag, err := cfg.computeETag(newStoredVideo(video), viewerID, time.Now())
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't compute ETag", err)
 		return
 	}
-
-	videos, err := cfg.db.GetVideos(userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+	if respondNotModified(w, r, etag) {
 		return
 	}
-
-	for i, video := range videos {
-		// Only generate presigned URL if video has been uploaded
-		if video.VideoURL != nil && *video.VideoURL != "" {
-			video, err = cfg.dbVideoToSignedVideo(video)
-			if err != nil {
-				respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-				return
-			}
-			videos[i] = video
-		}
+	presignedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, viewerID)
+	if errors.Is(err, errVideoObjectNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file not found", err)
+		return
 	}
-
-	respondWithJSON(w, http.StatusOK, videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+		return
+	}
+	video = presignedVideo
+	respondWithJSON(w, http.StatusOK, video)
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func previewKey(videoID uuid.UUID, seconds int) string {
+	return fmt.Sprintf("previews/%s-%ds.mp4", videoID, seconds)
+}
+
+// previewClipArgs builds the ffmpeg arguments copying the first seconds
+// of input to outPath. -t follows the input, so it bounds the output.
+func previewClipArgs(input, outPath string, seconds int) []string {
+	return []string{
+		"-y",
+		"-i", input,
+		"-t", fmt.Sprintf("%d", seconds),
+		"-c", "copy",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		outPath,
+	}
+}
+
+// cutPreviewClip copies the first seconds of input into a faststart mp4.
+// input may be a local path or a URL ffmpeg can read.
+func cutPreviewClip(ctx context.Context, input, outPath string, seconds int) error {
+	cmd := exec.CommandContext(ctx, ffmpegBin, previewClipArgs(input, outPath, seconds)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		os.Remove(outPath)
+		return fmt.Errorf("ffmpeg preview failed: %w\nstderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoPreview(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		PreviewURL string `json:"preview_url"`
+		Seconds    int    `json:"seconds"`
+	}
+
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	key, err := videoKey(video)
+	if errors.Is(err, errVideoArchived) {
+		respondWithError(w, http.StatusConflict, "Video is archived, restore it first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Video has not been uploaded yet", err)
+		return
+	}
+
+	clipKey := previewKey(video.ID, cfg.previewSeconds)
+	_, err = cfg.storage.Head(r.Context(), clipKey)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check preview", err)
+		return
+	}
+	if err != nil {
+		// ffmpeg only needs the head of the file, so read it over a
+		// presigned URL rather than downloading the whole video
+		sourceURL, err := cfg.storage.Presign(r.Context(), key, sourceURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+			return
+		}
+
+		workDir, err := mkdirTemp(r.Context(), "preview-")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create temp dir", err)
+			return
+		}
+		defer os.RemoveAll(workDir)
+
+		clipPath := filepath.Join(workDir, "preview.mp4")
+		ctx, cancel := cfg.withMediaTimeout(r.Context())
+		defer cancel()
+		if err := cutPreviewClip(ctx, sourceURL, clipPath, cfg.previewSeconds); err != nil {
+			respondMediaError(w, "Couldn't cut preview", err)
+			return
+		}
+
+		err = cfg.putObjectFile(r.Context(), clipKey, clipPath, "video/mp4")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot put to storage", err)
+			return
+		}
+	}
+
+	previewURL, err := cfg.videoObjectURL(r.Context(), video, clipKey, cfg.previewURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the preview", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		PreviewURL: previewURL,
+		Seconds:    cfg.previewSeconds,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestPreviewClipArgs(t *testing.T) {
+	for _, seconds := range []int{3, 10} {
+		cfg := apiConfig{previewSeconds: seconds}
+		args := previewClipArgs("in.mp4", "out.mp4", cfg.previewSeconds)
+		i := slices.Index(args, "-t")
+		if i < 0 {
+			t.Fatalf("no -t in %q", args)
+		}
+		if want := []string{"-t", strconv.Itoa(seconds)}; !slices.Equal(args[i:i+2], want) {
+			t.Errorf("duration args = %q, want %q", args[i:i+2], want)
+		}
+		// before the input it would only limit what is read
+		if in := slices.Index(args, "-i"); in < 0 || in > i {
+			t.Errorf("-t isn't an output option in %q", args)
+		}
+		if args[len(args)-1] != "out.mp4" {
+			t.Errorf("output isn't last in %q", args)
+		}
+	}
+}
+
+func TestCutPreviewClipBounded(t *testing.T) {
+	// a keyframe every second, so the stream copy can cut anywhere
+	video := writeTestVideo(t, 6, "-g", "25")
+	ctx := context.Background()
+	for _, seconds := range []int{2, 4} {
+		out := filepath.Join(t.TempDir(), "preview.mp4")
+		if err := cutPreviewClip(ctx, video, out, seconds); err != nil {
+			t.Fatal(err)
+		}
+		info, err := probeVideoInfo(ctx, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(info.Duration-float64(seconds)) > 0.5 {
+			t.Errorf("%ds preview lasts %gs", seconds, info.Duration)
+		}
+	}
+}
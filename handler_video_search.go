@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// maxSearchTerms bounds the terms of a query, each of which is a separate
+// prefix lookup in the index.
+const maxSearchTerms = 8
+
+// searchTerms splits a query into the words the index is searched for.
+// Anything but letters and digits separates words, so the query syntax of
+// the index can't be used, or broken, from outside.
+func searchTerms(q string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// highlightHTML escapes text from the search index and marks the matched
+// terms up with <mark>.
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, database.HighlightStart, "<mark>")
+	return strings.ReplaceAll(text, database.HighlightEnd, "</mark>")
+}
+
+// handlerVideosSearch searches the titles and descriptions of the user's
+// videos. Results are ranked, titles weighing more than descriptions, and
+// come with the matched terms highlighted as HTML.
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Video              database.Video `json:"video"`
+		Rank               float64        `json:"rank"`
+		TitleHighlight     string         `json:"title_highlight"`
+		DescriptionSnippet string         `json:"description_snippet"`
+	}
+	type response struct {
+		Results    []result `json:"results"`
+		NextOffset *int     `json:"next_offset,omitempty"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	terms := searchTerms(q.Get("q"))
+	if len(terms) == 0 {
+		respondWithError(w, http.StatusBadRequest, "q must contain at least one word", nil)
+		return
+	}
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), err)
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must not be negative", err)
+			return
+		}
+		offset = n
+	}
+
+	tags, err := parseTagsQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// one extra row tells whether there is a next page
+	matches, err := cfg.db.WithContext(r.Context()).SearchVideos(database.SearchVideosParams{
+		UserID: userID,
+		Terms:  terms,
+		Tags:   tags,
+		Limit:  limit + 1,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+	resp := response{Results: []result{}}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		next := offset + limit
+		resp.NextOffset = &next
+	}
+
+	videos := make([]database.Video, len(matches))
+	for i, match := range matches {
+		videos[i] = match.Video
+	}
+	videos, err = cfg.signVideos(r.Context(), videos, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	for i, match := range matches {
+		resp.Results = append(resp.Results, result{
+			Video:              videos[i],
+			Rank:               match.Rank,
+			TitleHighlight:     highlightHTML(match.TitleHighlight),
+			DescriptionSnippet: highlightHTML(match.DescriptionSnippet),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
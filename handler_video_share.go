@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// handlerVideoShareCreate mints a share link for one of the user's videos.
+// The body may set ttl_seconds, 24 hours by default, and max_views. The
+// token is only returned here.
+func (cfg *apiConfig) handlerVideoShareCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TTLSeconds *int `json:"ttl_seconds"`
+		MaxViews   *int `json:"max_views"`
+	}
+	type response struct {
+		database.ShareLink
+		Token string `json:"token"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	// an empty body takes the defaults
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	ttl := defaultShareTTL
+	if params.TTLSeconds != nil {
+		ttl = time.Duration(*params.TTLSeconds) * time.Second
+		if ttl <= 0 || ttl > maxShareTTL {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(maxShareTTL.Seconds())), nil)
+			return
+		}
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_views must be at least 1", nil)
+		return
+	}
+
+	link, err := cfg.db.WithContext(r.Context()).CreateShareLink(database.CreateShareLinkParams{
+		VideoID:   video.ID,
+		ExpiresAt: time.Now().Add(ttl),
+		MaxViews:  params.MaxViews,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+	token, err := auth.MakeShareToken(link.ID, cfg.jwtSecret, ttl)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign share token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{ShareLink: link, Token: token})
+}
+
+// handlerVideoSharesList returns the share links of one of the user's
+// videos, revoked and expired ones included.
+func (cfg *apiConfig) handlerVideoSharesList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.WithContext(r.Context()).GetShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve share links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func (cfg *apiConfig) handlerVideoShareRevoke(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	shareID, err := pathUUID(r, "shareID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+
+	link, err := cfg.db.WithContext(r.Context()).GetShareLink(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get share link", nil)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).RevokeShareLink(link.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerShareGet exchanges a share token for the shared video with its
+// URLs presigned, whatever the video's visibility. It needs no JWT and
+// counts a view of the link.
+func (cfg *apiConfig) handlerShareGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID           uuid.UUID            `json:"id"`
+		Title        string               `json:"title"`
+		Description  string               `json:"description"`
+		ThumbnailURL *string              `json:"thumbnail_url"`
+		VideoURL     *string              `json:"video_url"`
+		Renditions   database.Renditions  `json:"renditions,omitempty"`
+		AspectRatio  *string              `json:"aspect_ratio,omitempty"`
+		Status       database.VideoStatus `json:"status"`
+		ExpiresAt    time.Time            `json:"expires_at"`
+		ViewsLeft    *int                 `json:"views_left,omitempty"`
+	}
+
+	shareID, err := auth.ValidateShareToken(r.PathValue("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found or expired", err)
+		return
+	}
+	used, err := cfg.db.WithContext(r.Context()).UseShareLink(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use share link", err)
+		return
+	}
+	if !used {
+		respondWithError(w, http.StatusGone, "Share link is no longer valid", nil)
+		return
+	}
+	link, err := cfg.db.WithContext(r.Context()).GetShareLink(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(link.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ModerationStatus == database.ModerationStatusTakenDown {
+		respondWithError(w, http.StatusUnavailableForLegalReasons, "Video was taken down by a moderator", errVideoTakenDown)
+		return
+	}
+	// the link stands in for the owner
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if errors.Is(err, errVideoObjectNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+
+	resp := response{
+		ID:           video.ID,
+		Title:        video.Title,
+		Description:  video.Description,
+		ThumbnailURL: video.ThumbnailURL,
+		VideoURL:     video.VideoURL,
+		Renditions:   video.Renditions,
+		AspectRatio:  video.AspectRatio,
+		Status:       video.Status,
+		ExpiresAt:    link.ExpiresAt,
+	}
+	if link.MaxViews != nil {
+		left := *link.MaxViews - link.Views
+		resp.ViewsLeft = &left
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStatus is a cheap endpoint for clients polling a fresh
+// upload until it is playable.
+func (cfg *apiConfig) handlerVideoStatus(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID        uuid.UUID            `json:"id"`
+		Status    database.VideoStatus `json:"status"`
+		UpdatedAt time.Time            `json:"updated_at"`
+	}
+
+	video, _, ok := cfg.viewableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		ID:        video.ID,
+		Status:    video.Status,
+		UpdatedAt: video.UpdatedAt,
+	})
+}
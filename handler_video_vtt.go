@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	vttThumbnailWidth = 160
+	vttSpriteColumns  = 10
+)
+
+// showinfoFrame matches the line showinfo logs for each frame it passes.
+var showinfoFrame = regexp.MustCompile(`(?m)^\[Parsed_showinfo_\d+ @ [^\]]*\] n:\s*\d+ `)
+
+// countShowinfoFrames returns how many frames showinfo logged to stderr.
+func countShowinfoFrames(stderr string) int {
+	return len(showinfoFrame.FindAllStringIndex(stderr, -1))
+}
+
+// generateSpriteSheet renders a frame every interval seconds into a
+// columns x rows grid and returns how many tiles were filled. The fps
+// filter rounds the timestamps of the frames it picks, so the count can
+// differ from duration / interval either way.
+func generateSpriteSheet(ctx context.Context, filePath, outPath string, interval float64, thumbWidth, thumbHeight, columns, rows int) (int, error) {
+	filter := fmt.Sprintf(
+		"fps=1/%g,scale=%d:%d,showinfo,tile=%dx%d",
+		interval, thumbWidth, thumbHeight, columns, rows,
+	)
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBin,
+		"-y",
+		"-i", filePath,
+		"-vf", filter,
+		"-frames:v", "1",
+		outPath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		os.Remove(outPath)
+		return 0, fmt.Errorf("ffmpeg sprite failed: %w\nstderr: %s", err, stderr.String())
+	}
+	// frames past the grid start a sheet that isn't written
+	tiles := min(countShowinfoFrames(stderr.String()), columns*rows)
+	if tiles == 0 {
+		os.Remove(outPath)
+		return 0, fmt.Errorf("ffmpeg sprite has no tiles\nstderr: %s", stderr.String())
+	}
+	return tiles, nil
+}
+
+func formatVTTTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	h := ms / 3600000
+	ms -= h * 3600000
+	m := ms / 60000
+	ms -= m * 60000
+	s := ms / 1000
+	ms -= s * 1000
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// buildThumbnailVTT returns a WebVTT document with one cue per rendered
+// sprite tile. Cues are contiguous and the last one ends exactly at
+// duration, so players always find a preview for any seek position: tiles
+// starting after the end are dropped and the last tile stretches to the
+// end when fewer were rendered.
+func buildThumbnailVTT(spriteName string, duration, interval float64, tiles, thumbWidth, thumbHeight, columns int) (string, error) {
+	if duration <= 0 {
+		return "", fmt.Errorf("invalid duration %v", duration)
+	}
+	if tiles <= 0 {
+		return "", fmt.Errorf("invalid tile count %d", tiles)
+	}
+	count := min(tiles, int(math.Ceil(duration/interval)))
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i := 0; i < count; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		if i == count-1 {
+			end = duration
+		}
+		x := (i % columns) * thumbWidth
+		y := (i / columns) * thumbHeight
+		fmt.Fprintf(&b, "%s --> %s\n", formatVTTTimestamp(start), formatVTTTimestamp(end))
+		fmt.Fprintf(&b, "%s#xywh=%d,%d,%d,%d\n\n", spriteName, x, y, thumbWidth, thumbHeight)
+	}
+	return b.String(), nil
+}
+
+type storyboard struct {
+	VTTURL    string `json:"vtt_url"`
+	SpriteURL string `json:"sprite_url"`
+}
+
+const (
+	storyboardSpriteName = "sprite.jpg"
+	storyboardVTTName    = "thumbnails.vtt"
+)
+
+// storyboardAsset is the directory below the assets directory the
+// storyboard of a video is rendered into, sharded like local objects.
+func storyboardAsset(videoID uuid.UUID) string {
+	return storage.ShardKey("vtt/" + videoID.String())
+}
+
+func (cfg *apiConfig) storyboardDir(videoID uuid.UUID) string {
+	return filepath.Join(cfg.assetsRoot, filepath.FromSlash(storyboardAsset(videoID)))
+}
+
+func (cfg *apiConfig) storyboardURLs(videoID uuid.UUID) storyboard {
+	dir := storyboardAsset(videoID)
+	return storyboard{
+		VTTURL:    cfg.assetURL(dir + "/" + storyboardVTTName),
+		SpriteURL: cfg.assetURL(dir + "/" + storyboardSpriteName),
+	}
+}
+
+// generateStoryboard renders the sprite sheet and WebVTT track of a local
+// video file into the assets directory, one tile every
+// cfg.storyboardInterval seconds.
+func (cfg *apiConfig) generateStoryboard(ctx context.Context, videoID uuid.UUID, filePath string) (storyboard, error) {
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return storyboard{}, err
+	}
+
+	interval := cfg.storyboardInterval
+	// keep the tile aspect ratio and an even height for the scaler
+	thumbHeight := evenDimension(float64(vttThumbnailWidth) * float64(info.Height) / float64(info.Width))
+	count := int(math.Ceil(info.Duration / interval))
+	rows := (count + vttSpriteColumns - 1) / vttSpriteColumns
+
+	vttDir := cfg.storyboardDir(videoID)
+	if err := os.MkdirAll(vttDir, 0755); err != nil {
+		return storyboard{}, err
+	}
+
+	tiles, err := generateSpriteSheet(ctx, filePath, filepath.Join(vttDir, storyboardSpriteName), interval, vttThumbnailWidth, thumbHeight, vttSpriteColumns, rows)
+	if err != nil {
+		return storyboard{}, err
+	}
+
+	vtt, err := buildThumbnailVTT(storyboardSpriteName, info.Duration, interval, tiles, vttThumbnailWidth, thumbHeight, vttSpriteColumns)
+	if err != nil {
+		return storyboard{}, err
+	}
+	if err := os.WriteFile(filepath.Join(vttDir, storyboardVTTName), []byte(vtt), 0644); err != nil {
+		return storyboard{}, err
+	}
+	return cfg.storyboardURLs(videoID), nil
+}
+
+func (cfg *apiConfig) handlerVideoThumbnailVTT(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	if video.VideoObject == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	ctx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	localPath, err := cfg.downloadVideoToTemp(ctx, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(localPath)
+
+	sb, err := cfg.generateStoryboard(ctx, video.ID, localPath)
+	if err != nil {
+		respondMediaError(w, "Couldn't generate storyboard", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sb)
+}
+
+func (cfg *apiConfig) handlerVideoStoryboard(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.storyboardDir(video.ID), storyboardVTTName)); err != nil {
+		respondWithError(w, http.StatusNotFound, "Storyboard not found", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.storyboardURLs(video.ID))
+}
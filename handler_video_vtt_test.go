@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// vttCues returns the start and end timestamps and the tile fragment of
+// each cue.
+func vttCues(t *testing.T, vtt string) [][3]string {
+	t.Helper()
+	var cues [][3]string
+	for _, block := range strings.Split(strings.TrimSpace(vtt), "\n\n")[1:] {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 {
+			t.Fatalf("malformed cue %q", block)
+		}
+		start, end, ok := strings.Cut(lines[0], " --> ")
+		if !ok {
+			t.Fatalf("malformed cue timing %q", lines[0])
+		}
+		cues = append(cues, [3]string{start, end, lines[1]})
+	}
+	return cues
+}
+
+func TestBuildThumbnailVTT(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		tiles    int
+		cues     int
+		lastTile string
+	}{
+		{"tiles match", 7.3, 4, 4, "sprite.jpg#xywh=480,0,160,90"},
+		{"extra tile", 7.3, 5, 4, "sprite.jpg#xywh=480,0,160,90"},
+		{"missing tile", 7.3, 3, 3, "sprite.jpg#xywh=320,0,160,90"},
+		{"exact multiple", 8, 4, 4, "sprite.jpg#xywh=480,0,160,90"},
+		{"second row", 25, 13, 13, "sprite.jpg#xywh=320,90,160,90"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vtt, err := buildThumbnailVTT("sprite.jpg", tt.duration, 2, tt.tiles, 160, 90, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cues := vttCues(t, vtt)
+			if len(cues) != tt.cues {
+				t.Fatalf("%d cues, want %d", len(cues), tt.cues)
+			}
+			for i := 1; i < len(cues); i++ {
+				if cues[i][0] != cues[i-1][1] {
+					t.Errorf("cue %d starts at %s, previous ends at %s", i, cues[i][0], cues[i-1][1])
+				}
+			}
+			last := cues[len(cues)-1]
+			if want := formatVTTTimestamp(tt.duration); last[1] != want {
+				t.Errorf("last cue ends at %s, want %s", last[1], want)
+			}
+			if last[2] != tt.lastTile {
+				t.Errorf("last cue shows %s, want %s", last[2], tt.lastTile)
+			}
+		})
+	}
+}
+
+func TestCountShowinfoFrames(t *testing.T) {
+	stderr := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':
+[Parsed_showinfo_2 @ 0x5581c0a0c440] config in time_base: 1/25, frame_rate: 1/2
+[Parsed_showinfo_2 @ 0x5581c0a0c440] n:   0 pts:      0 pts_time:0       duration:     50
+[Parsed_showinfo_2 @ 0x5581c0a0c440] n:   1 pts:     50 pts_time:2       duration:     50
+[Parsed_showinfo_2 @ 0x5581c0a0c440] n:   2 pts:    100 pts_time:4       duration:     50
+frame=    1 fps=0.0 q=2.0 Lsize=N/A time=00:00:20.00 bitrate=N/A speed= 120x
+`
+	if got := countShowinfoFrames(stderr); got != 3 {
+		t.Errorf("countShowinfoFrames = %d, want 3", got)
+	}
+}
+
+func TestGenerateStoryboardEndsAtDuration(t *testing.T) {
+	const duration = 7.3
+	video := writeTestVideo(t, duration)
+	cfg := newTestConfig(t)
+	cfg.storyboardInterval = 2
+	ctx := context.Background()
+
+	info, err := probeVideoInfo(ctx, video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoID := uuid.New()
+	if _, err := cfg.generateStoryboard(ctx, videoID, video); err != nil {
+		t.Fatal(err)
+	}
+	vtt, err := os.ReadFile(filepath.Join(cfg.storyboardDir(videoID), storyboardVTTName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cues := vttCues(t, string(vtt))
+	if got, want := cues[len(cues)-1][1], formatVTTTimestamp(info.Duration); got != want {
+		t.Errorf("last cue ends at %s, want %s", got, want)
+	}
+}
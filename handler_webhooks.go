@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWebhooksPerUser   = 10
+	webhookDeliveryLimit = 50
+)
+
+// validateWebhookURL checks a callback URL is an absolute http(s) URL.
+// Where it points is checked when deliveries connect.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	return nil
+}
+
+// ownedWebhookFromPath loads the webhook in the path and checks the
+// requesting user owns it. It writes the error response itself.
+func (cfg *apiConfig) ownedWebhookFromPath(w http.ResponseWriter, r *http.Request) (database.Webhook, bool) {
+	webhookID, err := pathUUID(r, "webhookID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return database.Webhook{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return database.Webhook{}, false
+	}
+
+	webhook, err := cfg.db.WithContext(r.Context()).GetWebhook(webhookID)
+	if err != nil || webhook.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get webhook", err)
+		return database.Webhook{}, false
+	}
+	if webhook.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this webhook", errNotOwner)
+		return database.Webhook{}, false
+	}
+	return webhook, true
+}
+
+// handlerWebhookCreate registers a callback URL for some or, when events is
+// left out, all events. The signing secret is only returned here.
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateWebhookURL(params.URL); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	events := webhookEvents
+	if len(params.Events) > 0 {
+		events = []string{}
+		for _, event := range params.Events {
+			if !slices.Contains(webhookEvents, event) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q", event), nil)
+				return
+			}
+			if !slices.Contains(events, event) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	existing, err := cfg.db.WithContext(r.Context()).GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("a user can have at most %d webhooks", maxWebhooksPerUser), nil)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	webhook, err := cfg.db.WithContext(r.Context()).CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    params.URL,
+		Secret: hex.EncodeToString(secret),
+		Events: events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{Webhook: webhook, Secret: webhook.Secret})
+}
+
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+
+	webhooks, err := cfg.db.WithContext(r.Context()).GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.ownedWebhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.WithContext(r.Context()).DeleteWebhook(webhook.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookDeliveries returns the latest deliveries to a webhook with
+// the outcome of their last attempt.
+func (cfg *apiConfig) handlerWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.ownedWebhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := cfg.db.WithContext(r.Context()).GetWebhookDeliveries(webhook.ID, webhookDeliveryLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const hlsPlaylistName = "index.m3u8"
+
+// hlsDefaultBandwidth is announced for videos whose bit rate wasn't
+// probed, the master playlist can't leave it out.
+const hlsDefaultBandwidth = 5_000_000
+
+func hlsKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("hls/%s/", videoID)
+}
+
+// generateHLS segments the input into an HLS playlist with ~6 second
+// MPEG-TS segments written to outDir.
+func generateHLS(ctx context.Context, filePath, outDir string) error {
+	cmd := exec.CommandContext(
+		ctx,
+		ffmpegBin,
+		"-y",
+		"-i", filePath,
+		"-codec", "copy",
+		"-start_number", "0",
+		"-hls_time", "6",
+		"-hls_list_size", "0",
+		"-hls_segment_filename", filepath.Join(outDir, "segment_%05d.ts"),
+		"-f", "hls",
+		filepath.Join(outDir, hlsPlaylistName),
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg hls failed: %w\nstderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// packageHLS generates the HLS rendition of a local video file and uploads
+// the playlist and segments under the hls prefix of the video.
+func (cfg *apiConfig) packageHLS(ctx context.Context, videoID uuid.UUID, filePath string) error {
+	outDir, err := mkdirTemp(ctx, "hls-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := generateHLS(ctx, filePath, outDir); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return err
+	}
+	prefix := hlsKeyPrefix(videoID)
+	for _, entry := range entries {
+		if entry.Name() == hlsPlaylistName {
+			// upload the playlist last so it never references missing segments
+			continue
+		}
+		if err := cfg.putObjectFile(ctx, prefix+entry.Name(), filepath.Join(outDir, entry.Name()), "video/mp2t"); err != nil {
+			return fmt.Errorf("cannot upload hls segment: %w", err)
+		}
+	}
+	err = cfg.putObjectFile(ctx, prefix+hlsPlaylistName, filepath.Join(outDir, hlsPlaylistName), "application/vnd.apple.mpegurl")
+	if err != nil {
+		return fmt.Errorf("cannot upload hls playlist: %w", err)
+	}
+	return nil
+}
+
+// rewriteHLSPlaylist replaces every segment URI in the playlist with the
+// result of sign.
+func rewriteHLSPlaylist(playlist io.Reader, sign func(uri string) (string, error)) (string, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(playlist)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			signed, err := sign(line)
+			if err != nil {
+				return "", err
+			}
+			line = signed
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (cfg *apiConfig) handlerVideoManifest(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if video.StorageTier != database.StorageTierHot {
+		respondWithError(w, http.StatusConflict, "Video is archived", errVideoArchived)
+		return
+	}
+
+	prefix := hlsKeyPrefix(video.ID)
+	playlistKey := prefix + hlsPlaylistName
+	body, err := cfg.storage.Get(r.Context(), playlistKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "HLS playlist not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get HLS playlist", err)
+		return
+	}
+	defer body.Close()
+
+	// with captions the manifest is a master playlist offering them as
+	// subtitles next to the segments, which are the media variant
+	if len(video.Captions) > 0 && r.URL.Query().Get("variant") != "media" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(hlsMasterPlaylist(video)))
+		return
+	}
+
+	playlist, err := rewriteHLSPlaylist(body, func(uri string) (string, error) {
+		return cfg.mediaObjectURL(r.Context(), video, prefix+path.Base(uri), cfg.hlsURLExpiry)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign HLS playlist", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(playlist))
+}
+
+// hlsMasterPlaylist lists the caption tracks of a video as a subtitle
+// group of its one variant. URIs are relative to the manifest URL.
+func hlsMasterPlaylist(video database.Video) string {
+	bandwidth := int64(hlsDefaultBandwidth)
+	if video.Metadata != nil && video.Metadata.BitRate > 0 {
+		bandwidth = video.Metadata.BitRate
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, caption := range video.Captions {
+		fmt.Fprintf(&b,
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=%q,LANGUAGE=%q,DEFAULT=NO,AUTOSELECT=YES,URI=\"captions/%s/playlist.m3u8\"\n",
+			caption.Label, caption.Language, caption.Language,
+		)
+	}
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,SUBTITLES=\"subs\"\n", bandwidth)
+	b.WriteString("manifest.m3u8?variant=media\n")
+	return b.String()
+}
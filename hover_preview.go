@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// hoverPreviewSegments is how many excerpts, spread evenly over the
+	// video, a hover preview is made of.
+	hoverPreviewSegments = 6
+	hoverPreviewWidth    = 320
+	hoverPreviewFPS      = 15
+)
+
+// hoverPreviewFormat is how HOVER_PREVIEW encodes previews.
+type hoverPreviewFormat struct {
+	Ext       string
+	MediaType string
+	// Filter is appended to the sampling and scaling filters.
+	Filter    string
+	CodecArgs []string
+}
+
+var hoverPreviewFormats = map[string]hoverPreviewFormat{
+	"mp4": {
+		Ext:       "mp4",
+		MediaType: "video/mp4",
+		Filter:    "format=yuv420p",
+		CodecArgs: []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-movflags", "faststart", "-f", "mp4"},
+	},
+	"webp": {
+		Ext:       "webp",
+		MediaType: "image/webp",
+		CodecArgs: []string{"-c:v", "libwebp", "-loop", "0", "-q:v", "60", "-f", "webp"},
+	},
+}
+
+func hoverPreviewKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("hover/%s/", videoID)
+}
+
+// hoverPreviewFilter samples length seconds out of a video lasting duration
+// seconds: hoverPreviewSegments excerpts, one at the start of each equal
+// part of the video, played back to back. Videos not longer than length
+// are kept whole.
+func hoverPreviewFilter(duration, length float64, format hoverPreviewFormat) string {
+	filter := ""
+	if duration > length {
+		step := duration / hoverPreviewSegments
+		excerpt := length / hoverPreviewSegments
+		filter = fmt.Sprintf(`select=lt(mod(t\,%g)\,%g),setpts=N/FRAME_RATE/TB,`, step, excerpt)
+	}
+	filter += fmt.Sprintf("fps=%d,scale=%d:-2", hoverPreviewFPS, hoverPreviewWidth)
+	if format.Filter != "" {
+		filter += "," + format.Filter
+	}
+	return filter
+}
+
+// generateHoverPreview stores a short silent loop of the video at filePath
+// when HOVER_PREVIEW is on and returns its key, or nil when it is off.
+func (cfg *apiConfig) generateHoverPreview(ctx context.Context, videoID uuid.UUID, filePath string) (*string, error) {
+	format, ok := hoverPreviewFormats[cfg.hoverPreview]
+	if !ok {
+		return nil, nil
+	}
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := mkdirTemp(ctx, "hover-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	outPath := filepath.Join(workDir, "preview."+format.Ext)
+
+	args := []string{
+		"-y",
+		"-i", filePath,
+		"-an",
+		"-vf", hoverPreviewFilter(info.Duration, cfg.hoverPreviewLength, format),
+		"-t", fmt.Sprintf("%g", cfg.hoverPreviewLength),
+	}
+	args = append(args, format.CodecArgs...)
+	cmd := exec.CommandContext(ctx, ffmpegBin, append(args, outPath)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("ffmpeg hover preview failed: %w\nstderr: %s", err, stderr.String())
+	}
+
+	key := hoverPreviewKeyPrefix(videoID) + "preview." + format.Ext
+	if err := cfg.putObjectFile(ctx, key, outPath, format.MediaType); err != nil {
+		return nil, fmt.Errorf("cannot upload hover preview: %w", err)
+	}
+	return &key, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoEncoder is how the transcode pipeline encodes H.264: in software
+// with libx264 or on a GPU through one of ffmpeg's hardware encoders.
+type videoEncoder struct {
+	Name string
+	// InputArgs go before -i, e.g. to open the device.
+	InputArgs []string
+	// Format is the filter turning decoded frames into what the encoder
+	// takes, applied after scaling.
+	Format    string
+	CodecArgs []string
+}
+
+var softwareEncoder = videoEncoder{
+	Name:      "software",
+	Format:    "format=yuv420p",
+	CodecArgs: []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23"},
+}
+
+// hardwareEncoders returns the hardware encoders in the order HWACCEL=auto
+// tries them.
+func hardwareEncoders(vaapiDevice string) []videoEncoder {
+	return []videoEncoder{
+		{
+			Name:      "nvenc",
+			Format:    "format=yuv420p",
+			CodecArgs: []string{"-c:v", "h264_nvenc", "-preset", "p4", "-rc", "vbr", "-cq", "23", "-b:v", "0"},
+		},
+		{
+			Name:      "vaapi",
+			InputArgs: []string{"-vaapi_device", vaapiDevice},
+			Format:    "format=nv12,hwupload",
+			CodecArgs: []string{"-c:v", "h264_vaapi", "-qp", "23"},
+		},
+		{
+			Name:      "videotoolbox",
+			Format:    "format=yuv420p",
+			CodecArgs: []string{"-c:v", "h264_videotoolbox", "-q:v", "65"},
+		},
+	}
+}
+
+// videoEnc is set up by main from HWACCEL, see selectVideoEncoder.
+var videoEnc = softwareEncoder
+
+// encoderProbeTimeout bounds the test encode of each hardware encoder at
+// startup.
+const encoderProbeTimeout = 15 * time.Second
+
+// args returns the ffmpeg arguments encoding input, scaled to height when
+// it isn't 0. The output arguments go after them.
+func (e videoEncoder) args(input string, height int) []string {
+	args := append([]string{"-y"}, e.InputArgs...)
+	filter := e.Format
+	if height > 0 {
+		filter = fmt.Sprintf("scale=-2:%d,%s", height, e.Format)
+	}
+	args = append(args, "-i", input, "-vf", filter)
+	return append(args, e.CodecArgs...)
+}
+
+// overlayArgs returns the ffmpeg arguments encoding input with the image
+// scaled to width and overlaid at position, keeping the audio streams. The
+// output arguments go after them.
+func (e videoEncoder) overlayArgs(input, image string, width int, position database.WatermarkPosition) []string {
+	args := append([]string{"-y"}, e.InputArgs...)
+	x, y := overlayPosition(position)
+	filter := fmt.Sprintf("[1:v]scale=%d:-1,format=rgba[wm];[0:v][wm]overlay=%s:%s,%s[v]", width, x, y, e.Format)
+	args = append(args,
+		"-i", input,
+		"-i", image,
+		"-filter_complex", filter,
+		"-map", "[v]",
+		"-map", "0:a?",
+	)
+	return append(args, e.CodecArgs...)
+}
+
+// probe encodes a few generated frames, which fails when ffmpeg lacks the
+// encoder or the host lacks the device or driver.
+func (e videoEncoder) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, encoderProbeTimeout)
+	defer cancel()
+	args := append([]string{"-hide_banner", "-f", "lavfi", "-i", "testsrc2=size=320x240:rate=10:duration=0.5"}, e.InputArgs...)
+	args = append(args, "-vf", e.Format)
+	args = append(args, e.CodecArgs...)
+	args = append(args, "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// ffmpeg ends with the line saying what went wrong
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil
+}
+
+// selectVideoEncoder picks the encoder for HWACCEL mode: off, auto or the
+// name of a hardware encoder. Hardware encoders are only used once a test
+// encode worked; otherwise encoding falls back to software.
+func selectVideoEncoder(ctx context.Context, mode, vaapiDevice string) videoEncoder {
+	if mode == "off" {
+		return softwareEncoder
+	}
+	for _, enc := range hardwareEncoders(vaapiDevice) {
+		if mode != "auto" && mode != enc.Name {
+			continue
+		}
+		err := enc.probe(ctx)
+		if err == nil {
+			slog.Info("using hardware video encoder", "encoder", enc.Name)
+			return enc
+		}
+		if mode == "auto" {
+			slog.Debug("hardware video encoder unavailable", "encoder", enc.Name, "err", err)
+		} else {
+			slog.Warn("hardware video encoder unavailable, encoding in software", "encoder", enc.Name, "err", err)
+		}
+	}
+	if mode == "auto" {
+		slog.Info("no hardware video encoder available, encoding in software")
+	}
+	return softwareEncoder
+}
+
+// encodeWithFallback runs encode with the configured encoder and, when a
+// hardware encoder fails on the input, again in software. Hardware
+// encoders reject some inputs libx264 takes, like odd pixel formats or
+// sizes beyond what the GPU supports.
+func encodeWithFallback(ctx context.Context, encode func(videoEncoder) error) error {
+	err := encode(videoEnc)
+	if err == nil || videoEnc.Name == softwareEncoder.Name || !errors.Is(err, errMediaCommandFailed) {
+		return err
+	}
+	requestLogger(ctx).Warn("hardware encode failed, retrying in software", "encoder", videoEnc.Name, "err", err)
+	hardwareEncodeFallbacks.WithLabelValues(videoEnc.Name).Inc()
+	return encode(softwareEncoder)
+}
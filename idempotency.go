@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// idempotencyLockTimeout is how long a request holding a key can run
+	// before a retry with the key is let through, in case the server died
+	// while handling it.
+	idempotencyLockTimeout = time.Hour
+	// maxIdempotentResponse bounds the response stored for a key, bigger
+	// ones aren't replayed.
+	maxIdempotentResponse = 1 << 20
+)
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow && r.body.Len()+len(p) <= maxIdempotentResponse {
+		r.body.Write(p)
+	} else {
+		r.overflow = true
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// replayable reports whether a response is the outcome of the request
+// rather than of the server being unable to handle it right now.
+func replayable(status int) bool {
+	return status < 500 && status != http.StatusTooManyRequests
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotent lets clients send an Idempotency-Key header so that a retried
+// request gets the response to the first one instead of being handled
+// again. Keys are per user and remembered for IDEMPOTENCY_KEY_TTL; reusing
+// one for a different method or path is an error. Requests without the
+// header, or that aren't authenticated, go straight to next.
+func (cfg *apiConfig) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ASCII characters", nil)
+			return
+		}
+		p, err := auth.Authenticate(r)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		userID := p.UserID
+
+		request := r.Method + " " + r.URL.Path
+		now := time.Now()
+		existing, claimed, err := cfg.db.WithContext(r.Context()).ClaimIdempotencyKey(database.ClaimIdempotencyKeyParams{
+			UserID:          userID,
+			Key:             key,
+			Request:         request,
+			ExpiredBefore:   now.Add(-cfg.idempotencyKeyTTL),
+			AbandonedBefore: now.Add(-idempotencyLockTimeout),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check Idempotency-Key", err)
+			return
+		}
+		if !claimed {
+			switch {
+			case existing.Request == "":
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusConflict, "The request with this Idempotency-Key was just abandoned, retry it", nil)
+			case existing.Request != request:
+				respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+			case existing.CompletedAt == nil:
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+			default:
+				if existing.ResponseType != nil {
+					w.Header().Set("Content-Type", *existing.ResponseType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*existing.ResponseStatus)
+				if existing.ResponseBody != nil {
+					w.Write([]byte(*existing.ResponseBody))
+				}
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// the handler panicked, or its response can't be replayed
+			ctx, cancel := detach(r.Context(), cleanupTimeout)
+			defer cancel()
+			if err := cfg.db.WithContext(ctx).ReleaseIdempotencyKey(userID, key); err != nil {
+				requestLogger(r.Context()).Warn("cannot release idempotency key", "err", err)
+			}
+		}()
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if !replayable(rec.status) || rec.overflow {
+			return
+		}
+		// the handler is done, store its response even if the client hung up
+		ctx, cancel := detach(r.Context(), cleanupTimeout)
+		defer cancel()
+		err = cfg.db.WithContext(ctx).CompleteIdempotencyKey(userID, key, rec.status, rec.Header().Get("Content-Type"), rec.body.String())
+		if err != nil {
+			requestLogger(r.Context()).Warn("cannot store idempotent response", "err", err)
+			return
+		}
+		completed = true
+	}
+}
@@ -0,0 +1,134 @@
+// Package apierror defines the body of the API's error responses and the
+// stable codes clients can branch on, unlike the messages, which are meant
+// for people and may change.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code identifies the kind of an error.
+type Code string
+
+const (
+	CodeInvalidRequest       Code = "invalid_request"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotOwner             Code = "not_owner"
+	CodeNotFound             Code = "not_found"
+	CodeConflict             Code = "conflict"
+	CodeGone                 Code = "gone"
+	CodePreconditionFailed   Code = "precondition_failed"
+	CodePayloadTooLarge      Code = "payload_too_large"
+	CodeQuotaExceeded        Code = "quota_exceeded"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeMediaFailed          Code = "media_processing_failed"
+	CodeMediaLimitExceeded   Code = "media_limit_exceeded"
+	CodeTakenDown            Code = "taken_down"
+	CodeInternal             Code = "internal"
+	CodeUnavailable          Code = "unavailable"
+	CodeTimeout              Code = "timeout"
+	CodeInsufficientStorage  Code = "insufficient_storage"
+)
+
+// statusCodes are the codes of errors nothing more specific is known
+// about than their status.
+var statusCodes = map[int]Code{
+	http.StatusBadRequest:                 CodeInvalidRequest,
+	http.StatusUnauthorized:               CodeUnauthorized,
+	http.StatusForbidden:                  CodeForbidden,
+	http.StatusNotFound:                   CodeNotFound,
+	http.StatusConflict:                   CodeConflict,
+	http.StatusGone:                       CodeGone,
+	http.StatusPreconditionFailed:         CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge:      CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:       CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:        CodeMediaFailed,
+	http.StatusUnavailableForLegalReasons: CodeTakenDown,
+	http.StatusServiceUnavailable:         CodeUnavailable,
+	http.StatusGatewayTimeout:             CodeTimeout,
+	http.StatusInsufficientStorage:        CodeInsufficientStorage,
+}
+
+// CodeForStatus returns the code of an error known only by its status.
+func CodeForStatus(status int) Code {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// Body is what error responses carry.
+type Body struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	// RequestID is worth quoting to support, the server's logs of the
+	// request carry it.
+	RequestID string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// Error is an error to respond with. Err is the cause, which is logged but
+// never sent.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details any
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Mapping gives the errors matching Target, by errors.Is, a status and
+// code.
+type Mapping struct {
+	Target error
+	Status int
+	Code   Code
+}
+
+// Mappings translate internal errors to responses.
+type Mappings []Mapping
+
+// Lookup returns the first mapping err matches.
+func (m Mappings) Lookup(err error) (Mapping, bool) {
+	if err == nil {
+		return Mapping{}, false
+	}
+	for _, mapping := range m {
+		if errors.Is(err, mapping.Target) {
+			return mapping, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// Resolve returns the response to an error: err itself when it is an
+// *Error, else one with the mapped status and code and msg, falling back
+// to status.
+func (m Mappings) Resolve(status int, msg string, err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	e := &Error{Status: status, Code: CodeForStatus(status), Message: msg, Err: err}
+	if mapping, ok := m.Lookup(err); ok {
+		e.Status = mapping.Status
+		e.Code = mapping.Code
+	}
+	return e
+}
@@ -18,6 +18,8 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeShare tokens name a share link rather than a user.
+	TokenTypeShare TokenType = "tubely-share"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -38,50 +40,92 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// MakeJWT signs an access token for a user, issued for the session with
+// the given ID.
 func MakeJWT(
 	userID uuid.UUID,
+	sessionID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
+	return makeToken(TokenTypeAccess, userID, sessionID.String(), tokenSecret, expiresIn)
+}
+
+// ValidateJWT returns the user an access token was issued to and its
+// session. Tokens issued before sessions are rejected.
+func ValidateJWT(tokenString, tokenSecret string) (userID, sessionID uuid.UUID, err error) {
+	userID, claims, err := validateToken(TokenTypeAccess, tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	sessionID, err = uuid.Parse(claims.SessionID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("access token has no session")
+	}
+	return userID, sessionID, nil
+}
+
+// MakeShareToken signs a token for the share link with the given ID.
+func MakeShareToken(shareID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(TokenTypeShare, shareID, "", tokenSecret, expiresIn)
+}
+
+// ValidateShareToken returns the ID of the share link a token was made
+// for.
+func ValidateShareToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	id, _, err := validateToken(TokenTypeShare, tokenString, tokenSecret)
+	return id, err
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// SessionID is the session an access token was issued for.
+	SessionID string `json:"sid,omitempty"`
+}
+
+func makeToken(tokenType TokenType, subject uuid.UUID, sessionID, tokenSecret string, expiresIn time.Duration) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(tokenType),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   subject.String(),
+		},
+		SessionID: sessionID,
 	})
 	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func validateToken(tokenType TokenType, tokenString, tokenSecret string) (uuid.UUID, tokenClaims, error) {
+	claimsStruct := tokenClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, tokenClaims{}, err
 	}
 
-	userIDString, err := token.Claims.GetSubject()
+	subject, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, tokenClaims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, tokenClaims{}, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if issuer != string(tokenType) {
+		return uuid.Nil, tokenClaims{}, errors.New("invalid issuer")
 	}
 
-	id, err := uuid.Parse(userIDString)
+	id, err := uuid.Parse(subject)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, tokenClaims{}, fmt.Errorf("invalid subject ID: %w", err)
 	}
-	return id, nil
+	return id, claimsStruct, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyHeader carries an API key, as an alternative to a Bearer JWT.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to scan for.
+const apiKeyPrefix = "tubely_"
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid, revoked or expired API key")
+	ErrSessionRevoked = errors.New("session was revoked or has expired")
+)
+
+// Scope is something an API key may be used for. Requests authenticated
+// with a JWT may do anything.
+type Scope string
+
+const (
+	ScopeRead   Scope = "videos:read"
+	ScopeWrite  Scope = "videos:write"
+	ScopeUpload Scope = "videos:upload"
+)
+
+var Scopes = []Scope{ScopeRead, ScopeWrite, ScopeUpload}
+
+// Principal is who a request is authenticated as.
+type Principal struct {
+	UserID uuid.UUID
+	// APIKeyID is set when the request was made with an API key rather
+	// than a JWT.
+	APIKeyID uuid.UUID
+	Scopes   []Scope
+	// SessionID is the login a JWT was issued for.
+	SessionID uuid.UUID
+}
+
+// Allows reports whether the principal may do something needing scope. An
+// empty scope is only allowed with a JWT.
+func (p Principal) Allows(scope Scope) bool {
+	if p.APIKeyID == uuid.Nil {
+		return true
+	}
+	return scope != "" && slices.Contains(p.Scopes, scope)
+}
+
+// MakeAPIKey returns a new API key, the prefix of it that is safe to show
+// and store, and the hash it is looked up by.
+func MakeAPIKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash an API key is stored as. Keys are random
+// enough that a plain SHA-256 can't be brute forced.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyResolver returns the principal an API key authenticates, or
+// ErrInvalidAPIKey.
+type APIKeyResolver func(ctx context.Context, key string) (Principal, error)
+
+// SessionChecker returns ErrSessionRevoked unless the session an access
+// token was issued for is still active and belongs to the user.
+type SessionChecker func(ctx context.Context, userID, sessionID uuid.UUID) error
+
+type principalKey struct{}
+
+type authResult struct {
+	principal Principal
+	err       error
+}
+
+// Middleware authenticates requests by their X-API-Key or, without one,
+// their Bearer JWT and the session it was issued for, and keeps the
+// outcome for Authenticate. Requests aren't rejected here, handlers decide
+// whether they need a principal.
+func Middleware(tokenSecret string, resolveKey APIKeyResolver, checkSession SessionChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var res authResult
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				if strings.HasPrefix(key, apiKeyPrefix) {
+					res.principal, res.err = resolveKey(r.Context(), key)
+				} else {
+					res.err = ErrInvalidAPIKey
+				}
+			} else if token, err := GetBearerToken(r.Header); err != nil {
+				res.err = err
+			} else if userID, sessionID, err := ValidateJWT(token, tokenSecret); err != nil {
+				res.err = err
+			} else if err := checkSession(r.Context(), userID, sessionID); err != nil {
+				res.err = err
+			} else {
+				res.principal = Principal{UserID: userID, SessionID: sessionID}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, res)))
+		})
+	}
+}
+
+// Authenticate returns who the request was authenticated as by Middleware,
+// or why it couldn't be. Requests with neither an API key nor a JWT get
+// ErrNoAuthHeaderIncluded.
+func Authenticate(r *http.Request) (Principal, error) {
+	res, ok := r.Context().Value(principalKey{}).(authResult)
+	if !ok {
+		return Principal{}, ErrNoAuthHeaderIncluded
+	}
+	return res.principal, res.err
+}
@@ -0,0 +1,589 @@
+// Package config loads the server settings from an optional YAML file and
+// the environment, which takes precedence. Every setting is named after its
+// environment variable; in the file the same name is written in lowercase,
+// e.g. s3_bucket: tubely-123.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type Config struct {
+	DBPath           string
+	DBPool           database.PoolConfig
+	DBMigrateOnStart bool
+
+	JWTSecret   string
+	Platform    string
+	Port        string
+	AdminAPIKey string
+	LogFormat   string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	FilepathRoot string
+	AssetsRoot   string
+	// AssetsMaxAge is how long clients may reuse files of the assets
+	// directory before revalidating them. With AssetsPrivateThumbnails,
+	// thumbnails there are only served to those who may view their video.
+	AssetsMaxAge            time.Duration
+	AssetsPrivateThumbnails bool
+	// PublicBaseURL is where clients reach the server, including the path
+	// prefix a proxy mounts it at. Asset URLs are built from AssetsCDNURL
+	// instead when set, for a CDN in front of the assets directory.
+	PublicBaseURL string
+	AssetsCDNURL  string
+
+	StorageProvider  string
+	S3Bucket         string
+	S3Region         string
+	S3CfDistribution string
+	S3Endpoint       string
+	S3MaxAttempts    int
+	S3MaxBackoff     time.Duration
+	// S3BreakerThreshold is how many consecutive failures open the circuit
+	// breaker, 0 disables it.
+	S3BreakerThreshold int
+	S3BreakerCooldown  time.Duration
+	// S3SSE is the server-side encryption of stored objects: off for the
+	// bucket default, s3 for SSE-S3 or kms for SSE-KMS with S3SSEKMSKeyID,
+	// or the AWS managed key when that is empty.
+	S3SSE         string
+	S3SSEKMSKeyID string
+	// S3ReplicaBucket, in S3ReplicaRegion, gets a copy of every stored
+	// video, which requests from ReplicaCountries are served from. Their
+	// country is read from GeoCountryHeader, set by the CDN or proxy in
+	// front of the server.
+	S3ReplicaBucket  string
+	S3ReplicaRegion  string
+	ReplicaCountries []string
+	GeoCountryHeader string
+	// ArchiveStorageClass is the S3 storage class archived videos move to,
+	// restored with ArchiveRestoreTier and checked on every
+	// ArchiveRestorePollInterval.
+	ArchiveStorageClass        string
+	ArchiveRestoreTier         string
+	ArchiveRestorePollInterval time.Duration
+	// StorageSpillover stores uploads below the assets root while S3 is
+	// down, they are moved to S3 every SpilloverReconcileInterval.
+	StorageSpillover           bool
+	SpilloverReconcileInterval time.Duration
+
+	FFmpegPath  string
+	FFprobePath string
+	HWAccel     string
+	VAAPIDevice string
+
+	// Transcriber transcribes stored videos into a caption track and a
+	// searchable transcript: off, whisper to run whisper.cpp at WhisperPath
+	// with WhisperModel, or api for an OpenAI compatible transcription
+	// endpoint. The language is detected unless TranscriptionLanguage is set.
+	Transcriber           string
+	WhisperPath           string
+	WhisperModel          string
+	TranscriptionAPIURL   string
+	TranscriptionAPIKey   string
+	TranscriptionModel    string
+	TranscriptionLanguage string
+
+	// VirusScanner scans uploads before they are processed: off, or clamd
+	// to stream them to the ClamAV daemon at ClamdAddress, either
+	// unix:/path/to/socket or tcp:host:port.
+	VirusScanner string
+	ClamdAddress string
+	ClamdTimeout time.Duration
+
+	// Classifier scores frames sampled from every stored video for
+	// explicit content: off, http to post them to ClassifierURL, or command
+	// to run ClassifierCommand on them. Videos scoring at least
+	// ClassifierFlagThreshold are reported to the moderators, at least
+	// ClassifierTakedownThreshold, unless 0, taken down right away.
+	Classifier                  string
+	ClassifierURL               string
+	ClassifierAPIKey            string
+	ClassifierCommand           string
+	ClassifierFrames            int
+	ClassifierFlagThreshold     float64
+	ClassifierTakedownThreshold float64
+
+	// WatermarkPath is an image burned into every processed video at
+	// WatermarkPosition, unless its owner registered their own.
+	WatermarkPath     string
+	WatermarkPosition string
+
+	// ErrorReportURL receives a JSON event for every request that
+	// panicked, like a Sentry store endpoint, with ErrorReportAPIKey as
+	// bearer token.
+	ErrorReportURL    string
+	ErrorReportAPIKey string
+
+	AudioNormalize     bool
+	AudioTargetLUFS    float64
+	AudioRendition     string
+	VideoFormField     string
+	ThumbnailFormField string
+	PresignHeadCheck   bool
+	PreviewSeconds     int
+
+	UploadStreaming   bool
+	UploadPartSizeMB  int
+	UploadParallelism int
+	UserQuotaMB       int
+
+	// MaxVideoSizeMB and MaxThumbnailSizeMB are the upload limits of users
+	// whose plan or own limits don't say otherwise.
+	MaxVideoSizeMB     int
+	MaxThumbnailSizeMB int
+	// MaxVideoDuration, 0 for none, and MaxVideoResolution bound what
+	// videos are processed, either way round for portrait videos.
+	MaxVideoDuration   time.Duration
+	MaxVideoResolution Size
+
+	JobWorkers     int
+	JobMaxAttempts int
+
+	HLSEnabled         bool
+	TranscodeLadder    []int
+	StoryboardInterval float64
+	HoverPreview       string
+	HoverPreviewLength float64
+
+	OrphanGCInterval time.Duration
+	OrphanMinAge     time.Duration
+	OrphanGCDelete   bool
+
+	// TrashRetention is how long deleted videos stay in the trash before
+	// they are purged, checked every TrashPurgeInterval. 0 deletes them
+	// right away.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// SchedulerInterval is how often scheduled videos are published and
+	// expired. ExpiryAction is archive, which makes expired videos
+	// private, or delete.
+	SchedulerInterval time.Duration
+	ExpiryAction      string
+
+	ThumbnailMaxSize Size
+	ThumbnailMinSize Size
+	ThumbnailQuality int
+
+	DedupScope string
+
+	PresignCache     bool
+	VideoURLExpiry   time.Duration
+	HLSURLExpiry     time.Duration
+	PreviewURLExpiry time.Duration
+	PublicURLExpiry  time.Duration
+	PublicCDN        bool
+
+	// ExportExpiry is how long a user data export, and the URLs of the
+	// assets in it, can be downloaded.
+	ExportExpiry time.Duration
+
+	MediaConcurrency int
+	MediaQueueWait   time.Duration
+	MediaTimeout     time.Duration
+
+	ScratchRoot      string
+	ScratchMinFreeMB int
+
+	ShutdownTimeout time.Duration
+
+	WebhookTimeout      time.Duration
+	WebhookAllowPrivate bool
+
+	ImportMaxSizeMB    int
+	ImportTimeout      time.Duration
+	ImportAllowPrivate bool
+
+	IdempotencyKeyTTL time.Duration
+}
+
+// Error lists every problem found while loading the configuration.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Load reads the config file at path, if path isn't empty, overlays the
+// environment and validates the result. All problems are reported together
+// in an *Error.
+func Load(path string) (Config, error) {
+	s, err := newSource(path)
+	if err != nil {
+		return Config{}, err
+	}
+	dbPath, pool := s.database()
+	c := Config{
+		DBPath:           dbPath,
+		DBPool:           pool,
+		DBMigrateOnStart: s.boolean("DB_MIGRATE_ON_START", true),
+
+		JWTSecret:   s.required("JWT_SECRET"),
+		Platform:    s.required("PLATFORM"),
+		Port:        s.required("PORT"),
+		AdminAPIKey: s.str("ADMIN_API_KEY", ""),
+		LogFormat:   s.oneOf("LOG_FORMAT", "text", "text", "json"),
+
+		AccessTokenTTL:  s.positiveDuration("ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL: s.positiveDuration("REFRESH_TOKEN_TTL", 60*24*time.Hour),
+
+		FilepathRoot: s.required("FILEPATH_ROOT"),
+		AssetsRoot:   s.required("ASSETS_ROOT"),
+
+		AssetsMaxAge:            s.duration("ASSETS_MAX_AGE", time.Hour),
+		AssetsPrivateThumbnails: s.boolean("ASSETS_PRIVATE_THUMBNAILS", false),
+		PublicBaseURL:           s.str("PUBLIC_BASE_URL", ""),
+		AssetsCDNURL:            s.str("ASSETS_CDN_URL", ""),
+
+		StorageProvider:  s.oneOf("STORAGE_PROVIDER", "s3", "s3", "minio", "gcs", "local"),
+		S3Bucket:         s.required("S3_BUCKET"),
+		S3Region:         s.required("S3_REGION"),
+		S3CfDistribution: s.required("S3_CF_DISTRO"),
+		S3Endpoint:       s.str("S3_ENDPOINT", ""),
+		S3MaxAttempts:    s.integer("S3_MAX_ATTEMPTS", 3, 1),
+		S3MaxBackoff:     s.positiveDuration("S3_MAX_BACKOFF", 20*time.Second),
+
+		S3BreakerThreshold: s.integer("S3_BREAKER_THRESHOLD", 5, 0),
+		S3BreakerCooldown:  s.positiveDuration("S3_BREAKER_COOLDOWN", 30*time.Second),
+
+		S3SSE:         s.oneOf("S3_SSE", "off", "off", "s3", "kms"),
+		S3SSEKMSKeyID: s.str("S3_SSE_KMS_KEY_ID", ""),
+
+		S3ReplicaBucket:  s.str("S3_REPLICA_BUCKET", ""),
+		S3ReplicaRegion:  s.str("S3_REPLICA_REGION", ""),
+		ReplicaCountries: s.list("REPLICA_COUNTRIES"),
+		GeoCountryHeader: s.str("GEO_COUNTRY_HEADER", "CloudFront-Viewer-Country"),
+
+		ArchiveStorageClass:        s.oneOf("ARCHIVE_STORAGE_CLASS", "GLACIER", "GLACIER", "DEEP_ARCHIVE"),
+		ArchiveRestoreTier:         s.oneOf("ARCHIVE_RESTORE_TIER", "Standard", "Standard", "Bulk", "Expedited"),
+		ArchiveRestorePollInterval: s.positiveDuration("ARCHIVE_RESTORE_POLL_INTERVAL", 15*time.Minute),
+
+		StorageSpillover:           s.boolean("STORAGE_SPILLOVER", false),
+		SpilloverReconcileInterval: s.positiveDuration("SPILLOVER_RECONCILE_INTERVAL", time.Minute),
+
+		FFmpegPath:  s.str("FFMPEG_PATH", "ffmpeg"),
+		FFprobePath: s.str("FFPROBE_PATH", "ffprobe"),
+		HWAccel:     s.oneOf("HWACCEL", "off", "off", "auto", "nvenc", "vaapi", "videotoolbox"),
+		VAAPIDevice: s.str("VAAPI_DEVICE", "/dev/dri/renderD128"),
+
+		Transcriber:           s.oneOf("TRANSCRIBER", "off", "off", "whisper", "api"),
+		WhisperPath:           s.str("WHISPER_PATH", "whisper-cli"),
+		WhisperModel:          s.str("WHISPER_MODEL", ""),
+		TranscriptionAPIURL:   s.str("TRANSCRIPTION_API_URL", DefaultTranscriptionAPIURL),
+		TranscriptionAPIKey:   s.str("TRANSCRIPTION_API_KEY", ""),
+		TranscriptionModel:    s.str("TRANSCRIPTION_MODEL", "whisper-1"),
+		TranscriptionLanguage: s.str("TRANSCRIPTION_LANGUAGE", ""),
+
+		VirusScanner: s.oneOf("VIRUS_SCANNER", "off", "off", "clamd"),
+		ClamdAddress: s.str("CLAMD_ADDRESS", "unix:/var/run/clamav/clamd.ctl"),
+		ClamdTimeout: s.positiveDuration("CLAMD_TIMEOUT", 5*time.Minute),
+
+		Classifier:                  s.oneOf("CLASSIFIER", "off", "off", "http", "command"),
+		ClassifierURL:               s.str("CLASSIFIER_URL", ""),
+		ClassifierAPIKey:            s.str("CLASSIFIER_API_KEY", ""),
+		ClassifierCommand:           s.str("CLASSIFIER_COMMAND", ""),
+		ClassifierFrames:            s.intRange("CLASSIFIER_FRAMES", 8, 1, 64),
+		ClassifierFlagThreshold:     s.number("CLASSIFIER_FLAG_THRESHOLD", 0.8),
+		ClassifierTakedownThreshold: s.number("CLASSIFIER_TAKEDOWN_THRESHOLD", 0),
+
+		WatermarkPath:     s.str("WATERMARK_PATH", ""),
+		WatermarkPosition: s.oneOf("WATERMARK_POSITION", "bottom-right", "top-left", "top-right", "bottom-left", "bottom-right", "center"),
+
+		ErrorReportURL:    s.str("ERROR_REPORT_URL", ""),
+		ErrorReportAPIKey: s.str("ERROR_REPORT_API_KEY", ""),
+
+		AudioNormalize:     s.boolean("AUDIO_NORMALIZE", false),
+		AudioTargetLUFS:    s.number("AUDIO_TARGET_LUFS", -16),
+		AudioRendition:     s.oneOf("AUDIO_RENDITION", "off", "off", "aac", "mp3"),
+		VideoFormField:     s.str("VIDEO_FORM_FIELD", "video"),
+		ThumbnailFormField: s.str("THUMBNAIL_FORM_FIELD", "thumbnail"),
+		PresignHeadCheck:   s.boolean("PRESIGN_HEAD_CHECK", false),
+		PreviewSeconds:     s.integer("PREVIEW_SECONDS", 10, 1),
+
+		UploadStreaming:   s.boolean("UPLOAD_STREAMING", false),
+		UploadPartSizeMB:  s.integer("UPLOAD_PART_SIZE_MB", 8, 5),
+		UploadParallelism: s.integer("UPLOAD_PARALLELISM", 4, 1),
+		UserQuotaMB:       s.integer("USER_QUOTA_MB", 0, 0),
+
+		MaxVideoSizeMB:     s.integer("MAX_VIDEO_SIZE_MB", 1024, 1),
+		MaxThumbnailSizeMB: s.integer("MAX_THUMBNAIL_SIZE_MB", 10, 1),
+		MaxVideoDuration:   s.duration("MAX_VIDEO_DURATION", 4*time.Hour),
+		MaxVideoResolution: s.size("MAX_VIDEO_RESOLUTION", Size{Width: 3840, Height: 2160}),
+
+		JobWorkers:     s.integer("JOB_WORKERS", 2, 1),
+		JobMaxAttempts: s.integer("JOB_MAX_ATTEMPTS", 5, 1),
+
+		HLSEnabled:         s.boolean("HLS_ENABLED", true),
+		TranscodeLadder:    s.ladder("TRANSCODE_LADDER", []int{1080, 720, 480, 360}),
+		StoryboardInterval: s.positiveNumber("STORYBOARD_INTERVAL", 5),
+		HoverPreview:       s.oneOf("HOVER_PREVIEW", "off", "off", "mp4", "webp"),
+		HoverPreviewLength: s.positiveNumber("HOVER_PREVIEW_LENGTH", 3),
+
+		OrphanGCInterval: s.duration("ORPHAN_GC_INTERVAL", 24*time.Hour),
+		OrphanMinAge:     s.duration("ORPHAN_MIN_AGE", 24*time.Hour),
+		OrphanGCDelete:   s.boolean("ORPHAN_GC_DELETE", false),
+
+		TrashRetention:     s.duration("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: s.positiveDuration("TRASH_PURGE_INTERVAL", time.Hour),
+
+		SchedulerInterval: s.positiveDuration("SCHEDULER_INTERVAL", time.Minute),
+		ExpiryAction:      s.oneOf("EXPIRY_ACTION", "archive", "archive", "delete"),
+
+		ThumbnailMaxSize: s.size("THUMBNAIL_MAX_SIZE", Size{Width: 1280, Height: 720}),
+		ThumbnailMinSize: s.size("THUMBNAIL_MIN_SIZE", Size{Width: 160, Height: 90}),
+		ThumbnailQuality: s.intRange("THUMBNAIL_QUALITY", 85, 1, 100),
+
+		DedupScope: s.oneOf("DEDUP_SCOPE", "user", "off", "user", "global"),
+
+		PresignCache:     s.boolean("PRESIGN_CACHE", true),
+		VideoURLExpiry:   s.positiveDuration("VIDEO_URL_EXPIRY", 15*time.Minute),
+		HLSURLExpiry:     s.positiveDuration("HLS_URL_EXPIRY", 15*time.Minute),
+		PreviewURLExpiry: s.positiveDuration("PREVIEW_URL_EXPIRY", 15*time.Minute),
+		PublicURLExpiry:  s.positiveDuration("PUBLIC_URL_EXPIRY", 7*24*time.Hour),
+		PublicCDN:        s.boolean("PUBLIC_CDN", false),
+
+		ExportExpiry: s.positiveDuration("EXPORT_EXPIRY", 7*24*time.Hour),
+
+		MediaConcurrency: s.integer("MEDIA_CONCURRENCY", 4, 1),
+		MediaQueueWait:   s.duration("MEDIA_QUEUE_WAIT", 30*time.Second),
+		MediaTimeout:     s.positiveDuration("MEDIA_TIMEOUT", 30*time.Minute),
+
+		ScratchRoot:      s.str("SCRATCH_ROOT", os.TempDir()),
+		ScratchMinFreeMB: s.integer("SCRATCH_MIN_FREE_MB", 512, 0),
+
+		ShutdownTimeout: s.duration("SHUTDOWN_TIMEOUT", 2*time.Minute),
+
+		WebhookTimeout:      s.positiveDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookAllowPrivate: s.boolean("WEBHOOK_ALLOW_PRIVATE", false),
+
+		ImportMaxSizeMB:    s.integer("IMPORT_MAX_SIZE_MB", 1024, 1),
+		ImportTimeout:      s.positiveDuration("IMPORT_TIMEOUT", 30*time.Minute),
+		ImportAllowPrivate: s.boolean("IMPORT_ALLOW_PRIVATE", false),
+
+		IdempotencyKeyTTL: s.positiveDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+	}
+	c.validate(s)
+	s.unknownKeys()
+	if len(s.problems) > 0 {
+		return Config{}, &Error{Problems: s.problems}
+	}
+	return c, nil
+}
+
+// LoadDatabase is Load for commands that only need the database, like
+// migrate. Settings other than the database ones are neither required nor
+// checked.
+func LoadDatabase(path string) (Config, error) {
+	s, err := newSource(path)
+	if err != nil {
+		return Config{}, err
+	}
+	dbPath, pool := s.database()
+	if len(s.problems) > 0 {
+		return Config{}, &Error{Problems: s.problems}
+	}
+	return Config{DBPath: dbPath, DBPool: pool}, nil
+}
+
+// validate checks settings that depend on each other or on the host.
+func (c *Config) validate(s *source) {
+	if c.StorageProvider == "minio" && c.S3Endpoint == "" {
+		s.problemf("S3_ENDPOINT must be set for the minio storage provider")
+	}
+	if c.StorageProvider == "gcs" && c.S3Endpoint == "" {
+		c.S3Endpoint = "https://storage.googleapis.com"
+	}
+	if c.PublicBaseURL == "" {
+		c.PublicBaseURL = "http://localhost:" + c.Port
+	} else if u, err := parseBaseURL(c.PublicBaseURL); err != nil {
+		s.problemf("PUBLIC_BASE_URL: %v", err)
+	} else {
+		c.PublicBaseURL = u
+	}
+	if c.AssetsCDNURL != "" {
+		if u, err := parseBaseURL(c.AssetsCDNURL); err != nil {
+			s.problemf("ASSETS_CDN_URL: %v", err)
+		} else {
+			c.AssetsCDNURL = u
+		}
+	}
+	// SigV4 presigned URLs are valid for a week at most
+	if c.StorageProvider != "local" && c.PublicURLExpiry > 7*24*time.Hour {
+		s.problemf("PUBLIC_URL_EXPIRY must be at most 168h for the %s storage provider", c.StorageProvider)
+	}
+	if c.StorageProvider != "local" && c.ExportExpiry > 7*24*time.Hour {
+		s.problemf("EXPORT_EXPIRY must be at most 168h for the %s storage provider", c.StorageProvider)
+	}
+	if c.S3SSE != "off" && (c.StorageProvider == "local" || c.StorageProvider == "gcs") {
+		s.problemf("S3_SSE isn't supported by the %s storage provider", c.StorageProvider)
+	}
+	if c.S3SSEKMSKeyID != "" && c.S3SSE != "kms" {
+		s.problemf("S3_SSE_KMS_KEY_ID needs S3_SSE=kms")
+	}
+	if c.S3ReplicaBucket != "" {
+		if c.StorageProvider == "local" || c.StorageProvider == "gcs" {
+			s.problemf("S3_REPLICA_BUCKET isn't supported by the %s storage provider", c.StorageProvider)
+		}
+		if c.S3ReplicaRegion == "" {
+			s.problemf("S3_REPLICA_REGION must be set with S3_REPLICA_BUCKET")
+		}
+	}
+	if c.ArchiveStorageClass == "DEEP_ARCHIVE" && c.ArchiveRestoreTier == "Expedited" {
+		s.problemf("ARCHIVE_RESTORE_TIER=Expedited isn't available for DEEP_ARCHIVE")
+	}
+	if c.StorageSpillover && c.StorageProvider == "local" {
+		s.problemf("STORAGE_SPILLOVER needs an S3 compatible storage provider")
+	}
+	if c.PublicCDN && c.StorageProvider != "s3" {
+		s.problemf("PUBLIC_CDN needs the s3 storage provider")
+	}
+	switch c.Transcriber {
+	case "whisper":
+		if _, err := exec.LookPath(c.WhisperPath); err != nil {
+			s.problemf("WHISPER_PATH: %v", err)
+		}
+		if c.WhisperModel == "" {
+			s.problemf("WHISPER_MODEL must be set for TRANSCRIBER=whisper")
+		} else if _, err := os.Stat(c.WhisperModel); err != nil {
+			s.problemf("WHISPER_MODEL: %v", err)
+		}
+	case "api":
+		if c.TranscriptionAPIURL == DefaultTranscriptionAPIURL && c.TranscriptionAPIKey == "" {
+			s.problemf("TRANSCRIPTION_API_KEY must be set for the OpenAI transcription API")
+		}
+	}
+	if c.AudioTargetLUFS < -70 || c.AudioTargetLUFS > -5 {
+		s.problemf("AUDIO_TARGET_LUFS must be between -70 and -5")
+	}
+	if c.VirusScanner == "clamd" {
+		if _, _, err := ParseClamdAddress(c.ClamdAddress); err != nil {
+			s.problemf("CLAMD_ADDRESS: %v", err)
+		}
+	}
+	switch c.Classifier {
+	case "http":
+		if c.ClassifierURL == "" {
+			s.problemf("CLASSIFIER_URL must be set for CLASSIFIER=http")
+		}
+	case "command":
+		if _, err := exec.LookPath(c.ClassifierCommand); err != nil {
+			s.problemf("CLASSIFIER_COMMAND: %v", err)
+		}
+	}
+	if c.ClassifierFlagThreshold < 0 || c.ClassifierFlagThreshold > 1 {
+		s.problemf("CLASSIFIER_FLAG_THRESHOLD must be between 0 and 1")
+	}
+	if c.ClassifierTakedownThreshold < 0 || c.ClassifierTakedownThreshold > 1 {
+		s.problemf("CLASSIFIER_TAKEDOWN_THRESHOLD must be between 0 and 1")
+	}
+	if c.WatermarkPath != "" {
+		if _, err := os.Stat(c.WatermarkPath); err != nil {
+			s.problemf("WATERMARK_PATH: %v", err)
+		}
+	}
+	if c.ErrorReportURL != "" {
+		if u, err := url.Parse(c.ErrorReportURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			s.problemf("ERROR_REPORT_URL must be an http or https URL")
+		}
+	}
+	if info, err := os.Stat(c.ScratchRoot); err != nil || !info.IsDir() {
+		s.problemf("SCRATCH_ROOT %q is not a directory", c.ScratchRoot)
+	}
+	if _, err := exec.LookPath(c.FFmpegPath); err != nil {
+		s.problemf("FFMPEG_PATH: %v", err)
+	}
+	if _, err := exec.LookPath(c.FFprobePath); err != nil {
+		s.problemf("FFPROBE_PATH: %v", err)
+	}
+}
+
+// DefaultTranscriptionAPIURL is OpenAI's transcription endpoint.
+const DefaultTranscriptionAPIURL = "https://api.openai.com/v1/audio/transcriptions"
+
+type Size struct {
+	Width  int
+	Height int
+}
+
+// ParseSize parses sizes written as WIDTHxHEIGHT, e.g. "1280x720".
+func ParseSize(value string) (Size, error) {
+	w, h, ok := strings.Cut(strings.ToLower(value), "x")
+	if !ok {
+		return Size{}, fmt.Errorf("invalid image size %q", value)
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(w))
+	if err != nil || width < 1 {
+		return Size{}, fmt.Errorf("invalid image width %q", w)
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(h))
+	if err != nil || height < 1 {
+		return Size{}, fmt.Errorf("invalid image height %q", h)
+	}
+	return Size{Width: width, Height: height}, nil
+}
+
+// ParseClamdAddress splits a clamd address, unix:/path/to/socket or
+// tcp:host:port, into the network and address to dial.
+func ParseClamdAddress(value string) (network, address string, err error) {
+	network, address, ok := strings.Cut(value, ":")
+	if !ok || address == "" || (network != "unix" && network != "tcp") {
+		return "", "", fmt.Errorf("%q must be unix:/path/to/socket or tcp:host:port", value)
+	}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", err
+		}
+	}
+	return network, address, nil
+}
+
+// parseBaseURL checks an absolute http or https URL that paths are appended
+// to, and strips its trailing slash.
+func parseBaseURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q must be an http or https URL", value)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a query or fragment", value)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// ParseLadder parses a comma separated list of rendition heights, e.g.
+// "1080,720,480", into descending order. A "p" suffix is allowed.
+func ParseLadder(value string) ([]int, error) {
+	ladder := []int{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(field), "p"))
+		if field == "" {
+			continue
+		}
+		height, err := strconv.Atoi(field)
+		if err != nil || height < 2 {
+			return nil, fmt.Errorf("invalid rendition height %q", field)
+		}
+		ladder = append(ladder, height)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ladder)))
+	return ladder, nil
+}
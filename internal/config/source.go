@@ -0,0 +1,257 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// source looks settings up in the environment first and in the config
+// file second. File keys are the lowercase names of the environment
+// variables. Problems are collected instead of returned so Load can report
+// all of them at once.
+type source struct {
+	file     map[string]string
+	path     string
+	known    map[string]bool
+	problems []string
+}
+
+func newSource(path string) (*source, error) {
+	s := &source{
+		file:  map[string]string{},
+		path:  path,
+		known: map[string]bool{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(dat, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			s.file[key] = ""
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s.file[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			s.problemf("%s: %s must be a single value", path, key)
+		default:
+			s.file[key] = fmt.Sprint(v)
+		}
+	}
+	return s, nil
+}
+
+func (s *source) problemf(format string, args ...interface{}) {
+	s.problems = append(s.problems, fmt.Sprintf(format, args...))
+}
+
+// lookup returns the value of a setting. Empty values count as unset, the
+// way .env files usually list every variable.
+func (s *source) lookup(name string) (string, bool) {
+	s.known[strings.ToLower(name)] = true
+	if v := os.Getenv(name); v != "" {
+		return v, true
+	}
+	if v := s.file[strings.ToLower(name)]; v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+// lookupSet is lookup for settings where an empty value means something,
+// like an empty list.
+func (s *source) lookupSet(name string) (string, bool) {
+	s.known[strings.ToLower(name)] = true
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true
+	}
+	v, ok := s.file[strings.ToLower(name)]
+	return v, ok
+}
+
+// unknownKeys reports file keys no setting asked for, most likely typos.
+func (s *source) unknownKeys() {
+	var unknown []string
+	for key := range s.file {
+		if !s.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		s.problemf("%s: unknown setting %s", s.path, key)
+	}
+}
+
+// database reads the location of the database and its pool limits, whose
+// defaults depend on the kind of database.
+func (s *source) database() (string, database.PoolConfig) {
+	dsn := s.required("DB_PATH")
+	pool := database.DefaultPoolConfig(dsn)
+	return dsn, database.PoolConfig{
+		MaxOpenConns:    s.integer("DB_MAX_OPEN_CONNS", pool.MaxOpenConns, 1),
+		MaxIdleConns:    s.integer("DB_MAX_IDLE_CONNS", pool.MaxIdleConns, 0),
+		ConnMaxLifetime: s.duration("DB_CONN_MAX_LIFETIME", pool.ConnMaxLifetime),
+	}
+}
+
+func (s *source) required(name string) string {
+	v, ok := s.lookup(name)
+	if !ok {
+		s.problemf("%s is not set", name)
+	}
+	return v
+}
+
+func (s *source) str(name, def string) string {
+	if v, ok := s.lookup(name); ok {
+		return v
+	}
+	return def
+}
+
+// list reads a comma separated list, leaving out empty items.
+func (s *source) list(name string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s.str(name, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *source) oneOf(name, def string, allowed ...string) string {
+	v := s.str(name, def)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	s.problemf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), v)
+	return def
+}
+
+func (s *source) boolean(name string, def bool) bool {
+	v, ok := s.lookup(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.problemf("%s must be true or false, got %q", name, v)
+		return def
+	}
+	return b
+}
+
+// integer reads an int of at least min.
+func (s *source) integer(name string, def, min int) int {
+	v, ok := s.lookup(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		s.problemf("%s must be an integer of at least %d, got %q", name, min, v)
+		return def
+	}
+	return n
+}
+
+func (s *source) intRange(name string, def, min, max int) int {
+	n := s.integer(name, def, min)
+	if n > max {
+		s.problemf("%s must be an integer between %d and %d, got %d", name, min, max, n)
+		return def
+	}
+	return n
+}
+
+func (s *source) number(name string, def float64) float64 {
+	v, ok := s.lookup(name)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.problemf("%s must be a number, got %q", name, v)
+		return def
+	}
+	return f
+}
+
+func (s *source) positiveNumber(name string, def float64) float64 {
+	f := s.number(name, def)
+	if f <= 0 {
+		s.problemf("%s must be a positive number, got %v", name, f)
+		return def
+	}
+	return f
+}
+
+func (s *source) duration(name string, def time.Duration) time.Duration {
+	v, ok := s.lookup(name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		s.problemf("%s must be a duration like 30s or 15m, got %q", name, v)
+		return def
+	}
+	return d
+}
+
+func (s *source) positiveDuration(name string, def time.Duration) time.Duration {
+	d := s.duration(name, def)
+	if d == 0 {
+		s.problemf("%s must be a positive duration", name)
+		return def
+	}
+	return d
+}
+
+func (s *source) size(name string, def Size) Size {
+	v, ok := s.lookup(name)
+	if !ok {
+		return def
+	}
+	size, err := ParseSize(v)
+	if err != nil {
+		s.problemf("%s must be WIDTHxHEIGHT: %v", name, err)
+		return def
+	}
+	return size
+}
+
+func (s *source) ladder(name string, def []int) []int {
+	v, ok := s.lookupSet(name)
+	if !ok {
+		return def
+	}
+	ladder, err := ParseLadder(v)
+	if err != nil {
+		s.problemf("%s is invalid: %v", name, err)
+		return def
+	}
+	return ladder
+}
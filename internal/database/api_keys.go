@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey authenticates requests of a user for the scopes it was given.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, enough to recognize it.
+	Prefix  string   `json:"prefix"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+	// ExpiresAt is nil for keys that don't expire.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Active reports whether the key can still be used.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at,
+		last_used_at,
+		revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var (
+		k      APIKey
+		scopes string
+	)
+	err := row.Scan(
+		&k.ID,
+		&k.CreatedAt,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&scopes,
+		&k.ExpiresAt,
+		&k.LastUsedAt,
+		&k.RevokedAt,
+	)
+	k.Scopes = strings.Split(scopes, ",")
+	return k, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	var expiresAt any
+	if params.ExpiresAt != nil {
+		expiresAt = c.timeArg(*params.ExpiresAt)
+	}
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash, strings.Join(params.Scopes, ","), expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return c.GetAPIKey(id)
+}
+
+// GetAPIKey returns a zero APIKey when there is none with the id.
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	k, err := scanAPIKey(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, nil
+	}
+	return k, err
+}
+
+// GetAPIKeyByHash returns a zero APIKey when no key has the hash.
+func (c Client) GetAPIKeyByHash(hash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+	k, err := scanAPIKey(c.db.QueryRow(query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, nil
+	}
+	return k, err
+}
+
+// GetAPIKeys returns the keys of a user, revoked ones included, newest
+// first.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// TouchAPIKey records that a key was used, unless that was already
+// recorded since notBefore, to spare a write on every request.
+func (c Client) TouchAPIKey(id uuid.UUID, notBefore time.Time) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	_, err := c.db.Exec(query, id, c.timeArg(notBefore))
+	return err
+}
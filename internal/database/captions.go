@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CaptionSource string
+
+const (
+	CaptionSourceUpload        CaptionSource = "upload"
+	CaptionSourceTranscription CaptionSource = "transcription"
+)
+
+// Caption is the WebVTT caption track of a video in one language.
+type Caption struct {
+	// Language is a BCP 47 tag like en or pt-BR.
+	Language  string        `json:"language"`
+	Label     string        `json:"label"`
+	Source    CaptionSource `json:"source"`
+	ObjectKey string        `json:"-"`
+	// URL is presigned from ObjectKey when the video is sent to a client.
+	URL       *string   `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const captionColumns = `
+		language,
+		label,
+		source,
+		object_key,
+		created_at,
+		updated_at`
+
+// PutVideoCaption adds the caption track of a language to a video or
+// replaces it, bumping the video's version. It returns the object key of
+// the replaced track, empty when there was none.
+func (c Client) PutVideoCaption(videoID uuid.UUID, caption Caption) (string, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return "", err
+	}
+	defer t.Rollback()
+
+	replaced, _, err := getCaptionSource(t, videoID, caption.Language)
+	if err != nil {
+		return "", err
+	}
+	if err := putCaption(t, videoID, caption); err != nil {
+		return "", err
+	}
+	if err := bumpVideoVersion(t, videoID); err != nil {
+		return "", err
+	}
+	return replaced, t.Commit()
+}
+
+// getCaptionSource returns the object key and source of a video's track in
+// a language, an empty key when there is none.
+func getCaptionSource(t *tx, videoID uuid.UUID, language string) (string, CaptionSource, error) {
+	var (
+		key    string
+		source CaptionSource
+	)
+	err := t.QueryRow("SELECT object_key, source FROM video_captions WHERE video_id = ? AND language = ?", videoID, language).Scan(&key, &source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return key, source, err
+}
+
+func putCaption(t *tx, videoID uuid.UUID, caption Caption) error {
+	_, err := t.Exec(`
+	INSERT INTO video_captions (
+		video_id,
+		language,
+		label,
+		source,
+		object_key,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id, language) DO UPDATE SET
+		label = excluded.label,
+		source = excluded.source,
+		object_key = excluded.object_key,
+		updated_at = excluded.updated_at
+	`, videoID, caption.Language, caption.Label, caption.Source, caption.ObjectKey)
+	return err
+}
+
+// DeleteVideoCaption removes the caption track of a language from a video.
+// It returns the object key of the track, empty when there was none.
+func (c Client) DeleteVideoCaption(videoID uuid.UUID, language string) (string, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return "", err
+	}
+	defer t.Rollback()
+
+	key, _, err := getCaptionSource(t, videoID, language)
+	if err != nil || key == "" {
+		return "", err
+	}
+	if _, err := t.Exec("DELETE FROM video_captions WHERE video_id = ? AND language = ?", videoID, language); err != nil {
+		return "", err
+	}
+	if err := bumpVideoVersion(t, videoID); err != nil {
+		return "", err
+	}
+	return key, t.Commit()
+}
+
+func bumpVideoVersion(t *tx, videoID uuid.UUID) error {
+	_, err := t.Exec("UPDATE videos SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", videoID)
+	return err
+}
+
+// GetCaptionObjectKeys returns the object keys of all caption tracks.
+func (c Client) GetCaptionObjectKeys() ([]string, error) {
+	rows, err := c.db.Query("SELECT object_key FROM video_captions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// loadCaptions fills in the captions of videos with one query.
+func (c Client) loadCaptions(videos []Video) error {
+	if len(videos) == 0 {
+		return nil
+	}
+	index := make(map[uuid.UUID]int, len(videos))
+	args := make([]any, len(videos))
+	for i := range videos {
+		videos[i].Captions = []Caption{}
+		index[videos[i].ID] = i
+		args[i] = videos[i].ID
+	}
+	query := `
+	SELECT video_id,` + captionColumns + `
+	FROM video_captions
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videos)-1) + `)
+	ORDER BY language
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			videoID uuid.UUID
+			caption Caption
+		)
+		err := rows.Scan(&videoID, &caption.Language, &caption.Label, &caption.Source, &caption.ObjectKey, &caption.CreatedAt, &caption.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[videoID]; ok {
+			videos[i].Captions = append(videos[i].Captions, caption)
+		}
+	}
+	return rows.Err()
+}
+
+// loadRelated fills in the tags and captions of videos.
+func (c Client) loadRelated(videos []Video) error {
+	if err := c.loadTags(videos); err != nil {
+		return err
+	}
+	return c.loadCaptions(videos)
+}
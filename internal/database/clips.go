@@ -0,0 +1,33 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoClip is the part of a source video a clip was cut from, in seconds.
+// SourceVideoID is nil once the source is deleted.
+type VideoClip struct {
+	SourceVideoID *uuid.UUID `json:"source_video_id"`
+	Start         float64    `json:"start"`
+	End           float64    `json:"end"`
+}
+
+// GetVideoClips returns the clips cut from a video, trashed ones left out,
+// newest first.
+func (c Client) GetVideoClips(sourceID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE clip_source_id = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC, id DESC
+	`
+	rows, err := c.db.Query(query, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
+}
@@ -1,89 +1,136 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"time"
 
+	"github.com/XSAM/otelsql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
-	db *sql.DB
+	db *conn
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// PoolConfig controls the connection pool of the underlying sql.DB.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// sqliteWALConns is how many connections a SQLite database in WAL mode
+// gets. Readers run alongside the one writer, writers wait for each other
+// up to the driver's busy timeout.
+const sqliteWALConns = 4
+
+// DefaultPoolConfig returns the pool limits for the database at dsn. SQLite
+// without WAL only lets one connection use the file at a time, with WAL
+// readers don't block on the writer.
+func DefaultPoolConfig(dsn string) PoolConfig {
+	if dialectFor(dsn) == dialectPostgres {
+		return PoolConfig{
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		}
 	}
-	c := Client{db}
-	err = c.autoMigrate()
-	if err != nil {
-		return Client{}, err
+	if sqliteWAL(dsn) {
+		return PoolConfig{
+			MaxOpenConns:    sqliteWALConns,
+			MaxIdleConns:    sqliteWALConns,
+			ConnMaxLifetime: 0,
+		}
+	}
+	return PoolConfig{
+		MaxOpenConns:    1,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: 0,
 	}
-	return c, nil
-
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
+// NewClient opens the database. dsn is either a postgres:// URL or the path
+// of a SQLite file. The schema is left alone until Migrate is called.
+func NewClient(dsn string, pool PoolConfig) (Client, error) {
+	d := dialectFor(dsn)
+	system := semconv.DBSystemSqlite
+	if d == dialectPostgres {
+		system = semconv.DBSystemPostgreSQL
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
+	db, err := otelsql.Open(d.driverName(), dsn,
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// queries made without a traced context would each start a
+			// trace of their own
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
 	if err != nil {
-		return err
+		return Client{}, err
 	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	return Client{&conn{DB: db, dialect: d}}, nil
+}
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
-	}
-	return nil
+// WithContext returns a Client running its queries with ctx, so they are
+// cancelled with the request or job they are made for and traced as part
+// of it.
+func (c Client) WithContext(ctx context.Context) Client {
+	return Client{&conn{DB: c.db.DB, dialect: c.db.dialect, ctx: ctx}}
+}
+
+// Ping checks that the database can be reached.
+func (c Client) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// Stats returns the connection pool statistics of the database handle.
+func (c Client) Stats() sql.DBStats {
+	return c.db.Stats()
 }
 
 func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
-	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	// tables referencing others go first
+	tables := []string{
+		"jobs",
+		"user_deletions",
+		"user_exports",
+		"user_watermarks",
+		"idempotency_keys",
+		"api_keys",
+		"video_reports",
+		"playbacks",
+		"upload_parts",
+		"uploads",
+		"refresh_tokens",
+		"sessions",
+		"playlist_videos",
+		"playlists",
+		"share_links",
+		"video_tags",
+		"video_captions",
+		"tags",
+		"videos",
+		"webhook_deliveries",
+		"webhooks",
+		"users",
+		"plans",
+		"quarantined_uploads",
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	for _, table := range tables {
+		if _, err := c.db.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,99 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestDefaultPoolConfig(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want database.PoolConfig
+	}{
+		{"postgres://tubely@localhost/tubely", database.PoolConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}},
+		{"postgresql://tubely@localhost/tubely", database.PoolConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}},
+		{"tubely.db", database.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}},
+		{"file:tubely.db?_journal_mode=DELETE", database.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}},
+		// readers don't wait for the writer in WAL mode
+		{"tubely.db?_journal_mode=WAL", database.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 4}},
+		{"file:tubely.db?_busy_timeout=1000&_journal=wal", database.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 4}},
+	}
+	for _, tt := range tests {
+		if got := database.DefaultPoolConfig(tt.dsn); got != tt.want {
+			t.Errorf("DefaultPoolConfig(%q) = %+v, want %+v", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestPoolConfigFromEnv(t *testing.T) {
+	sqlite := filepath.Join(t.TempDir(), "tubely.db")
+	tests := []struct {
+		name     string
+		env      map[string]string
+		want     database.PoolConfig
+		maxConns int
+	}{
+		{
+			name:     "sqlite defaults",
+			env:      map[string]string{"DB_PATH": sqlite},
+			want:     database.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1},
+			maxConns: 1,
+		},
+		{
+			name:     "sqlite wal defaults",
+			env:      map[string]string{"DB_PATH": sqlite + "?_journal_mode=WAL"},
+			want:     database.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 4},
+			maxConns: 4,
+		},
+		{
+			name:     "postgres defaults",
+			env:      map[string]string{"DB_PATH": "postgres://tubely@localhost/tubely"},
+			want:     database.PoolConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute},
+			maxConns: 10,
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				"DB_PATH":              "postgres://tubely@localhost/tubely",
+				"DB_MAX_OPEN_CONNS":    "25",
+				"DB_MAX_IDLE_CONNS":    "0",
+				"DB_CONN_MAX_LIFETIME": "5m",
+			},
+			want:     database.PoolConfig{MaxOpenConns: 25, ConnMaxLifetime: 5 * time.Minute},
+			maxConns: 25,
+		},
+		{
+			name:     "sqlite override",
+			env:      map[string]string{"DB_PATH": sqlite, "DB_MAX_OPEN_CONNS": "4"},
+			want:     database.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 1},
+			maxConns: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"DB_PATH", "DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_CONN_MAX_LIFETIME"} {
+				t.Setenv(name, tt.env[name])
+			}
+			conf, err := config.LoadDatabase("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.DBPool != tt.want {
+				t.Fatalf("pool = %+v, want %+v", conf.DBPool, tt.want)
+			}
+
+			// opening doesn't connect, so postgres needs no server
+			db, err := database.NewClient(conf.DBPath, conf.DBPool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := db.Stats().MaxOpenConnections; got != tt.maxConns {
+				t.Errorf("MaxOpenConnections = %d, want %d", got, tt.maxConns)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dialect is the SQL flavour of the database behind a Client.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// dialectFor picks Postgres for postgres:// URLs and SQLite, with dsn as
+// the file path, for everything else.
+func dialectFor(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// sqliteWAL reports whether the SQLite dsn asks for the WAL journal, with
+// the _journal_mode or _journal parameter of the driver.
+func sqliteWAL(dsn string) bool {
+	_, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return false
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	for _, name := range []string{"_journal_mode", "_journal"} {
+		if strings.EqualFold(params.Get(name), "WAL") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d dialect) driverName() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite3"
+}
+
+// String names the dialect the way migration files are suffixed.
+func (d dialect) String() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+var (
+	ddlTimestamp = regexp.MustCompile(`\bTIMESTAMP\b`)
+	ddlInteger   = regexp.MustCompile(`\bINTEGER\b`)
+)
+
+// ddl adapts a schema statement written for SQLite. On Postgres
+// timestamps keep their time zone and, like SQLite's CURRENT_TIMESTAMP,
+// whole seconds; integers are 64 bit, as they are in SQLite.
+func (d dialect) ddl(statement string) string {
+	if d != dialectPostgres {
+		return statement
+	}
+	statement = ddlTimestamp.ReplaceAllString(statement, "TIMESTAMPTZ(0)")
+	return ddlInteger.ReplaceAllString(statement, "BIGINT")
+}
+
+// rebind turns the ? placeholders the queries are written with into the
+// numbered $1, $2, ... Postgres expects. Queries don't contain literal
+// question marks, so no quoting has to be considered.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conn is the *sql.DB of a Client with the query methods rebinding
+// placeholders for its dialect. Queries without a context of their own run
+// with ctx, see Client.WithContext.
+type conn struct {
+	*sql.DB
+	dialect dialect
+	ctx     context.Context
+}
+
+func (c *conn) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.ExecContext(c.context(), c.dialect.rebind(query), args...)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.QueryContext(c.context(), c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRowContext(c.context(), c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+// tx is a transaction of a Client, rebinding placeholders like conn. It is
+// rolled back when the context of the Client ends before the commit.
+type tx struct {
+	*sql.Tx
+	dialect dialect
+	ctx     context.Context
+}
+
+func (c *conn) begin() (*tx, error) {
+	t, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, dialect: c.dialect, ctx: c.context()}, nil
+}
+
+func (t *tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.ExecContext(t.ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.QueryContext(t.ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRowContext(t.ctx, t.dialect.rebind(query), args...)
+}
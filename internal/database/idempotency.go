@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey is a key a user sent with a request, and the response to
+// that request once it has finished.
+type IdempotencyKey struct {
+	UserID    uuid.UUID
+	Key       string
+	CreatedAt time.Time
+	// Request identifies what the key was first used for, a retry must
+	// match it.
+	Request        string
+	ResponseStatus *int
+	ResponseType   *string
+	ResponseBody   *string
+	// CompletedAt is nil while the first request is still running.
+	CompletedAt *time.Time
+}
+
+// ClaimIdempotencyKeyParams describe a request sent with an idempotency
+// key. Keys created before ExpiredBefore are forgotten, and so are keys
+// whose request never completed and that were created before
+// AbandonedBefore.
+type ClaimIdempotencyKeyParams struct {
+	UserID          uuid.UUID
+	Key             string
+	Request         string
+	ExpiredBefore   time.Time
+	AbandonedBefore time.Time
+}
+
+// ClaimIdempotencyKey records the key as used by the request. It reports
+// true when the caller now holds the key and must complete or release it,
+// otherwise it returns the key as recorded by the earlier request.
+func (c Client) ClaimIdempotencyKey(params ClaimIdempotencyKeyParams) (IdempotencyKey, bool, error) {
+	if _, err := c.db.Exec(
+		"DELETE FROM idempotency_keys WHERE created_at < ?",
+		c.timeArg(params.ExpiredBefore),
+	); err != nil {
+		return IdempotencyKey{}, false, err
+	}
+	abandoned := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = ? AND idempotency_key = ? AND completed_at IS NULL AND created_at < ?
+	`
+	if _, err := c.db.Exec(abandoned, params.UserID, params.Key, c.timeArg(params.AbandonedBefore)); err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	query := `
+	INSERT INTO idempotency_keys (
+		user_id,
+		idempotency_key,
+		created_at,
+		request
+	) VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT (user_id, idempotency_key) DO NOTHING
+	`
+	res, err := c.db.Exec(query, params.UserID, params.Key, params.Request)
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+	if n > 0 {
+		return IdempotencyKey{}, true, nil
+	}
+
+	existing, err := c.getIdempotencyKey(params.UserID, params.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released in the meantime, the retry can go ahead next time
+		return IdempotencyKey{}, false, nil
+	}
+	return existing, false, err
+}
+
+func (c Client) getIdempotencyKey(userID uuid.UUID, key string) (IdempotencyKey, error) {
+	query := `
+	SELECT
+		user_id,
+		idempotency_key,
+		created_at,
+		request,
+		response_status,
+		response_type,
+		response_body,
+		completed_at
+	FROM idempotency_keys
+	WHERE user_id = ? AND idempotency_key = ?
+	`
+	var k IdempotencyKey
+	err := c.db.QueryRow(query, userID, key).Scan(
+		&k.UserID,
+		&k.Key,
+		&k.CreatedAt,
+		&k.Request,
+		&k.ResponseStatus,
+		&k.ResponseType,
+		&k.ResponseBody,
+		&k.CompletedAt,
+	)
+	return k, err
+}
+
+// CompleteIdempotencyKey stores the response to the request holding the
+// key.
+func (c Client) CompleteIdempotencyKey(userID uuid.UUID, key string, status int, contentType, body string) error {
+	query := `
+	UPDATE idempotency_keys
+	SET
+		response_status = ?,
+		response_type = ?,
+		response_body = ?,
+		completed_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND idempotency_key = ?
+	`
+	_, err := c.db.Exec(query, status, contentType, body, userID, key)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a key whose request shouldn't be replayed,
+// so it can be retried.
+func (c Client) ReleaseIdempotencyKey(userID uuid.UUID, key string) error {
+	_, err := c.db.Exec(
+		"DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?",
+		userID, key,
+	)
+	return err
+}
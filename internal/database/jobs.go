@@ -0,0 +1,246 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Kind    string    `json:"kind"`
+	Payload string    `json:"payload"`
+	RunAt   time.Time `json:"run_at"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		kind,
+		payload,
+		status,
+		attempts,
+		run_at,
+		last_error`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		kind,
+		payload,
+		status,
+		attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Kind, params.Payload, JobStatusQueued, params.RunAt.UTC())
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest queued job that is due as running and returns
+// it. It returns nil when there is nothing to do.
+func (c Client) ClaimJob(now time.Time) (*Job, error) {
+	// on Postgres several servers can share the queue, so rows another
+	// worker is claiming are skipped instead of waited for
+	lock := ""
+	if c.db.dialect == dialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+		` + lock + `
+	)
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.db.QueryRow(query, JobStatusRunning, JobStatusQueued, now.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusDone, id)
+	return err
+}
+
+// RetryJob puts a failed job back into the queue to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		run_at = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, runAt.UTC(), lastError, id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusFailed, lastError, id)
+	return err
+}
+
+// RequeueRunningJobs puts jobs that were running when the server stopped
+// back into the queue.
+// ReleaseJob puts a running job back in the queue without counting the
+// interrupted attempt.
+func (c Client) ReleaseJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = CASE WHEN attempts > 0 THEN attempts - 1 ELSE 0 END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, id, JobStatusRunning)
+	return err
+}
+
+func (c Client) RequeueRunningJobs() error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, JobStatusRunning)
+	return err
+}
+
+// GetUnfinishedJobs returns the queued and running jobs of a kind.
+func (c Client) GetUnfinishedJobs(kind string) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE kind = ? AND status IN (?, ?)
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, kind, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// FailQueuedJob fails a job that no worker has claimed yet. It reports
+// whether the job was still queued.
+func (c Client) FailQueuedJob(id uuid.UUID, lastError string) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	res, err := c.db.Exec(query, JobStatusFailed, lastError, id, JobStatusQueued)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
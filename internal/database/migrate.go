@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/migrations"
+)
+
+// migrationLockKey is the Postgres advisory lock held while migrating, so
+// instances starting together don't apply the same migration twice.
+const migrationLockKey = 7265937
+
+// Migrate applies the migrations the database hasn't seen yet, each in a
+// transaction of its own, and returns them.
+func (c Client) Migrate(ctx context.Context) ([]migrations.Migration, error) {
+	if c.db.dialect == dialectPostgres {
+		lock, err := c.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Close()
+		if _, err := lock.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return nil, fmt.Errorf("cannot lock migrations: %w", err)
+		}
+		defer lock.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+
+	if err := c.adoptLegacySchema(ctx); err != nil {
+		return nil, err
+	}
+	pending, err := c.PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := c.apply(ctx, m); err != nil {
+			return pending[:i], fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// PendingMigrations returns the migrations Migrate would apply.
+func (c Client) PendingMigrations(ctx context.Context) ([]migrations.Migration, error) {
+	all, err := migrations.All(c.db.dialect.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := []migrations.Migration{}
+	for _, m := range all {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func (c Client) createMigrationsTable(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, c.db.dialect.ddl(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`))
+	return err
+}
+
+func (c Client) apply(ctx context.Context, m migrations.Migration) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, c.db.dialect.ddl(m.SQL)); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, c.db.dialect.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.Version, m.Name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacySchema brings databases created before migrations existed up
+// to the schema of the first migration. Their tables were created once and
+// then had columns added at startup, so depending on the release they were
+// created by some of those columns may be missing.
+func (c Client) adoptLegacySchema(ctx context.Context) error {
+	tracked, err := c.tableExists(ctx, "schema_migrations")
+	if err != nil || tracked {
+		return err
+	}
+	legacy, err := c.tableExists(ctx, "videos")
+	if err != nil || !legacy {
+		return err
+	}
+	columns := []struct{ name, definition string }{
+		{"status", "TEXT NOT NULL DEFAULT 'ready'"},
+		{"renditions", "TEXT"},
+		{"video_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"thumbnail_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"original_format", "TEXT"},
+		{"checksum", "TEXT"},
+	}
+	for _, col := range columns {
+		if err := c.addColumnIfMissing("videos", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Client) tableExists(ctx context.Context, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if c.db.dialect == dialectPostgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var n int
+	if err := c.db.QueryRowContext(ctx, query, table).Scan(&n); err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// addColumnIfMissing adds a column to a table created by an earlier
+// release, since CREATE TABLE IF NOT EXISTS leaves those untouched.
+func (c Client) addColumnIfMissing(table, column, definition string) error {
+	definition = c.db.dialect.ddl(definition)
+	if c.db.dialect == dialectPostgres {
+		_, err := c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		return err
+	}
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,29 @@
+-- Stored objects used to be referenced as "bucket,key" in video_url and
+-- thumbnail_url, which breaks on keys containing a comma. They get
+-- provider, bucket and key columns instead; a NULL provider means the
+-- object was stored before providers were recorded, with the provider the
+-- server is configured with. thumbnail_url keeps external URLs only.
+
+ALTER TABLE videos ADD COLUMN video_provider TEXT;
+ALTER TABLE videos ADD COLUMN video_bucket TEXT;
+ALTER TABLE videos ADD COLUMN video_key TEXT;
+ALTER TABLE videos ADD COLUMN thumbnail_provider TEXT;
+ALTER TABLE videos ADD COLUMN thumbnail_bucket TEXT;
+ALTER TABLE videos ADD COLUMN thumbnail_key TEXT;
+
+UPDATE videos SET
+	video_bucket = substr(video_url, 1, instr(video_url, ',') - 1),
+	video_key = substr(video_url, instr(video_url, ',') + 1)
+WHERE instr(video_url, ',') > 0;
+
+UPDATE videos SET
+	thumbnail_bucket = substr(thumbnail_url, 1, instr(thumbnail_url, ',') - 1),
+	thumbnail_key = substr(thumbnail_url, instr(thumbnail_url, ',') + 1),
+	thumbnail_url = NULL
+WHERE instr(thumbnail_url, ',') > 0
+	AND thumbnail_url NOT LIKE 'http://%'
+	AND thumbnail_url NOT LIKE 'https://%';
+
+ALTER TABLE videos DROP COLUMN video_url;
+
+CREATE INDEX videos_video_key ON videos (video_key);
@@ -0,0 +1,13 @@
+-- Videos can be listed by aspect ratio. It used to be kept in the key
+-- prefix of the video object only, where existing rows get it from.
+
+ALTER TABLE videos ADD COLUMN aspect_ratio TEXT;
+
+UPDATE videos SET aspect_ratio = CASE
+	WHEN video_key LIKE 'landscape/%' THEN '16:9'
+	WHEN video_key LIKE 'portrait/%' THEN '9:16'
+	ELSE 'other'
+END
+WHERE video_key IS NOT NULL;
+
+CREATE INDEX videos_user_created ON videos (user_id, created_at, id);
@@ -0,0 +1,10 @@
+-- Full-text index over video titles and descriptions. Titles weigh more
+-- than descriptions. The simple configuration doesn't stem, like the
+-- SQLite index.
+
+ALTER TABLE videos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+
+CREATE INDEX videos_search ON videos USING GIN (search);
@@ -0,0 +1,29 @@
+-- Full-text index over video titles and descriptions, kept in sync by
+-- triggers. FTS4 is used because FTS5 needs the sqlite_fts5 build tag.
+-- video_id isn't indexed, it only links rows back to videos.
+
+CREATE VIRTUAL TABLE videos_search USING fts4(
+	video_id,
+	title,
+	description,
+	notindexed=video_id,
+	tokenize=unicode61
+);
+
+INSERT INTO videos_search (video_id, title, description)
+SELECT id, title, COALESCE(description, '') FROM videos;
+
+CREATE TRIGGER videos_search_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_search (video_id, title, description)
+	VALUES (new.id, new.title, COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER videos_search_update AFTER UPDATE OF title, description ON videos BEGIN
+	UPDATE videos_search
+	SET title = new.title, description = COALESCE(new.description, '')
+	WHERE video_id = old.id;
+END;
+
+CREATE TRIGGER videos_search_delete AFTER DELETE ON videos BEGIN
+	DELETE FROM videos_search WHERE video_id = old.id;
+END;
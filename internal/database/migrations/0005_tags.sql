@@ -0,0 +1,18 @@
+-- Tags are shared by name between users; which videos carry them is
+-- recorded per video.
+
+CREATE TABLE tags (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	name TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE video_tags (
+	video_id TEXT NOT NULL,
+	tag_id TEXT NOT NULL,
+	PRIMARY KEY (video_id, tag_id),
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX video_tags_tag ON video_tags (tag_id);
@@ -0,0 +1,25 @@
+CREATE TABLE playlists (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX playlists_user ON playlists (user_id, created_at);
+
+-- position orders the videos of a playlist, gaps left by deleted videos
+-- are closed the next time the playlist changes
+CREATE TABLE playlist_videos (
+	playlist_id TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (playlist_id, video_id),
+	FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX playlist_videos_video ON playlist_videos (video_id);
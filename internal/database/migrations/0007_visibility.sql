@@ -0,0 +1,6 @@
+-- Videos are public, unlisted or private. New videos are private unless
+-- created otherwise, existing ones stay reachable by their ID like before.
+
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private';
+
+UPDATE videos SET visibility = 'unlisted';
@@ -0,0 +1,14 @@
+-- Share links hand out a video to anyone holding their token, which is
+-- signed and not stored. The row makes a link revocable and counts views.
+CREATE TABLE share_links (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	max_views INTEGER,
+	views INTEGER NOT NULL DEFAULT 0,
+	revoked_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX share_links_video ON share_links (video_id, created_at);
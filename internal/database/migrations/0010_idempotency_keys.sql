@@ -0,0 +1,17 @@
+-- Idempotency keys remember the response to a request so a client retrying
+-- it with the same key gets the response again instead of a second upload.
+-- completed_at is NULL while the first request is still running.
+CREATE TABLE idempotency_keys (
+	user_id TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	request TEXT NOT NULL,
+	response_status INTEGER,
+	response_type TEXT,
+	response_body TEXT,
+	completed_at TIMESTAMP,
+	PRIMARY KEY (user_id, idempotency_key),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idempotency_keys_created ON idempotency_keys (created_at);
@@ -0,0 +1,23 @@
+-- A session is one login: the refresh tokens rotated from it and the access
+-- tokens issued with them, which name the session so revoking it revokes
+-- them all. Refresh tokens from before sessions are revoked, their access
+-- tokens stop working too and users log in again.
+
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	refreshed_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user ON sessions (user_id, created_at);
+
+ALTER TABLE refresh_tokens ADD COLUMN session_id TEXT REFERENCES sessions(id) ON DELETE CASCADE;
+
+CREATE INDEX refresh_tokens_session ON refresh_tokens (session_id);
+
+UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE revoked_at IS NULL;
@@ -0,0 +1,4 @@
+-- Users are ordinary users or admins, who can manage every user's videos
+-- through the admin endpoints. Admins are appointed with `tubely role`.
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
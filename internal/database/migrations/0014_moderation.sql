@@ -0,0 +1,24 @@
+-- Users report videos they can see, admins work through the open reports
+-- and can take videos down, which stops their URLs from being handed out
+-- until they are restored.
+
+ALTER TABLE videos ADD COLUMN moderation_status TEXT NOT NULL DEFAULT 'active';
+
+CREATE TABLE video_reports (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	reporter_id TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	resolved_at TIMESTAMP,
+	resolution TEXT,
+	resolved_by TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(reporter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX video_reports_queue ON video_reports (resolved_at, created_at);
+
+-- one open report per user and video
+CREATE UNIQUE INDEX video_reports_open ON video_reports (video_id, reporter_id) WHERE resolved_at IS NULL;
@@ -0,0 +1,17 @@
+-- A playback is one viewing of a video, built up from the events the
+-- player reports. Videos keep a running count of them.
+
+ALTER TABLE videos ADD COLUMN view_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE playbacks (
+	id TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	viewer TEXT NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	watched_seconds INTEGER NOT NULL DEFAULT 0,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX playbacks_video ON playbacks (video_id, started_at);
@@ -0,0 +1,14 @@
+-- Uploads with a part size are chunked uploads. Their parts may arrive in
+-- any order and are tracked until the upload is completed.
+
+ALTER TABLE uploads ADD COLUMN part_size INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE upload_parts (
+	upload_id TEXT NOT NULL,
+	part_number INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (upload_id, part_number),
+	FOREIGN KEY(upload_id) REFERENCES uploads(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Videos keep what ffprobe reported about their stored file, as JSON.
+-- Existing videos get it when they are processed again.
+
+ALTER TABLE videos ADD COLUMN metadata TEXT;
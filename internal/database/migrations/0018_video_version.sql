@@ -0,0 +1,4 @@
+-- Videos count the edits to their metadata, so an edit based on an older
+-- version can be refused instead of overwriting the newer one.
+
+ALTER TABLE videos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,6 @@
+-- Deleted videos go to the trash first: they are hidden everywhere but the
+-- trash until they are restored or purged for good.
+
+ALTER TABLE videos ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX videos_deleted_at ON videos (deleted_at);
@@ -0,0 +1,8 @@
+-- Videos can be scheduled to go public at publish_at and to expire at
+-- expires_at, both checked by the scheduler.
+
+ALTER TABLE videos ADD COLUMN publish_at TIMESTAMP;
+ALTER TABLE videos ADD COLUMN expires_at TIMESTAMP;
+
+CREATE INDEX videos_publish_at ON videos (publish_at);
+CREATE INDEX videos_expires_at ON videos (expires_at);
@@ -0,0 +1,6 @@
+-- The objects of a video can be archived to a cold storage class, they have
+-- to be restored before the video can be played again.
+
+ALTER TABLE videos ADD COLUMN storage_tier TEXT NOT NULL DEFAULT 'hot';
+
+CREATE INDEX videos_storage_tier ON videos (storage_tier);
@@ -0,0 +1,5 @@
+-- replica_key is the video object last copied to the replica bucket, with
+-- the renditions and HLS segments. The replica is only used while it is
+-- still the video's object.
+
+ALTER TABLE videos ADD COLUMN replica_key TEXT;
@@ -0,0 +1,18 @@
+-- Deleting a user removes their videos, objects and every row about them
+-- in the background. The deletion outlives the user so its progress can
+-- still be looked up once they are gone.
+
+CREATE TABLE user_deletions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	requested_by TEXT,
+	status TEXT NOT NULL,
+	videos_total INTEGER NOT NULL DEFAULT 0,
+	videos_deleted INTEGER NOT NULL DEFAULT 0,
+	completed_at TIMESTAMP,
+	last_error TEXT
+);
+
+CREATE UNIQUE INDEX user_deletions_user ON user_deletions (user_id);
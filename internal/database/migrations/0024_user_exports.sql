@@ -0,0 +1,17 @@
+-- Users can export everything stored about them. An export is assembled
+-- in the background into one object, downloadable until expires_at.
+
+CREATE TABLE user_exports (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	user_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	object_key TEXT,
+	completed_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	last_error TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_exports_user ON user_exports (user_id, created_at);
@@ -0,0 +1,13 @@
+-- Videos can have one WebVTT caption track per language, stored as an
+-- object next to the video.
+
+CREATE TABLE video_captions (
+	video_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	label TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (video_id, language),
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
@@ -0,0 +1,19 @@
+-- Videos can be transcribed into a caption track marked as coming from
+-- transcription and a plain text transcript, which is searched along with
+-- titles and descriptions and weighs the least.
+
+ALTER TABLE video_captions ADD COLUMN source TEXT NOT NULL DEFAULT 'upload';
+
+ALTER TABLE videos ADD COLUMN transcript TEXT;
+ALTER TABLE videos ADD COLUMN transcript_language TEXT;
+ALTER TABLE videos ADD COLUMN transcribed_at TIMESTAMP;
+
+DROP INDEX videos_search;
+ALTER TABLE videos DROP COLUMN search;
+ALTER TABLE videos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B') ||
+	setweight(to_tsvector('simple', COALESCE(transcript, '')), 'C')
+) STORED;
+
+CREATE INDEX videos_search ON videos USING GIN (search);
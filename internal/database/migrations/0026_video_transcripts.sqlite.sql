@@ -0,0 +1,42 @@
+-- Videos can be transcribed into a caption track marked as coming from
+-- transcription and a plain text transcript, which is searched along with
+-- titles and descriptions. FTS4 tables can't get new columns, the index is
+-- built again.
+
+ALTER TABLE video_captions ADD COLUMN source TEXT NOT NULL DEFAULT 'upload';
+
+ALTER TABLE videos ADD COLUMN transcript TEXT;
+ALTER TABLE videos ADD COLUMN transcript_language TEXT;
+ALTER TABLE videos ADD COLUMN transcribed_at TIMESTAMP;
+
+DROP TRIGGER videos_search_insert;
+DROP TRIGGER videos_search_update;
+DROP TRIGGER videos_search_delete;
+DROP TABLE videos_search;
+
+CREATE VIRTUAL TABLE videos_search USING fts4(
+	video_id,
+	title,
+	description,
+	transcript,
+	notindexed=video_id,
+	tokenize=unicode61
+);
+
+INSERT INTO videos_search (video_id, title, description, transcript)
+SELECT id, title, COALESCE(description, ''), '' FROM videos;
+
+CREATE TRIGGER videos_search_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_search (video_id, title, description, transcript)
+	VALUES (new.id, new.title, COALESCE(new.description, ''), COALESCE(new.transcript, ''));
+END;
+
+CREATE TRIGGER videos_search_update AFTER UPDATE OF title, description, transcript ON videos BEGIN
+	UPDATE videos_search
+	SET title = new.title, description = COALESCE(new.description, ''), transcript = COALESCE(new.transcript, '')
+	WHERE video_id = old.id;
+END;
+
+CREATE TRIGGER videos_search_delete AFTER DELETE ON videos BEGIN
+	DELETE FROM videos_search WHERE video_id = old.id;
+END;
@@ -0,0 +1,9 @@
+-- Clips are videos cut from another video of the same user. They keep the
+-- part of the source they were cut from; the link to the source is
+-- cleared when it is deleted.
+
+ALTER TABLE videos ADD COLUMN clip_source_id TEXT REFERENCES videos(id) ON DELETE SET NULL;
+ALTER TABLE videos ADD COLUMN clip_start DOUBLE PRECISION;
+ALTER TABLE videos ADD COLUMN clip_end DOUBLE PRECISION;
+
+CREATE INDEX videos_clip_source_id ON videos (clip_source_id);
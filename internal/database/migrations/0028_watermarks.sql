@@ -0,0 +1,14 @@
+-- Users can register an image to burn into their processed videos instead
+-- of the operator's. Videos remember the watermark they got, so uploads are
+-- only deduplicated against videos branded the same way.
+
+CREATE TABLE user_watermarks (
+	user_id TEXT PRIMARY KEY,
+	object_key TEXT NOT NULL,
+	position TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE videos ADD COLUMN watermark TEXT NOT NULL DEFAULT '';
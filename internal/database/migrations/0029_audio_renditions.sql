@@ -0,0 +1,4 @@
+-- Videos can have an audio-only rendition for podcast feeds, stored as a
+-- JSON object like the video renditions.
+
+ALTER TABLE videos ADD COLUMN audio TEXT;
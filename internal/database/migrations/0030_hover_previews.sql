@@ -0,0 +1,4 @@
+-- The key of a short looping preview of each video, shown by listings on
+-- hover.
+
+ALTER TABLE videos ADD COLUMN preview_key TEXT;
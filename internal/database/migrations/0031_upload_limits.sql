@@ -0,0 +1,14 @@
+-- Upload size limits of plans, and of single users overriding their plan's.
+-- NULL limits fall back to the plan, then to the server's configuration.
+
+CREATE TABLE plans (
+	name TEXT PRIMARY KEY,
+	max_video_bytes BIGINT,
+	max_thumbnail_bytes BIGINT,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE users ADD COLUMN plan TEXT REFERENCES plans(name) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN max_video_bytes BIGINT;
+ALTER TABLE users ADD COLUMN max_thumbnail_bytes BIGINT;
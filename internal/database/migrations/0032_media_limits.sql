@@ -0,0 +1,5 @@
+-- Users exempt from the duration and resolution limits of uploads, and why
+-- a video failed when the reason is worth showing its owner.
+
+ALTER TABLE users ADD COLUMN media_limits_exempt BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE videos ADD COLUMN failure_reason TEXT;
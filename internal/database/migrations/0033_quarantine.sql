@@ -0,0 +1,14 @@
+-- Uploads the virus scanner found infected, moved out of staging for the
+-- admins to look at. They outlive the videos and users they came from.
+
+CREATE TABLE quarantined_uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	signature TEXT NOT NULL
+);
+
+CREATE INDEX quarantined_uploads_created_at ON quarantined_uploads (created_at);
@@ -0,0 +1,9 @@
+-- The score the content classifier gave each video, and reports it files
+-- itself, which have no reporter.
+
+ALTER TABLE videos ADD COLUMN content_score DOUBLE PRECISION;
+
+ALTER TABLE video_reports ALTER COLUMN reporter_id DROP NOT NULL;
+
+-- one open report filed by the classifier per video
+CREATE UNIQUE INDEX video_reports_open_automated ON video_reports (video_id) WHERE resolved_at IS NULL AND reporter_id IS NULL;
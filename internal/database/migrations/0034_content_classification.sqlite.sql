@@ -0,0 +1,29 @@
+-- The score the content classifier gave each video, and reports it files
+-- itself, which have no reporter. SQLite can't drop NOT NULL, so the
+-- reports table is rebuilt.
+
+ALTER TABLE videos ADD COLUMN content_score DOUBLE PRECISION;
+
+CREATE TABLE video_reports_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	reporter_id TEXT,
+	reason TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	resolved_at TIMESTAMP,
+	resolution TEXT,
+	resolved_by TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(reporter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO video_reports_new SELECT * FROM video_reports;
+DROP TABLE video_reports;
+ALTER TABLE video_reports_new RENAME TO video_reports;
+
+CREATE INDEX video_reports_queue ON video_reports (resolved_at, created_at);
+
+-- one open report per user and video, and one filed by the classifier
+CREATE UNIQUE INDEX video_reports_open ON video_reports (video_id, reporter_id) WHERE resolved_at IS NULL;
+CREATE UNIQUE INDEX video_reports_open_automated ON video_reports (video_id) WHERE resolved_at IS NULL AND reporter_id IS NULL;
@@ -0,0 +1,63 @@
+// Package migrations holds the schema of the database as a sequence of
+// versioned SQL files, named NNNN_description.sql and embedded into the
+// binary. Files are written for SQLite; the database package adapts their
+// types for Postgres. A migration that needs different SQL per database
+// comes as NNNN_description.sqlite.sql and NNNN_description.postgres.sql
+// instead. A migration that has been released is never edited,
+// later changes go into a new file with the next version.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// All returns the migrations for a dialect, "sqlite" or "postgres", in
+// version order.
+func All(dialect string) ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	all := make([]Migration, 0, len(names))
+	seen := map[int]string{}
+	for _, file := range names {
+		base := strings.TrimSuffix(file, ".sql")
+		if ext := path.Ext(base); ext == ".sqlite" || ext == ".postgres" {
+			if ext[1:] != dialect {
+				continue
+			}
+			base = strings.TrimSuffix(base, ext)
+		}
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_description.sql", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, file, version)
+		}
+		seen[version] = file
+		dat, err := files.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, Migration{Version: version, Name: name, SQL: string(dat)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrPlaybackOfOtherVideo is returned for an event whose playback ID was
+// already used for another video.
+var ErrPlaybackOfOtherVideo = errors.New("playback belongs to another video")
+
+// PlaybackEvent is what the player reports about a playback.
+type PlaybackEvent string
+
+const (
+	PlaybackEventStart    PlaybackEvent = "start"
+	PlaybackEventProgress PlaybackEvent = "progress"
+	PlaybackEventComplete PlaybackEvent = "complete"
+)
+
+// Playback is one viewing of a video.
+type Playback struct {
+	ID      uuid.UUID
+	VideoID uuid.UUID
+	// Viewer tells viewers apart, it is the same for every playback by
+	// one user or anonymous client.
+	Viewer         string
+	StartedAt      time.Time
+	WatchedSeconds int64
+	CompletedAt    *time.Time
+}
+
+type RecordPlaybackEventParams struct {
+	PlaybackID uuid.UUID
+	VideoID    uuid.UUID
+	Viewer     string
+	Event      PlaybackEvent
+	// Position is how far into the video the playback got, in seconds.
+	Position int64
+}
+
+// RecordPlaybackEvent adds an event to its playback, starting the playback
+// and counting it as a view of the video on its first event, whichever
+// that is. The watched seconds of a playback only grow.
+func (c Client) RecordPlaybackEvent(params RecordPlaybackEventParams) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	res, err := t.Exec(`
+	INSERT INTO playbacks (
+		id,
+		video_id,
+		viewer,
+		started_at,
+		updated_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`, params.PlaybackID, params.VideoID, params.Viewer)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		// updated_at is left alone, views aren't an edit of the video
+		_, err := t.Exec("UPDATE videos SET view_count = view_count + 1 WHERE id = ?", params.VideoID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+	UPDATE playbacks
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		watched_seconds = CASE WHEN watched_seconds < ? THEN ? ELSE watched_seconds END`
+	if params.Event == PlaybackEventComplete {
+		query += `,
+		completed_at = COALESCE(completed_at, CURRENT_TIMESTAMP)`
+	}
+	query += `
+	WHERE id = ? AND video_id = ?
+	`
+	res, err = t.Exec(query, params.Position, params.Position, params.PlaybackID, params.VideoID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPlaybackOfOtherVideo
+	}
+	return t.Commit()
+}
+
+// GetPlaybacks returns the playbacks of a video started in [from, to),
+// oldest first.
+func (c Client) GetPlaybacks(videoID uuid.UUID, from, to time.Time) ([]Playback, error) {
+	query := `
+	SELECT id, video_id, viewer, started_at, watched_seconds, completed_at
+	FROM playbacks
+	WHERE video_id = ? AND started_at >= ? AND started_at < ?
+	ORDER BY started_at, id
+	`
+	rows, err := c.db.Query(query, videoID, c.timeArg(from), c.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playbacks := []Playback{}
+	for rows.Next() {
+		var p Playback
+		err := rows.Scan(&p.ID, &p.VideoID, &p.Viewer, &p.StartedAt, &p.WatchedSeconds, &p.CompletedAt)
+		if err != nil {
+			return nil, err
+		}
+		playbacks = append(playbacks, p)
+	}
+	return playbacks, rows.Err()
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrPlaylistOrder is returned by ReorderPlaylist when the new order isn't
+// made of exactly the videos in the playlist.
+var ErrPlaylistOrder = errors.New("order must list every video of the playlist once")
+
+type Playlist struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	VideoCount int       `json:"video_count"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UserID      uuid.UUID `json:"user_id"`
+}
+
+const playlistColumns = `
+		p.id,
+		p.created_at,
+		p.updated_at,
+		p.title,
+		p.description,
+		p.user_id,
+		(SELECT COUNT(*) FROM playlist_videos pv JOIN videos v ON v.id = pv.video_id WHERE pv.playlist_id = p.id AND v.deleted_at IS NULL)`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var p Playlist
+	err := row.Scan(
+		&p.ID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.Title,
+		&p.Description,
+		&p.UserID,
+		&p.VideoCount,
+	)
+	return p, err
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		title,
+		description
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Title, params.Description)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(id)
+}
+
+// GetPlaylist returns a zero Playlist when there is none with the id.
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists p
+	WHERE p.id = ?
+	`
+	p, err := scanPlaylist(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Playlist{}, nil
+	}
+	return p, err
+}
+
+// GetPlaylists returns the playlists of a user, newest first.
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists p
+	WHERE p.user_id = ?
+	ORDER BY p.created_at DESC, p.id DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		p, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+func (c Client) UpdatePlaylist(p Playlist) error {
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		title = ?,
+		description = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, p.Title, p.Description, p.ID)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	// SQLite doesn't enforce the cascade
+	if _, err := t.Exec("DELETE FROM playlist_videos WHERE playlist_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM playlists WHERE id = ?", id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// GetPlaylistVideos returns the videos of a playlist in order.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	columns := strings.ReplaceAll(videoColumns, "\n\t\t", "\n\t\tv.")
+	query := `
+	SELECT` + columns + `
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY pv.position, pv.added_at
+	`
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
+}
+
+// AddPlaylistVideo inserts a video into a playlist at position, counted
+// from 0, or at the end when position is nil or past it. A video already
+// in the playlist is moved.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID, position *int) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	order, err := playlistOrder(t, playlistID)
+	if err != nil {
+		return err
+	}
+	present := false
+	for i, id := range order {
+		if id == videoID {
+			order = append(order[:i], order[i+1:]...)
+			present = true
+			break
+		}
+	}
+	at := len(order)
+	if position != nil && *position >= 0 && *position < at {
+		at = *position
+	}
+	order = append(order[:at], append([]uuid.UUID{videoID}, order[at:]...)...)
+
+	if !present {
+		_, err := t.Exec(`
+		INSERT INTO playlist_videos (playlist_id, video_id, position, added_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`, playlistID, videoID, at)
+		if err != nil {
+			return err
+		}
+	}
+	if err := writePlaylistOrder(t, playlistID, order); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// RemovePlaylistVideo takes a video out of a playlist. It reports whether
+// the video was in it.
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return false, err
+	}
+	defer t.Rollback()
+
+	res, err := t.Exec("DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ?", playlistID, videoID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	order, err := playlistOrder(t, playlistID)
+	if err != nil {
+		return false, err
+	}
+	if err := writePlaylistOrder(t, playlistID, order); err != nil {
+		return false, err
+	}
+	return true, t.Commit()
+}
+
+// ReorderPlaylist puts the videos of a playlist in the given order, which
+// has to list each of them exactly once.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	order, err := playlistOrder(t, playlistID)
+	if err != nil {
+		return err
+	}
+	if len(order) != len(videoIDs) {
+		return ErrPlaylistOrder
+	}
+	current := make(map[uuid.UUID]bool, len(order))
+	for _, id := range order {
+		current[id] = true
+	}
+	for _, id := range videoIDs {
+		if !current[id] {
+			return ErrPlaylistOrder
+		}
+		// also catches repeats
+		delete(current, id)
+	}
+	if err := writePlaylistOrder(t, playlistID, videoIDs); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// playlistOrder returns the videos of a playlist in order. Trashed videos
+// are left out; they keep their old position and come back about where
+// they were when restored.
+func playlistOrder(t *tx, playlistID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := t.Query(`
+	SELECT pv.video_id
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY pv.position, pv.added_at
+	`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		order = append(order, id)
+	}
+	return order, rows.Err()
+}
+
+// writePlaylistOrder numbers the videos of a playlist from 0 in the given
+// order and marks the playlist as updated.
+func writePlaylistOrder(t *tx, playlistID uuid.UUID, order []uuid.UUID) error {
+	for i, id := range order {
+		_, err := t.Exec("UPDATE playlist_videos SET position = ? WHERE playlist_id = ? AND video_id = ?", i, playlistID, id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := t.Exec("UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID)
+	return err
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// GetPodcastVideos returns the public, ready videos of a user that have an
+// audio rendition, newest publication first. Videos scheduled for later or
+// expired are left to the caller.
+func (c Client) GetPodcastVideos(userID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND visibility = ? AND status = ? AND moderation_status = ?
+		AND audio IS NOT NULL AND deleted_at IS NULL
+	ORDER BY COALESCE(publish_at, created_at) DESC, id DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, VideoVisibilityPublic, VideoStatusReady, ModerationStatusActive, limit)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
+}
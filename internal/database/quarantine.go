@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QuarantinedUpload is an upload the virus scanner found infected, kept
+// until an admin deletes it.
+type QuarantinedUpload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateQuarantinedUploadParams
+}
+
+type CreateQuarantinedUploadParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ObjectKey string    `json:"-"`
+	Size      int64     `json:"size"`
+	// Signature is the name the scanner knows the infection by.
+	Signature string `json:"signature"`
+}
+
+const quarantinedUploadColumns = `
+		id,
+		created_at,
+		video_id,
+		user_id,
+		object_key,
+		size,
+		signature`
+
+func scanQuarantinedUpload(row rowScanner) (QuarantinedUpload, error) {
+	var q QuarantinedUpload
+	err := row.Scan(
+		&q.ID,
+		&q.CreatedAt,
+		&q.VideoID,
+		&q.UserID,
+		&q.ObjectKey,
+		&q.Size,
+		&q.Signature,
+	)
+	return q, err
+}
+
+func (c Client) CreateQuarantinedUpload(params CreateQuarantinedUploadParams) (QuarantinedUpload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO quarantined_uploads (
+		id,
+		created_at,
+		video_id,
+		user_id,
+		object_key,
+		size,
+		signature
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), params.VideoID.String(), params.UserID.String(), params.ObjectKey, params.Size, params.Signature)
+	if err != nil {
+		return QuarantinedUpload{}, err
+	}
+	return c.GetQuarantinedUpload(id)
+}
+
+// GetQuarantinedUpload returns a zero QuarantinedUpload when there is none
+// of the ID.
+func (c Client) GetQuarantinedUpload(id uuid.UUID) (QuarantinedUpload, error) {
+	q, err := scanQuarantinedUpload(c.db.QueryRow(`
+	SELECT`+quarantinedUploadColumns+`
+	FROM quarantined_uploads
+	WHERE id = ?
+	`, id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return QuarantinedUpload{}, nil
+	}
+	return q, err
+}
+
+// GetQuarantinedUploads returns the newest quarantined uploads first.
+func (c Client) GetQuarantinedUploads(limit int) ([]QuarantinedUpload, error) {
+	rows, err := c.db.Query(`
+	SELECT`+quarantinedUploadColumns+`
+	FROM quarantined_uploads
+	ORDER BY created_at DESC, id
+	LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	uploads := []QuarantinedUpload{}
+	for rows.Next() {
+		q, err := scanQuarantinedUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, q)
+	}
+	return uploads, rows.Err()
+}
+
+// GetQuarantineObjectKeys returns the keys of all quarantined uploads.
+func (c Client) GetQuarantineObjectKeys() ([]string, error) {
+	rows, err := c.db.Query("SELECT object_key FROM quarantined_uploads")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) DeleteQuarantinedUpload(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM quarantined_uploads WHERE id = ?", id.String())
+	return err
+}
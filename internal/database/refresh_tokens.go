@@ -12,6 +12,8 @@ type RefreshToken struct {
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// SessionID is nil for tokens issued before sessions.
+	SessionID *uuid.UUID `json:"session_id"`
 }
 
 type CreateRefreshTokenParams struct {
@@ -50,14 +52,14 @@ func (c Client) RevokeRefreshToken(token string) error {
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, session_id
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.SessionID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetVideoReplica records that the objects of a video were copied to the
+// replica bucket while its video object was key. It reports whether key is
+// still the video object; when it isn't, the copy is already stale.
+func (c Client) SetVideoReplica(id uuid.UUID, key string) (bool, error) {
+	query := `
+	UPDATE videos
+	SET replica_key = ?
+	WHERE id = ? AND video_key = ?
+	`
+	res, err := c.db.Exec(query, key, id, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClearVideoReplica records that the replica bucket has no copy of a
+// video.
+func (c Client) ClearVideoReplica(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE videos SET replica_key = NULL WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateReport is returned when a user reports a video their
+// earlier report of is still open.
+var ErrDuplicateReport = errors.New("video already reported by the user")
+
+// ReportResolution is what a moderator did about a report.
+type ReportResolution string
+
+const (
+	ReportResolutionTakenDown ReportResolution = "taken_down"
+	ReportResolutionDismissed ReportResolution = "dismissed"
+)
+
+// VideoReport is a user's complaint about a video, open until a moderator
+// resolves it.
+type VideoReport struct {
+	ID         uuid.UUID         `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ResolvedAt *time.Time        `json:"resolved_at"`
+	Resolution *ReportResolution `json:"resolution"`
+	// ResolvedBy is nil for reports resolved with the admin API key.
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+	CreateVideoReportParams
+}
+
+type CreateVideoReportParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// ReporterID is nil for reports filed by the content classifier.
+	ReporterID *uuid.UUID `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+}
+
+const videoReportColumns = `
+		id,
+		created_at,
+		video_id,
+		reporter_id,
+		reason,
+		details,
+		resolved_at,
+		resolution,
+		resolved_by`
+
+func scanVideoReport(row rowScanner) (VideoReport, error) {
+	var r VideoReport
+	err := row.Scan(
+		&r.ID,
+		&r.CreatedAt,
+		&r.VideoID,
+		&r.ReporterID,
+		&r.Reason,
+		&r.Details,
+		&r.ResolvedAt,
+		&r.Resolution,
+		&r.ResolvedBy,
+	)
+	return r, err
+}
+
+// CreateVideoReport files a report, or returns ErrDuplicateReport.
+func (c Client) CreateVideoReport(params CreateVideoReportParams) (VideoReport, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO video_reports (
+		id,
+		created_at,
+		video_id,
+		reporter_id,
+		reason,
+		details
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING
+	`
+	res, err := c.db.Exec(query, id, params.VideoID, params.ReporterID, params.Reason, params.Details)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return VideoReport{}, err
+	}
+	if n == 0 {
+		return VideoReport{}, ErrDuplicateReport
+	}
+	return c.GetVideoReport(id)
+}
+
+// GetVideoReport returns a zero VideoReport when there is none with the
+// id.
+func (c Client) GetVideoReport(id uuid.UUID) (VideoReport, error) {
+	query := `
+	SELECT` + videoReportColumns + `
+	FROM video_reports
+	WHERE id = ?
+	`
+	r, err := scanVideoReport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoReport{}, nil
+	}
+	return r, err
+}
+
+// ListVideoReportsParams selects the open or the resolved reports, of one
+// video when VideoID is set.
+type ListVideoReportsParams struct {
+	Resolved bool
+	VideoID  uuid.UUID
+	Limit    int
+	// AfterCreatedAt and AfterID continue a listing after the report with
+	// that id.
+	AfterCreatedAt time.Time
+	AfterID        uuid.UUID
+}
+
+// ListVideoReports returns reports oldest first, the order they are worked
+// through in.
+func (c Client) ListVideoReports(params ListVideoReportsParams) ([]VideoReport, error) {
+	state := "resolved_at IS NULL"
+	if params.Resolved {
+		state = "resolved_at IS NOT NULL"
+	}
+	query := `
+	SELECT` + videoReportColumns + `
+	FROM video_reports
+	WHERE ` + state
+	args := []any{}
+	if params.VideoID != uuid.Nil {
+		query += " AND video_id = ?"
+		args = append(args, params.VideoID)
+	}
+	if params.AfterID != uuid.Nil {
+		after := c.timeArg(params.AfterCreatedAt)
+		query += " AND (created_at > ? OR (created_at = ? AND id > ?))"
+		args = append(args, after, after, params.AfterID)
+	}
+	query += " ORDER BY created_at, id LIMIT ?"
+	args = append(args, params.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []VideoReport{}
+	for rows.Next() {
+		r, err := scanVideoReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// DismissVideoReport resolves an open report without acting on the video.
+// It reports false when there is no open report with the id.
+func (c Client) DismissVideoReport(id uuid.UUID, moderatorID *uuid.UUID) (bool, error) {
+	query := `
+	UPDATE video_reports
+	SET resolved_at = CURRENT_TIMESTAMP, resolution = ?, resolved_by = ?
+	WHERE id = ? AND resolved_at IS NULL
+	`
+	res, err := c.db.Exec(query, ReportResolutionDismissed, moderatorID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TakeDownVideo marks a video as taken down and resolves its open reports.
+func (c Client) TakeDownVideo(videoID uuid.UUID, moderatorID *uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec(`
+	UPDATE videos
+	SET moderation_status = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, ModerationStatusTakenDown, videoID)
+	if err != nil {
+		return err
+	}
+	_, err = t.Exec(`
+	UPDATE video_reports
+	SET resolved_at = CURRENT_TIMESTAMP, resolution = ?, resolved_by = ?
+	WHERE video_id = ? AND resolved_at IS NULL
+	`, ReportResolutionTakenDown, moderatorID, videoID)
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// RestoreVideo undoes a takedown. The reports it resolved stay resolved.
+func (c Client) RestoreVideo(videoID uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET moderation_status = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, ModerationStatusActive, videoID)
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetVideosDueForPublish returns up to limit videos whose publish_at has
+// passed at now, longest due first.
+func (c Client) GetVideosDueForPublish(now time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE publish_at IS NOT NULL AND publish_at <= ? AND deleted_at IS NULL
+	ORDER BY publish_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, c.timeArg(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// PublishVideo clears the publish_at of a video, if that has passed at
+// now, and makes it public when it is private. Unlisted videos stay
+// unlisted. It reports whether the video was published, false when it was
+// rescheduled in the meantime.
+func (c Client) PublishVideo(id uuid.UUID, now time.Time) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		visibility = CASE WHEN visibility = ? THEN ? ELSE visibility END,
+		publish_at = NULL,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND publish_at <= ? AND deleted_at IS NULL
+	`
+	res, err := c.db.Exec(query, VideoVisibilityPrivate, VideoVisibilityPublic, id, c.timeArg(now))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetVideosDueForExpiry returns up to limit videos whose expires_at has
+// passed at now, longest due first. Videos being uploaded or processed are
+// left for later.
+func (c Client) GetVideosDueForExpiry(now time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE expires_at IS NOT NULL AND expires_at <= ? AND deleted_at IS NULL
+		AND status NOT IN (?, ?)
+	ORDER BY expires_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, c.timeArg(now), VideoStatusUploading, VideoStatusProcessing, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// ExpireVideo clears the expires_at of a video, if that has passed at now,
+// and either makes the video private or moves it to the trash. It reports
+// whether the video was expired, false when its expiry was changed in the
+// meantime.
+func (c Client) ExpireVideo(id uuid.UUID, now time.Time, trash bool) (bool, error) {
+	query := `
+	UPDATE videos
+	SET visibility = ?, expires_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND expires_at <= ? AND deleted_at IS NULL
+	`
+	args := []any{VideoVisibilityPrivate, id, c.timeArg(now)}
+	if trash {
+		query = `
+		UPDATE videos
+		SET deleted_at = CURRENT_TIMESTAMP, expires_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND expires_at <= ? AND deleted_at IS NULL
+		`
+		args = args[1:]
+	}
+	res, err := c.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
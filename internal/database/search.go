@@ -0,0 +1,223 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Highlighted terms in search results are wrapped in these control
+// characters rather than markup, so callers can escape the text before
+// marking them up.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// VideoSearchResult is a video matching a search with the matched terms
+// highlighted in its title and in a snippet of its description.
+type VideoSearchResult struct {
+	Video
+	Rank               float64
+	TitleHighlight     string
+	DescriptionSnippet string
+}
+
+// SearchVideosParams selects a page of a user's videos matching a search.
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	// Terms all have to match, as prefixes. They must only contain letters
+	// and digits.
+	Terms []string
+	// Tags filters the matches like ListVideosParams.Tags.
+	Tags   []string
+	Limit  int
+	Offset int
+}
+
+// SearchVideos returns a page of videos matching a search, best match
+// first.
+func (c Client) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	if len(params.Terms) == 0 {
+		return []VideoSearchResult{}, nil
+	}
+	var (
+		results []VideoSearchResult
+		err     error
+	)
+	if c.db.dialect == dialectPostgres {
+		results, err = c.searchVideosPostgres(params)
+	} else {
+		results, err = c.searchVideosSQLite(params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	videos := make([]Video, len(results))
+	for i := range results {
+		videos[i] = results[i].Video
+	}
+	if err := c.loadRelated(videos); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Video = videos[i]
+	}
+	return results, nil
+}
+
+func (c Client) searchVideosPostgres(params SearchVideosParams) ([]VideoSearchResult, error) {
+	prefixes := make([]string, len(params.Terms))
+	for i, term := range params.Terms {
+		prefixes[i] = term + ":*"
+	}
+	titleOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, HighlightAll=true", HighlightStart, HighlightEnd)
+	snippetOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=24, MinWords=8", HighlightStart, HighlightEnd)
+	query := `
+	SELECT` + videoColumns + `,
+		ts_rank_cd(search, q) AS rank,
+		ts_headline('simple', title, q, ?),
+		ts_headline('simple', COALESCE(description, ''), q, ?)
+	FROM videos, to_tsquery('simple', ?) q
+	WHERE user_id = ? AND deleted_at IS NULL AND search @@ q
+	`
+	args := []any{titleOptions, snippetOptions, strings.Join(prefixes, " & "), params.UserID}
+	if len(params.Tags) > 0 {
+		filter, filterArgs := tagFilter("id", params.Tags)
+		query += "AND " + filter + `
+	`
+		args = append(args, filterArgs...)
+	}
+	query += `ORDER BY rank DESC, created_at DESC, id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, params.Limit, params.Offset)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var r VideoSearchResult
+		r.Video, err = scanVideo(rows, &r.Rank, &r.TitleHighlight, &r.DescriptionSnippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchVideosSQLite ranks the matches with BM25, computed from the
+// matchinfo of the FTS4 index, which unlike FTS5 has no ranking built in.
+// All of the user's matches are ranked to cut out the page.
+func (c Client) searchVideosSQLite(params SearchVideosParams) ([]VideoSearchResult, error) {
+	prefixes := make([]string, len(params.Terms))
+	for i, term := range params.Terms {
+		prefixes[i] = term + "*"
+	}
+	columns := strings.ReplaceAll(videoColumns, "\n\t\t", "\n\t\tv.")
+	query := `
+	SELECT` + columns + `,
+		matchinfo(videos_search, 'pcnalx'),
+		snippet(videos_search, ?, ?, '', 1, 64),
+		snippet(videos_search, ?, ?, '…', 2, 24)
+	FROM videos_search
+	JOIN videos v ON v.id = videos_search.video_id
+	WHERE videos_search MATCH ? AND v.user_id = ? AND v.deleted_at IS NULL
+	`
+	args := []any{
+		HighlightStart, HighlightEnd,
+		HighlightStart, HighlightEnd,
+		strings.Join(prefixes, " "), params.UserID,
+	}
+	if len(params.Tags) > 0 {
+		filter, filterArgs := tagFilter("v.id", params.Tags)
+		query += "AND " + filter + `
+	`
+		args = append(args, filterArgs...)
+	}
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var (
+			r         VideoSearchResult
+			matchinfo []byte
+		)
+		r.Video, err = scanVideo(rows, &matchinfo, &r.TitleHighlight, &r.DescriptionSnippet)
+		if err != nil {
+			return nil, err
+		}
+		// video_id, title, description and transcript
+		r.Rank = bm25(matchinfo, []float64{0, 2, 1, 0.5})
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		if !results[i].CreatedAt.Equal(results[j].CreatedAt) {
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		}
+		return results[i].ID.String() < results[j].ID.String()
+	})
+	if params.Offset >= len(results) {
+		return []VideoSearchResult{}, nil
+	}
+	results = results[params.Offset:]
+	if len(results) > params.Limit {
+		results = results[:params.Limit]
+	}
+	return results, nil
+}
+
+// bm25 scores a row from the 'pcnalx' matchinfo of an FTS4 match, with a
+// weight per column. Higher is better.
+func bm25(matchinfo []byte, weights []float64) float64 {
+	const (
+		k1 = 1.2
+		b  = 0.75
+	)
+	info := make([]uint32, len(matchinfo)/4)
+	for i := range info {
+		info[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(info) < 3 {
+		return 0
+	}
+	phrases, cols, rows := int(info[0]), int(info[1]), float64(info[2])
+	avgLen := info[3 : 3+cols]
+	rowLen := info[3+cols : 3+2*cols]
+	hits := info[3+2*cols:]
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for col := 0; col < cols && col < len(weights); col++ {
+			if weights[col] == 0 || avgLen[col] == 0 {
+				continue
+			}
+			x := 3 * (col + p*cols)
+			tf, docs := float64(hits[x]), float64(hits[x+2])
+			// Lucene's variant, which stays positive for terms in most rows
+			idf := math.Log(1 + (rows-docs+0.5)/(docs+0.5))
+			norm := 1 - b + b*float64(rowLen[col])/float64(avgLen[col])
+			score += weights[col] * idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+	return score
+}
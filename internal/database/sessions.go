@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for refresh tokens that are
+	// unknown, expired or whose session is revoked.
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned for a refresh token that was
+	// already rotated. It may have been stolen, so its session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used, its session is revoked")
+)
+
+// Session is one login of a user, kept alive by rotating its refresh
+// token.
+type Session struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	UserID      uuid.UUID  `json:"user_id"`
+	UserAgent   string     `json:"user_agent"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the session can still be refreshed and its
+// access tokens used.
+func (s Session) Active(now time.Time) bool {
+	return s.ID != uuid.Nil && s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+type CreateSessionParams struct {
+	UserID    uuid.UUID
+	UserAgent string
+	// RefreshToken is the first refresh token of the session, valid until
+	// ExpiresAt.
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+const sessionColumns = `
+		id,
+		created_at,
+		refreshed_at,
+		user_id,
+		user_agent,
+		expires_at,
+		revoked_at`
+
+func scanSession(row rowScanner) (Session, error) {
+	var s Session
+	err := row.Scan(
+		&s.ID,
+		&s.CreatedAt,
+		&s.RefreshedAt,
+		&s.UserID,
+		&s.UserAgent,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+	)
+	return s, err
+}
+
+// CreateSession starts a session with its first refresh token.
+func (c Client) CreateSession(params CreateSessionParams) (Session, error) {
+	id := uuid.New()
+	t, err := c.db.begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer t.Rollback()
+
+	_, err = t.Exec(`
+	INSERT INTO sessions (
+		id,
+		created_at,
+		refreshed_at,
+		user_id,
+		user_agent,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`, id, params.UserID, params.UserAgent, c.timeArg(params.ExpiresAt))
+	if err != nil {
+		return Session{}, err
+	}
+	if err := c.insertRefreshToken(t, id, params.UserID, params.RefreshToken, params.ExpiresAt); err != nil {
+		return Session{}, err
+	}
+	if err := t.Commit(); err != nil {
+		return Session{}, err
+	}
+	return c.GetSession(id)
+}
+
+func (c Client) insertRefreshToken(t *tx, sessionID, userID uuid.UUID, token string, expiresAt time.Time) error {
+	_, err := t.Exec(`
+	INSERT INTO refresh_tokens (
+		token,
+		created_at,
+		updated_at,
+		user_id,
+		expires_at,
+		session_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`, token, userID, c.timeArg(expiresAt), sessionID)
+	return err
+}
+
+// GetSession returns a zero Session when there is none with the id.
+func (c Client) GetSession(id uuid.UUID) (Session, error) {
+	query := `
+	SELECT` + sessionColumns + `
+	FROM sessions
+	WHERE id = ?
+	`
+	s, err := scanSession(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, nil
+	}
+	return s, err
+}
+
+// GetActiveSessions returns the sessions of a user that are neither
+// revoked nor expired, newest first.
+func (c Client) GetActiveSessions(userID uuid.UUID, now time.Time) ([]Session, error) {
+	query := `
+	SELECT` + sessionColumns + `
+	FROM sessions
+	WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.db.Query(query, userID, c.timeArg(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RotateRefreshToken exchanges a refresh token for newToken, valid until
+// expiresAt, and returns the session they belong to. The old token can't
+// be used again: presenting it another time returns ErrRefreshTokenReused
+// and revokes the session.
+func (c Client) RotateRefreshToken(token, newToken string, expiresAt time.Time) (Session, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer t.Rollback()
+
+	var (
+		sessionID      *uuid.UUID
+		tokenExpiresAt time.Time
+		revokedAt      *time.Time
+	)
+	err = t.QueryRow(`
+	SELECT session_id, expires_at, revoked_at
+	FROM refresh_tokens
+	WHERE token = ?
+	`, token).Scan(&sessionID, &tokenExpiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	if sessionID == nil {
+		return Session{}, ErrRefreshTokenInvalid
+	}
+	session, err := scanSession(t.QueryRow(`
+	SELECT`+sessionColumns+`
+	FROM sessions
+	WHERE id = ?
+	`, *sessionID))
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	if !session.Active(now) || !now.Before(tokenExpiresAt) {
+		return Session{}, ErrRefreshTokenInvalid
+	}
+	if revokedAt != nil {
+		return Session{}, c.revokeReusedSession(t, session.ID)
+	}
+
+	res, err := t.Exec(`
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE token = ? AND revoked_at IS NULL
+	`, token)
+	if err != nil {
+		return Session{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Session{}, err
+	} else if n == 0 {
+		// rotated by a concurrent request
+		return Session{}, c.revokeReusedSession(t, session.ID)
+	}
+	if err := c.insertRefreshToken(t, session.ID, session.UserID, newToken, expiresAt); err != nil {
+		return Session{}, err
+	}
+	_, err = t.Exec(`
+	UPDATE sessions
+	SET refreshed_at = CURRENT_TIMESTAMP, expires_at = ?
+	WHERE id = ?
+	`, c.timeArg(expiresAt), session.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	if err := t.Commit(); err != nil {
+		return Session{}, err
+	}
+	return c.GetSession(session.ID)
+}
+
+func (c Client) revokeReusedSession(t *tx, id uuid.UUID) error {
+	if err := revokeSession(t, id); err != nil {
+		return err
+	}
+	if err := t.Commit(); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// RevokeSession revokes a session and every refresh token of it, the
+// access tokens issued for it stop working as well.
+func (c Client) RevokeSession(id uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	if err := revokeSession(t, id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+func revokeSession(t *tx, id uuid.UUID) error {
+	_, err := t.Exec(`
+	UPDATE sessions
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	_, err = t.Exec(`
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE session_id = ? AND revoked_at IS NULL
+	`, id)
+	return err
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets anyone holding its token watch a video until it expires,
+// runs out of views or is revoked.
+type ShareLink struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Views     int        `json:"views"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreateShareLinkParams
+}
+
+type CreateShareLinkParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxViews is unlimited when nil.
+	MaxViews *int `json:"max_views"`
+}
+
+const shareLinkColumns = `
+		id,
+		created_at,
+		video_id,
+		expires_at,
+		max_views,
+		views,
+		revoked_at`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.VideoID,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.Views,
+		&link.RevokedAt,
+	)
+	return link, err
+}
+
+func (c Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		video_id,
+		expires_at,
+		max_views
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, c.timeArg(params.ExpiresAt), params.MaxViews)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(id)
+}
+
+// GetShareLink returns a zero ShareLink when there is none with the id.
+func (c Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE id = ?
+	`
+	link, err := scanShareLink(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, nil
+	}
+	return link, err
+}
+
+// GetShareLinks returns the share links of a video, newest first.
+func (c Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (c Client) RevokeShareLink(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// UseShareLink counts a view of a share link. It reports false, without
+// counting, when the link is revoked, expired or out of views.
+func (c Client) UseShareLink(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE share_links
+	SET views = views + 1
+	WHERE id = ?
+		AND revoked_at IS NULL
+		AND expires_at > ?
+		AND (max_views IS NULL OR views < max_views)
+	`
+	res, err := c.db.Exec(query, id, c.timeArg(time.Now()))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CountVideosByStatus returns how many videos there are in each status.
+// Trashed videos count until they are purged.
+func (c Client) CountVideosByStatus() (map[VideoStatus]int, error) {
+	query := `
+	SELECT status, COUNT(*)
+	FROM videos
+	GROUP BY status
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[VideoStatus]int{}
+	for rows.Next() {
+		var status VideoStatus
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// StorageUsage is the storage all videos consume. Video bytes are summed
+// by the prefix of their key: landscape, portrait or other.
+type StorageUsage struct {
+	VideoBytesByPrefix map[string]int64
+	ThumbnailBytes     int64
+}
+
+func (c Client) GetStorageUsage() (StorageUsage, error) {
+	query := `
+	SELECT
+		CASE
+			WHEN video_key LIKE 'landscape/%' THEN 'landscape'
+			WHEN video_key LIKE 'portrait/%' THEN 'portrait'
+			ELSE 'other'
+		END AS prefix,
+		COALESCE(SUM(video_bytes), 0)
+	FROM videos
+	WHERE video_key IS NOT NULL
+	GROUP BY prefix
+	`
+	usage := StorageUsage{VideoBytesByPrefix: map[string]int64{}}
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var prefix string
+		var n int64
+		if err := rows.Scan(&prefix, &n); err != nil {
+			return usage, err
+		}
+		usage.VideoBytesByPrefix[prefix] = n
+	}
+	if err := rows.Err(); err != nil {
+		return usage, err
+	}
+
+	err = c.db.QueryRow("SELECT COALESCE(SUM(thumbnail_bytes), 0) FROM videos").Scan(&usage.ThumbnailBytes)
+	return usage, err
+}
+
+// JobCount is how many jobs of a kind are in a status.
+type JobCount struct {
+	Kind   string
+	Status JobStatus
+	Count  int
+}
+
+// CountUnfinishedJobs returns how many jobs are queued and running, by
+// kind.
+func (c Client) CountUnfinishedJobs() ([]JobCount, error) {
+	query := `
+	SELECT kind, status, COUNT(*)
+	FROM jobs
+	WHERE status IN (?, ?)
+	GROUP BY kind, status
+	ORDER BY kind, status
+	`
+	return c.countJobs(query, JobStatusQueued, JobStatusRunning)
+}
+
+// CountFinishedJobs returns how many jobs finished in [from, to), done or
+// failed, by kind.
+func (c Client) CountFinishedJobs(from, to time.Time) ([]JobCount, error) {
+	query := `
+	SELECT kind, status, COUNT(*)
+	FROM jobs
+	WHERE status IN (?, ?) AND updated_at >= ? AND updated_at < ?
+	GROUP BY kind, status
+	ORDER BY kind, status
+	`
+	return c.countJobs(query, JobStatusDone, JobStatusFailed, c.timeArg(from), c.timeArg(to))
+}
+
+func (c Client) countJobs(query string, args ...any) ([]JobCount, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []JobCount{}
+	for rows.Next() {
+		var count JobCount
+		if err := rows.Scan(&count.Kind, &count.Status, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// FinishedJob is when a job finished and whether it failed.
+type FinishedJob struct {
+	FinishedAt time.Time
+	Status     JobStatus
+}
+
+// GetFinishedJobs returns the jobs of a kind that finished in [from, to),
+// oldest first.
+func (c Client) GetFinishedJobs(kind string, from, to time.Time) ([]FinishedJob, error) {
+	query := `
+	SELECT updated_at, status
+	FROM jobs
+	WHERE kind = ? AND status IN (?, ?) AND updated_at >= ? AND updated_at < ?
+	ORDER BY updated_at
+	`
+	rows, err := c.db.Query(query, kind, JobStatusDone, JobStatusFailed, c.timeArg(from), c.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []FinishedJob{}
+	for rows.Next() {
+		var job FinishedJob
+		if err := rows.Scan(&job.FinishedAt, &job.Status); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GetVideoCreationTimes returns when the videos created in [from, to)
+// were, oldest first.
+func (c Client) GetVideoCreationTimes(from, to time.Time) ([]time.Time, error) {
+	query := `
+	SELECT created_at
+	FROM videos
+	WHERE created_at >= ? AND created_at < ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, c.timeArg(from), c.timeArg(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := []time.Time{}
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
+
+// Uploader is a user with the storage their videos consume.
+type Uploader struct {
+	UserID     uuid.UUID
+	Email      string
+	VideoCount int
+	Bytes      int64
+}
+
+// GetTopUploaders returns the limit users whose videos and thumbnails
+// consume the most storage, most first.
+func (c Client) GetTopUploaders(limit int) ([]Uploader, error) {
+	query := `
+	SELECT users.id, users.email, COUNT(*), COALESCE(SUM(videos.video_bytes + videos.thumbnail_bytes), 0) AS bytes
+	FROM videos
+	JOIN users ON users.id = videos.user_id
+	GROUP BY users.id, users.email
+	ORDER BY bytes DESC, users.id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploaders := []Uploader{}
+	for rows.Next() {
+		var u Uploader
+		if err := rows.Scan(&u.UserID, &u.Email, &u.VideoCount, &u.Bytes); err != nil {
+			return nil, err
+		}
+		uploaders = append(uploaders, u)
+	}
+	return uploaders, rows.Err()
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetVideoStorageTier moves a video from one storage tier to another. It
+// reports whether the video was in the from tier.
+func (c Client) SetVideoStorageTier(id uuid.UUID, from, to StorageTier) (bool, error) {
+	query := `
+	UPDATE videos
+	SET storage_tier = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND storage_tier = ?
+	`
+	res, err := c.db.Exec(query, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetVideosInStorageTier returns up to limit videos in a storage tier
+// whose ID sorts after afterID, in ID order so callers can page through
+// them. Trashed videos are included.
+func (c Client) GetVideosInStorageTier(tier StorageTier, afterID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE storage_tier = ? AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, tier, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TagCount is a tag with the number of videos carrying it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// setVideoTags replaces the tags of a video. Names are expected to be
+// normalized already; tags that don't exist yet are created.
+func setVideoTags(t *tx, videoID uuid.UUID, names []string) error {
+	if _, err := t.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err := t.Exec(`
+		INSERT INTO tags (id, created_at, name)
+		VALUES (?, CURRENT_TIMESTAMP, ?)
+		ON CONFLICT (name) DO NOTHING
+		`, uuid.New(), name)
+		if err != nil {
+			return err
+		}
+		_, err = t.Exec(`
+		INSERT INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		`, videoID, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserTags returns the tags on a user's videos, most used first.
+func (c Client) GetUserTags(userID uuid.UUID) ([]TagCount, error) {
+	query := `
+	SELECT t.name, COUNT(*)
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	JOIN videos v ON v.id = vt.video_id
+	WHERE v.user_id = ? AND v.deleted_at IS NULL
+	GROUP BY t.name
+	ORDER BY COUNT(*) DESC, t.name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// loadTags fills in the tags of videos with one query.
+func (c Client) loadTags(videos []Video) error {
+	if len(videos) == 0 {
+		return nil
+	}
+	index := make(map[uuid.UUID]int, len(videos))
+	args := make([]any, len(videos))
+	for i := range videos {
+		videos[i].Tags = []string{}
+		index[videos[i].ID] = i
+		args[i] = videos[i].ID
+	}
+	query := `
+	SELECT vt.video_id, t.name
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	WHERE vt.video_id IN (?` + strings.Repeat(", ?", len(videos)-1) + `)
+	ORDER BY t.name
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			videoID uuid.UUID
+			name    string
+		)
+		if err := rows.Scan(&videoID, &name); err != nil {
+			return err
+		}
+		if i, ok := index[videoID]; ok {
+			videos[i].Tags = append(videos[i].Tags, name)
+		}
+	}
+	return rows.Err()
+}
+
+// tagFilter is a condition on the id column of videos matching videos that
+// carry all of tags.
+func tagFilter(idColumn string, tags []string) (string, []any) {
+	args := make([]any, 0, len(tags)+1)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	args = append(args, len(tags))
+	return idColumn + ` IN (
+		SELECT vt.video_id
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE t.name IN (?` + strings.Repeat(", ?", len(tags)-1) + `)
+		GROUP BY vt.video_id
+		HAVING COUNT(*) = ?
+	)`, args
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoTranscript is the text spoken in a video, as transcribed.
+type VideoTranscript struct {
+	VideoID       uuid.UUID `json:"video_id"`
+	Language      string    `json:"language"`
+	Text          string    `json:"text"`
+	TranscribedAt time.Time `json:"transcribed_at"`
+}
+
+// SetVideoTranscript stores the transcript of a video along with its
+// caption track in the same language, bumping the video's version. A track uploaded in the same
+// language is kept, the transcribed one is dropped then, as it is for a
+// deleted video. It returns the
+// object key of the replaced track, empty when there was none, and whether
+// caption was stored.
+func (c Client) SetVideoTranscript(videoID uuid.UUID, text string, caption Caption) (string, bool, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer t.Rollback()
+
+	res, err := t.Exec(`
+	UPDATE videos
+	SET transcript = ?, transcript_language = ?, transcribed_at = CURRENT_TIMESTAMP, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, text, caption.Language, videoID)
+	if err != nil {
+		return "", false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// deleted in the meantime
+		return "", false, err
+	}
+	replaced, source, err := getCaptionSource(t, videoID, caption.Language)
+	if err != nil {
+		return "", false, err
+	}
+	captioned := source != CaptionSourceUpload
+	if captioned {
+		if err := putCaption(t, videoID, caption); err != nil {
+			return "", false, err
+		}
+	} else {
+		replaced = ""
+	}
+	return replaced, captioned, t.Commit()
+}
+
+// GetVideoTranscript returns a zero VideoTranscript when the video wasn't
+// transcribed.
+func (c Client) GetVideoTranscript(videoID uuid.UUID) (VideoTranscript, error) {
+	query := `
+	SELECT transcript, transcript_language, transcribed_at
+	FROM videos
+	WHERE id = ? AND transcript IS NOT NULL
+	`
+	tr := VideoTranscript{VideoID: videoID}
+	err := c.db.QueryRow(query, videoID).Scan(&tr.Text, &tr.Language, &tr.TranscribedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoTranscript{}, nil
+	}
+	return tr, err
+}
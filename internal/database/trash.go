@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash. Trashed videos are left out of
+// GetVideo and every listing but the trash until RestoreTrashedVideo, and
+// are purged with DeleteVideo eventually. It reports whether the video was
+// trashed, false when it was already in the trash or is gone.
+func (c Client) TrashVideo(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET deleted_at = CURRENT_TIMESTAMP, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	res, err := c.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RestoreTrashedVideo takes a video out of the trash. It reports whether
+// the video was in the trash.
+func (c Client) RestoreTrashedVideo(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	res, err := c.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetTrashedVideo returns a video in the trash, or a zero video when there
+// is no such video or it isn't in the trash.
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
+}
+
+// GetTrashedVideos returns the videos in a user's trash, most recently
+// trashed first.
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
+}
+
+// GetVideosTrashedBefore returns up to limit videos that went to the trash
+// before the given time, longest trashed first.
+func (c Client) GetVideosTrashedBefore(before time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	ORDER BY deleted_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, c.timeArg(before), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
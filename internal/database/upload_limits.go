@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadLimits are the largest uploads in bytes, nil where the next level
+// decides: a user's limits override their plan's, which override the
+// server's.
+type UploadLimits struct {
+	MaxVideoBytes     *int64 `json:"max_video_bytes"`
+	MaxThumbnailBytes *int64 `json:"max_thumbnail_bytes"`
+}
+
+// Plan is a named set of limits users can be put on.
+type Plan struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UploadLimits
+}
+
+// UserLimits are what a user is put on, see SetUserLimits.
+type UserLimits struct {
+	Plan *string `json:"plan"`
+	UploadLimits
+	// MediaLimitsExempt lifts the duration and resolution limits.
+	MediaLimitsExempt bool `json:"media_limits_exempt"`
+}
+
+func (c Client) GetPlans() ([]Plan, error) {
+	rows, err := c.db.Query(`
+	SELECT name, created_at, updated_at, max_video_bytes, max_thumbnail_bytes
+	FROM plans
+	ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plans := []Plan{}
+	for rows.Next() {
+		var plan Plan
+		if err := rows.Scan(&plan.Name, &plan.CreatedAt, &plan.UpdatedAt, &plan.MaxVideoBytes, &plan.MaxThumbnailBytes); err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+// GetPlan returns the plan of a name, a zero Plan when there is none.
+func (c Client) GetPlan(name string) (Plan, error) {
+	var plan Plan
+	err := c.db.QueryRow(`
+	SELECT name, created_at, updated_at, max_video_bytes, max_thumbnail_bytes
+	FROM plans
+	WHERE name = ?
+	`, name).Scan(&plan.Name, &plan.CreatedAt, &plan.UpdatedAt, &plan.MaxVideoBytes, &plan.MaxThumbnailBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Plan{}, nil
+	}
+	return plan, err
+}
+
+// PutPlan creates a plan or replaces the limits of an existing one.
+func (c Client) PutPlan(name string, limits UploadLimits) (Plan, error) {
+	_, err := c.db.Exec(`
+	INSERT INTO plans (
+		name,
+		max_video_bytes,
+		max_thumbnail_bytes,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (name) DO UPDATE SET
+		max_video_bytes = excluded.max_video_bytes,
+		max_thumbnail_bytes = excluded.max_thumbnail_bytes,
+		updated_at = excluded.updated_at
+	`, name, limits.MaxVideoBytes, limits.MaxThumbnailBytes)
+	if err != nil {
+		return Plan{}, err
+	}
+	return c.GetPlan(name)
+}
+
+// DeletePlan removes a plan, its users fall back to the server's limits
+// unless they have their own. It reports whether there was one.
+func (c Client) DeletePlan(name string) (bool, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return false, err
+	}
+	defer t.Rollback()
+
+	// SQLite doesn't enforce ON DELETE SET NULL
+	if _, err := t.Exec("UPDATE users SET plan = NULL WHERE plan = ?", name); err != nil {
+		return false, err
+	}
+	res, err := t.Exec("DELETE FROM plans WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, t.Commit()
+}
+
+// GetUserLimits returns what a user is put on, zero when they don't exist.
+func (c Client) GetUserLimits(userID uuid.UUID) (UserLimits, error) {
+	var limits UserLimits
+	err := c.db.QueryRow(`
+	SELECT plan, max_video_bytes, max_thumbnail_bytes, media_limits_exempt
+	FROM users
+	WHERE id = ?
+	`, userID.String()).Scan(&limits.Plan, &limits.MaxVideoBytes, &limits.MaxThumbnailBytes, &limits.MediaLimitsExempt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserLimits{}, nil
+	}
+	return limits, err
+}
+
+// SetUserLimits puts a user on a plan, nil for none, with their own limits
+// overriding it.
+func (c Client) SetUserLimits(userID uuid.UUID, limits UserLimits) error {
+	_, err := c.db.Exec(`
+	UPDATE users
+	SET plan = ?, max_video_bytes = ?, max_thumbnail_bytes = ?, media_limits_exempt = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, limits.Plan, limits.MaxVideoBytes, limits.MaxThumbnailBytes, limits.MediaLimitsExempt, userID.String())
+	return err
+}
+
+// GetUploadLimits returns the limits that apply to a user, theirs where
+// set and else their plan's. What is still nil is up to the server.
+func (c Client) GetUploadLimits(userID uuid.UUID) (UploadLimits, error) {
+	var limits UploadLimits
+	err := c.db.QueryRow(`
+	SELECT
+		COALESCE(u.max_video_bytes, p.max_video_bytes),
+		COALESCE(u.max_thumbnail_bytes, p.max_thumbnail_bytes)
+	FROM users u
+	LEFT JOIN plans p ON p.name = u.plan
+	WHERE u.id = ?
+	`, userID.String()).Scan(&limits.MaxVideoBytes, &limits.MaxThumbnailBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UploadLimits{}, nil
+	}
+	return limits, err
+}
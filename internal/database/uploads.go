@@ -0,0 +1,205 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload tracks a resumable or chunked upload that is being assembled on
+// local disk.
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	MediaType string    `json:"media_type"`
+	Length    int64     `json:"length"`
+	// PartSize is the size of every part of a chunked upload but the
+	// last, 0 for tus uploads.
+	PartSize int64  `json:"part_size,omitempty"`
+	Path     string `json:"-"`
+}
+
+// Chunked reports whether the upload is sent in parts.
+func (u Upload) Chunked() bool {
+	return u.PartSize > 0
+}
+
+// PartCount is how many parts make up a chunked upload.
+func (u Upload) PartCount() int {
+	if u.PartSize <= 0 {
+		return 0
+	}
+	return int((u.Length + u.PartSize - 1) / u.PartSize)
+}
+
+// UploadPart is a part of a chunked upload that has been received.
+type UploadPart struct {
+	Number    int       `json:"part_number"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		media_type,
+		length,
+		upload_offset,
+		part_size,
+		path
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.MediaType, params.Length, params.PartSize, params.Path)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		media_type,
+		length,
+		upload_offset,
+		part_size,
+		path
+	FROM uploads
+	WHERE id = ?
+	`
+
+	var upload Upload
+	err := c.db.QueryRow(query, id).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.MediaType,
+		&upload.Length,
+		&upload.Offset,
+		&upload.PartSize,
+		&upload.Path,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, nil
+		}
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// GetUploadPaths returns the local file paths of all unfinished uploads.
+func (c Client) GetUploadPaths() ([]string, error) {
+	rows, err := c.db.Query("SELECT path FROM uploads")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func (c Client) UpdateUploadOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, id)
+	return err
+}
+
+// PutUploadPart records a received part of a chunked upload, replacing an
+// earlier copy of it.
+func (c Client) PutUploadPart(uploadID uuid.UUID, part UploadPart) (UploadPart, error) {
+	part.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `
+	INSERT INTO upload_parts (upload_id, part_number, size, sha256, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (upload_id, part_number) DO UPDATE SET
+		size = excluded.size,
+		sha256 = excluded.sha256,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, uploadID, part.Number, part.Size, part.SHA256, c.timeArg(part.UpdatedAt))
+	if err != nil {
+		return UploadPart{}, err
+	}
+	return part, nil
+}
+
+// GetUploadParts returns the received parts of a chunked upload by number.
+func (c Client) GetUploadParts(uploadID uuid.UUID) ([]UploadPart, error) {
+	query := `
+	SELECT part_number, size, sha256, updated_at
+	FROM upload_parts
+	WHERE upload_id = ?
+	ORDER BY part_number
+	`
+	rows, err := c.db.Query(query, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []UploadPart{}
+	for rows.Next() {
+		var part UploadPart
+		if err := rows.Scan(&part.Number, &part.Size, &part.SHA256, &part.UpdatedAt); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, rows.Err()
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	if _, err := t.Exec("DELETE FROM upload_parts WHERE upload_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM uploads WHERE id = ?", id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserDeletionStatus string
+
+const (
+	UserDeletionStatusQueued  UserDeletionStatus = "queued"
+	UserDeletionStatusRunning UserDeletionStatus = "running"
+	UserDeletionStatusDone    UserDeletionStatus = "done"
+	UserDeletionStatusFailed  UserDeletionStatus = "failed"
+)
+
+// UserDeletion is the progress of deleting a user and everything they
+// stored.
+type UserDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	// RequestedBy is the admin who deleted the user, nil when they deleted
+	// themselves or the admin API key was used.
+	RequestedBy   *uuid.UUID         `json:"requested_by"`
+	Status        UserDeletionStatus `json:"status"`
+	VideosTotal   int                `json:"videos_total"`
+	VideosDeleted int                `json:"videos_deleted"`
+	CompletedAt   *time.Time         `json:"completed_at"`
+	LastError     *string            `json:"last_error"`
+}
+
+const userDeletionColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		requested_by,
+		status,
+		videos_total,
+		videos_deleted,
+		completed_at,
+		last_error`
+
+func scanUserDeletion(row rowScanner) (UserDeletion, error) {
+	var d UserDeletion
+	err := row.Scan(
+		&d.ID,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.UserID,
+		&d.RequestedBy,
+		&d.Status,
+		&d.VideosTotal,
+		&d.VideosDeleted,
+		&d.CompletedAt,
+		&d.LastError,
+	)
+	return d, err
+}
+
+// CreateUserDeletion starts deleting a user: their password, sessions and
+// API keys stop working at once, the rest is up to the caller. A user is
+// only deleted once, for a user already being deleted the existing
+// deletion is returned, a failed one queued again. It reports whether the
+// deletion was queued and has to be run.
+func (c Client) CreateUserDeletion(userID uuid.UUID, requestedBy *uuid.UUID) (UserDeletion, bool, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return UserDeletion{}, false, err
+	}
+	defer t.Rollback()
+
+	// the password goes first so nobody logs in while the rest is deleted
+	if _, err := t.Exec("UPDATE users SET password = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?", userID); err != nil {
+		return UserDeletion{}, false, err
+	}
+	if _, err := t.Exec("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID); err != nil {
+		return UserDeletion{}, false, err
+	}
+	if _, err := t.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID); err != nil {
+		return UserDeletion{}, false, err
+	}
+	if _, err := t.Exec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID); err != nil {
+		return UserDeletion{}, false, err
+	}
+
+	res, err := t.Exec(`
+	INSERT INTO user_deletions (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		requested_by,
+		status
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	ON CONFLICT DO NOTHING
+	`, uuid.New(), userID, requestedBy, UserDeletionStatusQueued)
+	if err != nil {
+		return UserDeletion{}, false, err
+	}
+	queued, err := res.RowsAffected()
+	if err != nil {
+		return UserDeletion{}, false, err
+	}
+	if queued == 0 {
+		queued, err = requeueFailed(t, userID)
+		if err != nil {
+			return UserDeletion{}, false, err
+		}
+	}
+	if err := t.Commit(); err != nil {
+		return UserDeletion{}, false, err
+	}
+	d, err := c.GetUserDeletionOfUser(userID)
+	return d, queued > 0, err
+}
+
+func requeueFailed(t *tx, userID uuid.UUID) (int64, error) {
+	res, err := t.Exec(`
+	UPDATE user_deletions
+	SET status = ?, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND status = ?
+	`, UserDeletionStatusQueued, userID, UserDeletionStatusFailed)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetUserDeletion returns a zero UserDeletion when there is none with the
+// id.
+func (c Client) GetUserDeletion(id uuid.UUID) (UserDeletion, error) {
+	query := `
+	SELECT` + userDeletionColumns + `
+	FROM user_deletions
+	WHERE id = ?
+	`
+	d, err := scanUserDeletion(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserDeletion{}, nil
+	}
+	return d, err
+}
+
+// GetUserDeletionOfUser returns a zero UserDeletion when the user isn't
+// being deleted.
+func (c Client) GetUserDeletionOfUser(userID uuid.UUID) (UserDeletion, error) {
+	query := `
+	SELECT` + userDeletionColumns + `
+	FROM user_deletions
+	WHERE user_id = ?
+	`
+	d, err := scanUserDeletion(c.db.QueryRow(query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserDeletion{}, nil
+	}
+	return d, err
+}
+
+// StartUserDeletion marks a deletion as running with remaining videos left
+// to delete.
+func (c Client) StartUserDeletion(id uuid.UUID, remaining int) error {
+	query := `
+	UPDATE user_deletions
+	SET status = ?, videos_total = videos_deleted + ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, UserDeletionStatusRunning, remaining, id)
+	return err
+}
+
+// RecordUserVideoDeleted counts one more deleted video of a deletion.
+func (c Client) RecordUserVideoDeleted(id uuid.UUID) error {
+	query := `
+	UPDATE user_deletions
+	SET videos_deleted = videos_deleted + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// CompleteUserDeletion deletes what is left of the user once their videos
+// are gone, and marks the deletion as done. Their playbacks of other
+// users' videos are kept for those videos' analytics, as anonymous ones.
+func (c Client) CompleteUserDeletion(id, userID uuid.UUID, viewer string) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	if _, err := t.Exec("UPDATE playbacks SET viewer = 'playback:' || id WHERE viewer = ?", viewer); err != nil {
+		return err
+	}
+	// SQLite doesn't enforce the cascades, rows referencing others go first
+	statements := []string{
+		"DELETE FROM video_reports WHERE reporter_id = ?",
+		"DELETE FROM playlist_videos WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)",
+		"DELETE FROM playlists WHERE user_id = ?",
+		"DELETE FROM upload_parts WHERE upload_id IN (SELECT id FROM uploads WHERE user_id = ?)",
+		"DELETE FROM uploads WHERE user_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM user_exports WHERE user_id = ?",
+		"DELETE FROM user_watermarks WHERE user_id = ?",
+		"DELETE FROM idempotency_keys WHERE user_id = ?",
+		"DELETE FROM api_keys WHERE user_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := t.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+	_, err = t.Exec(`
+	UPDATE user_deletions
+	SET status = ?, completed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, UserDeletionStatusDone, id)
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// FailUserDeletion marks a deletion as failed, deleting the user again
+// queues it anew.
+func (c Client) FailUserDeletion(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE user_deletions
+	SET status = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, UserDeletionStatusFailed, lastError, id)
+	return err
+}
+
+// CountUserVideos counts all videos of a user, including the trashed ones.
+func (c Client) CountUserVideos(userID uuid.UUID) (int, error) {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM videos WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
+
+// GetUserVideosToDelete returns up to limit videos of a user, including
+// the trashed ones, for deleting them batch by batch.
+func (c Client) GetUserVideosToDelete(userID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+	ORDER BY id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserExportStatus string
+
+const (
+	UserExportStatusQueued UserExportStatus = "queued"
+	UserExportStatusDone   UserExportStatus = "done"
+	UserExportStatusFailed UserExportStatus = "failed"
+)
+
+// UserExport is an export of everything stored about a user.
+type UserExport struct {
+	ID          uuid.UUID        `json:"id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	UserID      uuid.UUID        `json:"user_id"`
+	Status      UserExportStatus `json:"status"`
+	ObjectKey   *string          `json:"-"`
+	CompletedAt *time.Time       `json:"completed_at"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+	LastError   *string          `json:"last_error"`
+}
+
+// Expired reports whether a done export can't be downloaded anymore.
+func (e UserExport) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+const userExportColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		status,
+		object_key,
+		completed_at,
+		expires_at,
+		last_error`
+
+func scanUserExport(row rowScanner) (UserExport, error) {
+	var e UserExport
+	err := row.Scan(
+		&e.ID,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.UserID,
+		&e.Status,
+		&e.ObjectKey,
+		&e.CompletedAt,
+		&e.ExpiresAt,
+		&e.LastError,
+	)
+	return e, err
+}
+
+func (c Client) CreateUserExport(userID uuid.UUID) (UserExport, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO user_exports (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		status
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	if _, err := c.db.Exec(query, id, userID, UserExportStatusQueued); err != nil {
+		return UserExport{}, err
+	}
+	return c.GetUserExport(id)
+}
+
+// GetUserExport returns a zero UserExport when there is none with the id.
+func (c Client) GetUserExport(id uuid.UUID) (UserExport, error) {
+	query := `
+	SELECT` + userExportColumns + `
+	FROM user_exports
+	WHERE id = ?
+	`
+	e, err := scanUserExport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserExport{}, nil
+	}
+	return e, err
+}
+
+// GetUserExports returns the exports of a user, newest first.
+func (c Client) GetUserExports(userID uuid.UUID) ([]UserExport, error) {
+	query := `
+	SELECT` + userExportColumns + `
+	FROM user_exports
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id DESC
+	`
+	return c.queryUserExports(query, userID)
+}
+
+// GetLiveUserExports returns the exports that can still be downloaded, so
+// their objects are kept.
+func (c Client) GetLiveUserExports(now time.Time) ([]UserExport, error) {
+	query := `
+	SELECT` + userExportColumns + `
+	FROM user_exports
+	WHERE object_key IS NOT NULL AND expires_at > ?
+	`
+	return c.queryUserExports(query, c.timeArg(now))
+}
+
+func (c Client) queryUserExports(query string, args ...any) ([]UserExport, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []UserExport{}
+	for rows.Next() {
+		e, err := scanUserExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// CompleteUserExport records the object an export was written to.
+func (c Client) CompleteUserExport(id uuid.UUID, objectKey string, expiresAt time.Time) error {
+	query := `
+	UPDATE user_exports
+	SET status = ?, object_key = ?, completed_at = CURRENT_TIMESTAMP, expires_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, UserExportStatusDone, objectKey, c.timeArg(expiresAt), id)
+	return err
+}
+
+func (c Client) FailUserExport(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE user_exports
+	SET status = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, UserExportStatusFailed, lastError, id)
+	return err
+}
+
+// GetAllUserVideos returns every video of a user, trashed ones included,
+// oldest first.
+func (c Client) GetAllUserVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
+}
@@ -2,18 +2,321 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// VideoStatus is the lifecycle state of a video: pending until an upload
+// starts, uploading while the bytes come in, processing while the job
+// turns them into the playable object and finally ready or failed.
+type VideoStatus string
+
+const (
+	VideoStatusPending    VideoStatus = "pending"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+	// VideoStatusRejected is for uploads the virus scanner found infected.
+	VideoStatusRejected VideoStatus = "rejected"
+)
+
+// VideoVisibility decides who gets the URLs of a video: everyone for
+// public videos, anyone with the ID for unlisted ones and only the owner
+// for private ones.
+type VideoVisibility string
+
+const (
+	VideoVisibilityPublic   VideoVisibility = "public"
+	VideoVisibilityUnlisted VideoVisibility = "unlisted"
+	VideoVisibilityPrivate  VideoVisibility = "private"
+)
+
+// ModerationStatus is whether a moderator took a video down. The URLs of
+// taken down videos aren't handed out to anyone.
+type ModerationStatus string
+
+const (
+	ModerationStatusActive    ModerationStatus = "active"
+	ModerationStatusTakenDown ModerationStatus = "taken_down"
+)
+
+// StorageTier is whether the objects of a video can be read right away or
+// are archived to a cold storage class.
+type StorageTier string
+
+const (
+	StorageTierHot       StorageTier = "hot"
+	StorageTierArchived  StorageTier = "archived"
+	StorageTierRestoring StorageTier = "restoring"
+)
+
+// Rendition is an additional encode of a video at a lower resolution.
+type Rendition struct {
+	Label  string  `json:"label"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Key    string  `json:"-"`
+	URL    *string `json:"url"`
+}
+
+// Renditions is stored as a JSON column on the videos table.
+type Renditions []Rendition
+
+type renditionRecord struct {
+	Label  string `json:"label"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Key    string `json:"key"`
+}
+
+func (r Renditions) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+	records := make([]renditionRecord, 0, len(r))
+	for _, rendition := range r {
+		records = append(records, renditionRecord{
+			Label:  rendition.Label,
+			Width:  rendition.Width,
+			Height: rendition.Height,
+			Key:    rendition.Key,
+		})
+	}
+	dat, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (r *Renditions) Scan(src any) error {
+	var dat []byte
+	switch v := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case string:
+		dat = []byte(v)
+	case []byte:
+		dat = v
+	default:
+		return fmt.Errorf("cannot scan %T into Renditions", src)
+	}
+	var records []renditionRecord
+	if err := json.Unmarshal(dat, &records); err != nil {
+		return err
+	}
+	renditions := make(Renditions, 0, len(records))
+	for _, record := range records {
+		renditions = append(renditions, Rendition{
+			Label:  record.Label,
+			Width:  record.Width,
+			Height: record.Height,
+			Key:    record.Key,
+		})
+	}
+	*r = renditions
+	return nil
+}
+
+// VideoMetadata is what ffprobe reports about the stored file of a video.
+// BitRate is in bits per second, FrameRate in frames per second and Size
+// in bytes. AudioCodec is empty for videos without sound.
+type VideoMetadata struct {
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Duration   float64 `json:"duration"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	VideoCodec string  `json:"video_codec"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	BitRate    int64   `json:"bit_rate"`
+	Size       int64   `json:"size,omitempty"`
+	// Rotation is how many degrees clockwise players turn the stored
+	// video, Width and Height are its size once turned. Processing turns
+	// rotated videos upright, only streamed uploads keep it.
+	Rotation int `json:"rotation,omitempty"`
+	// Loudness is measured before normalizing when AUDIO_NORMALIZE is on.
+	Loudness *Loudness `json:"loudness,omitempty"`
+}
+
+// Loudness is the EBU R128 loudness of a video's audio: integrated in LUFS,
+// true peak in dBTP and loudness range in LU.
+type Loudness struct {
+	Integrated float64 `json:"integrated"`
+	TruePeak   float64 `json:"true_peak"`
+	Range      float64 `json:"range"`
+}
+
+// VideoMetadata is stored as a JSON column on the videos table.
+func (m VideoMetadata) Value() (driver.Value, error) {
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// scanVideoMetadata decodes the nullable metadata column.
+func scanVideoMetadata(s sql.NullString) (*VideoMetadata, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var m VideoMetadata
+	if err := json.Unmarshal([]byte(s.String), &m); err != nil {
+		return nil, fmt.Errorf("cannot decode video metadata: %w", err)
+	}
+	return &m, nil
+}
+
+// AudioRendition is the audio of a video on its own, for podcast apps.
+type AudioRendition struct {
+	Key       string  `json:"-"`
+	MediaType string  `json:"content_type"`
+	Size      int64   `json:"size"`
+	URL       *string `json:"url"`
+}
+
+type audioRecord struct {
+	Key       string `json:"key"`
+	MediaType string `json:"content_type"`
+	Size      int64  `json:"size"`
+}
+
+// AudioRendition is stored as a JSON column on the videos table.
+func (a AudioRendition) Value() (driver.Value, error) {
+	dat, err := json.Marshal(audioRecord{Key: a.Key, MediaType: a.MediaType, Size: a.Size})
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// scanAudioRendition decodes the nullable audio column.
+func scanAudioRendition(s sql.NullString) (*AudioRendition, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var record audioRecord
+	if err := json.Unmarshal([]byte(s.String), &record); err != nil {
+		return nil, fmt.Errorf("cannot decode audio rendition: %w", err)
+	}
+	return &AudioRendition{Key: record.Key, MediaType: record.MediaType, Size: record.Size}, nil
+}
+
+// ObjectLocation is where a stored object lives. Provider is empty for
+// objects stored before providers were recorded, those are in the storage
+// the server is configured with.
+type ObjectLocation struct {
+	Provider string
+	Bucket   string
+	Key      string
+}
+
+// scanObjectLocation collects the nullable columns of an object location.
+type scanObjectLocation struct {
+	provider, bucket, key sql.NullString
+}
+
+func (s scanObjectLocation) location() *ObjectLocation {
+	if !s.key.Valid {
+		return nil
+	}
+	return &ObjectLocation{Provider: s.provider.String, Bucket: s.bucket.String, Key: s.key.String}
+}
+
+// objectLocationArgs returns the column values of an optional location.
+func objectLocationArgs(loc *ObjectLocation) (provider, bucket, key any) {
+	if loc == nil {
+		return nil, nil, nil
+	}
+	return nullIfEmpty(loc.Provider), nullIfEmpty(loc.Bucket), loc.Key
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ThumbnailURL is only stored for thumbnails served from elsewhere,
+	// like the assets directory. For stored thumbnails it is presigned from
+	// ThumbnailObject when the video is sent to a client, the same way
+	// VideoURL is from VideoObject.
+	ThumbnailURL    *string         `json:"thumbnail_url"`
+	ThumbnailObject *ObjectLocation `json:"-"`
+	VideoURL        *string         `json:"video_url"`
+	VideoObject     *ObjectLocation `json:"-"`
+	Status          VideoStatus     `json:"status"`
+	Renditions      Renditions      `json:"renditions,omitempty"`
+	// FailureReason tells the owner why a failed video was rejected, it is
+	// only set by SetVideoFailed and RejectVideo.
+	FailureReason *string `json:"failure_reason,omitempty"`
+	// ContentScore is how likely the content classifier found the video
+	// to be explicit, from 0 to 1, see SetVideoContentScore.
+	ContentScore *float64 `json:"content_score,omitempty"`
+	// Audio is set when AUDIO_RENDITION is on and the video has sound.
+	Audio *AudioRendition `json:"audio,omitempty"`
+	// PreviewURL is presigned from PreviewKey, the looping preview made
+	// when HOVER_PREVIEW is on.
+	PreviewURL *string `json:"preview_url,omitempty"`
+	PreviewKey *string `json:"-"`
+	// OriginalFormat is the media type the video was uploaded as, before
+	// it was transcoded to mp4.
+	OriginalFormat *string `json:"original_format,omitempty"`
+	// Checksum is the hex SHA-256 of the uploaded file.
+	Checksum *string `json:"checksum,omitempty"`
+	// AspectRatio is 16:9, 9:16 or other once the video is stored.
+	AspectRatio *string `json:"aspect_ratio,omitempty"`
+	// Metadata is set once the video is stored.
+	Metadata *VideoMetadata `json:"metadata,omitempty"`
+	// ModerationStatus is only changed by TakeDownVideo and RestoreVideo,
+	// UpdateVideo leaves it alone.
+	ModerationStatus ModerationStatus `json:"moderation_status"`
+	// ViewCount is the number of playbacks started, counted by
+	// RecordPlaybackEvent.
+	ViewCount int64 `json:"view_count"`
+	// StorageTier is only changed by SetVideoStorageTier, UpdateVideo
+	// leaves it alone.
+	StorageTier StorageTier `json:"storage_tier"`
+	// ReplicaKey is the video object whose copy is in the replica bucket,
+	// see SetVideoReplica.
+	ReplicaKey *string `json:"-"`
+	// Clip is set for videos cut from another one, see CreateClip.
+	Clip *VideoClip `json:"clip,omitempty"`
+	// Watermark identifies the watermark burned into the stored video,
+	// empty when there is none.
+	Watermark string `json:"-"`
+	// DeletedAt is set while the video is in the trash, see TrashVideo.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version is bumped by every change to the video but view counts.
+	// UpdateVideo and UpdateVideoMeta only change the version they were
+	// given.
+	Version int64 `json:"version"`
+	// Tags are loaded by the queries returning videos to clients, other
+	// queries leave them nil.
+	Tags []string `json:"tags,omitempty"`
+	// Captions are loaded along with Tags.
+	Captions []Caption `json:"captions,omitempty"`
+	// VideoSize and VideoContentType are not persisted, they are filled in
+	// from the object store when the video URL is presigned.
+	VideoSize        *int64  `json:"video_size,omitempty"`
+	VideoContentType *string `json:"video_content_type,omitempty"`
+	// VideoBytes and ThumbnailBytes count everything stored for the video
+	// towards the owner's quota, renditions and HLS segments included.
+	VideoBytes     int64 `json:"-"`
+	ThumbnailBytes int64 `json:"-"`
 	CreateVideoParams
 }
 
@@ -21,52 +324,197 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// Visibility defaults to private when empty.
+	Visibility VideoVisibility `json:"visibility"`
+	// PublishAt is when the video goes public, until then only its owner
+	// can view it. ExpiresAt is when it is archived or deleted.
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		thumbnail_provider,
+		thumbnail_bucket,
+		thumbnail_key,
+		video_provider,
+		video_bucket,
+		video_key,
+		user_id,
+		status,
+		renditions,
+		video_bytes,
+		thumbnail_bytes,
+		original_format,
+		checksum,
+		aspect_ratio,
+		metadata,
+		visibility,
+		moderation_status,
+		view_count,
+		version,
+		deleted_at,
+		publish_at,
+		expires_at,
+		storage_tier,
+		replica_key,
+		clip_source_id,
+		clip_start,
+		clip_end,
+		watermark,
+		audio,
+		preview_key,
+		failure_reason,
+		content_score`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	rows, err := c.db.Query(query, userID)
+// scanVideo scans the videoColumns of a row, followed by extra columns
+// into extra.
+func scanVideo(row rowScanner, extra ...any) (Video, error) {
+	var (
+		video            Video
+		thumbnail, media scanObjectLocation
+		metadata         sql.NullString
+		audio            sql.NullString
+		clipSourceID     *uuid.UUID
+		clipStart        sql.NullFloat64
+		clipEnd          sql.NullFloat64
+	)
+	dest := []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&thumbnail.provider,
+		&thumbnail.bucket,
+		&thumbnail.key,
+		&media.provider,
+		&media.bucket,
+		&media.key,
+		&video.UserID,
+		&video.Status,
+		&video.Renditions,
+		&video.VideoBytes,
+		&video.ThumbnailBytes,
+		&video.OriginalFormat,
+		&video.Checksum,
+		&video.AspectRatio,
+		&metadata,
+		&video.Visibility,
+		&video.ModerationStatus,
+		&video.ViewCount,
+		&video.Version,
+		&video.DeletedAt,
+		&video.PublishAt,
+		&video.ExpiresAt,
+		&video.StorageTier,
+		&video.ReplicaKey,
+		&clipSourceID,
+		&clipStart,
+		&clipEnd,
+		&video.Watermark,
+		&audio,
+		&video.PreviewKey,
+		&video.FailureReason,
+		&video.ContentScore,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return video, err
+	}
+	video.ThumbnailObject = thumbnail.location()
+	video.VideoObject = media.location()
+	if clipStart.Valid && clipEnd.Valid {
+		video.Clip = &VideoClip{SourceVideoID: clipSourceID, Start: clipStart.Float64, End: clipEnd.Float64}
+	}
+	var err error
+	video.Metadata, err = scanVideoMetadata(metadata)
 	if err != nil {
-		return nil, err
+		return video, err
 	}
+	video.Audio, err = scanAudioRendition(audio)
+	return video, err
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
+
+// GetAllVideos returns the videos of all users, trashed ones included.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
 
-	return videos, nil
+// GetRecentVideos returns the most recently created videos of all users,
+// newest first. When beforeID is set, only videos created strictly before
+// (beforeCreatedAt, beforeID) are returned, which allows keyset pagination.
+func (c Client) GetRecentVideos(beforeCreatedAt time.Time, beforeID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL
+	`
+	args := []any{}
+	if beforeID != uuid.Nil {
+		cursor := c.timeArg(beforeCreatedAt)
+		query += `AND (created_at < ? OR (created_at = ? AND id < ?))
+	`
+		args = append(args, cursor, cursor, beforeID)
+	}
+	query += `ORDER BY created_at DESC, id DESC
+	LIMIT ?
+	`
+	args = append(args, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+	return c.createVideo(params, nil)
+}
+
+// CreateClip creates a video to be cut from the source named in clip.
+func (c Client) CreateClip(params CreateVideoParams, clip VideoClip) (Video, error) {
+	return c.createVideo(params, &clip)
+}
+
+func (c Client) createVideo(params CreateVideoParams, clip *VideoClip) (Video, error) {
 	id := uuid.New()
 	query := `
 	INSERT INTO videos (
@@ -75,10 +523,38 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		status,
+		visibility,
+		publish_at,
+		expires_at,
+		clip_source_id,
+		clip_start,
+		clip_end
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = VideoVisibilityPrivate
+	}
+	var clipSourceID, clipStart, clipEnd any
+	if clip != nil {
+		clipSourceID, clipStart, clipEnd = clip.SourceVideoID, clip.Start, clip.End
+	}
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.Title,
+		params.Description,
+		params.UserID,
+		VideoStatusPending,
+		visibility,
+		c.optionalTimeArg(params.PublishAt),
+		c.optionalTimeArg(params.ExpiresAt),
+		clipSourceID,
+		clipStart,
+		clipEnd,
+	)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +564,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,38 +577,508 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
-	return video, nil
+	videos := []Video{video}
+	if err := c.loadRelated(videos); err != nil {
+		return Video{}, err
+	}
+	return videos[0], nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as the
+// escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetVideoByThumbnail returns the video whose thumbnail is stored at key,
+// or served from the assets directory under assetName, whichever is set.
+func (c Client) GetVideoByThumbnail(key, assetName string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL AND (thumbnail_key = ? OR thumbnail_url LIKE ? ESCAPE '\')
+	LIMIT 1
+	`
+	pattern := ""
+	if assetName != "" {
+		pattern = "%/assets/" + likeEscaper.Replace(assetName)
+	}
+	video, err := scanVideo(c.db.QueryRow(query, key, pattern))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
 }
 
+// UpdateVideo stores the objects and processing results of a video, unless
+// it was changed since video.Version was read, which fails with
+// ErrVersionMismatch. The title, description and visibility are only
+// changed by UpdateVideoMeta.
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
 	SET
-		title = ?,
-		description = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1,
 		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		thumbnail_provider = ?,
+		thumbnail_bucket = ?,
+		thumbnail_key = ?,
+		video_provider = ?,
+		video_bucket = ?,
+		video_key = ?,
+		user_id = ?,
+		status = ?,
+		renditions = ?,
+		video_bytes = ?,
+		thumbnail_bytes = ?,
+		original_format = ?,
+		checksum = ?,
+		aspect_ratio = ?,
+		metadata = ?,
+		watermark = ?,
+		audio = ?,
+		preview_key = ?
+	WHERE id = ? AND version = ?
 	`
 
-	_, err := c.db.Exec(
+	thumbnailProvider, thumbnailBucket, thumbnailKey := objectLocationArgs(video.ThumbnailObject)
+	videoProvider, videoBucket, videoKey := objectLocationArgs(video.VideoObject)
+	res, err := c.db.Exec(
 		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		video.ThumbnailURL,
+		thumbnailProvider,
+		thumbnailBucket,
+		thumbnailKey,
+		videoProvider,
+		videoBucket,
+		videoKey,
 		video.UserID,
+		video.Status,
+		video.Renditions,
+		video.VideoBytes,
+		video.ThumbnailBytes,
+		video.OriginalFormat,
+		video.Checksum,
+		video.AspectRatio,
+		video.Metadata,
+		video.Watermark,
+		video.Audio,
+		video.PreviewKey,
 		video.ID,
+		video.Version,
 	)
+	if err != nil {
+		return err
+	}
+	return checkVersionUpdated(res)
+}
+
+// checkVersionUpdated turns an update matching no row into
+// ErrVersionMismatch.
+func checkVersionUpdated(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+func (c Client) SetVideoStatus(id uuid.UUID, status VideoStatus) error {
+	query := `
+	UPDATE videos
+	SET status = ?, failure_reason = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, id)
+	return err
+}
+
+// SetVideoContentScore records the score the content classifier gave a
+// video. UpdateVideo leaves it alone.
+func (c Client) SetVideoContentScore(id uuid.UUID, score float64) error {
+	query := `
+	UPDATE videos
+	SET content_score = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, score, id)
+	return err
+}
+
+// SetVideoFailed marks a video failed, with reason shown to its owner.
+func (c Client) SetVideoFailed(id uuid.UUID, reason string) error {
+	return c.setVideoStatusReason(id, VideoStatusFailed, reason)
+}
+
+// RejectVideo marks a video rejected, with reason shown to its owner.
+func (c Client) RejectVideo(id uuid.UUID, reason string) error {
+	return c.setVideoStatusReason(id, VideoStatusRejected, reason)
+}
+
+func (c Client) setVideoStatusReason(id uuid.UUID, status VideoStatus, reason string) error {
+	query := `
+	UPDATE videos
+	SET status = ?, failure_reason = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, reason, id)
 	return err
 }
 
+// ErrVersionMismatch is returned by conditional updates when the video was
+// changed since the version they are based on, or deleted.
+var ErrVersionMismatch = errors.New("video was edited in the meantime")
+
+// UpdateVideoMetaParams are the fields UpdateVideoMeta changes, nil ones
+// are left alone. Tags replace the current ones and are expected to be
+// normalized already.
+type UpdateVideoMetaParams struct {
+	Title       *string
+	Description *string
+	Visibility  *VideoVisibility
+	Tags        *[]string
+	// PublishAt and ExpiresAt are cleared when set but not Valid.
+	PublishAt *sql.NullTime
+	ExpiresAt *sql.NullTime
+}
+
+// UpdateVideoMeta edits a video and bumps its version. With a version, only
+// a video still at that version is edited.
+func (c Client) UpdateVideoMeta(id uuid.UUID, version *int64, params UpdateVideoMetaParams) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	query := `
+	UPDATE videos
+	SET
+		title = COALESCE(?, title),
+		description = COALESCE(?, description),
+		visibility = COALESCE(?, visibility),
+		publish_at = CASE WHEN ? THEN ? ELSE publish_at END,
+		expires_at = CASE WHEN ? THEN ? ELSE expires_at END,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND version = COALESCE(?, version)
+	`
+	res, err := t.Exec(
+		query,
+		params.Title,
+		params.Description,
+		params.Visibility,
+		params.PublishAt != nil, c.nullTimeArg(params.PublishAt),
+		params.ExpiresAt != nil, c.nullTimeArg(params.ExpiresAt),
+		id,
+		version,
+	)
+	if err != nil {
+		return err
+	}
+	if err := checkVersionUpdated(res); err != nil {
+		return err
+	}
+	if params.Tags != nil {
+		if err := setVideoTags(t, id, *params.Tags); err != nil {
+			return err
+		}
+	}
+	return t.Commit()
+}
+
+// UserUsage is the storage a user consumes, summed over their videos.
+// Trashed videos count until they are purged.
+type UserUsage struct {
+	VideoBytes     int64
+	ThumbnailBytes int64
+	VideoCount     int
+}
+
+func (c Client) GetUserUsage(userID uuid.UUID) (UserUsage, error) {
+	query := `
+	SELECT
+		COALESCE(SUM(video_bytes), 0),
+		COALESCE(SUM(thumbnail_bytes), 0),
+		COUNT(*)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var usage UserUsage
+	err := c.db.QueryRow(query, userID).Scan(&usage.VideoBytes, &usage.ThumbnailBytes, &usage.VideoCount)
+	return usage, err
+}
+
+// FindVideoByChecksum returns the oldest ready, hot video other than
+// excludeID whose upload had the given checksum and that got the given
+// watermark, limited to one user's videos when userID is set. Trashed and
+// taken down videos are skipped, their objects may go away. The video is
+// zero when there is none.
+func (c Client) FindVideoByChecksum(checksum, watermark string, userID *uuid.UUID, excludeID uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE checksum = ? AND watermark = ? AND status = ? AND video_key IS NOT NULL AND id != ? AND storage_tier = ?
+		AND deleted_at IS NULL AND moderation_status = ?
+	`
+	args := []any{checksum, watermark, VideoStatusReady, excludeID, StorageTierHot, ModerationStatusActive}
+	if userID != nil {
+		query += `AND user_id = ?
+	`
+		args = append(args, *userID)
+	}
+	query += `ORDER BY created_at
+	LIMIT 1
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
+}
+
+// CountVideosWithObject returns how many videos point at the stored object,
+// as their video or their thumbnail.
+func (c Client) CountVideosWithObject(loc ObjectLocation) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE (video_key = ?
+			AND COALESCE(video_bucket, '') = ?
+			AND COALESCE(video_provider, '') = ?)
+		OR (thumbnail_key = ?
+			AND COALESCE(thumbnail_bucket, '') = ?
+			AND COALESCE(thumbnail_provider, '') = ?)
+	`
+	var count int
+	err := c.db.QueryRow(query, loc.Key, loc.Bucket, loc.Provider, loc.Key, loc.Bucket, loc.Provider).Scan(&count)
+	return count, err
+}
+
+// RelocateObject points the videos and thumbnails stored at from, matched
+// by provider and key, at to.
+func (c Client) RelocateObject(from, to ObjectLocation) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	provider, bucket, key := objectLocationArgs(&to)
+	for _, column := range []string{"video", "thumbnail"} {
+		query := fmt.Sprintf(`
+		UPDATE videos
+		SET %[1]s_provider = ?, %[1]s_bucket = ?, %[1]s_key = ?, version = version + 1
+		WHERE %[1]s_key = ? AND COALESCE(%[1]s_provider, '') = ?
+		`, column)
+		if _, err := t.Exec(query, provider, bucket, key, from.Key, from.Provider); err != nil {
+			return err
+		}
+	}
+	return t.Commit()
+}
+
+// ThumbnailMove points the thumbnail of a video, served from FromURL until
+// now, at a stored object of Size bytes.
+type ThumbnailMove struct {
+	VideoID uuid.UUID
+	FromURL string
+	To      ObjectLocation
+	Size    int64
+}
+
+// MoveThumbnails applies moves in one transaction, each only if the video
+// still has its thumbnail at FromURL. It returns the videos that moved.
+func (c Client) MoveThumbnails(moves []ThumbnailMove) ([]uuid.UUID, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	query := `
+	UPDATE videos
+	SET thumbnail_url = NULL, thumbnail_provider = ?, thumbnail_bucket = ?, thumbnail_key = ?,
+		thumbnail_bytes = ?, version = version + 1
+	WHERE id = ? AND thumbnail_url = ? AND thumbnail_key IS NULL
+	`
+	var moved []uuid.UUID
+	for _, move := range moves {
+		provider, bucket, key := objectLocationArgs(&move.To)
+		res, err := t.Exec(query, provider, bucket, key, move.Size, move.VideoID, move.FromURL)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			moved = append(moved, move.VideoID)
+		}
+	}
+	if err := t.Commit(); err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	// SQLite doesn't enforce the cascades
+	if _, err := t.Exec("DELETE FROM video_tags WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM video_captions WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM playlist_videos WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM share_links WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM video_reports WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM playbacks WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("UPDATE videos SET clip_source_id = NULL WHERE clip_source_id = ?", id); err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	if _, err := t.Exec(query, id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// timeArg prepares a time to be compared with a timestamp column. SQLite
+// compares the timestamps CURRENT_TIMESTAMP wrote as text.
+func (c Client) timeArg(t time.Time) any {
+	if c.db.dialect == dialectPostgres {
+		return t
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// optionalTimeArg and nullTimeArg are timeArg for nullable columns.
+func (c Client) optionalTimeArg(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return c.timeArg(*t)
+}
+
+func (c Client) nullTimeArg(t *sql.NullTime) any {
+	if t == nil || !t.Valid {
+		return nil
+	}
+	return c.timeArg(t.Time)
+}
+
+// VideoSort is a column videos can be listed by.
+type VideoSort string
+
+const (
+	VideoSortCreated VideoSort = "created_at"
+	VideoSortUpdated VideoSort = "updated_at"
+	VideoSortTitle   VideoSort = "title"
+)
+
+// ListVideosParams selects a page of a user's videos.
+type ListVideosParams struct {
+	UserID uuid.UUID
+	// Statuses, AspectRatio and Tags filter the videos when set. Videos
+	// must carry all of Tags, which must not repeat.
+	Statuses    []VideoStatus
+	AspectRatio string
+	Tags        []string
+	Sort        VideoSort
+	Descending  bool
+	// After is the last video of the previous page. Only its ID and the
+	// column sorted by are used.
+	After *Video
+	Limit int
+}
+
+// ListVideos returns a page of videos. Ties in the sort column are broken
+// by ID, so pages continue exactly where the previous one ended.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL
+	`
+	args := []any{params.UserID}
+	if len(params.Statuses) > 0 {
+		query += `AND status IN (?` + strings.Repeat(", ?", len(params.Statuses)-1) + `)
+	`
+		for _, status := range params.Statuses {
+			args = append(args, status)
+		}
+	}
+	if params.AspectRatio != "" {
+		query += `AND aspect_ratio = ?
+	`
+		args = append(args, params.AspectRatio)
+	}
+	if len(params.Tags) > 0 {
+		filter, filterArgs := tagFilter("id", params.Tags)
+		query += "AND " + filter + `
+	`
+		args = append(args, filterArgs...)
+	}
+
+	var after any
+	switch params.Sort {
+	case VideoSortCreated:
+		if params.After != nil {
+			after = c.timeArg(params.After.CreatedAt)
+		}
+	case VideoSortUpdated:
+		if params.After != nil {
+			after = c.timeArg(params.After.UpdatedAt)
+		}
+	case VideoSortTitle:
+		if params.After != nil {
+			after = params.After.Title
+		}
+	default:
+		return nil, fmt.Errorf("cannot sort videos by %q", params.Sort)
+	}
+	order, cmp := "ASC", ">"
+	if params.Descending {
+		order, cmp = "DESC", "<"
+	}
+	col := string(params.Sort)
+	if params.After != nil {
+		query += fmt.Sprintf(`AND (%s %s ? OR (%s = ? AND id %s ?))
+	`, col, cmp, col, cmp)
+		args = append(args, after, after, params.After.ID)
+	}
+	query += fmt.Sprintf(`ORDER BY %s %s, id %s
+	LIMIT ?
+	`, col, order, order)
+	args = append(args, params.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	return videos, c.loadRelated(videos)
 }
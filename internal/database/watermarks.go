@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WatermarkPosition is the corner, or the center, of the video a watermark
+// is put in.
+type WatermarkPosition string
+
+const (
+	WatermarkTopLeft     WatermarkPosition = "top-left"
+	WatermarkTopRight    WatermarkPosition = "top-right"
+	WatermarkBottomLeft  WatermarkPosition = "bottom-left"
+	WatermarkBottomRight WatermarkPosition = "bottom-right"
+	WatermarkCenter      WatermarkPosition = "center"
+)
+
+// UserWatermark is the image a user has burned into their processed videos.
+type UserWatermark struct {
+	UserID    uuid.UUID         `json:"user_id"`
+	Position  WatermarkPosition `json:"position"`
+	ObjectKey string            `json:"-"`
+	// URL is presigned from ObjectKey when the watermark is sent to a
+	// client.
+	URL       *string   `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetUserWatermark returns the watermark of a user, a zero UserWatermark
+// when they have none.
+func (c Client) GetUserWatermark(userID uuid.UUID) (UserWatermark, error) {
+	var wm UserWatermark
+	err := c.db.QueryRow(`
+	SELECT user_id, position, object_key, created_at, updated_at
+	FROM user_watermarks
+	WHERE user_id = ?
+	`, userID).Scan(&wm.UserID, &wm.Position, &wm.ObjectKey, &wm.CreatedAt, &wm.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserWatermark{}, nil
+	}
+	return wm, err
+}
+
+// PutUserWatermark sets the watermark of a user. It returns the object key
+// of the replaced image, empty when there was none.
+func (c Client) PutUserWatermark(wm UserWatermark) (string, error) {
+	t, err := c.db.begin()
+	if err != nil {
+		return "", err
+	}
+	defer t.Rollback()
+
+	var replaced string
+	err = t.QueryRow("SELECT object_key FROM user_watermarks WHERE user_id = ?", wm.UserID).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	_, err = t.Exec(`
+	INSERT INTO user_watermarks (
+		user_id,
+		object_key,
+		position,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (user_id) DO UPDATE SET
+		object_key = excluded.object_key,
+		position = excluded.position,
+		updated_at = excluded.updated_at
+	`, wm.UserID, wm.ObjectKey, wm.Position)
+	if err != nil {
+		return "", err
+	}
+	return replaced, t.Commit()
+}
+
+// DeleteUserWatermark removes the watermark of a user. It returns the
+// object key of the image, empty when there was none.
+func (c Client) DeleteUserWatermark(userID uuid.UUID) (string, error) {
+	var key string
+	err := c.db.QueryRow("DELETE FROM user_watermarks WHERE user_id = ? RETURNING object_key", userID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+// GetWatermarkObjectKeys returns the object keys of all user watermarks.
+func (c Client) GetWatermarkObjectKeys() ([]string, error) {
+	rows, err := c.db.Query("SELECT object_key FROM user_watermarks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a URL a user wants events about their videos POSTed to.
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	// Secret signs the deliveries. It is only shown when the webhook is
+	// created.
+	Secret string   `json:"-"`
+	Events []string `json:"events"`
+}
+
+// Subscribed reports whether the webhook receives event.
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusSucceeded DeliveryStatus = "succeeded"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook.
+type WebhookDelivery struct {
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	WebhookID uuid.UUID      `json:"webhook_id"`
+	Event     string         `json:"event"`
+	Payload   string         `json:"payload"`
+	Status    DeliveryStatus `json:"status"`
+	Attempts  int            `json:"attempts"`
+	// ResponseStatus is the HTTP status of the last attempt, nil when it
+	// got no response.
+	ResponseStatus *int       `json:"response_status"`
+	LastError      *string    `json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret,
+		events`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var (
+		w      Webhook
+		events string
+	)
+	err := row.Scan(
+		&w.ID,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+		&w.UserID,
+		&w.URL,
+		&w.Secret,
+		&events,
+	)
+	w.Events = strings.Split(events, ",")
+	return w, err
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret,
+		events
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.URL, params.Secret, strings.Join(params.Events, ","))
+	if err != nil {
+		return Webhook{}, err
+	}
+	return c.GetWebhook(id)
+}
+
+// GetWebhook returns a zero Webhook when there is none with the id.
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	w, err := scanWebhook(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, nil
+	}
+	return w, err
+}
+
+// GetWebhooks returns the webhooks of a user, oldest first.
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	t, err := c.db.begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	// SQLite doesn't enforce the cascade
+	if _, err := t.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := t.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+const deliveryColumns = `
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event,
+		payload,
+		status,
+		attempts,
+		response_status,
+		last_error,
+		delivered_at`
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(
+		&d.ID,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.WebhookID,
+		&d.Event,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.ResponseStatus,
+		&d.LastError,
+		&d.DeliveredAt,
+	)
+	return d, err
+}
+
+// CreateWebhookDelivery records a pending delivery of an event.
+func (c Client) CreateWebhookDelivery(id, webhookID uuid.UUID, event, payload string) (WebhookDelivery, error) {
+	query := `
+	INSERT INTO webhook_deliveries (
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event,
+		payload,
+		status
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, webhookID, event, payload, DeliveryStatusPending)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return c.GetWebhookDelivery(id)
+}
+
+// GetWebhookDelivery returns a zero WebhookDelivery when there is none
+// with the id.
+func (c Client) GetWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	query := `
+	SELECT` + deliveryColumns + `
+	FROM webhook_deliveries
+	WHERE id = ?
+	`
+	d, err := scanDelivery(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookDelivery{}, nil
+	}
+	return d, err
+}
+
+// GetWebhookDeliveries returns the latest deliveries to a webhook, newest
+// first.
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + deliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY created_at DESC, id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordDeliveryAttempt logs the outcome of sending a delivery. A nil
+// attemptErr marks it as delivered.
+func (c Client) RecordDeliveryAttempt(id uuid.UUID, responseStatus *int, attemptErr error) error {
+	if attemptErr == nil {
+		query := `
+		UPDATE webhook_deliveries
+		SET
+			updated_at = CURRENT_TIMESTAMP,
+			attempts = attempts + 1,
+			status = ?,
+			response_status = ?,
+			last_error = NULL,
+			delivered_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		`
+		_, err := c.db.Exec(query, DeliveryStatusSucceeded, responseStatus, id)
+		return err
+	}
+	query := `
+	UPDATE webhook_deliveries
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		attempts = attempts + 1,
+		response_status = ?,
+		last_error = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, responseStatus, attemptErr.Error(), id)
+	return err
+}
+
+// FailWebhookDelivery gives up on a delivery.
+func (c Client) FailWebhookDelivery(id uuid.UUID) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DeliveryStatusFailed, id)
+	return err
+}
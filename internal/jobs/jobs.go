@@ -0,0 +1,235 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// RunFunc executes a job. Returning an error schedules a retry, unless it
+// is a Permanent one.
+type RunFunc func(ctx context.Context, job database.Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as one retrying won't fix, the job fails at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// FailFunc is called once a job has used up all its attempts.
+type FailFunc func(ctx context.Context, job database.Job, err error)
+
+type handler struct {
+	run    RunFunc
+	failed FailFunc
+}
+
+// Pool runs jobs persisted in the database with a fixed number of workers.
+type Pool struct {
+	db           database.Client
+	workers      int
+	maxAttempts  int
+	pollInterval time.Duration
+	baseBackoff  time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]handler
+	wake     chan struct{}
+	wg       sync.WaitGroup
+
+	// stop tells the workers not to claim more jobs, cancel interrupts the
+	// ones that are running.
+	stop     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+}
+
+func NewPool(db database.Client, workers, maxAttempts int) *Pool {
+	return &Pool{
+		db:           db,
+		workers:      workers,
+		maxAttempts:  maxAttempts,
+		pollInterval: 2 * time.Second,
+		baseBackoff:  5 * time.Second,
+		handlers:     map[string]handler{},
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Register sets the functions used for jobs of the given kind. failed may
+// be nil.
+func (p *Pool) Register(kind string, run RunFunc, failed FailFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[kind] = handler{run: run, failed: failed}
+}
+
+// Enqueue persists a job with a JSON encoded payload and wakes a worker.
+func (p *Pool) Enqueue(kind string, payload interface{}) (database.Job, error) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := p.db.CreateJob(database.CreateJobParams{
+		Kind:    kind,
+		Payload: string(dat),
+		RunAt:   time.Now(),
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start requeues jobs interrupted by a previous shutdown and starts the
+// workers. They stop once ctx is cancelled or Shutdown is called.
+func (p *Pool) Start(ctx context.Context) error {
+	if err := p.db.RequeueRunningJobs(); err != nil {
+		return err
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+	return nil
+}
+
+// Wait blocks until all workers have returned.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// Shutdown stops claiming new jobs and waits for the running ones to
+// finish. When ctx ends first they are cancelled and put back in the queue
+// without using up an attempt.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if p.cancel != nil {
+			p.cancel()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) stopping() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil && !p.stopping() {
+			job, err := p.db.ClaimJob(time.Now())
+			if err != nil {
+				log.Printf("cannot claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			p.run(ctx, *job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling each time.
+func (p *Pool) backoff(attempts int) time.Duration {
+	return p.baseBackoff * time.Duration(1<<min(attempts-1, 10))
+}
+
+func (p *Pool) run(ctx context.Context, job database.Job) {
+	p.mu.RLock()
+	h, ok := p.handlers[job.Kind]
+	p.mu.RUnlock()
+	if !ok {
+		if err := p.db.FailJob(job.ID, fmt.Sprintf("no handler for job kind %q", job.Kind)); err != nil {
+			log.Printf("cannot fail job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	err := h.run(ctx, job)
+	if err == nil {
+		if err := p.db.CompleteJob(job.ID); err != nil {
+			log.Printf("cannot complete job %s: %v", job.ID, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		log.Printf("job %s (%s) interrupted by shutdown, requeueing it", job.ID, job.Kind)
+		if err := p.db.ReleaseJob(job.ID); err != nil {
+			log.Printf("cannot requeue job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("job %s (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, err)
+	var permanent *permanentError
+	if job.Attempts < p.maxAttempts && !errors.As(err, &permanent) {
+		runAt := time.Now().Add(p.backoff(job.Attempts))
+		if err := p.db.RetryJob(job.ID, runAt, err.Error()); err != nil {
+			log.Printf("cannot retry job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := p.db.FailJob(job.ID, err.Error()); err != nil {
+		log.Printf("cannot fail job %s: %v", job.ID, err)
+	}
+	if h.failed != nil {
+		h.failed(ctx, job, err)
+	}
+}
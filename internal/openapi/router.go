@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Router registers routes on a ServeMux and documents them. Requests are
+// validated against the operation of their route before the handler runs.
+type Router struct {
+	mux         *http.ServeMux
+	doc         Document
+	annotations map[string]Operation
+	onInvalid   func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewRouter documents the routes registered through it in a document with
+// info. annotations describe the routes by pattern, a route without one is
+// only documented with what its pattern tells. onInvalid writes the
+// response to a request failing validation.
+func NewRouter(mux *http.ServeMux, info Info, schemes map[string]SecurityScheme, annotations map[string]Operation, onInvalid func(w http.ResponseWriter, r *http.Request, err error)) *Router {
+	return &Router{
+		mux: mux,
+		doc: Document{
+			OpenAPI:    "3.0.3",
+			Info:       info,
+			Paths:      map[string]*PathItem{},
+			Components: Components{SecuritySchemes: schemes},
+		},
+		annotations: annotations,
+		onInvalid:   onInvalid,
+	}
+}
+
+// HandleFunc registers handler for pattern, which must name a method, and
+// adds it to the document.
+func (rt *Router) HandleFunc(pattern string, handler http.HandlerFunc) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		panic(fmt.Sprintf("openapi: pattern %q has no method", pattern))
+	}
+	op := rt.annotations[pattern]
+	delete(rt.annotations, pattern)
+	op.Parameters = append(pathParams(path, op.Parameters), op.Parameters...)
+	if op.Responses == nil {
+		op.Responses = map[string]Response{"default": {Description: "See the error in the body for failures"}}
+	}
+
+	item := rt.doc.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		rt.doc.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = &op
+
+	rt.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if err := Validate(&op, r); err != nil {
+			rt.onInvalid(w, r, err)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// pathParams returns the parameters of a path pattern that aren't declared
+// already. Those named like IDs are UUIDs.
+func pathParams(path string, declared []Parameter) []Parameter {
+	var params []Parameter
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		name := m[1]
+		if declaresPathParam(declared, name) {
+			continue
+		}
+		schema := String()
+		if strings.HasSuffix(name, "ID") {
+			schema = UUID()
+		}
+		params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	return params
+}
+
+func declaresPathParam(params []Parameter, name string) bool {
+	for _, p := range params {
+		if p.In == "path" && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Unregistered returns the patterns of annotations no route was registered
+// for, most likely typos.
+func (rt *Router) Unregistered() []string {
+	patterns := make([]string, 0, len(rt.annotations))
+	for pattern := range rt.annotations {
+		patterns = append(patterns, pattern)
+	}
+	slices.Sort(patterns)
+	return patterns
+}
+
+// ServeHTTP serves the document as JSON.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dat, err := json.Marshal(rt.doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(dat)
+}
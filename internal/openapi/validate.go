@@ -0,0 +1,249 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxJSONBody is the largest JSON request body that is validated, bigger
+// ones are refused with ErrBodyTooLarge.
+const MaxJSONBody = 1 << 20
+
+// ErrBodyTooLarge is returned for JSON bodies over MaxJSONBody.
+var ErrBodyTooLarge = errors.New("request body is too large")
+
+// ValidationError is a part of a request that doesn't match the document.
+type ValidationError struct {
+	// Location names the offending value, like query.limit or body.title.
+	Location string
+	Message  string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Location + ": " + e.Message
+}
+
+// Validate checks the parameters and JSON body of a request against an
+// operation. A JSON body is read whole and put back for the handler.
+func Validate(op *Operation, r *http.Request) error {
+	for _, p := range op.Parameters {
+		var (
+			v       string
+			present bool
+		)
+		switch p.In {
+		case "path":
+			v = r.PathValue(p.Name)
+			present = v != ""
+		case "query":
+			present = r.URL.Query().Has(p.Name)
+			v = r.URL.Query().Get(p.Name)
+		case "header":
+			v = r.Header.Get(p.Name)
+			present = v != ""
+		default:
+			continue
+		}
+		location := p.In + "." + p.Name
+		if !present {
+			if p.Required {
+				return &ValidationError{Location: location, Message: "is required"}
+			}
+			continue
+		}
+		if err := validateParam(p.Schema, v, location); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxJSONBody+1))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxJSONBody {
+		return ErrBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return &ValidationError{Location: "body", Message: "is required"}
+		}
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return &ValidationError{Location: "body", Message: "is not valid JSON"}
+	}
+	return validateValue(media.Schema, v, "body")
+}
+
+// validateParam parses a parameter by the type of its schema first, they
+// all arrive as strings.
+func validateParam(s *Schema, raw, location string) error {
+	if s == nil {
+		return nil
+	}
+	var v any = raw
+	switch s.Type {
+	case "integer", "number":
+		v = json.Number(raw)
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return &ValidationError{Location: location, Message: "must be true or false"}
+		}
+		v = b
+	}
+	return validateValue(s, v, location)
+}
+
+func validateValue(s *Schema, v any, location string) error {
+	if s == nil {
+		return nil
+	}
+	invalid := func(format string, args ...any) error {
+		return &ValidationError{Location: location, Message: fmt.Sprintf(format, args...)}
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return invalid("must not be null")
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return invalid("must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return &ValidationError{Location: location + "." + name, Message: "is required"}
+			}
+		}
+		// properties are checked in a fixed order, for stable errors
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			value, ok := obj[name]
+			if !ok {
+				continue
+			}
+			if err := validateValue(s.Properties[name], value, location+"."+name); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return invalid("must be an array")
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			return invalid("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return invalid("must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range items {
+			if err := validateValue(s.Items, item, fmt.Sprintf("%s[%d]", location, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			return invalid("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return invalid("must be at most %d characters", *s.MaxLength)
+		}
+		switch s.Format {
+		case "uuid":
+			if _, err := uuid.Parse(str); err != nil {
+				return invalid("must be a UUID")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return invalid("must be an RFC 3339 time")
+			}
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, any(str)) {
+			return invalid("must be one of %s", enumList(s.Enum))
+		}
+		return nil
+
+	case "integer", "number":
+		kind := "a number"
+		if s.Type == "integer" {
+			kind = "an integer"
+		}
+		num, ok := v.(json.Number)
+		if !ok {
+			return invalid("must be %s", kind)
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return invalid("must be %s", kind)
+		}
+		if s.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				return invalid("must be an integer")
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return invalid("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return invalid("must be at most %v", *s.Maximum)
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid("must be true or false")
+		}
+		return nil
+	}
+	return nil
+}
+
+func enumList(values []any) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, ", ")
+}
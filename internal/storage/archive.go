@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Objects are archived by copying them onto themselves in a cold storage
+// class. Reading them again takes a restore, which makes a temporary copy
+// readable after minutes to hours; Restore then copies that back into the
+// standard class for good. Copies are limited to 5 GB objects.
+
+// restoreDays is how long S3 keeps the temporary copy of a restored
+// object, which only has to outlive copying it back.
+const restoreDays = 7
+
+// isCold reports whether objects of class have to be restored to be read.
+func isCold(class types.StorageClass) bool {
+	return class == types.StorageClassGlacier || class == types.StorageClassDeepArchive
+}
+
+// Archive moves the object to the cold storage class. Objects already in
+// a cold class are left alone.
+func (s *S3) Archive(ctx context.Context, key string, class types.StorageClass) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+	if isCold(head.StorageClass) {
+		return nil
+	}
+	return s.copyToClass(ctx, key, class)
+}
+
+// Restore takes one step towards making an archived object readable again
+// and reports whether it is. It requests the restore with tier, waits for
+// it, and copies the restored object back into the standard class; callers
+// call it again until it is done.
+func (s *S3) Restore(ctx context.Context, key string, tier types.Tier) (bool, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return false, err
+	}
+	if !isCold(head.StorageClass) {
+		return true, nil
+	}
+	// Restore is set once a restore was requested, e.g.
+	// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+	restore := aws.ToString(head.Restore)
+	switch {
+	case restore == "":
+		_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+			Bucket: &s.bucket,
+			Key:    &key,
+			RestoreRequest: &types.RestoreRequest{
+				Days:                 aws.Int32(restoreDays),
+				GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+			},
+		})
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+			return false, nil
+		}
+		return false, err
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return false, nil
+	}
+	if err := s.copyToClass(ctx, key, types.StorageClassStandard); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// copyToClass copies the object onto itself in class, keeping its
+// metadata and encryption.
+func (s *S3) copyToClass(ctx context.Context, key string, class types.StorageClass) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            &s.bucket,
+		Key:               &key,
+		CopySource:        copySource(s.bucket, key),
+		StorageClass:      class,
+		MetadataDirective: types.MetadataDirectiveCopy,
+	}
+	s.opts.Encryption.applyCopy(input)
+	_, err := s.client.CopyObject(ctx, input)
+	return err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	slowDownMinDelay = 100 * time.Millisecond
+	slowDownMaxDelay = 20 * time.Second
+)
+
+// slowDownController adapts the delay between S3 calls to how often the
+// bucket answers with SlowDown. It is shared by all requests so that one
+// throttled upload also slows down the uploads running next to it.
+type slowDownController struct {
+	mu       sync.Mutex
+	delay    time.Duration
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+var s3SlowDown = newSlowDownController(slowDownMinDelay, slowDownMaxDelay)
+
+func newSlowDownController(minDelay, maxDelay time.Duration) *slowDownController {
+	return &slowDownController{
+		minDelay: minDelay,
+		maxDelay: maxDelay,
+	}
+}
+
+// Delay returns the current delay applied before S3 calls.
+func (c *slowDownController) Delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delay
+}
+
+// OnSlowDown widens the delay, doubling it for every consecutive SlowDown.
+func (c *slowDownController) OnSlowDown() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.delay == 0 {
+		c.delay = c.minDelay
+	} else {
+		c.delay *= 2
+	}
+	if c.delay > c.maxDelay {
+		c.delay = c.maxDelay
+	}
+	return c.delay
+}
+
+// OnSuccess narrows the delay again, halving it until it drops below the
+// minimum and is removed altogether.
+func (c *slowDownController) OnSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delay /= 2
+	if c.delay < c.minDelay {
+		c.delay = 0
+	}
+}
+
+func (c *slowDownController) wait(ctx context.Context) error {
+	delay := c.Delay()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isSlowDownError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "SlowDown" {
+		return true
+	}
+	var httpErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpErr) && httpErr.HTTPStatusCode() == http.StatusServiceUnavailable {
+		return true
+	}
+	return false
+}
+
+// slowDownRetryer paces every attempt of an S3 call, retries included, by
+// the controller and tells it how each attempt went. The wrapped retryer
+// still decides whether and how often to retry.
+type slowDownRetryer struct {
+	aws.RetryerV2
+	c *slowDownController
+}
+
+func (r slowDownRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if err := r.c.wait(ctx); err != nil {
+		return nil, err
+	}
+	release, err := r.RetryerV2.GetAttemptToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(err error) error {
+		switch {
+		case err == nil:
+			r.c.OnSuccess()
+		case isSlowDownError(err):
+			r.c.OnSlowDown()
+		}
+		return release(err)
+	}, nil
+}
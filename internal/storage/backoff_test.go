@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const slowDownBody = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+
+func TestSlowDownBackoffWidens(t *testing.T) {
+	controller := newSlowDownController(time.Millisecond, time.Second)
+	saved := s3SlowDown
+	s3SlowDown = controller
+	defer func() { s3SlowDown = saved }()
+
+	var (
+		mu       sync.Mutex
+		delays   []time.Duration
+		slowDown atomic.Bool
+	)
+	slowDown.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		delays = append(delays, controller.Delay())
+		mu.Unlock()
+		if slowDown.Load() {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(slowDownBody))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	const maxAttempts = 4
+	client := NewS3Client(aws.Config{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}}, srv.URL, RetryOptions{
+		MaxAttempts: maxAttempts,
+		MaxBackoff:  time.Millisecond,
+	})
+	put := func() error {
+		_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("key"),
+			Body:   bytes.NewReader([]byte("body")),
+		})
+		return err
+	}
+
+	err := put()
+	if !isSlowDownError(err) {
+		t.Fatalf("err = %v, want SlowDown", err)
+	}
+	// the SDK retries, nothing retries on top of it
+	if len(delays) != maxAttempts {
+		t.Fatalf("%d requests, want %d", len(delays), maxAttempts)
+	}
+	if delays[0] != 0 {
+		t.Errorf("first attempt delayed by %s", delays[0])
+	}
+	for i := 1; i < len(delays); i++ {
+		if delays[i] <= delays[i-1] {
+			t.Errorf("delay before attempt %d = %s, not wider than %s", i+1, delays[i], delays[i-1])
+		}
+	}
+	widened := controller.Delay()
+	if widened <= delays[len(delays)-1] {
+		t.Errorf("delay after the last SlowDown = %s, want wider than %s", widened, delays[len(delays)-1])
+	}
+
+	slowDown.Store(false)
+	if err := put(); err != nil {
+		t.Fatal(err)
+	}
+	if narrowed := controller.Delay(); narrowed >= widened {
+		t.Errorf("delay after success = %s, want narrower than %s", narrowed, widened)
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned without calling the storage while the circuit
+// breaker is open.
+var ErrUnavailable = errors.New("storage is unavailable")
+
+// CircuitBreaker wraps a Storage and stops calling it after Threshold
+// consecutive failures, failing fast with ErrUnavailable instead of making
+// every request wait for retries to run out. After Cooldown one call is let
+// through to probe whether the storage recovered; its outcome closes the
+// breaker or keeps it open for another Cooldown.
+type CircuitBreaker struct {
+	Storage
+	threshold int
+	cooldown  time.Duration
+	// OnStateChange, when set, is called whenever the breaker opens or
+	// closes.
+	OnStateChange func(open bool)
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(s Storage, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Storage:   s,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow returns ErrUnavailable while the breaker is open or another call is
+// already probing.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return fmt.Errorf("%w, retrying in %s", ErrUnavailable, wait.Round(time.Second))
+	}
+	if b.probing {
+		return ErrUnavailable
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openUntil.IsZero()
+	b.probing = false
+	if !isOutage(err) {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		if wasOpen && b.OnStateChange != nil {
+			b.OnStateChange(false)
+		}
+		return
+	}
+	b.failures++
+	if wasOpen || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if !wasOpen && b.OnStateChange != nil {
+			b.OnStateChange(true)
+		}
+	}
+}
+
+// isOutage reports whether err says the storage is failing, as opposed to
+// the request being wrong or abandoned.
+func isOutage(err error) bool {
+	if err == nil ||
+		errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrChecksumMismatch) ||
+		errors.Is(err, ErrNotEncrypted) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+	var httpErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpErr) {
+		// no status means no response, the request never got through
+		status := httpErr.HTTPStatusCode()
+		return status == 0 || status >= 500 || status == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (b *CircuitBreaker) Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error) {
+	if err := b.allow(); err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := b.Storage.Put(ctx, key, body, contentType)
+	b.record(err)
+	return info, err
+}
+
+func (b *CircuitBreaker) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	body, err := b.Storage.Get(ctx, key)
+	b.record(err)
+	return body, err
+}
+
+func (b *CircuitBreaker) Delete(ctx context.Context, key string) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.Storage.Delete(ctx, key)
+	b.record(err)
+	return err
+}
+
+func (b *CircuitBreaker) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := b.allow(); err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := b.Storage.Head(ctx, key)
+	b.record(err)
+	return info, err
+}
+
+func (b *CircuitBreaker) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	objects, err := b.Storage.List(ctx, prefix)
+	b.record(err)
+	return objects, err
+}
+
+// Presign only signs locally, it fails fast while the breaker is open so
+// clients aren't handed URLs to a storage that doesn't answer, but doesn't
+// count towards opening it.
+func (b *CircuitBreaker) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	b.mu.Lock()
+	open := time.Now().Before(b.openUntil)
+	b.mu.Unlock()
+	if open {
+		return "", ErrUnavailable
+	}
+	return b.Storage.Presign(ctx, key, expires)
+}
+
+func (b *CircuitBreaker) Check(ctx context.Context) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.Storage.Check(ctx)
+	b.record(err)
+	return err
+}
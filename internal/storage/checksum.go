@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ErrChecksumMismatch is returned by Put when the stored object doesn't
+// match what was sent.
+var ErrChecksumMismatch = errors.New("stored object doesn't match the uploaded content")
+
+// partHasher computes the SHA-256 of a whole stream and of each partSize
+// chunk of it. The chunk digests predict the composite checksum S3 reports
+// for multipart uploads, which is a digest of the part digests.
+type partHasher struct {
+	partSize int64
+	whole    hash.Hash
+	part     hash.Hash
+	partLen  int64
+	parts    [][]byte
+	size     int64
+}
+
+func newPartHasher(partSize int64) *partHasher {
+	return &partHasher{
+		partSize: partSize,
+		whole:    sha256.New(),
+		part:     sha256.New(),
+	}
+}
+
+func (h *partHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.whole.Write(p)
+	h.size += int64(n)
+	for len(p) > 0 {
+		chunk := p[:min(int64(len(p)), h.partSize-h.partLen)]
+		h.part.Write(chunk)
+		h.partLen += int64(len(chunk))
+		p = p[len(chunk):]
+		if h.partLen == h.partSize {
+			h.parts = append(h.parts, h.part.Sum(nil))
+			h.part.Reset()
+			h.partLen = 0
+		}
+	}
+	return n, nil
+}
+
+// partSums returns the digest of every part, including the trailing
+// partial one.
+func (h *partHasher) partSums() [][]byte {
+	sums := h.parts
+	if h.partLen > 0 || len(sums) == 0 {
+		sums = append(sums[:len(sums):len(sums)], h.part.Sum(nil))
+	}
+	return sums
+}
+
+// Sum returns the hex SHA-256 of everything written.
+func (h *partHasher) Sum() string {
+	return hex.EncodeToString(h.whole.Sum(nil))
+}
+
+// s3Checksum returns the ChecksumSHA256 S3 reports for the object, either
+// for a single PutObject or for a multipart upload split at partSize.
+func (h *partHasher) s3Checksum(multipart bool) string {
+	if !multipart {
+		return base64.StdEncoding.EncodeToString(h.whole.Sum(nil))
+	}
+	sums := h.partSums()
+	composite := sha256.New()
+	for _, sum := range sums {
+		composite.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(composite.Sum(nil)), len(sums))
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNotEncrypted is returned by Put when S3 didn't store the object with
+// the configured server-side encryption.
+var ErrNotEncrypted = errors.New("stored object isn't encrypted as configured")
+
+// Encryption is the server-side encryption every object is stored with.
+// The zero value leaves it to the bucket default.
+type Encryption struct {
+	// Algorithm is types.ServerSideEncryptionAes256 for SSE-S3 or
+	// types.ServerSideEncryptionAwsKms for SSE-KMS.
+	Algorithm types.ServerSideEncryption
+	// KMSKeyID is the KMS key of SSE-KMS, the bucket or AWS managed key
+	// when empty.
+	KMSKeyID string
+}
+
+func (e Encryption) kmsKeyID() *string {
+	if e.Algorithm != types.ServerSideEncryptionAwsKms || e.KMSKeyID == "" {
+		return nil
+	}
+	return &e.KMSKeyID
+}
+
+func (e Encryption) applyPut(input *s3.PutObjectInput) {
+	input.ServerSideEncryption = e.Algorithm
+	input.SSEKMSKeyId = e.kmsKeyID()
+}
+
+func (e Encryption) applyMultipart(input *s3.CreateMultipartUploadInput) {
+	input.ServerSideEncryption = e.Algorithm
+	input.SSEKMSKeyId = e.kmsKeyID()
+}
+
+func (e Encryption) applyCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = e.Algorithm
+	input.SSEKMSKeyId = e.kmsKeyID()
+}
+
+// PostFields returns the form fields a presigned POST upload must send,
+// each also to be required by an "eq" condition of its policy.
+func (e Encryption) PostFields() map[string]string {
+	fields := map[string]string{}
+	if e.Algorithm != "" {
+		fields["x-amz-server-side-encryption"] = string(e.Algorithm)
+	}
+	if id := e.kmsKeyID(); id != nil {
+		fields["x-amz-server-side-encryption-aws-kms-key-id"] = *id
+	}
+	return fields
+}
+
+// check compares the encryption HeadObject reported for key with e.
+func (e Encryption) check(key string, head *s3.HeadObjectOutput) error {
+	if e.Algorithm == "" {
+		return nil
+	}
+	if head.ServerSideEncryption != e.Algorithm {
+		return fmt.Errorf("%w: %s is stored with %q, want %q", ErrNotEncrypted, key, head.ServerSideEncryption, e.Algorithm)
+	}
+	// S3 reports the key ARN, which a key ID is the end of; an alias can't
+	// be told apart from it
+	if id := e.kmsKeyID(); id != nil && !strings.HasPrefix(*id, "alias/") && !strings.Contains(*id, ":alias/") {
+		got := aws.ToString(head.SSEKMSKeyId)
+		if got != *id && !strings.HasSuffix(got, "/"+*id) {
+			return fmt.Errorf("%w: %s is encrypted with KMS key %q, want %q", ErrNotEncrypted, key, got, *id)
+		}
+	}
+	return nil
+}
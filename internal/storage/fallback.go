@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Fallback wraps a Storage, S3 in practice, and spills the objects it fails
+// to store while it is down into Spill, a local storage, instead of failing
+// the upload. Spilled objects are read from Spill until they are put into
+// the primary storage again, which removes the spilled copy.
+type Fallback struct {
+	Storage
+	Spill *Local
+	// OnSpill, when set, is called for every object put into Spill with
+	// the error the primary storage failed with.
+	OnSpill func(key string, err error)
+}
+
+func NewFallback(primary Storage, spill *Local) *Fallback {
+	return &Fallback{
+		Storage: primary,
+		Spill:   spill,
+	}
+}
+
+type noSpillKey struct{}
+
+// WithoutSpill makes Puts under ctx fail like the primary storage does
+// instead of spilling, for putting spilled objects back.
+func WithoutSpill(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noSpillKey{}, true)
+}
+
+// Spilled reports whether key is stored in Spill.
+func (f *Fallback) Spilled(ctx context.Context, key string) bool {
+	_, err := f.Spill.Head(ctx, key)
+	return err == nil
+}
+
+// Put spills the object when the primary storage is failing and the body
+// can still be read from the start: it is seekable or the primary storage
+// failed without reading it, like a CircuitBreaker does.
+func (f *Fallback) Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error) {
+	seeker, seekable := body.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	info, err := f.Storage.Put(ctx, key, body, contentType)
+	if err == nil {
+		// the object made it, an older spilled copy is stale now
+		if err := f.Spill.Delete(ctx, key); err != nil {
+			return ObjectInfo{}, fmt.Errorf("cannot remove spilled copy: %w", err)
+		}
+		return info, nil
+	}
+	if !isOutage(err) || ctx.Value(noSpillKey{}) != nil {
+		return ObjectInfo{}, err
+	}
+	switch {
+	case seekable:
+		if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr != nil {
+			return ObjectInfo{}, err
+		}
+	case !errors.Is(err, ErrUnavailable):
+		// part of the body is gone already
+		return ObjectInfo{}, err
+	}
+	info, spillErr := f.Spill.Put(ctx, key, body, contentType)
+	if spillErr != nil {
+		return ObjectInfo{}, fmt.Errorf("%w, and spilling failed: %v", err, spillErr)
+	}
+	if f.OnSpill != nil {
+		f.OnSpill(key, err)
+	}
+	return info, nil
+}
+
+func (f *Fallback) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := f.Spill.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return body, err
+	}
+	return f.Storage.Get(ctx, key)
+}
+
+func (f *Fallback) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := f.Spill.Head(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+	return f.Storage.Head(ctx, key)
+}
+
+// Delete removes the object from both storages.
+func (f *Fallback) Delete(ctx context.Context, key string) error {
+	if err := f.Spill.Delete(ctx, key); err != nil {
+		return err
+	}
+	return f.Storage.Delete(ctx, key)
+}
+
+// List returns the objects of both storages, a spilled copy taking the
+// place of one in the primary storage.
+func (f *Fallback) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	spilled, err := f.Spill.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := f.Storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(spilled))
+	for _, object := range spilled {
+		seen[object.Key] = true
+	}
+	for _, object := range objects {
+		if !seen[object.Key] {
+			spilled = append(spilled, object)
+		}
+	}
+	return spilled, nil
+}
+
+func (f *Fallback) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if f.Spilled(ctx, key) {
+		return f.Spill.Presign(ctx, key, expires)
+	}
+	return f.Storage.Presign(ctx, key, expires)
+}
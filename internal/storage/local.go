@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files below root, which is expected to be served
+// over HTTP at baseURL. Files are sharded, see ShardKey. Those stored before
+// sharding are still found until they are moved.
+type Local struct {
+	root    string
+	baseURL string
+}
+
+func NewLocal(root, baseURL string) *Local {
+	return &Local{
+		root:    root,
+		baseURL: baseURL,
+	}
+}
+
+func (l *Local) path(key string) (string, error) {
+	rel, err := l.find(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(rel)), nil
+}
+
+// find returns the path below root key is stored at: its sharded path,
+// unless only a file from before sharding exists.
+func (l *Local) find(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	rel := ShardKey(key)
+	if rel == key {
+		return rel, nil
+	}
+	if _, err := os.Stat(filepath.Join(l.root, filepath.FromSlash(rel))); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(filepath.Join(l.root, filepath.FromSlash(key))); err == nil {
+			return key, nil
+		}
+	}
+	return rel, nil
+}
+
+// Put writes to a temp file next to the target and renames it into place,
+// so readers never see a partially written object. The file is read back
+// afterwards to check it against what was written.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return ObjectInfo{}, fmt.Errorf("invalid object key %q", key)
+	}
+	filePath := filepath.Join(l.root, filepath.FromSlash(ShardKey(key)))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return ObjectInfo{}, err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(tempFile.Name())
+
+	written := sha256.New()
+	body = &progressReader{r: body, fn: progressFrom(ctx)}
+	size, err := io.Copy(io.MultiWriter(tempFile, written), body)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.Rename(tempFile.Name(), filePath); err != nil {
+		return ObjectInfo{}, err
+	}
+	if ShardKey(key) != key {
+		// a file from before sharding would otherwise outlive the object
+		os.Remove(filepath.Join(l.root, filepath.FromSlash(key)))
+	}
+
+	want := hex.EncodeToString(written.Sum(nil))
+	got, err := fileSHA256(filePath)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("cannot verify stored object: %w", err)
+	}
+	if got != want {
+		return ObjectInfo{}, fmt.Errorf("%w: %s has checksum %s, sent %s", ErrChecksumMismatch, key, got, want)
+	}
+	return ObjectInfo{
+		Key:         key,
+		Size:        size,
+		ContentType: contentType,
+		SHA256:      want,
+	}, nil
+}
+
+func fileSHA256(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, rel := range []string{ShardKey(key), key} {
+		err := os.Remove(filepath.Join(l.root, filepath.FromSlash(rel)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Head guesses the content type from the key's extension, the way the file
+// server serving root will.
+func (l *Local) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}, nil
+}
+
+// Check creates and removes a file below root.
+func (l *Local) Check(ctx context.Context) error {
+	if err := os.MkdirAll(l.root, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(l.root, ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// List walks the directories prefix may be stored in. Prefixes may end in
+// the middle of a file name, when that is the name the shard is picked by
+// the whole top directory is walked.
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var walkRoots []string
+	if dir, rest, ok := strings.Cut(prefix, "/"); !ok {
+		walkRoots = []string{""}
+	} else if name, _, ok := strings.Cut(rest, "/"); !ok {
+		walkRoots = []string{dir}
+	} else {
+		// the directory of the name and where it was before sharding
+		walkRoots = []string{ShardKey(dir + "/" + name), dir + "/" + name}
+	}
+
+	var objects []ObjectInfo
+	for _, walkRoot := range walkRoots {
+		err := filepath.WalkDir(filepath.Join(l.root, filepath.FromSlash(walkRoot)), func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(l.root, filePath)
+			if err != nil {
+				return err
+			}
+			key, _ := UnshardKey(filepath.ToSlash(rel))
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			stat, err := d.Info()
+			if err != nil {
+				return err
+			}
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         stat.Size(),
+				ContentType:  mime.TypeByExtension(path.Ext(key)),
+				LastModified: stat.ModTime(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// Presign returns the public URL of the file. Local files don't expire.
+func (l *Local) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	rel, err := l.find(key)
+	if err != nil {
+		return "", err
+	}
+	return l.baseURL + "/" + rel, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// uploadFileMultipart uploads f as an S3 multipart upload, sending up to
+// parallelism parts of partSize bytes at a time. Each part is retried on its
+// own, so a transient error doesn't resend the whole file. If any part fails
+// the multipart upload is aborted so S3 doesn't keep the orphaned parts.
+// When partSums is set, every part is sent with its SHA-256 from it.
+func uploadFileMultipart(ctx context.Context, client *s3.Client, bucket, key, contentType string, f *os.File, partSize int64, parallelism int, partSums [][]byte, sse Encryption) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		ContentType: &contentType,
+	}
+	if partSums != nil {
+		createInput.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	sse.applyMultipart(createInput)
+	created, err := client.CreateMultipartUpload(ctx, createInput)
+	if err != nil {
+		return fmt.Errorf("cannot create multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	abort := func(cause error) error {
+		// the request context may already be cancelled, abort regardless
+		_, err := client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   &bucket,
+			Key:      &key,
+			UploadId: uploadID,
+		})
+		if err != nil {
+			log.Printf("cannot abort multipart upload %s: %v", *uploadID, err)
+		}
+		return cause
+	}
+
+	partCount := int((size + partSize - 1) / partSize)
+	if partCount == 0 {
+		partCount = 1
+	}
+
+	progress := progressFrom(ctx)
+	var (
+		mu        sync.Mutex
+		sent      int64
+		completed = make([]types.CompletedPart, 0, partCount)
+		firstErr  error
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, parallelism)
+	)
+	for i := 0; i < partCount; i++ {
+		partNumber := int32(i + 1)
+		offset := int64(i) * partSize
+		length := min(partSize, size-offset)
+		var checksum *string
+		if partSums != nil {
+			checksum = aws.String(base64.StdEncoding.EncodeToString(partSums[i]))
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			mu.Lock()
+			failed := firstErr != nil
+			mu.Unlock()
+			if failed {
+				return
+			}
+
+			section := io.NewSectionReader(f, offset, length)
+			out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:         &bucket,
+				Key:            &key,
+				UploadId:       uploadID,
+				PartNumber:     &partNumber,
+				Body:           section,
+				ContentLength:  &length,
+				ChecksumSHA256: checksum,
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("cannot upload part %d: %w", partNumber, err)
+				}
+				return
+			}
+			completed = append(completed, types.CompletedPart{
+				ETag:           out.ETag,
+				PartNumber:     &partNumber,
+				ChecksumSHA256: out.ChecksumSHA256,
+			})
+			sent += length
+			progress(sent, size)
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return abort(firstErr)
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("cannot complete multipart upload: %w", err))
+	}
+	return nil
+}
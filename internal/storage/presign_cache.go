@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// presignReuse is the share of a presigned URL's lifetime during which it is
+// handed out again instead of signing a new one.
+const presignReuse = 0.8
+
+// presignCacheSweep is the number of cached keys above which expired URLs
+// are dropped on insert.
+const presignCacheSweep = 10000
+
+type presignEntry struct {
+	url      string
+	reuseEnd time.Time
+}
+
+// PresignCache wraps a Storage and reuses presigned URLs until 80% of their
+// lifetime has passed, so listing videos doesn't sign every URL again. URLs
+// are cached per key and requested lifetime.
+type PresignCache struct {
+	Storage
+
+	mu      sync.Mutex
+	entries map[string]map[time.Duration]presignEntry
+}
+
+func NewPresignCache(s Storage) *PresignCache {
+	return &PresignCache{
+		Storage: s,
+		entries: map[string]map[time.Duration]presignEntry{},
+	}
+}
+
+func (c *PresignCache) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key][expires]
+	c.mu.Unlock()
+	if ok && now.Before(entry.reuseEnd) {
+		return entry.url, nil
+	}
+
+	url, err := c.Storage.Presign(ctx, key, expires)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= presignCacheSweep {
+		for k, byExpiry := range c.entries {
+			for expiry, entry := range byExpiry {
+				if !now.Before(entry.reuseEnd) {
+					delete(byExpiry, expiry)
+				}
+			}
+			if len(byExpiry) == 0 {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if c.entries[key] == nil {
+		c.entries[key] = map[time.Duration]presignEntry{}
+	}
+	c.entries[key][expires] = presignEntry{
+		url:      url,
+		reuseEnd: now.Add(time.Duration(float64(expires) * presignReuse)),
+	}
+	return url, nil
+}
+
+// forget drops the cached URLs of a key.
+func (c *PresignCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *PresignCache) Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error) {
+	c.forget(key)
+	return c.Storage.Put(ctx, key, body, contentType)
+}
+
+func (c *PresignCache) Delete(ctx context.Context, key string) error {
+	c.forget(key)
+	return c.Storage.Delete(ctx, key)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// ProgressFunc is told how many bytes of a Put have been sent so far, out of
+// total when the size is known up front and 0 otherwise.
+type ProgressFunc func(sent, total int64)
+
+type progressKey struct{}
+
+// WithProgress makes Puts under ctx report how far along they are to fn.
+// Calls may come from several goroutines but never at the same time.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFrom(ctx context.Context) ProgressFunc {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		return fn
+	}
+	return func(int64, int64) {}
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	r     io.Reader
+	fn    ProgressFunc
+	sent  int64
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.fn(p.sent, p.total)
+	}
+	return n, err
+}
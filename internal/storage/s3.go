@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Options tunes how large objects are uploaded.
+type S3Options struct {
+	PartSize    int64
+	Parallelism int
+	// Checksums sends a SHA-256 with every upload for the service to check.
+	// Not every S3 compatible service supports it.
+	Checksums bool
+	// Encryption is set on every upload and checked once it is stored.
+	Encryption Encryption
+}
+
+// S3 stores objects in a bucket of Amazon S3 or any service speaking the
+// S3 API, like MinIO or the GCS XML API.
+type S3 struct {
+	client *s3.Client
+	bucket string
+	opts   S3Options
+}
+
+func NewS3(client *s3.Client, bucket string, opts S3Options) *S3 {
+	return &S3{
+		client: client,
+		bucket: bucket,
+		opts:   opts,
+	}
+}
+
+// RetryOptions configures how every S3 call, the parts of multipart uploads
+// included, is retried: up to MaxAttempts attempts in total, with
+// exponential backoff and jitter capped at MaxBackoff. Every attempt is
+// also paced by the shared SlowDown controller.
+type RetryOptions struct {
+	MaxAttempts int
+	MaxBackoff  time.Duration
+	// OnRetry, when set, is called before every retried attempt.
+	OnRetry func()
+}
+
+// countingRetryer reports the retries of the wrapped retryer.
+type countingRetryer struct {
+	aws.RetryerV2
+	onRetry func()
+}
+
+func (r countingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	delay, err := r.RetryerV2.RetryDelay(attempt, err)
+	if err == nil {
+		r.onRetry()
+	}
+	return delay, err
+}
+
+// NewS3Client creates the S3 API client. When endpoint is set requests go
+// there instead of AWS, with path style addressing and without the default
+// request checksums, which MinIO and GCS don't all understand.
+func NewS3Client(awsConf aws.Config, endpoint string, retryOpts RetryOptions) *s3.Client {
+	return s3.NewFromConfig(awsConf, func(o *s3.Options) {
+		var retryer aws.RetryerV2 = slowDownRetryer{
+			RetryerV2: retry.NewStandard(func(so *retry.StandardOptions) {
+				so.MaxAttempts = retryOpts.MaxAttempts
+				so.MaxBackoff = retryOpts.MaxBackoff
+			}),
+			c: s3SlowDown,
+		}
+		o.Retryer = retryer
+		if retryOpts.OnRetry != nil {
+			o.Retryer = countingRetryer{RetryerV2: retryer, onRetry: retryOpts.OnRetry}
+		}
+		if endpoint == "" {
+			return
+		}
+		o.BaseEndpoint = &endpoint
+		o.UsePathStyle = true
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+}
+
+func isNotFoundError(err error) bool {
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var httpErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &httpErr) && httpErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// Put uploads files larger than the part size as a multipart upload and
+// seekable bodies in a single request the SDK can retry. Other readers are
+// streamed through the upload manager. The content is hashed
+// on the way and, with checksums enabled, S3 rejects any part that arrives
+// differently.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error) {
+	hasher := newPartHasher(s.opts.PartSize)
+	if f, ok := body.(*os.File); ok {
+		stat, err := f.Stat()
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		if stat.Size() > s.opts.PartSize {
+			if _, err := io.Copy(hasher, io.NewSectionReader(f, 0, stat.Size())); err != nil {
+				return ObjectInfo{}, err
+			}
+			var partSums [][]byte
+			if s.opts.Checksums {
+				partSums = hasher.partSums()
+			}
+			err := uploadFileMultipart(ctx, s.client, s.bucket, key, contentType, f, s.opts.PartSize, s.opts.Parallelism, partSums, s.opts.Encryption)
+			if err != nil {
+				return ObjectInfo{}, err
+			}
+			return s.verify(ctx, key, contentType, hasher)
+		}
+	}
+	progress := progressFrom(ctx)
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		if _, err := io.Copy(hasher, seeker); err != nil {
+			return ObjectInfo{}, err
+		}
+		var checksum *string
+		if s.opts.Checksums {
+			checksum = aws.String(hasher.s3Checksum(false))
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return ObjectInfo{}, err
+		}
+		input := &s3.PutObjectInput{
+			Bucket:         &s.bucket,
+			Key:            &key,
+			Body:           seeker,
+			ContentType:    &contentType,
+			ChecksumSHA256: checksum,
+		}
+		s.opts.Encryption.applyPut(input)
+		if _, err := s.client.PutObject(ctx, input); err != nil {
+			return ObjectInfo{}, err
+		}
+		// a single request, done all at once
+		progress(hasher.size, hasher.size)
+		return s.verify(ctx, key, contentType, hasher)
+	}
+
+	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
+		u.PartSize = s.opts.PartSize
+		u.Concurrency = s.opts.Parallelism
+	})
+	input := &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		Body:        io.TeeReader(&progressReader{r: body, fn: progress}, hasher),
+		ContentType: &contentType,
+	}
+	if s.opts.Checksums {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	s.opts.Encryption.applyPut(input)
+	if _, err := uploader.Upload(ctx, input); err != nil {
+		return ObjectInfo{}, err
+	}
+	return s.verify(ctx, key, contentType, hasher)
+}
+
+// verify compares the size and, when the service reports one, the SHA-256
+// checksum of the stored object with what Put read from the body, and its
+// encryption with the configured one.
+func (s *S3) verify(ctx context.Context, key, contentType string, hasher *partHasher) (ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &s.bucket,
+		Key:          &key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("cannot verify stored object: %w", err)
+	}
+	if err := s.opts.Encryption.check(key, head); err != nil {
+		return ObjectInfo{}, err
+	}
+	if size := aws.ToInt64(head.ContentLength); size != hasher.size {
+		return ObjectInfo{}, fmt.Errorf("%w: %s has %d bytes, sent %d", ErrChecksumMismatch, key, size, hasher.size)
+	}
+	if got := aws.ToString(head.ChecksumSHA256); got != "" {
+		// multipart uploads report a checksum of the part checksums
+		if want := hasher.s3Checksum(strings.Contains(got, "-")); got != want {
+			return ObjectInfo{}, fmt.Errorf("%w: %s has checksum %s, sent %s", ErrChecksumMismatch, key, got, want)
+		}
+	}
+	return ObjectInfo{
+		Key:         key,
+		Size:        hasher.size,
+		ContentType: contentType,
+		SHA256:      hasher.Sum(),
+	}, nil
+}
+
+// CopyFrom copies the object under key in another bucket, which may be in
+// another region, to the same key in this one. Objects up to 5 GB can be
+// copied.
+func (s *S3) CopyFrom(ctx context.Context, bucket, key string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     &s.bucket,
+		Key:        &key,
+		CopySource: copySource(bucket, key),
+	}
+	s.opts.Encryption.applyCopy(input)
+	_, err := s.client.CopyObject(ctx, input)
+	return err
+}
+
+// copySource is the CopySource of an object.
+func copySource(bucket, key string) *string {
+	return aws.String(bucket + "/" + (&url.URL{Path: key}).EscapedPath())
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if isNotFoundError(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+func (s *S3) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if isNotFoundError(err) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	var info ObjectInfo
+	if head.ContentLength != nil {
+		info.Size = *head.ContentLength
+	}
+	if head.ContentType != nil {
+		info.ContentType = *head.ContentType
+	}
+	return info, nil
+}
+
+// Check asks for the bucket, which fails when it is missing or the
+// credentials can't access it.
+func (s *S3) Check(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: &s.bucket,
+	})
+	return err
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			info := ObjectInfo{Key: aws.ToString(object.Key)}
+			if object.Size != nil {
+				info.Size = *object.Size
+			}
+			if object.LastModified != nil {
+				info.LastModified = *object.LastModified
+			}
+			objects = append(objects, info)
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client)
+	resp, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
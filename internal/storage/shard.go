@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Local files are sharded below the first directory of their key by a hash
+// of the next path element, so a directory like hls/, with one entry per
+// video, never holds more than 256 entries on each level:
+// hls/<id>/index.m3u8 is stored at hls/ab/cd/<id>/index.m3u8.
+
+// ShardKey returns the path below the root key is stored at. Keys without
+// a directory are left alone.
+func ShardKey(key string) string {
+	dir, rest, ok := strings.Cut(key, "/")
+	if !ok || rest == "" {
+		return key
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	sum := sha256.Sum256([]byte(name))
+	shard := hex.EncodeToString(sum[:2])
+	return dir + "/" + shard[:2] + "/" + shard[2:] + "/" + rest
+}
+
+// UnshardKey returns the key stored at a path below the root and whether
+// the path is sharded. Paths from before sharding are their own key.
+func UnshardKey(p string) (string, bool) {
+	parts := strings.SplitN(p, "/", 4)
+	if len(parts) == 4 {
+		key := parts[0] + "/" + parts[3]
+		if ShardKey(key) == p {
+			return key, true
+		}
+	}
+	return p, false
+}
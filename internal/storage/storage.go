@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Get and Head when the key doesn't exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object. Key and LastModified are only set
+// by List, SHA256 only by Put.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// SHA256 is the hex digest of the object content.
+	SHA256 string
+}
+
+// Storage is a key/value blob store the videos, thumbnails and derived
+// files are written to. Keys are slash separated paths like
+// "landscape/abc.mp4".
+type Storage interface {
+	// Put stores body under key, replacing any existing object. The stored
+	// object is checked against the content read from body and
+	// ErrChecksumMismatch returned if they differ.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (ObjectInfo, error)
+	// Get opens the object for reading. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// Head returns the size and content type of the object.
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns all objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Presign returns a URL clients can fetch the object from for at least
+	// the given duration.
+	Presign(ctx context.Context, key string, expires time.Duration) (string, error)
+	// Check reports whether the storage is reachable and accepts writes.
+	Check(ctx context.Context) error
+}
@@ -2,22 +2,53 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
+// apiErrors maps the internal errors clients can act on to the status and
+// code they are answered with, whatever status the handler picked.
+var apiErrors = apierror.Mappings{
+	{Target: errNotOwner, Status: http.StatusForbidden, Code: apierror.CodeNotOwner},
+	{Target: errUnsupportedMediaType, Status: http.StatusUnsupportedMediaType, Code: apierror.CodeUnsupportedMediaType},
+	{Target: errMediaBusy, Status: http.StatusServiceUnavailable, Code: apierror.CodeUnavailable},
+	{Target: storage.ErrUnavailable, Status: http.StatusServiceUnavailable, Code: apierror.CodeUnavailable},
+	{Target: errVideoDeleted, Status: http.StatusNotFound, Code: apierror.CodeNotFound},
+	{Target: errVideoArchived, Status: http.StatusConflict, Code: apierror.CodeConflict},
+	{Target: database.ErrVersionMismatch, Status: http.StatusPreconditionFailed, Code: apierror.CodePreconditionFailed},
+	{Target: errMediaCommandFailed, Status: http.StatusUnprocessableEntity, Code: apierror.CodeMediaFailed},
+	{Target: errMediaLimit, Status: http.StatusUnprocessableEntity, Code: apierror.CodeMediaLimitExceeded},
+}
+
+// respondWithError logs err and answers with msg, unless err is one of
+// apiErrors or an *apierror.Error, which decide the response themselves.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+	respondWithAPIError(w, apiErrors.Resolve(code, msg, err))
+}
+
+// respondWithAPIError logs the cause of e and answers with the error
+// envelope. The request ID set by requestLogMiddleware is part of both so
+// a failure can be traced from the client's side.
+func respondWithAPIError(w http.ResponseWriter, e *apierror.Error) {
+	id := w.Header().Get(requestIDHeader)
+	logger := slog.Default()
+	if id != "" {
+		logger = logger.With("request_id", id)
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	if e.Status > 499 {
+		logger.Error("responding with error", "status", e.Status, "code", e.Code, "response", e.Message, "err", e.Err)
+	} else if e.Err != nil {
+		logger.Info("responding with error", "status", e.Status, "code", e.Code, "response", e.Message, "err", e.Err)
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+	respondWithJSON(w, e.Status, apierror.Body{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestID: id,
+		Details:   e.Details,
 	})
 }
 
@@ -25,7 +56,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("cannot marshal JSON", "err", err)
 		w.WriteHeader(500)
 		return
 	}
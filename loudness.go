@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	loudnormTruePeak = -1.5
+	loudnormRange    = 11
+	// loudnormSampleRate is what normalized audio is resampled to, loudnorm
+	// itself outputs 192 kHz.
+	loudnormSampleRate = "48000"
+)
+
+var errNoLoudnessReport = errors.New("ffmpeg printed no loudness measurement")
+
+// loudnessMeasurement is what the first loudnorm pass reports about the
+// audio of a file, as ffmpeg prints it. The second pass is given the
+// strings back unchanged.
+type loudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// summary returns the measurement for the video metadata, or nil for
+// silent audio, which measures -inf.
+func (m loudnessMeasurement) summary() *database.Loudness {
+	integrated, err1 := strconv.ParseFloat(m.InputI, 64)
+	truePeak, err2 := strconv.ParseFloat(m.InputTP, 64)
+	lra, err3 := strconv.ParseFloat(m.InputLRA, 64)
+	if err := errors.Join(err1, err2, err3); err != nil || math.IsInf(integrated, 0) || math.IsInf(truePeak, 0) {
+		return nil
+	}
+	return &database.Loudness{Integrated: integrated, TruePeak: truePeak, Range: lra}
+}
+
+// loudnormFilter returns the EBU R128 normalization filter to target LUFS.
+// Given the first pass measurement it normalizes linearly, keeping the
+// dynamics, instead of the dynamic single pass mode.
+func loudnormFilter(target float64, m *loudnessMeasurement) string {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%d", target, loudnormTruePeak, loudnormRange)
+	if m == nil {
+		return filter
+	}
+	return filter + fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset)
+}
+
+type loudnessKey struct{}
+
+// measuredFile is a file whose loudness measurement is carried by a
+// context.
+type measuredFile struct {
+	path string
+	m    loudnessMeasurement
+}
+
+// withLoudness returns ctx carrying the loudness measurement of the file at
+// path, which measureLoudness then returns instead of measuring again.
+func withLoudness(ctx context.Context, path string, m loudnessMeasurement) context.Context {
+	return context.WithValue(ctx, loudnessKey{}, measuredFile{path: path, m: m})
+}
+
+// measureLoudness runs the first loudnorm pass over the first audio stream
+// of the file at filePath, decoding it without writing anything.
+func measureLoudness(ctx context.Context, filePath string, target float64) (loudnessMeasurement, error) {
+	if measured, ok := ctx.Value(loudnessKey{}).(measuredFile); ok && measured.path == filePath {
+		return measured.m, nil
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpegBin,
+		"-hide_banner",
+		"-nostats",
+		"-i", filePath,
+		"-map", "0:a:0",
+		"-af", loudnormFilter(target, nil)+":print_format=json",
+		"-f", "null",
+		"-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return loudnessMeasurement{}, fmt.Errorf("ffmpeg loudness measurement failed: %w\nstderr: %s", err, stderr.String())
+	}
+
+	// the report is the last JSON object ffmpeg prints
+	out := stderr.Bytes()
+	start := bytes.LastIndexByte(out, '{')
+	end := bytes.LastIndexByte(out, '}')
+	if start < 0 || end < start {
+		return loudnessMeasurement{}, errNoLoudnessReport
+	}
+	var m loudnessMeasurement
+	if err := json.Unmarshal(out[start:end+1], &m); err != nil {
+		return loudnessMeasurement{}, fmt.Errorf("cannot decode loudness measurement: %w", err)
+	}
+	if m.InputI == "" {
+		return loudnessMeasurement{}, errNoLoudnessReport
+	}
+	return m, nil
+}
+
+// normalizationFilter returns the loudnorm filter for the audio of the file
+// at filePath, measured first, or "" when it is silent and there is
+// nothing to normalize.
+func normalizationFilter(ctx context.Context, filePath string, target float64) (string, error) {
+	m, err := measureLoudness(ctx, filePath, target)
+	if err != nil {
+		return "", err
+	}
+	if m.summary() == nil {
+		return "", nil
+	}
+	return loudnormFilter(target, &m), nil
+}
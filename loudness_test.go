@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestFastStartLoudnorm(t *testing.T) {
+	const filePath = "in.mp4"
+	loud := loudnessMeasurement{InputI: "-23.4", InputTP: "-4.1", InputLRA: "6.2", InputThresh: "-33.9", TargetOffset: "0.3"}
+	silent := loudnessMeasurement{InputI: "-inf", InputTP: "-inf", InputLRA: "0.00", InputThresh: "-70.00", TargetOffset: "inf"}
+	tests := []struct {
+		name      string
+		normalize bool
+		audio     string
+		m         loudnessMeasurement
+		loudnorm  bool
+	}{
+		{"enabled", true, "aac", loud, true},
+		{"disabled", false, "aac", loud, false},
+		{"no audio", true, "", loud, false},
+		{"silent", true, "aac", silent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// measured up front, nothing runs ffmpeg
+			ctx := withVideoProbe(context.Background(), filePath, videoProbeInfo{database.VideoMetadata{AudioCodec: tt.audio}})
+			ctx = withLoudness(ctx, filePath, tt.m)
+			filter, err := fastStartAudioFilter(ctx, filePath, processingOptions{NormalizeAudio: tt.normalize, TargetLUFS: -16})
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := fastStartArgs(filePath, "out.mp4", filter)
+
+			i := slices.Index(args, "-af")
+			if !tt.loudnorm {
+				if i >= 0 {
+					t.Fatalf("args filter the audio: %q", args)
+				}
+				if !slices.Contains(args, "copy") || slices.Contains(args, "aac") {
+					t.Errorf("args don't stream copy: %q", args)
+				}
+				if slices.Contains(args, "-ar") {
+					t.Errorf("stream copied audio is resampled: %q", args)
+				}
+				return
+			}
+			if i < 0 {
+				t.Fatalf("args have no audio filter: %q", args)
+			}
+			want := "loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-23.4:measured_TP=-4.1:measured_LRA=6.2:measured_thresh=-33.9:offset=0.3:linear=true"
+			if args[i+1] != want {
+				t.Errorf("audio filter = %q, want %q", args[i+1], want)
+			}
+			joined := strings.Join(args, " ")
+			if !strings.Contains(joined, "-c:v copy") {
+				t.Errorf("args re-encode the video: %q", args)
+			}
+			// loudnorm outputs 192 kHz, which aac would keep at 96 kHz
+			if !strings.Contains(joined, "-ar 48000") {
+				t.Errorf("normalized audio isn't resampled to 48 kHz: %q", args)
+			}
+		})
+	}
+}
+
+func TestExtractAudioArgsResamplesLoudnorm(t *testing.T) {
+	format := audioFormats["aac"]
+	args := strings.Join(extractAudioArgs("in.mp4", "out.m4a", format, "aac", loudnormFilter(-16, nil)), " ")
+	if !strings.Contains(args, "-ar 48000") {
+		t.Errorf("normalized audio isn't resampled to 48 kHz: %s", args)
+	}
+	args = strings.Join(extractAudioArgs("in.mp4", "out.m4a", format, "aac", ""), " ")
+	if strings.Contains(args, "-ar") {
+		t.Errorf("copied audio is resampled: %s", args)
+	}
+}
@@ -2,30 +2,125 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 )
 
 type apiConfig struct {
 	db               database.Client
 	jwtSecret        string
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
+	assetsMaxAge     time.Duration
+	assetsPrivate    bool
+	assetsBaseURL    string
+	publicBaseURL    string
 	s3Bucket         string
+	storageProvider  string
 	s3Region         string
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+	s3Encryption     storage.Encryption
+	// archiveStorage is only set for the s3 storage provider, the others
+	// have no cold storage classes.
+	archiveStorage *storage.S3
+	// replica is set with S3_REPLICA_BUCKET, see regionMiddleware.
+	replica            *storage.S3
+	replicaRegion      string
+	replicaCountries   map[string]bool
+	geoCountryHeader   string
+	archiveClass       types.StorageClass
+	archiveRestoreTier types.Tier
+	storage            storage.Storage
+	// spillover is set when uploads spill to local disk while S3 is down.
+	spillover      *storage.Fallback
+	audioNormalize bool
+	// virusScanner is nil unless VIRUS_SCANNER is set.
+	virusScanner virusScanner
+	// classifier is nil unless CLASSIFIER is set, the scores videos are
+	// flagged and taken down at come with it.
+	classifier           contentClassifier
+	classifierFrames     int
+	classifierFlagAt     float64
+	classifierTakedownAt float64
+	// transcriber is nil unless TRANSCRIBER is set.
+	transcriber           transcriber
+	transcriptionLanguage string
+	audioTargetLUFS       float64
+	audioRendition        string
+	videoFormField        string
+	thumbnailFormField    string
+	presignHeadCheck      bool
+	adminAPIKey           string
+	previewSeconds        int
+	uploadStreaming       bool
+	jobs                  *jobs.Pool
+	hlsEnabled            bool
+	transcodeLadder       []int
+	storyboardInterval    float64
+	hoverPreview          string
+	hoverPreviewLength    float64
+	orphanMinAge          time.Duration
+	orphanGCDelete        bool
+	trashRetention        time.Duration
+	expiryAction          string
+	userQuota             int64
+	maxVideoSize          int64
+	maxThumbnailSize      int64
+	maxVideoDuration      time.Duration
+	maxVideoResolution    imageSize
+	thumbnailMaxSize      imageSize
+	thumbnailMinSize      imageSize
+	thumbnailQuality      int
+	dedupScope            dedupScope
+	videoURLExpiry        time.Duration
+	hlsURLExpiry          time.Duration
+	previewURLExpiry      time.Duration
+	publicURLExpiry       time.Duration
+	publicCDN             bool
+	exportExpiry          time.Duration
+	mediaTimeout          time.Duration
+	scratchMinFree        int64
+	uploads               *uploadTracker
+	cancels               *cancelRegistry
+	webhookClient         *http.Client
+	importClient          *http.Client
+	importMaxSize         int64
+	importTimeout         time.Duration
+	progress              *progressHub
+	idempotencyKeyTTL     time.Duration
+	// watermark is the operator's, nil unless WATERMARK_PATH is set.
+	watermark *watermark
+	// errorReporter is nil unless ERROR_REPORT_URL is set.
+	errorReporter *errorReporter
 }
 
 type thumbnail struct {
@@ -38,106 +133,407 @@ var videoThumbnails = map[uuid.UUID]thumbnail{}
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
 	}
-
-	db, err := database.NewClient(pathToDB)
-	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		runRoleCommand(os.Args[2:])
+		return
 	}
-
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		runReprocessCommand(os.Args[2:])
+		return
 	}
-
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	if len(os.Args) > 1 && os.Args[1] == "shard-assets" {
+		runShardAssetsCommand(os.Args[2:])
+		return
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	conf, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("cannot set up tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	// logs go through slog, as text by default or as JSON for log shippers
+	if conf.LogFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	db, err := database.NewClient(conf.DBPath, conf.DBPool)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+	if err := migrateOnStart(db, conf.DBMigrateOnStart); err != nil {
+		log.Fatal(err)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	mediaSlots = newMediaLimiter(conf.MediaConcurrency, conf.MediaQueueWait)
+	ffmpegBin = conf.FFmpegPath
+	ffprobeBin = conf.FFprobePath
+	scratchRoot = conf.ScratchRoot
+	videoEnc = selectVideoEncoder(context.Background(), conf.HWAccel, conf.VAAPIDevice)
+	var mark *watermark
+	if conf.WatermarkPath != "" {
+		mark, err = loadWatermark(conf.WatermarkPath, conf.WatermarkPosition)
+		if err != nil {
+			log.Fatalf("Couldn't load watermark: %v", err)
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	assetsBaseURL := conf.PublicBaseURL + "/assets"
+	if conf.AssetsCDNURL != "" {
+		assetsBaseURL = conf.AssetsCDNURL
 	}
-	awsConf, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
-	if err != nil {
-		log.Fatal("cannot create aws cofnig %w", err)
+	var (
+		s3Client     *s3.Client
+		s3Encryption storage.Encryption
+		s3Storage    *storage.S3
+		replica      *storage.S3
+		videoStorage storage.Storage
+		spillover    *storage.Fallback
+	)
+	switch conf.StorageProvider {
+	case "s3", "minio", "gcs":
+		awsConf, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(conf.S3Region))
+		if err != nil {
+			log.Fatal("cannot create aws cofnig %w", err)
+		}
+		otelaws.AppendMiddlewares(&awsConf.APIOptions)
+		switch conf.S3SSE {
+		case "s3":
+			s3Encryption.Algorithm = types.ServerSideEncryptionAes256
+		case "kms":
+			s3Encryption = storage.Encryption{Algorithm: types.ServerSideEncryptionAwsKms, KMSKeyID: conf.S3SSEKMSKeyID}
+		}
+		s3Client = storage.NewS3Client(awsConf, conf.S3Endpoint, storage.RetryOptions{
+			MaxAttempts: conf.S3MaxAttempts,
+			MaxBackoff:  conf.S3MaxBackoff,
+			OnRetry:     storageRetries.Inc,
+		})
+		s3Storage = storage.NewS3(s3Client, conf.S3Bucket, storage.S3Options{
+			PartSize:    int64(conf.UploadPartSizeMB) << 20,
+			Parallelism: conf.UploadParallelism,
+			// the GCS XML API doesn't take x-amz-checksum headers
+			Checksums:  conf.StorageProvider != "gcs",
+			Encryption: s3Encryption,
+		})
+		videoStorage = s3Storage
+		if conf.S3ReplicaBucket != "" {
+			replicaConf := awsConf.Copy()
+			replicaConf.Region = conf.S3ReplicaRegion
+			replicaClient := storage.NewS3Client(replicaConf, conf.S3Endpoint, storage.RetryOptions{
+				MaxAttempts: conf.S3MaxAttempts,
+				MaxBackoff:  conf.S3MaxBackoff,
+				OnRetry:     storageRetries.Inc,
+			})
+			replica = storage.NewS3(replicaClient, conf.S3ReplicaBucket, storage.S3Options{
+				PartSize:    int64(conf.UploadPartSizeMB) << 20,
+				Parallelism: conf.UploadParallelism,
+				Encryption:  s3Encryption,
+			})
+		}
+		if conf.S3BreakerThreshold > 0 {
+			breaker := storage.NewCircuitBreaker(videoStorage, conf.S3BreakerThreshold, conf.S3BreakerCooldown)
+			breaker.OnStateChange = onStorageCircuitChange
+			videoStorage = breaker
+		}
+		if conf.StorageSpillover {
+			spill := storage.NewLocal(filepath.Join(conf.AssetsRoot, spilloverDir), assetsBaseURL+"/"+spilloverDir)
+			spillover = storage.NewFallback(videoStorage, spill)
+			spillover.OnSpill = onSpill
+			videoStorage = spillover
+		}
+	case "local":
+		videoStorage = storage.NewLocal(filepath.Join(conf.AssetsRoot, localObjectsDir), assetsBaseURL+"/"+localObjectsDir)
+	}
+	// the cache sits in front so the metrics only count real signing
+	videoStorage = instrumentedStorage{videoStorage}
+	if conf.PresignCache {
+		videoStorage = storage.NewPresignCache(videoStorage)
 	}
-	s3Client := s3.NewFromConfig(awsConf)
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                    db,
+		jwtSecret:             conf.JWTSecret,
+		accessTokenTTL:        conf.AccessTokenTTL,
+		refreshTokenTTL:       conf.RefreshTokenTTL,
+		platform:              conf.Platform,
+		filepathRoot:          conf.FilepathRoot,
+		assetsRoot:            conf.AssetsRoot,
+		assetsMaxAge:          conf.AssetsMaxAge,
+		assetsPrivate:         conf.AssetsPrivateThumbnails,
+		assetsBaseURL:         assetsBaseURL,
+		publicBaseURL:         conf.PublicBaseURL,
+		s3Bucket:              conf.S3Bucket,
+		storageProvider:       conf.StorageProvider,
+		s3Region:              conf.S3Region,
+		s3CfDistribution:      conf.S3CfDistribution,
+		port:                  conf.Port,
+		s3Client:              s3Client,
+		s3Encryption:          s3Encryption,
+		replica:               replica,
+		replicaRegion:         conf.S3ReplicaRegion,
+		replicaCountries:      map[string]bool{},
+		geoCountryHeader:      conf.GeoCountryHeader,
+		archiveClass:          types.StorageClass(conf.ArchiveStorageClass),
+		archiveRestoreTier:    types.Tier(conf.ArchiveRestoreTier),
+		storage:               videoStorage,
+		spillover:             spillover,
+		audioNormalize:        conf.AudioNormalize,
+		transcriber:           newTranscriber(conf),
+		virusScanner:          newVirusScanner(conf),
+		classifier:            newContentClassifier(conf),
+		classifierFrames:      conf.ClassifierFrames,
+		classifierFlagAt:      conf.ClassifierFlagThreshold,
+		classifierTakedownAt:  conf.ClassifierTakedownThreshold,
+		transcriptionLanguage: conf.TranscriptionLanguage,
+		watermark:             mark,
+		errorReporter:         newErrorReporter(conf.ErrorReportURL, conf.ErrorReportAPIKey),
+		audioTargetLUFS:       conf.AudioTargetLUFS,
+		audioRendition:        conf.AudioRendition,
+		videoFormField:        conf.VideoFormField,
+		thumbnailFormField:    conf.ThumbnailFormField,
+		presignHeadCheck:      conf.PresignHeadCheck,
+		adminAPIKey:           conf.AdminAPIKey,
+		previewSeconds:        conf.PreviewSeconds,
+		uploadStreaming:       conf.UploadStreaming,
+		jobs:                  jobs.NewPool(db, conf.JobWorkers, conf.JobMaxAttempts),
+		hlsEnabled:            conf.HLSEnabled,
+		transcodeLadder:       conf.TranscodeLadder,
+		storyboardInterval:    conf.StoryboardInterval,
+		hoverPreview:          conf.HoverPreview,
+		hoverPreviewLength:    conf.HoverPreviewLength,
+		orphanMinAge:          conf.OrphanMinAge,
+		orphanGCDelete:        conf.OrphanGCDelete,
+		trashRetention:        conf.TrashRetention,
+		expiryAction:          conf.ExpiryAction,
+		userQuota:             int64(conf.UserQuotaMB) << 20,
+		maxVideoSize:          int64(conf.MaxVideoSizeMB) << 20,
+		maxThumbnailSize:      int64(conf.MaxThumbnailSizeMB) << 20,
+		maxVideoDuration:      conf.MaxVideoDuration,
+		maxVideoResolution:    imageSize(conf.MaxVideoResolution),
+		thumbnailMaxSize:      imageSize(conf.ThumbnailMaxSize),
+		thumbnailMinSize:      imageSize(conf.ThumbnailMinSize),
+		thumbnailQuality:      conf.ThumbnailQuality,
+		dedupScope:            dedupScope(conf.DedupScope),
+		videoURLExpiry:        conf.VideoURLExpiry,
+		hlsURLExpiry:          conf.HLSURLExpiry,
+		previewURLExpiry:      conf.PreviewURLExpiry,
+		publicURLExpiry:       conf.PublicURLExpiry,
+		publicCDN:             conf.PublicCDN,
+		exportExpiry:          conf.ExportExpiry,
+		mediaTimeout:          conf.MediaTimeout,
+		scratchMinFree:        int64(conf.ScratchMinFreeMB) << 20,
+		uploads:               &uploadTracker{},
+		cancels:               newCancelRegistry(),
+		webhookClient:         newWebhookClient(conf.WebhookTimeout, conf.WebhookAllowPrivate),
+		importClient:          newImportClient(conf.ImportAllowPrivate),
+		importMaxSize:         int64(conf.ImportMaxSizeMB) << 20,
+		importTimeout:         conf.ImportTimeout,
+		progress:              newProgressHub(),
+		idempotencyKeyTTL:     conf.IdempotencyKeyTTL,
+	}
+	for _, country := range conf.ReplicaCountries {
+		cfg.replicaCountries[strings.ToUpper(country)] = true
 	}
 
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
+	// nothing runs yet, whatever is in the workspaces was left by a crash
+	removeWorkspaces()
+
+	cfg.jobs.Register(jobKindProcessVideo, timeVideoJob(cfg.processVideoJob), cfg.failVideoJob)
+	cfg.jobs.Register(jobKindImportVideo, cfg.importVideoJob, cfg.failVideoJob)
+	cfg.jobs.Register(jobKindClipVideo, cfg.clipVideoJob, cfg.failVideoJob)
+	cfg.jobs.Register(jobKindDeliverWebhook, cfg.deliverWebhookJob, cfg.failWebhookJob)
+	cfg.jobs.Register(jobKindReplicateVideo, cfg.replicateVideoJob, nil)
+	cfg.jobs.Register(jobKindTranscribeVideo, cfg.transcribeVideoJob, nil)
+	cfg.jobs.Register(jobKindClassifyVideo, cfg.classifyVideoJob, nil)
+	cfg.jobs.Register(jobKindReprocessVideo, cfg.reprocessVideoJob, nil)
+	cfg.jobs.Register(jobKindDeleteUser, cfg.deleteUserJob, cfg.failDeleteUserJob)
+	cfg.jobs.Register(jobKindExportUser, cfg.exportUserJob, cfg.failExportUserJob)
+	err = cfg.jobs.Start(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't start job workers: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if conf.OrphanGCInterval > 0 {
+		go cfg.runOrphanCollector(ctx, conf.OrphanGCInterval)
+	}
+	if spillover != nil {
+		go cfg.runSpilloverReconciler(ctx, conf.SpilloverReconcileInterval)
+	}
+	if conf.TrashRetention > 0 {
+		go cfg.runTrashPurger(ctx, conf.TrashPurgeInterval)
+	}
+	go cfg.runVideoScheduler(ctx, conf.SchedulerInterval)
+	if conf.StorageProvider == "s3" {
+		cfg.archiveStorage = s3Storage
+		go cfg.runArchiveRestorer(ctx, conf.ArchiveRestorePollInterval)
+	}
 
 	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.HandleFunc("GET /assets/", cfg.handlerAssets)
+
+	api := openapi.NewRouter(mux, openapi.Info{
+		Title:   "Tubely API",
+		Version: "1.0.0",
+	}, apiSecuritySchemes, cfg.apiAnnotations(), respondInvalidRequest)
+	mux.Handle("GET /api/openapi.json", api)
+	mux.HandleFunc("GET /api/docs", handlerAPIDocs)
+
+	api.HandleFunc("POST /api/login", cfg.handlerLogin)
+	api.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	api.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	api.HandleFunc("GET /api/sessions", cfg.handlerSessionsList)
+	api.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerSessionRevoke)
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	api.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	api.HandleFunc("DELETE /api/users/me", cfg.handlerUserDelete)
+	api.HandleFunc("GET /api/users/me/usage", cfg.handlerUsageGet)
+	api.HandleFunc("GET /api/users/me/watermark", cfg.handlerWatermarkGet)
+	api.HandleFunc("PUT /api/users/me/watermark", cfg.handlerWatermarkPut)
+	api.HandleFunc("DELETE /api/users/me/watermark", cfg.handlerWatermarkDelete)
+	api.HandleFunc("POST /api/users/me/export", cfg.handlerUserExportCreate)
+	api.HandleFunc("GET /api/users/me/exports/{exportID}", cfg.handlerUserExportGet)
+	api.HandleFunc("GET /api/users/{userID}/podcast.xml", cfg.handlerUserPodcast)
+	api.HandleFunc("GET /api/user_deletions/{deletionID}", cfg.handlerUserDeletionGet)
+	api.HandleFunc("GET /api/tags", cfg.handlerTagsList)
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	api.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
+	api.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.acceptingUploads(cfg.idempotent(withWorkspace(cfg.handlerUploadThumbnail))))
+	api.HandleFunc("POST /api/video_upload/{videoID}", cfg.acceptingUploads(cfg.idempotent(withWorkspace(cfg.handlerUploadVideo))))
+	api.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	api.HandleFunc("GET /api/videos/compare", cfg.handlerVideosCompare)
+	api.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
+	api.HandleFunc("GET /api/videos/trash", cfg.handlerVideosTrash)
+	api.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	api.HandleFunc("GET /api/videos/{videoID}/status", cfg.handlerVideoStatus)
+	api.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
+	api.HandleFunc("POST /api/videos/{videoID}/thumbnails_vtt", withWorkspace(cfg.handlerVideoThumbnailVTT))
+	api.HandleFunc("GET /api/videos/{videoID}/storyboard", cfg.handlerVideoStoryboard)
+	api.HandleFunc("POST /api/videos/{videoID}/faststart", withWorkspace(cfg.handlerVideoFastStart))
+	api.HandleFunc("GET /api/videos/{videoID}/preview", withWorkspace(cfg.handlerVideoPreview))
+	api.HandleFunc("POST /api/videos/{videoID}/thumbnail/regenerate", withWorkspace(cfg.handlerThumbnailRegenerate))
+	api.HandleFunc("GET /api/videos/{videoID}/manifest.m3u8", cfg.handlerVideoManifest)
+	api.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.handlerVideoCaptionPut)
+	api.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerVideoCaptionDelete)
+	api.HandleFunc("GET /api/videos/{videoID}/captions/{language}/playlist.m3u8", cfg.handlerVideoCaptionPlaylist)
+	api.HandleFunc("GET /api/videos/{videoID}/transcript", cfg.handlerVideoTranscript)
+	api.HandleFunc("POST /api/videos/{videoID}/upload_url", cfg.acceptingUploads(cfg.handlerVideoUploadURL))
+	api.HandleFunc("POST /api/videos/{videoID}/finalize", cfg.handlerVideoFinalize)
+	api.HandleFunc("POST /api/videos/{videoID}/import", cfg.acceptingUploads(cfg.handlerVideoImport))
+	api.HandleFunc("POST /api/videos/{videoID}/clips", cfg.acceptingUploads(cfg.handlerVideoClipCreate))
+	api.HandleFunc("GET /api/videos/{videoID}/clips", cfg.handlerVideoClips)
+	api.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	api.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	api.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
+	api.HandleFunc("POST /api/videos/{videoID}/archive", cfg.handlerVideoArchive)
+	api.HandleFunc("POST /api/videos/{videoID}/archive/restore", cfg.handlerVideoArchiveRestore)
+	api.HandleFunc("POST /api/videos/{videoID}/share", cfg.handlerVideoShareCreate)
+	api.HandleFunc("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	api.HandleFunc("GET /api/videos/{videoID}/shares", cfg.handlerVideoSharesList)
+	api.HandleFunc("DELETE /api/videos/{videoID}/shares/{shareID}", cfg.handlerVideoShareRevoke)
+	api.HandleFunc("POST /api/videos/{videoID}/report", cfg.handlerVideoReport)
+	api.HandleFunc("POST /api/videos/{videoID}/playback", cfg.handlerVideoPlayback)
+	api.HandleFunc("GET /api/videos/{videoID}/analytics", cfg.handlerVideoAnalytics)
+	api.HandleFunc("GET /api/shares/{token}", cfg.handlerShareGet)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	api.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	api.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsList)
+	api.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	api.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	api.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	api.HandleFunc("GET /api/playlists/{playlistID}/podcast.xml", cfg.handlerPlaylistPodcast)
+	api.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideoAdd)
+	api.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistVideoRemove)
+	api.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.handlerPlaylistReorder)
+
+	api.HandleFunc("POST /api/api_keys", cfg.handlerAPIKeyCreate)
+	api.HandleFunc("GET /api/api_keys", cfg.handlerAPIKeysList)
+	api.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.handlerAPIKeyRevoke)
+
+	api.HandleFunc("POST /api/webhooks", cfg.handlerWebhookCreate)
+	api.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksList)
+	api.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	api.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.handlerWebhookDeliveries)
+
+	api.HandleFunc("OPTIONS /api/uploads", cfg.handlerTusOptions)
+	api.HandleFunc("POST /api/uploads", cfg.acceptingUploads(cfg.handlerUploadCreate))
+	api.HandleFunc("HEAD /api/uploads/{uploadID}", cfg.handlerTusHead)
+	api.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.handlerTusPatch)
+	api.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerUploadCancel)
+	api.HandleFunc("PUT /api/uploads/{uploadID}/parts/{partNumber}", cfg.handlerUploadPartPut)
+	api.HandleFunc("POST /api/uploads/{uploadID}/complete", cfg.handlerUploadComplete)
+
+	api.HandleFunc("GET /api/admin/recent", cfg.handlerAdminRecentVideos)
+	api.HandleFunc("GET /api/admin/stats", cfg.handlerAdminStats)
+	api.HandleFunc("GET /api/admin/orphans", cfg.handlerAdminOrphans)
+	api.HandleFunc("POST /api/admin/thumbnails/migrate", cfg.handlerAdminThumbnailMigration)
+	api.HandleFunc("GET /api/admin/users", cfg.handlerAdminUsersList)
+	api.HandleFunc("PUT /api/admin/users/{userID}/role", cfg.handlerAdminUserRole)
+	api.HandleFunc("GET /api/admin/users/{userID}/limits", cfg.handlerAdminUserLimitsGet)
+	api.HandleFunc("PUT /api/admin/users/{userID}/limits", cfg.handlerAdminUserLimitsPut)
+	api.HandleFunc("GET /api/admin/quarantine", cfg.handlerAdminQuarantineList)
+	api.HandleFunc("DELETE /api/admin/quarantine/{uploadID}", cfg.handlerAdminQuarantineDelete)
+	api.HandleFunc("GET /api/admin/plans", cfg.handlerAdminPlansList)
+	api.HandleFunc("PUT /api/admin/plans/{name}", cfg.handlerAdminPlanPut)
+	api.HandleFunc("DELETE /api/admin/plans/{name}", cfg.handlerAdminPlanDelete)
+	api.HandleFunc("DELETE /api/admin/users/{userID}", cfg.handlerAdminUserDelete)
+	api.HandleFunc("GET /api/admin/users/{userID}/videos", cfg.handlerAdminUserVideos)
+	api.HandleFunc("DELETE /api/admin/videos/{videoID}", cfg.handlerAdminVideoDelete)
+	api.HandleFunc("POST /api/admin/videos/{videoID}/reprocess", cfg.handlerAdminVideoReprocess)
+	api.HandleFunc("POST /api/admin/videos/reprocess", cfg.handlerAdminVideosReprocess)
+	api.HandleFunc("POST /api/admin/videos/{videoID}/takedown", cfg.handlerAdminVideoTakedown)
+	api.HandleFunc("POST /api/admin/videos/{videoID}/restore", cfg.handlerAdminVideoRestore)
+	api.HandleFunc("GET /api/admin/reports", cfg.handlerAdminReportsList)
+	api.HandleFunc("POST /api/admin/reports/{reportID}/dismiss", cfg.handlerAdminReportDismiss)
+	if patterns := api.Unregistered(); len(patterns) > 0 {
+		log.Fatalf("API annotations without a route: %v", patterns)
+	}
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
+	prometheus.MustRegister(statsCollector{db: cfg.db})
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+
+	// the JWT or API key is resolved once, before the request is logged
+	authenticated := auth.Middleware(cfg.jwtSecret, cfg.resolveAPIKey, cfg.checkSession)
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:    ":" + conf.Port,
+		Handler: tracingMiddleware(authenticated(cfg.requestLogMiddleware(cfg.recoverMiddleware(cfg.regionMiddleware(metricsMiddleware(routeSpanName(mux))))))),
 	}
+	// event streams never finish on their own
+	srv.RegisterOnShutdown(cfg.progress.close)
+
+	go func() {
+		slog.Info("serving", "url", conf.PublicBaseURL+"/app/")
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down, draining requests and jobs", "timeout", conf.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	cfg.drain(drainCtx, srv)
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestConfig returns a server on a migrated SQLite database and local
+// storage in a temp directory. Jobs are persisted but never run.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	dsn := filepath.Join(dir, "tubely.db")
+	db, err := database.NewClient(dsn, database.DefaultPoolConfig(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	assetsRoot := filepath.Join(dir, "assets")
+	assetsBaseURL := "http://localhost:8091/assets"
+	cfg := &apiConfig{
+		db:                 db,
+		jwtSecret:          testJWTSecret,
+		accessTokenTTL:     time.Hour,
+		platform:           "dev",
+		assetsRoot:         assetsRoot,
+		assetsBaseURL:      assetsBaseURL,
+		publicBaseURL:      "http://localhost:8091",
+		storageProvider:    "local",
+		storage:            storage.NewLocal(filepath.Join(assetsRoot, localObjectsDir), assetsBaseURL+"/"+localObjectsDir),
+		replicaCountries:   map[string]bool{},
+		videoFormField:     "video",
+		thumbnailFormField: "thumbnail",
+		jobs:               jobs.NewPool(db, 0, 0),
+		maxVideoSize:       1 << 30,
+		maxThumbnailSize:   10 << 20,
+		thumbnailMaxSize:   imageSize{Width: 1280, Height: 720},
+		thumbnailQuality:   85,
+		dedupScope:         dedupUser,
+		videoURLExpiry:     time.Hour,
+		hlsURLExpiry:       time.Hour,
+		previewURLExpiry:   time.Hour,
+		publicURLExpiry:    time.Hour,
+		mediaTimeout:       time.Minute,
+		uploads:            &uploadTracker{},
+		cancels:            newCancelRegistry(),
+		progress:           newProgressHub(),
+	}
+	if err := cfg.ensureAssetsDir(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func createTestUser(t *testing.T, cfg *apiConfig, email string) uuid.UUID {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// setTestCreatedAt backdates a video in the database of newTestConfig,
+// which has no API to.
+func setTestCreatedAt(t *testing.T, cfg *apiConfig, videoID uuid.UUID, at time.Time) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(filepath.Dir(cfg.assetsRoot), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE videos SET created_at = ? WHERE id = ?", at.UTC().Format("2006-01-02 15:04:05"), videoID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID, title string) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: title, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+// putTestObject stores body under key and returns where.
+func putTestObject(t *testing.T, cfg *apiConfig, key, body, contentType string) *database.ObjectLocation {
+	t.Helper()
+	if _, err := cfg.storage.Put(context.Background(), key, strings.NewReader(body), contentType); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.objectLocation(context.Background(), key)
+}
+
+// multipartUpload returns a request with a single file part.
+func multipartUpload(t *testing.T, path, field, contentType string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="`+field+`"; filename="upload"`)
+	h.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+	r := httptest.NewRequest("POST", path, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// errorLocation decodes an error response and returns its code and the
+// location of the input it is about.
+func errorLocation(t *testing.T, w *httptest.ResponseRecorder) (apierror.Code, string) {
+	t.Helper()
+	var body struct {
+		Code    apierror.Code     `json:"code"`
+		Details map[string]string `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Code, body.Details["location"]
+}
+
+func objectExists(t *testing.T, cfg *apiConfig, key string) bool {
+	t.Helper()
+	objects, err := cfg.storage.List(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if object.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// serveAs serves r with handler registered at pattern, so path values are
+// set, authenticated as userID with a JWT unless it is uuid.Nil.
+func serveAs(t *testing.T, cfg *apiConfig, userID uuid.UUID, pattern string, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	if userID != uuid.Nil {
+		token, err := auth.MakeJWT(userID, uuid.New(), cfg.jwtSecret, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, handler)
+	// sessions aren't stored, every token counts as active
+	noSessions := func(context.Context, uuid.UUID, uuid.UUID) error { return nil }
+	w := httptest.NewRecorder()
+	auth.Middleware(cfg.jwtSecret, cfg.resolveAPIKey, noSessions)(mux).ServeHTTP(w, r)
+	return w
+}
+
+// requireFFmpeg skips tests that run ffmpeg and ffprobe where they aren't
+// installed.
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, bin := range []string{ffmpegBin, ffprobeBin} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not installed", bin)
+		}
+	}
+}
+
+// writeTestVideo renders a test pattern video of the given length with
+// ffmpeg and returns its path.
+func writeTestVideo(t *testing.T, seconds float64, args ...string) string {
+	t.Helper()
+	requireFFmpeg(t)
+	out := filepath.Join(t.TempDir(), "test.mp4")
+	cmdArgs := []string{"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=320x180:rate=25:duration=%g", seconds),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p"}
+	cmdArgs = append(append(cmdArgs, args...), out)
+	if output, err := exec.Command(ffmpegBin, cmdArgs...).CombinedOutput(); err != nil {
+		t.Fatalf("cannot render test video: %v\n%s", err, output)
+	}
+	return out
+}
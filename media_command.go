@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errMediaBusy = errors.New("too many media jobs running")
+
+// mediaWaitDelay is how long a killed media command gets to close its
+// output.
+const mediaWaitDelay = 5 * time.Second
+
+// errMediaCommandFailed wraps the exit of an ffmpeg or ffprobe command that
+// ran and failed, most likely on its input.
+var errMediaCommandFailed = errors.New("media command failed")
+
+// ffmpegBin and ffprobeBin are set up by main from FFMPEG_PATH and
+// FFPROBE_PATH.
+var (
+	ffmpegBin  = "ffmpeg"
+	ffprobeBin = "ffprobe"
+)
+
+// mediaLimiter caps how many ffmpeg and ffprobe processes run at once.
+// Callers wait up to queueWait for a free slot, background jobs as long as
+// their context allows.
+type mediaLimiter struct {
+	slots     chan struct{}
+	queueWait time.Duration
+}
+
+func newMediaLimiter(concurrency int, queueWait time.Duration) *mediaLimiter {
+	return &mediaLimiter{
+		slots:     make(chan struct{}, concurrency),
+		queueWait: queueWait,
+	}
+}
+
+// mediaSlots is set up by main from MEDIA_CONCURRENCY and
+// MEDIA_QUEUE_WAIT.
+var mediaSlots = newMediaLimiter(4, 30*time.Second)
+
+type mediaQueueKey struct{}
+
+// withUnboundedMediaQueue marks ctx as belonging to a background job, which
+// waits for a free slot instead of giving up after queueWait.
+func withUnboundedMediaQueue(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mediaQueueKey{}, true)
+}
+
+func (l *mediaLimiter) acquire(ctx context.Context) error {
+	var timeout <-chan time.Time
+	if unbounded, _ := ctx.Value(mediaQueueKey{}).(bool); !unbounded {
+		timer := time.NewTimer(l.queueWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return errMediaBusy
+	}
+}
+
+func (l *mediaLimiter) release() {
+	<-l.slots
+}
+
+// redactMediaArgs strips the query and user info from URL arguments, like
+// the presigned URLs ffmpeg reads from, so traces don't carry credentials.
+func redactMediaArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		u, err := url.Parse(arg)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			continue
+		}
+		if u.RawQuery != "" || u.User != nil {
+			u.RawQuery = ""
+			u.User = nil
+			redacted[i] = u.String()
+		}
+	}
+	return redacted
+}
+
+// runMediaCommand runs an ffmpeg or ffprobe command created with
+// exec.CommandContext once a media slot is free. When the command was killed
+// because ctx ended, the context error is returned instead of the kill
+// signal so callers can tell a timeout from a bad input.
+func runMediaCommand(ctx context.Context, cmd *exec.Cmd) (err error) {
+	name := filepath.Base(cmd.Args[0])
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.StringSlice("media.args", redactMediaArgs(cmd.Args[1:])),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if err := mediaSlots.acquire(ctx); err != nil {
+		return fmt.Errorf("cannot run %s: %w", cmd.Args[0], err)
+	}
+	defer mediaSlots.release()
+	span.AddEvent("media slot acquired")
+
+	// a killed command returns even if something it started keeps its
+	// output open
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = mediaWaitDelay
+	}
+	start := time.Now()
+	err = cmd.Run()
+	mediaCommandDuration.WithLabelValues(name, resultLabel(err)).
+		Observe(time.Since(start).Seconds())
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s stopped: %w", cmd.Args[0], ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: %s: %w", errMediaCommandFailed, name, err)
+	}
+	return err
+}
+
+// withMediaTimeout bounds a whole processing job or request's worth of
+// ffmpeg work by cfg.mediaTimeout.
+func (cfg *apiConfig) withMediaTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cfg.mediaTimeout)
+}
+
+// cleanupTimeout bounds the storage work finishing after a response, like
+// deleting or archiving the objects of a video.
+const cleanupTimeout = 15 * time.Minute
+
+// detach returns a context for work that has to finish even if the client
+// hangs up or the job is interrupted. It keeps the values of ctx, like its
+// logger and trace, and ends after timeout.
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// respondMediaError maps media processing failures to a response, a
+// timeout or a full queue being the server's fault rather than the file's.
+func respondMediaError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, errMediaBusy) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(mediaSlots.queueWait.Seconds()))))
+		respondWithError(w, http.StatusServiceUnavailable, "Server is busy processing other media, try again later", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondWithError(w, http.StatusGatewayTimeout, "Media processing timed out", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tubely_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and status code.",
+		Buckets: []float64{.005, .025, .1, .5, 1, 5, 30, 120, 600},
+	}, []string{"method", "route", "code"})
+
+	uploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_uploads_total",
+		Help: "Completed uploads by kind.",
+	}, []string{"kind"})
+	uploadBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_upload_bytes_total",
+		Help: "Bytes received in completed uploads by kind.",
+	}, []string{"kind"})
+
+	mediaCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_media_command_duration_seconds",
+		Help:    "Run time of ffmpeg and ffprobe invocations.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 3, 10),
+	}, []string{"command", "result"})
+	storageRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_storage_retries_total",
+		Help: "S3 calls retried after a failed attempt.",
+	})
+	storageCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tubely_storage_circuit_open",
+		Help: "1 while the storage circuit breaker is open and calls fail fast.",
+	})
+	storageSpills = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_storage_spills_total",
+		Help: "Objects stored on local disk because the storage was failing.",
+	})
+	hardwareEncodeFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_hardware_encode_fallbacks_total",
+		Help: "Encodes retried in software after the hardware encoder failed.",
+	}, []string{"encoder"})
+	videoJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_video_job_duration_seconds",
+		Help:    "Run time of video processing jobs.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"result"})
+
+	storageOpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_storage_operation_duration_seconds",
+		Help:    "Latency of object storage operations.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 3, 10),
+	}, []string{"operation"})
+	storageOpErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_storage_operation_errors_total",
+		Help: "Failed object storage operations.",
+	}, []string{"operation"})
+	presignsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tubely_presigned_urls_total",
+		Help: "URLs signed by the object storage, not counting cache hits.",
+	})
+)
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricsMiddleware records the latency of every request by the mux
+// pattern that served it, so path parameters don't blow up the label set.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+func recordUpload(kind string, size int64) {
+	uploadsTotal.WithLabelValues(kind).Inc()
+	uploadBytesTotal.WithLabelValues(kind).Add(float64(size))
+}
+
+// timeVideoJob records how long each processing job takes.
+func timeVideoJob(run jobs.RunFunc) jobs.RunFunc {
+	return func(ctx context.Context, job database.Job) error {
+		start := time.Now()
+		err := run(ctx, job)
+		videoJobDuration.WithLabelValues(resultLabel(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// instrumentedStorage times and traces every call to the wrapped storage.
+type instrumentedStorage struct {
+	storage.Storage
+}
+
+// startStorageOp starts the span of a storage operation. The returned
+// function ends it and records the latency.
+func startStorageOp(ctx context.Context, operation, key string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "storage."+operation, trace.WithAttributes(
+		attribute.String("storage.key", key),
+	))
+	return ctx, func(err error) {
+		storageOpDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			storageOpErrors.WithLabelValues(operation).Inc()
+		}
+		endSpan(span, err)
+	}
+}
+
+func (s instrumentedStorage) Put(ctx context.Context, key string, body io.Reader, contentType string) (storage.ObjectInfo, error) {
+	ctx, done := startStorageOp(ctx, "put", key)
+	info, err := s.Storage.Put(ctx, key, body, contentType)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("storage.size", info.Size))
+	done(err)
+	return info, err
+}
+
+func (s instrumentedStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, done := startStorageOp(ctx, "get", key)
+	body, err := s.Storage.Get(ctx, key)
+	done(err)
+	return body, err
+}
+
+func (s instrumentedStorage) Delete(ctx context.Context, key string) error {
+	ctx, done := startStorageOp(ctx, "delete", key)
+	err := s.Storage.Delete(ctx, key)
+	done(err)
+	return err
+}
+
+func (s instrumentedStorage) Head(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	ctx, done := startStorageOp(ctx, "head", key)
+	info, err := s.Storage.Head(ctx, key)
+	// a missing object is an answer, not a failure
+	if errors.Is(err, storage.ErrNotFound) {
+		done(nil)
+	} else {
+		done(err)
+	}
+	return info, err
+}
+
+func (s instrumentedStorage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	ctx, done := startStorageOp(ctx, "list", prefix)
+	objects, err := s.Storage.List(ctx, prefix)
+	done(err)
+	return objects, err
+}
+
+func (s instrumentedStorage) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	ctx, done := startStorageOp(ctx, "presign", key)
+	url, err := s.Storage.Presign(ctx, key, expires)
+	done(err)
+	if err == nil {
+		presignsTotal.Inc()
+	}
+	return url, err
+}
+
+func (s instrumentedStorage) Check(ctx context.Context) error {
+	ctx, done := startStorageOp(ctx, "check", "")
+	err := s.Storage.Check(ctx)
+	done(err)
+	return err
+}
+
+// onStorageCircuitChange reports the storage circuit breaker opening and
+// closing.
+func onStorageCircuitChange(open bool) {
+	if open {
+		storageCircuitOpen.Set(1)
+		slog.Warn("storage is failing, circuit breaker opened")
+		return
+	}
+	storageCircuitOpen.Set(0)
+	slog.Info("storage recovered, circuit breaker closed")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runMigrateCommand implements `tubely migrate [status]`, which applies the
+// pending schema migrations, or only lists them, and exits.
+func runMigrateCommand(args []string) {
+	conf, err := config.LoadDatabase(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.NewClient(conf.DBPath, conf.DBPool)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	ctx := context.Background()
+	switch {
+	case len(args) == 0:
+		applied, err := db.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case len(args) == 1 && args[0] == "status":
+		pending, err := db.PendingMigrations(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range pending {
+			fmt.Printf("pending %04d_%s\n", m.Version, m.Name)
+		}
+		if len(pending) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	default:
+		log.Fatalf("usage: %s migrate [status]", os.Args[0])
+	}
+}
+
+// migrateOnStart applies pending migrations when the server is allowed to,
+// and otherwise refuses to run against a schema older than the code.
+func migrateOnStart(db database.Client, enabled bool) error {
+	ctx := context.Background()
+	if !enabled {
+		pending, err := db.PendingMigrations(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot check migrations: %w", err)
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d schema migrations are pending, run `%s migrate` first", len(pending), os.Args[0])
+		}
+		return nil
+	}
+	applied, err := db.Migrate(ctx)
+	for _, m := range applied {
+		slog.Info("applied schema migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot migrate database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxReportDetailsLen = 1000
+
+var reportReasons = map[string]bool{
+	"spam":       true,
+	"harassment": true,
+	"violence":   true,
+	"sexual":     true,
+	"copyright":  true,
+	"other":      true,
+}
+
+// handlerVideoReport files a report about a video the user can see, for
+// the moderators to look at.
+func (cfg *apiConfig) handlerVideoReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	video, viewerID, ok := cfg.viewableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if viewerID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Log in to report a video", nil)
+		return
+	}
+	if video.UserID == viewerID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !reportReasons[params.Reason] {
+		respondWithError(w, http.StatusBadRequest, "reason must be spam, harassment, violence, sexual, copyright or other", nil)
+		return
+	}
+	params.Details = strings.TrimSpace(params.Details)
+	if len(params.Details) > maxReportDetailsLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("details must be at most %d bytes", maxReportDetailsLen), nil)
+		return
+	}
+
+	report, err := cfg.db.WithContext(r.Context()).CreateVideoReport(database.CreateVideoReportParams{
+		VideoID:    video.ID,
+		ReporterID: &viewerID,
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if errors.Is(err, database.ErrDuplicateReport) {
+		respondWithError(w, http.StatusConflict, "You already reported this video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't file report", err)
+		return
+	}
+	requestLogger(r.Context()).Info("video reported", "video_id", video.ID, "report_id", report.ID, "reason", report.Reason)
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+func encodeReportCursor(report database.VideoReport) string {
+	raw := fmt.Sprintf("%d,%s", report.CreatedAt.Unix(), report.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// handlerAdminReportsList is the moderation queue: open reports, oldest
+// first, or with ?status=resolved the ones already dealt with.
+func (cfg *apiConfig) handlerAdminReportsList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Reports    []database.VideoReport `json:"reports"`
+		NextCursor string                 `json:"next_cursor,omitempty"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+
+	params := database.ListVideoReportsParams{Limit: 20}
+	if v := query.Get("status"); v != "" {
+		if err := checkOneOf("query.status", v, "open", "resolved"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "status must be open or resolved", err)
+			return
+		}
+		params.Resolved = v == "resolved"
+	}
+	if query.Has("video_id") {
+		id, err := queryUUID(r, "video_id")
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+			return
+		}
+		params.VideoID = id
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+			return
+		}
+		params.Limit = n
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		params.AfterCreatedAt, params.AfterID, err = decodeRecentCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+	}
+
+	reports, err := cfg.db.WithContext(r.Context()).ListVideoReports(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reports", err)
+		return
+	}
+	resp := response{Reports: reports}
+	if len(reports) == params.Limit {
+		resp.NextCursor = encodeReportCursor(reports[len(reports)-1])
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// moderatorID returns the admin acting on a request, nil when the admin
+// API key was used.
+func moderatorID(r *http.Request) *uuid.UUID {
+	if _, err := auth.GetAPIKey(r.Header); err == nil {
+		return nil
+	}
+	p, err := auth.Authenticate(r)
+	if err != nil {
+		return nil
+	}
+	return &p.UserID
+}
+
+// handlerAdminReportDismiss closes a report without acting on the video.
+func (cfg *apiConfig) handlerAdminReportDismiss(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	reportID, err := pathUUID(r, "reportID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID", err)
+		return
+	}
+	dismissed, err := cfg.db.WithContext(r.Context()).DismissVideoReport(reportID, moderatorID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't dismiss report", err)
+		return
+	}
+	if !dismissed {
+		respondWithError(w, http.StatusNotFound, "No open report with this ID", nil)
+		return
+	}
+	requestLogger(r.Context()).Info("admin dismissed report", "report_id", reportID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminVideoTakedown hides a video from everyone but its owner and
+// stops handing out its URLs. Its open reports are resolved with it.
+// Already presigned URLs stay valid until they expire.
+func (cfg *apiConfig) handlerAdminVideoTakedown(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.adminVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).TakeDownVideo(video.ID, moderatorID(r)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't take down video", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin took down video", "video_id", video.ID, "owner_id", video.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminVideoRestore undoes a takedown.
+func (cfg *apiConfig) handlerAdminVideoRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.adminVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if video.ModerationStatus != database.ModerationStatusTakenDown {
+		respondWithError(w, http.StatusConflict, "Video isn't taken down", nil)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).RestoreVideo(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin restored video", "video_id", video.ID, "owner_id", video.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
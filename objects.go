@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+var (
+	errVideoNotUploaded    = errors.New("video has not been uploaded")
+	errVideoObjectNotFound = errors.New("video object not found in storage")
+	errVideoArchived       = errors.New("video is archived")
+)
+
+// sourceURLExpiry is how long the URLs ffmpeg and ffprobe read stored
+// videos through stay valid.
+const sourceURLExpiry = 5 * time.Minute
+
+// videoKey returns the storage key of the uploaded video.
+func videoKey(video database.Video) (string, error) {
+	if video.VideoObject == nil {
+		return "", errVideoNotUploaded
+	}
+	// archived objects can't be read until they are restored
+	if video.StorageTier != database.StorageTierHot {
+		return "", errVideoArchived
+	}
+	return video.VideoObject.Key, nil
+}
+
+// mediaKeys returns the keys of the objects a video is played from: the
+// video, its renditions, audio and HLS segments.
+func (cfg *apiConfig) mediaKeys(ctx context.Context, video database.Video) ([]string, error) {
+	var keys []string
+	if video.VideoObject != nil {
+		keys = append(keys, video.VideoObject.Key)
+	}
+	for _, rendition := range video.Renditions {
+		keys = append(keys, rendition.Key)
+	}
+	if video.Audio != nil {
+		keys = append(keys, video.Audio.Key)
+	}
+	segments, err := cfg.storage.List(ctx, hlsKeyPrefix(video.ID))
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range segments {
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+// objectLocation records where an object written to the video storage
+// under key lives.
+func (cfg *apiConfig) objectLocation(ctx context.Context, key string) *database.ObjectLocation {
+	if cfg.spillover != nil && cfg.spillover.Spilled(ctx, key) {
+		return &database.ObjectLocation{Provider: spilloverProvider, Key: key}
+	}
+	loc := &database.ObjectLocation{Provider: cfg.storageProvider, Key: key}
+	if cfg.storageProvider != "local" {
+		loc.Bucket = cfg.s3Bucket
+	}
+	return loc
+}
+
+// downloadVideoToTemp fetches the stored object of a video into a temp file
+// and returns its path. The caller is responsible for removing it.
+func (cfg *apiConfig) downloadVideoToTemp(ctx context.Context, video database.Video) (string, error) {
+	key, err := videoKey(video)
+	if err != nil {
+		return "", err
+	}
+	return cfg.downloadObjectToTemp(ctx, key)
+}
+
+// downloadObjectToTemp fetches an object into a temp file and returns its
+// path. The caller is responsible for removing it.
+func (cfg *apiConfig) downloadObjectToTemp(ctx context.Context, key string) (string, error) {
+	return cfg.downloadObjectToTempWith(ctx, key, io.Discard)
+}
+
+// downloadObjectToTempWith is downloadObjectToTemp also writing the object
+// to w as it comes in, e.g. to hash it.
+func (cfg *apiConfig) downloadObjectToTempWith(ctx context.Context, key string, w io.Writer) (string, error) {
+	body, err := cfg.storage.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("cannot get object from storage: %w", err)
+	}
+	defer body.Close()
+
+	tempFile, err := createTemp(ctx, "download-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	if _, err := io.Copy(io.MultiWriter(tempFile, w), body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("cannot download object: %w", err)
+	}
+	return tempFile.Name(), nil
+}
+
+// deleteVideoObjects removes everything stored for a video: the video and
+// its renditions, HLS segments, previews, leftover staging uploads, the
+// thumbnail and the storyboard. It keeps going past failures and returns
+// them joined, whatever is left behind is picked up by the orphan
+// collector.
+func (cfg *apiConfig) deleteVideoObjects(ctx context.Context, video database.Video) error {
+	var errs []error
+	deleteKey := func(key string) {
+		if err := cfg.storage.Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("cannot delete %s: %w", key, err))
+		}
+		// the replica has no copy of most keys, deleting them is a no-op
+		if cfg.replica != nil {
+			if err := cfg.replica.Delete(ctx, key); err != nil {
+				errs = append(errs, fmt.Errorf("cannot delete replica of %s: %w", key, err))
+			}
+		}
+	}
+
+	// deduplicated videos share the video object and its renditions, those
+	// go with the last video referencing them
+	shared := false
+	if video.VideoObject != nil {
+		refs, err := cfg.db.WithContext(ctx).CountVideosWithObject(*video.VideoObject)
+		if err != nil {
+			errs = append(errs, err)
+			shared = true
+		} else {
+			shared = refs > 0
+		}
+	}
+	if video.VideoObject != nil && !shared {
+		deleteKey(video.VideoObject.Key)
+	}
+	if !shared {
+		for _, rendition := range video.Renditions {
+			deleteKey(rendition.Key)
+		}
+		if video.Audio != nil {
+			deleteKey(video.Audio.Key)
+		}
+		if video.PreviewKey != nil {
+			deleteKey(*video.PreviewKey)
+		}
+	}
+
+	prefixes := []string{
+		hlsKeyPrefix(video.ID),
+		fmt.Sprintf("previews/%s-", video.ID),
+		stagingKeyPrefix(video.ID),
+		captionKeyPrefix(video.ID),
+	}
+	for _, prefix := range prefixes {
+		objects, err := cfg.storage.List(ctx, prefix)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot list %s: %w", prefix, err))
+			continue
+		}
+		for _, object := range objects {
+			deleteKey(object.Key)
+		}
+	}
+
+	if video.ThumbnailObject != nil {
+		deleteKey(video.ThumbnailObject.Key)
+	} else if video.ThumbnailURL != nil && *video.ThumbnailURL != "" {
+		if err := cfg.removeLocalAsset(*video.ThumbnailURL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := os.RemoveAll(cfg.storyboardDir(video.ID)); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// removeLocalAsset deletes a file served from the assets directory by its
+// URL. URLs pointing elsewhere are left alone.
+func (cfg *apiConfig) removeLocalAsset(assetURL string) error {
+	name, ok := assetName(assetURL)
+	if !ok {
+		return nil
+	}
+	err := os.Remove(filepath.Join(cfg.assetsRoot, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// putObjectFile uploads a local file to the video storage.
+func (cfg *apiConfig) putObjectFile(ctx context.Context, key, filePath, contentType string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = cfg.storage.Put(ctx, key, f, contentType)
+	return err
+}
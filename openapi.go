@@ -0,0 +1,935 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"maps"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+//go:embed openapi_docs.html
+var apiDocsPage []byte
+
+// handlerAPIDocs serves Swagger UI for /api/openapi.json.
+func handlerAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(apiDocsPage)
+}
+
+// respondInvalidRequest is the response to a request that doesn't match
+// the OpenAPI document.
+func respondInvalidRequest(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, openapi.ErrBodyTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Request body is too large", err)
+		return
+	}
+	var invalid *openapi.ValidationError
+	if errors.As(err, &invalid) {
+		respondWithError(w, http.StatusBadRequest, invalid.Error(), invalidInput(invalid.Location, invalid.Message, err))
+		return
+	}
+	respondWithError(w, http.StatusBadRequest, "Couldn't read request", err)
+}
+
+var apiSecuritySchemes = map[string]openapi.SecurityScheme{
+	"jwt": {
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "Access token from POST /api/login or POST /api/refresh.",
+	},
+	"apiKey": {
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "Authorization",
+		Description: `"ApiKey <key>" with a key from POST /api/api_keys, or the ADMIN_API_KEY for admin routes.`,
+	},
+}
+
+// jwtOnly is the security of routes API keys can't be used for.
+var jwtOnly = []map[string][]string{{"jwt": {}}}
+
+// scoped is the security of routes taking a JWT or an API key with scope.
+func scoped(scope auth.Scope) []map[string][]string {
+	return []map[string][]string{{"jwt": {}}, {"apiKey": {string(scope)}}}
+}
+
+// optionalAuth is the security of routes anonymous requests may use, for
+// public and unlisted videos.
+var optionalAuth = []map[string][]string{{}, {"jwt": {}}, {"apiKey": {string(auth.ScopeRead)}}}
+
+var adminAuth = []map[string][]string{{"jwt": {}}, {"apiKey": {}}}
+
+var (
+	errorSchema = openapi.Object(map[string]*openapi.Schema{
+		"code":       openapi.String().Describe("Stable identifier of the kind of error, like not_found or quota_exceeded"),
+		"message":    openapi.String(),
+		"request_id": openapi.String().Describe("Quote it when asking for support"),
+		"details":    openapi.Object(nil),
+	}, "code", "message")
+
+	videoSchema = openapi.Object(map[string]*openapi.Schema{
+		"id":                openapi.UUID(),
+		"created_at":        openapi.DateTime(),
+		"updated_at":        openapi.DateTime(),
+		"title":             openapi.String(),
+		"description":       openapi.String(),
+		"user_id":           openapi.UUID(),
+		"visibility":        visibilitySchema(),
+		"thumbnail_url":     openapi.String().OrNull(),
+		"video_url":         openapi.String().OrNull(),
+		"status":            openapi.Enum("pending", "uploading", "processing", "ready", "failed", "rejected").Describe("rejected when the virus scanner found the upload infected"),
+		"failure_reason":    openapi.String().Describe("Why the video failed or was rejected, like exceeding MAX_VIDEO_DURATION"),
+		"content_score":     openapi.Number().Min(0).Max(1).Describe("How likely the content classifier found the video to be explicit"),
+		"moderation_status": openapi.Enum("active", "taken_down"),
+		"storage_tier":      openapi.Enum("hot", "archived", "restoring"),
+		"view_count":        openapi.Integer(),
+		"version":           openapi.Integer(),
+		"deleted_at":        openapi.DateTime(),
+		"publish_at":        openapi.DateTime().Describe("Until then only the owner can view the video"),
+		"expires_at":        openapi.DateTime(),
+		"original_format":   openapi.String(),
+		"checksum":          openapi.String(),
+		"aspect_ratio":      openapi.Enum("16:9", "9:16", "other"),
+		"metadata": openapi.Object(map[string]*openapi.Schema{
+			"width":       openapi.Integer(),
+			"height":      openapi.Integer(),
+			"duration":    openapi.Number().Describe("Seconds"),
+			"frame_rate":  openapi.Number().Describe("Frames per second"),
+			"size":        openapi.Integer().Describe("Bytes of the stored video"),
+			"video_codec": openapi.String(),
+			"audio_codec": openapi.String(),
+			"bit_rate":    openapi.Integer().Describe("Bits per second"),
+			"loudness": openapi.Object(map[string]*openapi.Schema{
+				"integrated": openapi.Number().Describe("LUFS"),
+				"true_peak":  openapi.Number().Describe("dBTP"),
+				"range":      openapi.Number().Describe("LU"),
+			}).Describe("Measured before normalizing when the server has AUDIO_NORMALIZE set"),
+		}),
+		"tags": openapi.Array(openapi.String()),
+		"captions": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+			"language":   openapi.String(),
+			"label":      openapi.String(),
+			"source":     openapi.Enum("upload", "transcription"),
+			"url":        openapi.String().OrNull().Describe("WebVTT"),
+			"created_at": openapi.DateTime(),
+			"updated_at": openapi.DateTime(),
+		})),
+		"clip": openapi.Object(map[string]*openapi.Schema{
+			"source_video_id": openapi.UUID().OrNull().Describe("Null once the source is deleted"),
+			"start":           openapi.Number().Describe("Seconds into the source"),
+			"end":             openapi.Number().Describe("Seconds into the source"),
+		}),
+		"video_size":         openapi.Integer(),
+		"video_content_type": openapi.String(),
+		"renditions": openapi.Array(openapi.Object(map[string]*openapi.Schema{
+			"label":  openapi.String(),
+			"width":  openapi.Integer(),
+			"height": openapi.Integer(),
+			"url":    openapi.String().OrNull(),
+		})),
+		"preview_url": openapi.String().Describe("A short looping preview, made when the server has HOVER_PREVIEW set"),
+		"audio": openapi.Object(map[string]*openapi.Schema{
+			"content_type": openapi.String(),
+			"size":         openapi.Integer(),
+			"url":          openapi.String().OrNull(),
+		}).Describe("Extracted when the server has AUDIO_RENDITION set"),
+	})
+
+	userSchema = openapi.Object(map[string]*openapi.Schema{
+		"id":         openapi.UUID(),
+		"created_at": openapi.DateTime(),
+		"updated_at": openapi.DateTime(),
+		"email":      openapi.String(),
+		"role":       openapi.Enum("user", "admin"),
+	})
+
+	watermarkSchema = openapi.Object(map[string]*openapi.Schema{
+		"user_id":    openapi.UUID(),
+		"position":   openapi.Enum("top-left", "top-right", "bottom-left", "bottom-right", "center"),
+		"url":        openapi.String().Describe("The image as a png"),
+		"created_at": openapi.DateTime(),
+		"updated_at": openapi.DateTime(),
+	})
+
+	userDeletionSchema = openapi.Object(map[string]*openapi.Schema{
+		"id":             openapi.UUID(),
+		"created_at":     openapi.DateTime(),
+		"updated_at":     openapi.DateTime(),
+		"user_id":        openapi.UUID(),
+		"requested_by":   openapi.UUID().OrNull().Describe("The admin who deleted the user"),
+		"status":         openapi.Enum("queued", "running", "done", "failed"),
+		"videos_total":   openapi.Integer(),
+		"videos_deleted": openapi.Integer(),
+		"completed_at":   openapi.DateTime().OrNull(),
+		"last_error":     openapi.String().OrNull(),
+	})
+
+	userExportSchema = openapi.Object(map[string]*openapi.Schema{
+		"id":           openapi.UUID(),
+		"created_at":   openapi.DateTime(),
+		"updated_at":   openapi.DateTime(),
+		"user_id":      openapi.UUID(),
+		"status":       openapi.Enum("queued", "done", "failed"),
+		"completed_at": openapi.DateTime().OrNull(),
+		"expires_at":   openapi.DateTime().OrNull(),
+		"last_error":   openapi.String().OrNull(),
+		"download_url": openapi.String().OrNull().Describe("JSON with the user's data and presigned URLs of their video files, until expires_at"),
+	})
+
+	loginSchema = openapi.Object(map[string]*openapi.Schema{
+		"id":            openapi.UUID(),
+		"created_at":    openapi.DateTime(),
+		"updated_at":    openapi.DateTime(),
+		"email":         openapi.String(),
+		"role":          openapi.Enum("user", "admin"),
+		"token":         openapi.String(),
+		"refresh_token": openapi.String(),
+	})
+
+	credentialsSchema = openapi.Object(map[string]*openapi.Schema{
+		"email":    openapi.String(),
+		"password": openapi.String(),
+	}, "email", "password")
+
+	tokensSchema = openapi.Object(map[string]*openapi.Schema{
+		"token":         openapi.String(),
+		"refresh_token": openapi.String(),
+	})
+
+	planSchema = openapi.Object(map[string]*openapi.Schema{
+		"name":                openapi.String(),
+		"created_at":          openapi.DateTime(),
+		"updated_at":          openapi.DateTime(),
+		"max_video_bytes":     openapi.Integer().OrNull(),
+		"max_thumbnail_bytes": openapi.Integer().OrNull(),
+	})
+
+	userLimitsSchema = openapi.Object(map[string]*openapi.Schema{
+		"plan":                openapi.String().OrNull(),
+		"max_video_bytes":     openapi.Integer().OrNull().Describe("Overrides the plan's"),
+		"max_thumbnail_bytes": openapi.Integer().OrNull().Describe("Overrides the plan's"),
+		"media_limits_exempt": openapi.Boolean().Describe("Lifts MAX_VIDEO_DURATION and MAX_VIDEO_RESOLUTION"),
+		"effective": openapi.Object(map[string]*openapi.Schema{
+			"max_video_bytes":     openapi.Integer(),
+			"max_thumbnail_bytes": openapi.Integer(),
+		}).Describe("The limits that apply, falling back to MAX_VIDEO_SIZE_MB and MAX_THUMBNAIL_SIZE_MB"),
+	})
+
+	limitParam = openapi.Query("limit", openapi.Integer().Min(1).Max(maxListLimit), "Page size, 20 by default.")
+)
+
+func visibilitySchema() *openapi.Schema {
+	return openapi.Enum("public", "unlisted", "private")
+}
+
+func errorResponse(description string) openapi.Response {
+	return openapi.JSON(description, errorSchema)
+}
+
+// apiAnnotations describes the API routes by the pattern they are
+// registered with. Routes left out are documented from their pattern
+// alone.
+func (cfg *apiConfig) apiAnnotations() map[string]openapi.Operation {
+	videoResponse := map[string]openapi.Response{
+		"200":     openapi.JSON("The video", videoSchema),
+		"default": errorResponse("Error"),
+	}
+	trashedVideo := maps.Clone(videoSchema.Properties)
+	trashedVideo["purge_at"] = openapi.DateTime()
+	noContent := map[string]openapi.Response{
+		"204":     {Description: "Done"},
+		"default": errorResponse("Error"),
+	}
+	multipartFile := func(field, description string) *openapi.RequestBody {
+		return &openapi.RequestBody{
+			Required:    true,
+			Description: description,
+			Content: map[string]openapi.MediaType{
+				"multipart/form-data": {Schema: openapi.Object(map[string]*openapi.Schema{
+					field: openapi.Binary(),
+				}, field)},
+			},
+		}
+	}
+
+	return map[string]openapi.Operation{
+		"POST /api/login": {
+			Summary:     "Log in",
+			Description: "Starts a session and returns an access token and the first refresh token of it.",
+			Tags:        []string{"auth"},
+			RequestBody: openapi.JSONBody(credentialsSchema),
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The user and their tokens", loginSchema),
+				"default": errorResponse("Error"),
+			},
+		},
+		"POST /api/refresh": {
+			Summary:     "Rotate the refresh token",
+			Description: "Takes the refresh token as a bearer token. Each refresh token works once, using one again revokes its session.",
+			Tags:        []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("New tokens", tokensSchema),
+				"default": errorResponse("Error"),
+			},
+		},
+		"POST /api/revoke": {
+			Summary:     "Log out",
+			Description: "Takes the refresh token as a bearer token and revokes its session.",
+			Tags:        []string{"auth"},
+			Responses:   noContent,
+		},
+		"GET /api/sessions": {
+			Summary:  "List the active sessions of the user",
+			Tags:     []string{"auth"},
+			Security: jwtOnly,
+		},
+		"DELETE /api/sessions/{sessionID}": {
+			Summary:   "Revoke a session",
+			Tags:      []string{"auth"},
+			Security:  jwtOnly,
+			Responses: noContent,
+		},
+		"POST /api/users": {
+			Summary:     "Sign up",
+			Tags:        []string{"auth"},
+			RequestBody: openapi.JSONBody(credentialsSchema),
+			Responses: map[string]openapi.Response{
+				"201":     openapi.JSON("The user", userSchema),
+				"default": errorResponse("Error"),
+			},
+		},
+		"DELETE /api/users/me": {
+			Summary:     "Delete your account",
+			Description: "Locks the user out at once and deletes their videos and data in the background. Admins can't be deleted.",
+			Tags:        []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"202":     openapi.JSON("The deletion, see GET /api/user_deletions/{deletionID}", userDeletionSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: jwtOnly,
+		},
+		"POST /api/users/me/export": {
+			Summary:     "Export your data",
+			Description: "Assembles the export in the background and sends export.ready to webhooks once it can be downloaded. While an export is queued it is returned instead of starting another.",
+			Tags:        []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"202":     openapi.JSON("The export", userExportSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: jwtOnly,
+		},
+		"GET /api/users/me/exports/{exportID}": {
+			Summary: "Get an export of your data",
+			Tags:    []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The export", userExportSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: jwtOnly,
+		},
+		"GET /api/users/{userID}/podcast.xml": {
+			Summary:     "Get the podcast feed of a user",
+			Description: "An RSS feed of the public videos of the user that have audio, newest first. Episode URLs expire like those of public videos.",
+			Tags:        []string{"podcasts"},
+			Responses: map[string]openapi.Response{
+				"200":     {Description: "An RSS 2.0 feed"},
+				"default": errorResponse("Error"),
+			},
+		},
+		"GET /api/users/me/watermark": {
+			Summary: "Get your watermark",
+			Tags:    []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The watermark", watermarkSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"PUT /api/users/me/watermark": {
+			Summary:     "Set your watermark",
+			Description: "The image is burned into your videos processed from then on, in place of the server's watermark. Videos already processed keep the one they got.",
+			Tags:        []string{"auth"},
+			RequestBody: &openapi.RequestBody{
+				Required:    true,
+				Description: "A JPEG, PNG, WebP or AVIF image of at most 5 MiB.",
+				Content: map[string]openapi.MediaType{
+					"multipart/form-data": {Schema: openapi.Object(map[string]*openapi.Schema{
+						watermarkFormField: openapi.Binary(),
+						"position":         openapi.Enum("top-left", "top-right", "bottom-left", "bottom-right", "center").Describe("bottom-right by default"),
+					}, watermarkFormField)},
+				},
+			},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The watermark", watermarkSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"DELETE /api/users/me/watermark": {
+			Summary:   "Remove your watermark",
+			Tags:      []string{"auth"},
+			Responses: noContent,
+			Security:  scoped(auth.ScopeWrite),
+		},
+		"GET /api/user_deletions/{deletionID}": {
+			Summary: "Get the progress of deleting a user",
+			Tags:    []string{"auth"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The deletion", userDeletionSchema),
+				"default": errorResponse("Error"),
+			},
+		},
+		"POST /api/api_keys": {
+			Summary: "Create an API key",
+			Tags:    []string{"auth"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"name":               openapi.String().MinLen(1).MaxLen(maxAPIKeyNameLen),
+				"scopes":             openapi.Array(openapi.Enum(string(auth.ScopeRead), string(auth.ScopeWrite), string(auth.ScopeUpload))),
+				"expires_in_seconds": openapi.Integer().Min(1).Max(maxAPIKeyExpiresIn.Seconds()).OrNull(),
+			}, "name")),
+			Security: jwtOnly,
+		},
+		"GET /api/api_keys": {
+			Summary:  "List the user's API keys",
+			Tags:     []string{"auth"},
+			Security: jwtOnly,
+		},
+		"DELETE /api/api_keys/{keyID}": {
+			Summary:   "Revoke an API key",
+			Tags:      []string{"auth"},
+			Security:  jwtOnly,
+			Responses: noContent,
+		},
+
+		"POST /api/videos": {
+			Summary: "Create a video draft",
+			Tags:    []string{"videos"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"title":       openapi.String(),
+				"description": openapi.String(),
+				"visibility":  visibilitySchema().Describe("private by default"),
+				"publish_at":  openapi.DateTime().Describe("A private video is made public then"),
+				"expires_at":  openapi.DateTime().Describe("The video is archived or deleted then, see EXPIRY_ACTION"),
+			})),
+			Responses: map[string]openapi.Response{
+				"201":     openapi.JSON("The video", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"GET /api/videos": {
+			Summary: "List the user's videos",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				limitParam,
+				openapi.Query("cursor", openapi.String(), "next_cursor of the previous page."),
+				openapi.Query("sort", openapi.Enum("created", "updated", "title"), "created by default."),
+				openapi.Query("order", openapi.Enum("asc", "desc"), "Newest or A-Z first by default."),
+				openapi.Query("owner", openapi.String(), "me or the user's own ID."),
+				openapi.Query("status", openapi.String(), "Comma separated statuses."),
+				openapi.Query("tags", openapi.String(), "Comma separated tags the videos must all carry."),
+				openapi.Query("aspect_ratio", openapi.Enum("16:9", "9:16", "other"), ""),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"GET /api/videos/search": {
+			Summary: "Search the user's videos",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				{Name: "q", In: "query", Required: true, Schema: openapi.String(), Description: "Words to search titles and descriptions for."},
+				limitParam,
+				openapi.Query("offset", openapi.Integer().Min(0), ""),
+				openapi.Query("tags", openapi.String(), "Comma separated tags the videos must all carry."),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"GET /api/videos/trash": {
+			Summary: "List the user's trashed videos",
+			Tags:    []string{"videos"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The trashed videos, most recently trashed first", openapi.Array(openapi.Object(trashedVideo))),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"GET /api/videos/compare": {
+			Summary: "Compare the media of two of the user's videos",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				{Name: "a", In: "query", Required: true, Schema: openapi.UUID()},
+				{Name: "b", In: "query", Required: true, Schema: openapi.UUID()},
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"GET /api/videos/{videoID}": {
+			Summary: "Get a video",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				{Name: clientRegionHeader, In: "header", Schema: openapi.String(), Description: "Region to presign media URLs for, the replica's when S3_REPLICA_BUCKET is set."},
+			},
+			Responses: videoResponse,
+			Security:  optionalAuth,
+		},
+		"GET /api/videos/{videoID}/status": {
+			Summary:  "Get the processing status of a video",
+			Tags:     []string{"videos"},
+			Security: optionalAuth,
+		},
+		"PATCH /api/videos/{videoID}": {
+			Summary: "Edit the title, description, visibility, tags or schedule of a video",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				{Name: "If-Match", In: "header", Schema: openapi.String(), Description: "ETag the edit is based on, 412 when the video changed since."},
+			},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"title":       openapi.String().OrNull(),
+				"description": openapi.String().OrNull(),
+				"visibility":  visibilitySchema().OrNull(),
+				"tags":        openapi.Array(openapi.String()).OrNull(),
+				"publish_at":  openapi.DateTime().OrNull().Describe("null unschedules"),
+				"expires_at":  openapi.DateTime().OrNull().Describe("null unschedules"),
+				"version":     openapi.Integer().OrNull(),
+			})),
+			Responses: videoResponse,
+			Security:  scoped(auth.ScopeWrite),
+		},
+		"DELETE /api/videos/{videoID}": {
+			Summary:     "Move a video to the trash",
+			Description: "Trashed videos are purged with their stored objects after the retention period, or right away when the trash is turned off.",
+			Tags:        []string{"videos"},
+			Responses:   noContent,
+			Security:    scoped(auth.ScopeWrite),
+		},
+		"POST /api/videos/{videoID}/restore": {
+			Summary:   "Take a video out of the trash",
+			Tags:      []string{"videos"},
+			Responses: videoResponse,
+			Security:  scoped(auth.ScopeWrite),
+		},
+		"POST /api/videos/{videoID}/archive": {
+			Summary:     "Archive a video to cold storage",
+			Description: "The video, its renditions and HLS segments move to ARCHIVE_STORAGE_CLASS and aren't handed out until the video is restored. Needs the s3 storage provider.",
+			Tags:        []string{"videos"},
+			Responses:   videoResponse,
+			Security:    scoped(auth.ScopeWrite),
+		},
+		"POST /api/videos/{videoID}/archive/restore": {
+			Summary:     "Restore an archived video",
+			Description: "Restoring takes hours; the video's storage_tier is restoring until it can be played again.",
+			Tags:        []string{"videos"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The video, restored", videoSchema),
+				"202":     openapi.JSON("The video, being restored", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeWrite),
+		},
+		"GET /api/videos/{videoID}/manifest.m3u8": {
+			Summary:     "Get the HLS master playlist of a video",
+			Description: "Videos with captions get a master playlist offering them as subtitles, the segments are the media variant.",
+			Tags:        []string{"videos"},
+			Parameters: []openapi.Parameter{
+				openapi.Query("variant", openapi.Enum("media"), "Get the media playlist of the segments."),
+			},
+			Security: optionalAuth,
+		},
+		"PUT /api/videos/{videoID}/captions/{language}": {
+			Summary:     "Add or replace the captions of a language",
+			Description: "The language is a tag like en or pt-BR. SRT files are converted to WebVTT.",
+			Tags:        []string{"captions"},
+			RequestBody: &openapi.RequestBody{
+				Required:    true,
+				Description: "An SRT or WebVTT file of at most 1 MiB.",
+				Content: map[string]openapi.MediaType{
+					"multipart/form-data": {Schema: openapi.Object(map[string]*openapi.Schema{
+						captionFormField: openapi.Binary(),
+						"label":          openapi.String().MaxLen(maxCaptionLabelLen).Describe("Shown by players, the language by default"),
+					}, captionFormField)},
+				},
+			},
+			Responses: videoResponse,
+			Security:  scoped(auth.ScopeUpload),
+		},
+		"DELETE /api/videos/{videoID}/captions/{language}": {
+			Summary:   "Delete the captions of a language",
+			Tags:      []string{"captions"},
+			Responses: noContent,
+			Security:  scoped(auth.ScopeWrite),
+		},
+		"GET /api/videos/{videoID}/captions/{language}/playlist.m3u8": {
+			Summary:  "Get the HLS subtitle playlist of the captions of a language",
+			Tags:     []string{"captions"},
+			Security: optionalAuth,
+		},
+		"GET /api/videos/{videoID}/transcript": {
+			Summary:     "Get the transcript of a video",
+			Description: "Videos are transcribed after they are stored when the server has a transcriber configured.",
+			Tags:        []string{"captions"},
+			Responses: map[string]openapi.Response{
+				"200": openapi.JSON("The transcript", openapi.Object(map[string]*openapi.Schema{
+					"video_id":       openapi.UUID(),
+					"language":       openapi.String(),
+					"text":           openapi.String(),
+					"transcribed_at": openapi.DateTime(),
+				})),
+				"default": errorResponse("Error"),
+			},
+			Security: optionalAuth,
+		},
+		"GET /api/videos/{videoID}/preview": {
+			Summary:  "Get the preview clip of a video",
+			Tags:     []string{"videos"},
+			Security: optionalAuth,
+		},
+		"POST /api/videos/{videoID}/share": {
+			Summary: "Create a share link",
+			Tags:    []string{"videos"},
+			RequestBody: &openapi.RequestBody{Content: map[string]openapi.MediaType{
+				"application/json": {Schema: openapi.Object(map[string]*openapi.Schema{
+					"ttl_seconds": openapi.Integer().Min(1).Max(maxShareTTL.Seconds()).OrNull(),
+					"max_views":   openapi.Integer().Min(1).OrNull(),
+				})},
+			}},
+			Security: scoped(auth.ScopeWrite),
+		},
+		"POST /api/videos/{videoID}/report": {
+			Summary: "Report a video to the moderators",
+			Tags:    []string{"videos"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"reason":  openapi.Enum("spam", "harassment", "violence", "sexual", "copyright", "other"),
+				"details": openapi.String(),
+			}, "reason")),
+			Security: scoped(auth.ScopeRead),
+		},
+		"POST /api/videos/{videoID}/playback": {
+			Summary: "Record a playback event",
+			Tags:    []string{"videos"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"playback_id": openapi.UUID(),
+				"event":       openapi.Enum("start", "progress", "complete"),
+				"position":    openapi.Number().Min(0).Max(maxPlaybackPosition),
+				"client_id":   openapi.UUID().OrNull(),
+			}, "playback_id", "event")),
+			Responses: noContent,
+			Security:  optionalAuth,
+		},
+		"GET /api/videos/{videoID}/analytics": {
+			Summary: "Get the views of a video",
+			Tags:    []string{"videos"},
+			Parameters: []openapi.Parameter{
+				openapi.Query("from", openapi.DateTime(), "30 days before to by default."),
+				openapi.Query("to", openapi.DateTime(), "Now by default."),
+				openapi.Query("interval", openapi.Enum("hour", "day"), "day by default."),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+
+		"POST /api/video_upload/{videoID}": {
+			Summary:     "Upload the video file",
+			Description: "Stores the file and queues it for processing, the video is ready once its status is.",
+			Tags:        []string{"uploads"},
+			Parameters: []openapi.Parameter{
+				{Name: "Idempotency-Key", In: "header", Schema: openapi.String().MaxLen(maxIdempotencyKeyLen)},
+			},
+			RequestBody: multipartFile(cfg.videoFormField, "An mp4 or another format ffmpeg can transcode."),
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The video, when the same file was uploaded before", videoSchema),
+				"202":     openapi.JSON("The video, processing", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"POST /api/videos/{videoID}/upload_url": {
+			Summary:  "Get a presigned POST to upload the video file to the bucket directly",
+			Tags:     []string{"uploads"},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"POST /api/videos/{videoID}/finalize": {
+			Summary: "Process a video uploaded with an upload URL",
+			Tags:    []string{"uploads"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"key": openapi.String(),
+			}, "key")),
+			Security: scoped(auth.ScopeUpload),
+		},
+		"POST /api/videos/{videoID}/clips": {
+			Summary:     "Create a clip of a video",
+			Description: "The part of the ready video between start and end becomes a new video linked to it, cut at keyframes without re-encoding. The clip is uploading until it is processed or failed.",
+			Tags:        []string{"uploads"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"start": openapi.Number().Describe("Seconds"),
+				"end":   openapi.Number().Describe("Seconds"),
+				"title": openapi.String().Describe("Defaults to the title of the video with \"(clip)\" appended"),
+			}, "start", "end")),
+			Responses: map[string]openapi.Response{
+				"202":     openapi.JSON("Clip queued", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"GET /api/videos/{videoID}/clips": {
+			Summary: "List the clips of a video",
+			Tags:    []string{"videos"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("Clips, newest first", openapi.Array(videoSchema)),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeRead),
+		},
+		"POST /api/videos/{videoID}/import": {
+			Summary:     "Import a video from a URL",
+			Description: "The server downloads the video in the background, the video is uploading until it is processed or failed.",
+			Tags:        []string{"uploads"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"url": openapi.String().Describe("http or https URL of the video"),
+			}, "url")),
+			Responses: map[string]openapi.Response{
+				"202":     openapi.JSON("Import queued", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"POST /api/uploads": {
+			Summary: "Start a resumable tus upload or a chunked upload",
+			Description: "Requests with a Tus-Resumable header start a tus upload, see https://tus.io/protocols/resumable-upload; " +
+				"the Upload-Metadata names the video_id. Other requests start a chunked upload described by the JSON body, " +
+				"its parts are then sent with PUT /api/uploads/{uploadID}/parts/{partNumber}.",
+			Tags: []string{"uploads"},
+			RequestBody: &openapi.RequestBody{Content: map[string]openapi.MediaType{"application/json": {Schema: openapi.Object(map[string]*openapi.Schema{
+				"video_id":   openapi.UUID(),
+				"media_type": openapi.String(),
+				"length":     openapi.Integer().Min(1),
+				"part_size":  openapi.Integer().Min(minUploadPartSize).Max(maxUploadPartSize).Describe("8 MiB by default"),
+			}, "video_id", "media_type", "length")}}},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"PUT /api/uploads/{uploadID}/parts/{partNumber}": {
+			Summary:     "Send a part of a chunked upload",
+			Description: "Every part but the last is part_size bytes. Sending a part again replaces it.",
+			Tags:        []string{"uploads"},
+			Parameters: []openapi.Parameter{
+				{Name: "partNumber", In: "path", Required: true, Schema: openapi.Integer().Min(1)},
+				{Name: partChecksumHeader, In: "header", Required: true, Schema: openapi.String(), Description: "Hex SHA-256 of the part"},
+			},
+			RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/octet-stream": {Schema: openapi.Binary()}}},
+			Security:    scoped(auth.ScopeUpload),
+		},
+		"POST /api/uploads/{uploadID}/complete": {
+			Summary:     "Finish a chunked upload",
+			Description: "Fails with the missing part numbers in details.missing_parts until every part is in.",
+			Tags:        []string{"uploads"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The upload duplicated a ready video", videoSchema),
+				"202":     openapi.JSON("Video queued for processing", videoSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"HEAD /api/uploads/{uploadID}": {
+			Summary:  "Get the offset of a tus upload",
+			Tags:     []string{"uploads"},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"PATCH /api/uploads/{uploadID}": {
+			Summary:  "Continue a tus upload",
+			Tags:     []string{"uploads"},
+			Security: scoped(auth.ScopeUpload),
+		},
+		"DELETE /api/uploads/{uploadID}": {
+			Summary: "Cancel an upload",
+			Description: "Cancels a tus or chunked upload, or the processing of a finished one, and marks its video failed. " +
+				"Form uploads queued for processing are cancelled through the upload in their Location header.",
+			Tags:      []string{"uploads"},
+			Responses: noContent,
+			Security:  scoped(auth.ScopeUpload),
+		},
+		"OPTIONS /api/uploads": {
+			Summary: "Get the tus capabilities of the server",
+			Tags:    []string{"uploads"},
+		},
+
+		"POST /api/thumbnail_upload/{videoID}": {
+			Summary: "Upload a thumbnail",
+			Tags:    []string{"thumbnails"},
+			Parameters: []openapi.Parameter{
+				{Name: "Idempotency-Key", In: "header", Schema: openapi.String().MaxLen(maxIdempotencyKeyLen)},
+			},
+			RequestBody: multipartFile(cfg.thumbnailFormField, "A JPEG or PNG image."),
+			Responses:   videoResponse,
+			Security:    scoped(auth.ScopeUpload),
+		},
+		"GET /api/thumbnails/{videoID}": {
+			Summary:  "Get the thumbnail of a video",
+			Tags:     []string{"thumbnails"},
+			Security: optionalAuth,
+		},
+		"POST /api/videos/{videoID}/thumbnail/regenerate": {
+			Summary: "Make a new thumbnail from a frame of the video",
+			Tags:    []string{"thumbnails"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"timestamp": openapi.Number().Min(0).OrNull().Describe("Seconds into the video, picked automatically when null"),
+			})),
+			Responses: videoResponse,
+			Security:  scoped(auth.ScopeWrite),
+		},
+		"POST /api/videos/{videoID}/thumbnails_vtt": {
+			Summary:  "Generate the storyboard of a video",
+			Tags:     []string{"thumbnails"},
+			Security: scoped(auth.ScopeWrite),
+		},
+		"GET /api/videos/{videoID}/storyboard": {
+			Summary:  "Get the WebVTT storyboard of a video",
+			Tags:     []string{"thumbnails"},
+			Security: optionalAuth,
+		},
+
+		"PUT /api/admin/users/{userID}/role": {
+			Summary: "Change the role of a user",
+			Tags:    []string{"admin"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"role": openapi.Enum("user", "admin"),
+			}, "role")),
+			Responses: noContent,
+			Security:  adminAuth,
+		},
+		"DELETE /api/admin/users/{userID}": {
+			Summary: "Delete a user and all their data",
+			Tags:    []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"202":     openapi.JSON("The deletion, see GET /api/user_deletions/{deletionID}", userDeletionSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"GET /api/admin/reports": {
+			Summary:     "List reports of videos",
+			Description: "Reports the content classifier files have the reason classifier and no reporter_id.",
+			Tags:        []string{"admin"},
+			Parameters: []openapi.Parameter{
+				openapi.Query("status", openapi.Enum("open", "resolved"), "open by default."),
+				openapi.Query("video_id", openapi.UUID(), ""),
+				limitParam,
+				openapi.Query("cursor", openapi.String(), "next_cursor of the previous page."),
+			},
+			Security: adminAuth,
+		},
+		"GET /api/admin/stats": {
+			Summary:     "Get statistics of videos, storage and jobs",
+			Description: "Totals over all videos and the job queue, the outcomes of the jobs that finished between from and to by kind, the videos created and processing jobs finished in each interval and the users consuming the most storage. /metrics exports the totals too.",
+			Tags:        []string{"admin"},
+			Parameters: []openapi.Parameter{
+				openapi.Query("from", openapi.DateTime(), "30 days before to by default."),
+				openapi.Query("to", openapi.DateTime(), "Now by default."),
+				openapi.Query("interval", openapi.Enum("hour", "day"), "day by default."),
+			},
+			Security: adminAuth,
+		},
+		"POST /api/admin/videos/reprocess": {
+			Summary:     "Queue videos for processing again",
+			Description: "Each video is downloaded from storage and processed again, e.g. after processing gained new outputs. With all, every video that is ready or failed and not archived or trashed is queued.",
+			Tags:        []string{"admin"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"video_ids": openapi.Array(openapi.UUID()),
+				"all":       openapi.Boolean(),
+			})),
+			Responses: map[string]openapi.Response{
+				"202": openapi.JSON("The queued videos", openapi.Object(map[string]*openapi.Schema{
+					"queued":    openapi.Array(openapi.UUID()),
+					"not_found": openapi.Array(openapi.UUID()),
+				})),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"POST /api/admin/thumbnails/migrate": {
+			Summary:     "Move thumbnails from the assets directory to the video storage",
+			Description: "Uploads the thumbnails videos still reference by an /assets/ URL and points the videos at the stored objects in one transaction. Thumbnails replaced meanwhile are skipped.",
+			Tags:        []string{"admin"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"dry_run":      openapi.Boolean().Describe("Only report what would be migrated"),
+				"delete_local": openapi.Boolean().Describe("Delete the files of migrated thumbnails"),
+			})),
+			Security: adminAuth,
+		},
+		"GET /api/admin/quarantine": {
+			Summary:     "List quarantined uploads",
+			Description: "Uploads the virus scanner found infected, newest first. Admins' webhooks get upload.quarantined for each.",
+			Tags:        []string{"admin"},
+			Parameters:  []openapi.Parameter{limitParam},
+			Responses: map[string]openapi.Response{
+				"200": openapi.JSON("The quarantined uploads", openapi.Array(openapi.Object(map[string]*openapi.Schema{
+					"id":         openapi.UUID(),
+					"created_at": openapi.DateTime(),
+					"video_id":   openapi.UUID(),
+					"user_id":    openapi.UUID(),
+					"size":       openapi.Integer(),
+					"signature":  openapi.String().Describe("The name the scanner knows the infection by"),
+				}))),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"DELETE /api/admin/quarantine/{uploadID}": {
+			Summary:   "Delete a quarantined upload for good",
+			Tags:      []string{"admin"},
+			Responses: noContent,
+			Security:  adminAuth,
+		},
+		"GET /api/admin/plans": {
+			Summary: "List plans",
+			Tags:    []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The plans", openapi.Array(planSchema)),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"PUT /api/admin/plans/{name}": {
+			Summary: "Create a plan or replace its upload limits",
+			Tags:    []string{"admin"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"max_video_bytes":     openapi.Integer().Min(1).OrNull(),
+				"max_thumbnail_bytes": openapi.Integer().Min(1).OrNull(),
+			})),
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The plan", planSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"DELETE /api/admin/plans/{name}": {
+			Summary:   "Delete a plan, its users fall back to the server's limits",
+			Tags:      []string{"admin"},
+			Responses: noContent,
+			Security:  adminAuth,
+		},
+		"GET /api/admin/users/{userID}/limits": {
+			Summary: "Get the plan and upload limits of a user",
+			Tags:    []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The limits", userLimitsSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+		"PUT /api/admin/users/{userID}/limits": {
+			Summary: "Put a user on a plan and set their own upload limits",
+			Tags:    []string{"admin"},
+			RequestBody: openapi.JSONBody(openapi.Object(map[string]*openapi.Schema{
+				"plan":                openapi.String().OrNull(),
+				"max_video_bytes":     openapi.Integer().Min(1).OrNull(),
+				"max_thumbnail_bytes": openapi.Integer().Min(1).OrNull(),
+				"media_limits_exempt": openapi.Boolean(),
+			})),
+			Responses: map[string]openapi.Response{
+				"200":     openapi.JSON("The limits", userLimitsSchema),
+				"default": errorResponse("Error"),
+			},
+			Security: adminAuth,
+		},
+	}
+}
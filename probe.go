@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoProbeInfo is everything the pipeline needs to know about a video,
+// read with a single ffprobe call.
+type videoProbeInfo struct {
+	database.VideoMetadata
+}
+
+func (info videoProbeInfo) hasAudio() bool {
+	return info.AudioCodec != ""
+}
+
+// aspectRatio is 16:9 or 9:16 for videos close to them and the reduced
+// ratio of the dimensions otherwise.
+func (info videoProbeInfo) aspectRatio() string {
+	w := float64(info.Width)
+	h := float64(info.Height)
+	ratio := w / h
+
+	const epsilon = 0.02
+
+	switch {
+	case math.Abs(ratio-(16.0/9.0)) < epsilon:
+		return "16:9"
+	case math.Abs(ratio-(9.0/16.0)) < epsilon:
+		return "9:16"
+	default:
+		a, b := info.Width, info.Height
+		for b != 0 {
+			a, b = b, a%b
+		}
+		return fmt.Sprintf("%d:%d", info.Width/a, info.Height/a)
+	}
+}
+
+type probeKey struct{}
+
+// probedFile is a file whose probe is carried by a context.
+type probedFile struct {
+	path string
+	info videoProbeInfo
+}
+
+// withVideoProbe returns ctx carrying the probe of the file at path, which
+// probeVideoInfo then returns instead of running ffprobe again. Processing
+// probes its input once and hands the result to every step this way.
+func withVideoProbe(ctx context.Context, path string, info videoProbeInfo) context.Context {
+	return context.WithValue(ctx, probeKey{}, probedFile{path: path, info: info})
+}
+
+var errNoVideoStream = errors.New("no video stream found")
+
+// displayRotation turns the rotation of a video stream into the clockwise
+// degrees players turn it by: 0, 90, 180 or 270. Older muxers tag it as
+// rotate, newer ffprobes report the display matrix, counterclockwise.
+func displayRotation(rotateTag string, matrixRotation float64) int {
+	degrees := -matrixRotation
+	if tag, err := strconv.ParseFloat(rotateTag, 64); err == nil {
+		degrees = tag
+	}
+	quarter := int(math.Round(degrees/90)) % 4
+	if quarter < 0 {
+		quarter += 4
+	}
+	return quarter * 90
+}
+
+// probeVideoInfo reads the dimensions, duration, codecs and bit rate of the
+// video at filePath, which may also be a URL. The dimensions are those the
+// video is displayed with, rotation applied.
+func probeVideoInfo(ctx context.Context, filePath string) (videoProbeInfo, error) {
+	if probed, ok := ctx.Value(probeKey{}).(probedFile); ok && probed.path == filePath {
+		return probed.info, nil
+	}
+
+	type FFProbeOutput struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+			// AvgFrameRate is a fraction like 30000/1001, 0/0 when
+			// unknown.
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+			Tags         struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+			Size     string `json:"size"`
+		} `json:"format"`
+	}
+
+	cmd := exec.CommandContext(
+		ctx,
+		ffprobeBin,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		filePath,
+	)
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return videoProbeInfo{}, fmt.Errorf("unable to run ffprobe %w %s", err, stderr.String())
+	}
+	var jsonFFP FFProbeOutput
+	if err := json.Unmarshal(out.Bytes(), &jsonFFP); err != nil {
+		return videoProbeInfo{}, fmt.Errorf("unmarshal error %w", err)
+	}
+
+	var info videoProbeInfo
+	for _, stream := range jsonFFP.Streams {
+		switch {
+		case stream.CodecType == "video" && info.VideoCodec == "":
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.VideoCodec = stream.CodecName
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			matrixRotation := 0.0
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != 0 {
+					matrixRotation = sideData.Rotation
+				}
+			}
+			info.Rotation = displayRotation(stream.Tags.Rotate, matrixRotation)
+			if info.Rotation == 90 || info.Rotation == 270 {
+				info.Width, info.Height = info.Height, info.Width
+			}
+		case stream.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = stream.CodecName
+		}
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return videoProbeInfo{}, errNoVideoStream
+	}
+	duration, err := strconv.ParseFloat(jsonFFP.Format.Duration, 64)
+	if err != nil {
+		return videoProbeInfo{}, fmt.Errorf("invalid duration %q: %w", jsonFFP.Format.Duration, err)
+	}
+	info.Duration = duration
+	// not every container reports them, they are only informational
+	info.BitRate, _ = strconv.ParseInt(jsonFFP.Format.BitRate, 10, 64)
+	info.Size, _ = strconv.ParseInt(jsonFFP.Format.Size, 10, 64)
+	return info, nil
+}
+
+// parseFrameRate parses the frame rates ffprobe prints as fractions, 0 for
+// unknown ones.
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	// round away float noise of NTSC rates like 30000/1001
+	return math.Round(n/d*1000) / 1000
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Stages of an upload reported to progress streams.
+const (
+	stageReceiving  = "receiving"
+	stageProcessing = "processing"
+	stageStoring    = "storing"
+)
+
+// progressInterval is the least time between two progress events of the
+// same stage, so a fast upload doesn't flood the streams.
+const progressInterval = 250 * time.Millisecond
+
+// progressEvent is sent to the progress streams of a video. Event is either
+// "progress", with the stage and how far along it is, or one of the video
+// lifecycle events sent to webhooks, with the status it left the video in.
+type progressEvent struct {
+	Event      string               `json:"-"`
+	Stage      string               `json:"stage,omitempty"`
+	Bytes      int64                `json:"bytes,omitempty"`
+	TotalBytes int64                `json:"total_bytes,omitempty"`
+	Seconds    float64              `json:"seconds,omitempty"`
+	Duration   float64              `json:"duration,omitempty"`
+	Percent    *float64             `json:"percent,omitempty"`
+	Status     database.VideoStatus `json:"status,omitempty"`
+}
+
+// final reports whether nothing comes after e for the video.
+func (e progressEvent) final() bool {
+	return e.Event == eventVideoReady || e.Event == eventVideoFailed || e.Event == eventVideoDeleted
+}
+
+func percentOf(done, total float64) *float64 {
+	if total <= 0 {
+		return nil
+	}
+	p := min(100, 100*done/total)
+	p = float64(int(p*10)) / 10
+	return &p
+}
+
+// progressHub fans progress events of videos out to the streams following
+// them. It only knows about work done by this instance, and keeps the last
+// event of each video so a stream opened mid-upload starts from it.
+type progressHub struct {
+	mu     sync.Mutex
+	closed bool
+	videos map[uuid.UUID]*videoProgress
+}
+
+type videoProgress struct {
+	last *progressEvent
+	subs map[chan progressEvent]struct{}
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{videos: map[uuid.UUID]*videoProgress{}}
+}
+
+// subscribe returns a channel of the video's events, the last one sent
+// before it if any, and a function to stop following. The channel is
+// closed when the hub is.
+func (h *progressHub) subscribe(videoID uuid.UUID) (<-chan progressEvent, *progressEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan progressEvent, 16)
+	if h.closed {
+		close(ch)
+		return ch, nil, func() {}
+	}
+	vp := h.videos[videoID]
+	if vp == nil {
+		vp = &videoProgress{subs: map[chan progressEvent]struct{}{}}
+		h.videos[videoID] = vp
+	}
+	vp.subs[ch] = struct{}{}
+	last := vp.last
+
+	return ch, last, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := vp.subs[ch]; !ok {
+			return
+		}
+		delete(vp.subs, ch)
+		close(ch)
+		if len(vp.subs) == 0 && vp.last == nil {
+			delete(h.videos, videoID)
+		}
+	}
+}
+
+// publish sends e to the streams of the video. A stream that falls behind
+// loses its oldest event rather than holding up the upload.
+func (h *progressHub) publish(videoID uuid.UUID, e progressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	vp := h.videos[videoID]
+	if vp == nil {
+		if e.final() {
+			return
+		}
+		vp = &videoProgress{subs: map[chan progressEvent]struct{}{}}
+		h.videos[videoID] = vp
+	}
+	for ch := range vp.subs {
+		select {
+		case ch <- e:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	if e.final() {
+		vp.last = nil
+		if len(vp.subs) == 0 {
+			delete(h.videos, videoID)
+		}
+		return
+	}
+	vp.last = &e
+}
+
+// forget drops the last event of a video whose upload was abandoned.
+func (h *progressHub) forget(videoID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	vp := h.videos[videoID]
+	if vp == nil {
+		return
+	}
+	vp.last = nil
+	if len(vp.subs) == 0 {
+		delete(h.videos, videoID)
+	}
+}
+
+// close ends every stream, it is called when the server shuts down.
+func (h *progressHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, vp := range h.videos {
+		for ch := range vp.subs {
+			close(ch)
+		}
+		vp.subs = nil
+	}
+	h.videos = map[uuid.UUID]*videoProgress{}
+}
+
+type progressKey struct{}
+
+type progressTarget struct {
+	hub     *progressHub
+	videoID uuid.UUID
+}
+
+// withVideoProgress makes the work done under ctx report its progress to
+// the streams of the video.
+func (cfg *apiConfig) withVideoProgress(ctx context.Context, videoID uuid.UUID) context.Context {
+	return context.WithValue(ctx, progressKey{}, progressTarget{hub: cfg.progress, videoID: videoID})
+}
+
+// byteProgress returns a function reporting bytes done of a stage of the
+// video tracked by ctx, at most every progressInterval. total is 0 when
+// unknown. Without a tracked video it returns nil.
+func byteProgress(ctx context.Context, stage string) func(done, total int64) {
+	target, ok := ctx.Value(progressKey{}).(progressTarget)
+	if !ok {
+		return nil
+	}
+	var reported time.Time
+	return func(done, total int64) {
+		if (total == 0 || done < total) && time.Since(reported) < progressInterval {
+			return
+		}
+		reported = time.Now()
+		target.hub.publish(target.videoID, progressEvent{
+			Event:      "progress",
+			Stage:      stage,
+			Bytes:      done,
+			TotalBytes: total,
+			Percent:    percentOf(float64(done), float64(total)),
+		})
+	}
+}
+
+// progressBody reports the bytes read from a request body as the receiving
+// stage of the video tracked by ctx.
+func progressBody(ctx context.Context, body io.ReadCloser, total int64) io.ReadCloser {
+	report := byteProgress(ctx, stageReceiving)
+	if report == nil {
+		return body
+	}
+	return &countingBody{ReadCloser: body, report: report, total: max(total, 0)}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	report func(done, total int64)
+	total  int64
+	n      int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if n > 0 {
+		c.report(c.n, c.total)
+	}
+	return n, err
+}
+
+// withStoreProgress makes storage Puts under ctx report to the storing
+// stage of the video tracked by ctx, out of size bytes.
+func withStoreProgress(ctx context.Context, size int64) context.Context {
+	report := byteProgress(ctx, stageStoring)
+	if report == nil {
+		return ctx
+	}
+	return storage.WithProgress(ctx, func(sent, total int64) {
+		if total == 0 {
+			total = size
+		}
+		report(sent, total)
+	})
+}
+
+// trackFFmpegProgress makes an ffmpeg command report how far into the input
+// it got as the processing stage of the video tracked by ctx, using ffmpeg's
+// -progress output on stdout. inputPath is probed for its duration to give
+// a percentage. It must be called before the command starts, and does
+// nothing without a tracked video.
+func trackFFmpegProgress(ctx context.Context, cmd *exec.Cmd, inputPath string) {
+	target, ok := ctx.Value(progressKey{}).(progressTarget)
+	if !ok {
+		return
+	}
+	var duration float64
+	if info, err := probeVideoInfo(ctx, inputPath); err == nil {
+		duration = info.Duration
+	}
+	cmd.Args = append([]string{cmd.Args[0], "-progress", "pipe:1", "-nostats"}, cmd.Args[1:]...)
+	cmd.Stdout = &ffmpegProgressWriter{target: target, duration: duration}
+}
+
+// ffmpegProgressWriter parses the key=value lines of ffmpeg's -progress
+// output as they are written.
+type ffmpegProgressWriter struct {
+	target   progressTarget
+	duration float64
+	line     []byte
+	reported time.Time
+}
+
+func (w *ffmpegProgressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.handleLine(strings.TrimSpace(string(w.line[:i])))
+		w.line = w.line[i+1:]
+	}
+}
+
+func (w *ffmpegProgressWriter) handleLine(line string) {
+	key, value, _ := strings.Cut(line, "=")
+	var seconds float64
+	switch {
+	case key == "progress" && value == "end":
+		seconds = w.duration
+	case key == "out_time_us":
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || time.Since(w.reported) < progressInterval {
+			return
+		}
+		seconds = float64(us) / 1e6
+	default:
+		return
+	}
+	w.reported = time.Now()
+	w.target.hub.publish(w.target.videoID, progressEvent{
+		Event:    "progress",
+		Stage:    stageProcessing,
+		Seconds:  seconds,
+		Duration: w.duration,
+		Percent:  percentOf(seconds, w.duration),
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// quotaDetails are the details of quota_exceeded errors.
+type quotaDetails struct {
+	QuotaBytes     int64 `json:"quota_bytes"`
+	UsedBytes      int64 `json:"used_bytes"`
+	RequestedBytes int64 `json:"requested_bytes"`
+}
+
+func (cfg *apiConfig) respondQuotaExceeded(w http.ResponseWriter, used, requested int64) {
+	respondWithAPIError(w, &apierror.Error{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    apierror.CodeQuotaExceeded,
+		Message: "Storage quota exceeded",
+		Details: quotaDetails{
+			QuotaBytes:     cfg.userQuota,
+			UsedBytes:      used,
+			RequestedBytes: requested,
+		},
+	})
+}
+
+// remainingQuota returns how many more bytes the user may store, not
+// counting replacing bytes that the upload is about to replace. It returns
+// math.MaxInt64 when quotas are disabled.
+func (cfg *apiConfig) remainingQuota(ctx context.Context, userID uuid.UUID, replacing int64) (remaining, used int64, err error) {
+	if cfg.userQuota <= 0 {
+		return math.MaxInt64, 0, nil
+	}
+	usage, err := cfg.db.WithContext(ctx).GetUserUsage(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	used = usage.VideoBytes + usage.ThumbnailBytes - replacing
+	return max(cfg.userQuota-used, 0), used, nil
+}
+
+// checkQuota writes the error response itself and returns false when
+// requested bytes don't fit in the user's quota.
+func (cfg *apiConfig) checkQuota(w http.ResponseWriter, r *http.Request, userID uuid.UUID, replacing, requested int64) bool {
+	remaining, used, err := cfg.remainingQuota(r.Context(), userID, replacing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return false
+	}
+	if requested > remaining {
+		cfg.respondQuotaExceeded(w, used, requested)
+		return false
+	}
+	return true
+}
+
+// measureVideoBytes sums the size of the stored video, its renditions, audio,
+// hover preview and HLS segments.
+func (cfg *apiConfig) measureVideoBytes(ctx context.Context, videoID uuid.UUID, key string) (int64, error) {
+	head, err := cfg.storage.Head(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	total := head.Size
+	for _, prefix := range []string{renditionKeyPrefix(videoID), hlsKeyPrefix(videoID), audioKeyPrefix(videoID), hoverPreviewKeyPrefix(videoID)} {
+		objects, err := cfg.storage.List(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, object := range objects {
+			total += object.Size
+		}
+	}
+	return total, nil
+}
+
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoBytes     int64        `json:"video_bytes"`
+		ThumbnailBytes int64        `json:"thumbnail_bytes"`
+		TotalBytes     int64        `json:"total_bytes"`
+		VideoCount     int          `json:"video_count"`
+		QuotaBytes     *int64       `json:"quota_bytes"`
+		Limits         uploadLimits `json:"limits"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+
+	usage, err := cfg.db.WithContext(r.Context()).GetUserUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+	limits, err := cfg.uploadLimitsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limits", err)
+		return
+	}
+	resp := response{
+		VideoBytes:     usage.VideoBytes,
+		ThumbnailBytes: usage.ThumbnailBytes,
+		TotalBytes:     usage.VideoBytes + usage.ThumbnailBytes,
+		VideoCount:     usage.VideoCount,
+		Limits:         limits,
+	}
+	if cfg.userQuota > 0 {
+		resp.QuotaBytes = &cfg.userQuota
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// isBodyTooLarge reports whether reading the request body hit the limit
+// set with http.MaxBytesReader.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
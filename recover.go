@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errorReportTimeout bounds sending an event to the error report sink.
+const errorReportTimeout = 10 * time.Second
+
+// errorEvent is what the error report sink receives for a panic.
+type errorEvent struct {
+	EventID    string    `json:"event_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Level      string    `json:"level"`
+	Platform   string    `json:"platform"`
+	Message    string    `json:"message"`
+	Stacktrace string    `json:"stacktrace"`
+	Request    struct {
+		Method    string `json:"method"`
+		URL       string `json:"url"`
+		RequestID string `json:"request_id"`
+	} `json:"request"`
+}
+
+// errorReporter posts errorEvents to ERROR_REPORT_URL.
+type errorReporter struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+func newErrorReporter(url, key string) *errorReporter {
+	if url == "" {
+		return nil
+	}
+	return &errorReporter{url: url, key: key, client: &http.Client{Timeout: errorReportTimeout}}
+}
+
+func (e *errorReporter) report(ctx context.Context, event errorEvent) error {
+	dat, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.key != "" {
+		req.Header.Set("Authorization", "Bearer "+e.key)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("error report sink responded with %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// headerRecorder notes whether the response was started, after which a
+// panic can't be answered with an error anymore.
+type headerRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (r *headerRecorder) WriteHeader(status int) {
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *headerRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *headerRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// recoverMiddleware turns a panicking handler into a 500 with the error
+// envelope instead of a dropped connection. The panic is logged with its
+// stack and the request ID, and sent to the error report sink when one is
+// set. http.ErrAbortHandler is left to the server, it aborts on purpose.
+func (cfg *apiConfig) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &headerRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := string(debug.Stack())
+			requestLogger(r.Context()).Error("handler panicked", "method", r.Method, "path", r.URL.Path, "panic", v, "stack", stack)
+			if cfg.errorReporter != nil {
+				event := errorEvent{
+					EventID:    uuid.NewString(),
+					Timestamp:  time.Now().UTC(),
+					Level:      "fatal",
+					Platform:   "go",
+					Message:    fmt.Sprint(v),
+					Stacktrace: stack,
+				}
+				event.Request.Method = r.Method
+				event.Request.URL = r.URL.String()
+				event.Request.RequestID = requestID(r.Context())
+				// reporting doesn't hold up the response
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), errorReportTimeout)
+					defer cancel()
+					if err := cfg.errorReporter.report(ctx, event); err != nil {
+						slog.Warn("cannot report panic", "event_id", event.EventID, "err", err)
+					}
+				}()
+			}
+			if rec.wroteHeader {
+				// the client sees a truncated response
+				panic(http.ErrAbortHandler)
+			}
+			respondWithError(rec, http.StatusInternalServerError, "Internal server error", fmt.Errorf("panic: %v", v))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func renditionKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("renditions/%s/", videoID)
+}
+
+func renditionKey(videoID uuid.UUID, height int) string {
+	return fmt.Sprintf("%s%dp.mp4", renditionKeyPrefix(videoID), height)
+}
+
+// evenDimension rounds to the nearest even number, H.264 encoders reject odd
+// sizes.
+func evenDimension(v float64) int {
+	return int(math.Round(v/2)) * 2
+}
+
+func transcodeRendition(ctx context.Context, filePath, outPath string, height int) error {
+	return encodeWithFallback(ctx, func(enc videoEncoder) error {
+		args := append(enc.args(filePath, height),
+			"-c:a", "aac",
+			"-movflags", "faststart",
+			"-f", "mp4",
+			outPath,
+		)
+		cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := runMediaCommand(ctx, cmd); err != nil {
+			os.Remove(outPath)
+			return fmt.Errorf("ffmpeg transcode to %dp with %s encoder failed: %w\nstderr: %s", height, enc.Name, err, stderr.String())
+		}
+		return nil
+	})
+}
+
+// transcodeRenditions encodes every rung of the configured ladder that is lower
+// than the source resolution and uploads the results.
+func (cfg *apiConfig) transcodeRenditions(ctx context.Context, videoID uuid.UUID, filePath string) (database.Renditions, error) {
+	if len(cfg.transcodeLadder) == 0 {
+		return nil, nil
+	}
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := mkdirTemp(ctx, "renditions-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	renditions := database.Renditions{}
+	for _, height := range cfg.transcodeLadder {
+		if height >= info.Height {
+			continue
+		}
+		outPath := filepath.Join(workDir, fmt.Sprintf("%dp.mp4", height))
+		start := time.Now()
+		if err := transcodeRendition(ctx, filePath, outPath, height); err != nil {
+			return nil, err
+		}
+		log.Printf("transcoded %s to %dp in %s", videoID, height, time.Since(start).Round(time.Millisecond))
+
+		key := renditionKey(videoID, height)
+		if err := cfg.putObjectFile(ctx, key, outPath, "video/mp4"); err != nil {
+			return nil, fmt.Errorf("cannot upload %dp rendition: %w", height, err)
+		}
+		renditions = append(renditions, database.Rendition{
+			Label:  fmt.Sprintf("%dp", height),
+			Width:  evenDimension(float64(info.Width) * float64(height) / float64(info.Height)),
+			Height: height,
+			Key:    key,
+		})
+	}
+	return renditions, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With S3_REPLICA_BUCKET, the media of every stored video is copied to a
+// bucket in a second region. Requests nearer to that region get their
+// media URLs presigned from the replica, once the copy is done: clients or
+// a proxy can name the region in X-Client-Region, otherwise the country
+// the CDN's GeoIP put the request in is looked up in cfg.replicaCountries.
+// Thumbnails and previews are only kept in the primary bucket.
+
+const jobKindReplicateVideo = "replicate_video"
+
+// clientRegionHeader names the region a request should be served from.
+const clientRegionHeader = "X-Client-Region"
+
+type replicateVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+}
+
+type nearReplicaKey struct{}
+
+// regionMiddleware records whether a request is nearer to the replica
+// region than to the primary one.
+func (cfg *apiConfig) regionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.replica != nil && cfg.nearReplica(r) {
+			r = r.WithContext(context.WithValue(r.Context(), nearReplicaKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) nearReplica(r *http.Request) bool {
+	if region := r.Header.Get(clientRegionHeader); region != "" {
+		return region == cfg.replicaRegion
+	}
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(cfg.geoCountryHeader)))
+	return country != "" && cfg.replicaCountries[country]
+}
+
+// isReplicated reports whether the media of video can be served from the
+// replica bucket.
+func isReplicated(video database.Video) bool {
+	return video.ReplicaKey != nil && video.VideoObject != nil && *video.ReplicaKey == video.VideoObject.Key
+}
+
+// mediaObjectURL is videoObjectURL for the objects mediaKeys returns,
+// which requests near the replica region get from the replica bucket once
+// they are copied there. Public videos behind the CDN are left to it.
+func (cfg *apiConfig) mediaObjectURL(ctx context.Context, video database.Video, key string, expires time.Duration) (string, error) {
+	near, _ := ctx.Value(nearReplicaKey{}).(bool)
+	public := video.Visibility == database.VideoVisibilityPublic
+	if !near || !isReplicated(video) || public && cfg.publicCDN {
+		return cfg.videoObjectURL(ctx, video, key, expires)
+	}
+	if public {
+		expires = cfg.publicURLExpiry
+	}
+	return cfg.replica.Presign(ctx, key, expires)
+}
+
+// queueReplication queues copying the media of a video to the replica
+// bucket, if there is one.
+func (cfg *apiConfig) queueReplication(ctx context.Context, video database.Video) {
+	if cfg.replica == nil || isReplicated(video) {
+		return
+	}
+	if _, err := cfg.jobs.Enqueue(jobKindReplicateVideo, replicateVideoPayload{VideoID: video.ID}); err != nil {
+		requestLogger(ctx).Warn("cannot queue replication", "video_id", video.ID, "err", err)
+	}
+}
+
+// replicateVideoJob copies the media of a video to the replica bucket.
+// Videos that changed in the meantime are left to the job queued for the
+// change.
+func (cfg *apiConfig) replicateVideoJob(ctx context.Context, job database.Job) error {
+	var payload replicateVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	// spilled objects are replicated once they are moved to S3
+	if video.ID == uuid.Nil || video.VideoObject == nil || video.VideoObject.Provider == "local" ||
+		video.StorageTier != database.StorageTierHot || isReplicated(video) {
+		return nil
+	}
+	keys, err := cfg.mediaKeys(ctx, video)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := cfg.replica.CopyFrom(ctx, cfg.s3Bucket, key); err != nil {
+			return err
+		}
+	}
+	_, err = cfg.db.WithContext(ctx).SetVideoReplica(video.ID, video.VideoObject.Key)
+	return err
+}
+
+// dropReplica deletes the copy of a video's media from the replica bucket,
+// when its objects are archived.
+func (cfg *apiConfig) dropReplica(ctx context.Context, video database.Video) error {
+	if cfg.replica == nil || video.ReplicaKey == nil {
+		return nil
+	}
+	if err := cfg.db.WithContext(ctx).ClearVideoReplica(video.ID); err != nil {
+		return err
+	}
+	keys, err := cfg.mediaKeys(ctx, video)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := cfg.replica.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
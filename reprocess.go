@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// Reprocessing many videos, e.g. after processing gained new outputs, is
+// queued as a job per video, which downloads its stored video and queues
+// it for processing like the single video admin endpoint does.
+
+const jobKindReprocessVideo = "reprocess_video"
+
+type reprocessVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+}
+
+// reprocessSelection is what queueReprocessing queued and which of the
+// requested videos don't exist.
+type reprocessSelection struct {
+	Queued   []uuid.UUID `json:"queued"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// queueReprocessing queues the videos with the given IDs, or with all every
+// video that can be processed again: neither trashed, archived nor busy.
+func queueReprocessing(db database.Client, pool *jobs.Pool, ids []uuid.UUID, all bool) (reprocessSelection, error) {
+	selection := reprocessSelection{Queued: []uuid.UUID{}, NotFound: []uuid.UUID{}}
+	if all {
+		videos, err := db.GetAllVideos()
+		if err != nil {
+			return selection, err
+		}
+		ids = ids[:0]
+		for _, video := range videos {
+			if video.DeletedAt != nil || video.StorageTier != database.StorageTierHot {
+				continue
+			}
+			if video.Status == database.VideoStatusReady || video.Status == database.VideoStatusFailed {
+				ids = append(ids, video.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		if !all {
+			video, err := db.GetVideo(id)
+			if err != nil {
+				return selection, err
+			}
+			if video.ID == uuid.Nil {
+				selection.NotFound = append(selection.NotFound, id)
+				continue
+			}
+		}
+		if _, err := pool.Enqueue(jobKindReprocessVideo, reprocessVideoPayload{VideoID: id}); err != nil {
+			return selection, err
+		}
+		selection.Queued = append(selection.Queued, id)
+	}
+	return selection, nil
+}
+
+// reprocessVideoJob queues a video for processing again. Videos that can't
+// be, because they are busy, archived or have nothing to process, are
+// skipped.
+func (cfg *apiConfig) reprocessVideoJob(ctx context.Context, job database.Job) error {
+	var payload reprocessVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return nil
+	}
+	_, err = cfg.reprocessVideo(ctx, video)
+	if errors.Is(err, errVideoBusy) || errors.Is(err, errVideoArchived) || errors.Is(err, errNothingStaged) {
+		log.Printf("skipped reprocessing video %s: %v", video.ID, err)
+		return nil
+	}
+	return err
+}
+
+// handlerAdminVideosReprocess queues the videos in video_ids, or every
+// video with all, for processing again.
+func (cfg *apiConfig) handlerAdminVideosReprocess(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	params := struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+		All      bool        `json:"all"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.All == (len(params.VideoIDs) > 0) {
+		respondWithError(w, http.StatusBadRequest, "Set either video_ids or all", nil)
+		return
+	}
+	selection, err := queueReprocessing(cfg.db, cfg.jobs, params.VideoIDs, params.All)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue videos for processing", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin queued videos for processing", "count", len(selection.Queued))
+	respondWithJSON(w, http.StatusAccepted, selection)
+}
+
+// runReprocessCommand implements `tubely reprocess all|<video-id>...`,
+// which queues videos for processing again and exits. The running server
+// picks the jobs up.
+func runReprocessCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: %s reprocess all|<video-id>...", os.Args[0])
+	}
+	all := len(args) == 1 && args[0] == "all"
+	var ids []uuid.UUID
+	if !all {
+		for _, arg := range args {
+			id, err := uuid.Parse(arg)
+			if err != nil {
+				log.Fatalf("invalid video ID %q", arg)
+			}
+			ids = append(ids, id)
+		}
+	}
+	conf, err := config.LoadDatabase(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.NewClient(conf.DBPath, conf.DBPool)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	// the pool only persists the jobs, it isn't started
+	selection, err := queueReprocessing(db, jobs.NewPool(db, 0, 0), ids, all)
+	for _, id := range selection.NotFound {
+		fmt.Printf("no video with ID %s\n", id)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("queued %d videos for processing\n", len(selection.Queued))
+}
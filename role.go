@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runRoleCommand implements `tubely role <email> [user|admin]`, which
+// prints the role of a user or changes it, and exits. It is how the first
+// admin is appointed.
+func runRoleCommand(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		log.Fatalf("usage: %s role <email> [user|admin]", os.Args[0])
+	}
+	conf, err := config.LoadDatabase(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.NewClient(conf.DBPath, conf.DBPool)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	user, err := db.GetUserByEmail(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if user.Email == "" {
+		log.Fatalf("no user with email %s", args[0])
+	}
+	if len(args) == 1 {
+		fmt.Println(user.Role)
+		return
+	}
+	role, ok := roles[args[1]]
+	if !ok {
+		log.Fatalf("role must be user or admin")
+	}
+	if err := db.SetUserRole(user.ID, role); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s is now %s\n", user.Email, role)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Videos can be scheduled: published at their publish_at and archived or
+// deleted, as cfg.expiryAction says, at their expires_at. canViewVideo
+// already hides them from others outside that window, the scheduler makes
+// it stick in their visibility.
+
+const (
+	expiryArchive = "archive"
+	expiryDelete  = "delete"
+)
+
+// scheduleBatch is how many due videos are handled per query.
+const scheduleBatch = 100
+
+// publishDueVideos publishes the videos whose publish_at has passed,
+// returning how many it published.
+func (cfg *apiConfig) publishDueVideos(ctx context.Context, now time.Time) (int, error) {
+	published := 0
+	for {
+		videos, err := cfg.db.WithContext(ctx).GetVideosDueForPublish(now, scheduleBatch)
+		if err != nil {
+			return published, err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return published, err
+			}
+			ok, err := cfg.db.WithContext(ctx).PublishVideo(video.ID, now)
+			if err != nil {
+				return published, err
+			}
+			if ok {
+				cfg.emitVideoEvent(eventVideoPublished, video)
+				published++
+			}
+		}
+		if len(videos) < scheduleBatch {
+			return published, nil
+		}
+	}
+}
+
+// expireDueVideos archives or deletes the videos whose expires_at has
+// passed, returning how many it expired. Deleted videos go to the trash
+// like any other, unless it is turned off.
+func (cfg *apiConfig) expireDueVideos(ctx context.Context, now time.Time) (int, error) {
+	expired := 0
+	for {
+		videos, err := cfg.db.WithContext(ctx).GetVideosDueForExpiry(now, scheduleBatch)
+		if err != nil {
+			return expired, err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return expired, err
+			}
+			ok, err := cfg.expireVideo(ctx, video, now)
+			if err != nil {
+				return expired, err
+			}
+			if ok {
+				expired++
+			}
+		}
+		if len(videos) < scheduleBatch {
+			return expired, nil
+		}
+	}
+}
+
+func (cfg *apiConfig) expireVideo(ctx context.Context, video database.Video, now time.Time) (bool, error) {
+	if cfg.expiryAction == expiryDelete && cfg.trashRetention == 0 {
+		if err := cfg.purgeVideo(ctx, video); err != nil {
+			return false, err
+		}
+		cfg.emitVideoEvent(eventVideoExpired, video)
+		cfg.emitVideoEvent(eventVideoDeleted, video)
+		return true, nil
+	}
+	trash := cfg.expiryAction == expiryDelete
+	ok, err := cfg.db.WithContext(ctx).ExpireVideo(video.ID, now, trash)
+	if err != nil || !ok {
+		return false, err
+	}
+	cfg.emitVideoEvent(eventVideoExpired, video)
+	if trash {
+		cfg.emitVideoEvent(eventVideoDeleted, video)
+	}
+	return true, nil
+}
+
+// runVideoScheduler publishes and expires due videos every interval until
+// ctx is done.
+func (cfg *apiConfig) runVideoScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		published, err := cfg.publishDueVideos(ctx, now)
+		if err != nil {
+			log.Printf("publishing scheduled videos failed after %d videos: %v", published, err)
+		}
+		expired, err := cfg.expireDueVideos(ctx, now)
+		if err != nil {
+			log.Printf("expiring videos failed after %d videos: %v", expired, err)
+		}
+		if published > 0 || expired > 0 {
+			log.Printf("scheduler published %d videos and expired %d", published, expired)
+		}
+	}
+}
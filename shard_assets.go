@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runShardAssetsCommand implements `tubely shard-assets`, which moves the
+// local objects and storyboards stored before sharding to their sharded
+// paths, and exits. The server finds objects in either place, so it can
+// keep running, but storyboards are only found once moved.
+func runShardAssetsCommand(args []string) {
+	if len(args) != 0 {
+		log.Fatalf("usage: %s shard-assets", os.Args[0])
+	}
+	conf, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	moved := 0
+	for _, dir := range []string{localObjectsDir, spilloverDir} {
+		n, err := shardFiles(filepath.Join(conf.AssetsRoot, dir), "")
+		moved += n
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	n, err := shardFiles(conf.AssetsRoot, "vtt")
+	moved += n
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("moved %d files\n", moved)
+}
+
+// shardFiles moves the files below dir in root that aren't sharded to
+// their sharded path below root, and removes the directories left empty.
+// Hidden files are uploads still being written.
+func shardFiles(root, dir string) (int, error) {
+	var files, dirs []string
+	err := filepath.WalkDir(filepath.Join(root, dir), func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			dirs = append(dirs, filePath)
+			return nil
+		}
+		if _, sharded := storage.UnshardKey(rel); sharded || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if storage.ShardKey(rel) != rel {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, rel := range files {
+		target := filepath.Join(root, filepath.FromSlash(storage.ShardKey(rel)))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(filepath.Join(root, filepath.FromSlash(rel)), target); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	// deepest first, removing fails for those that aren't empty
+	slices.Reverse(dirs)
+	for _, d := range dirs {
+		if d != filepath.Join(root, dir) {
+			os.Remove(d)
+		}
+	}
+	return moved, nil
+}
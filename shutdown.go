@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// uploadTracker counts the uploads being received so shutdown can wait for
+// them while refusing new ones.
+type uploadTracker struct {
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func (t *uploadTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *uploadTracker) done() {
+	t.wg.Done()
+}
+
+func (t *uploadTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// drain refuses new uploads and waits for the running ones until ctx ends.
+func (t *uploadTracker) drain(ctx context.Context) error {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acceptingUploads answers 503 once the server is draining, so clients
+// retry new uploads against another instance instead of starting one that
+// might not finish here.
+func (cfg *apiConfig) acceptingUploads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.uploads.start() {
+			w.Header().Set("Connection", "close")
+			respondWithError(w, http.StatusServiceUnavailable, "Server is shutting down, try again", nil)
+			return
+		}
+		defer cfg.uploads.done()
+		next(w, r)
+	}
+}
+
+// drain shuts the server down in stages. New uploads are refused and
+// /readyz fails right away, while running uploads and processing jobs get
+// until ctx ends to finish; the rest of the API keeps answering meanwhile so
+// clients can follow them. Jobs still running at the deadline are requeued
+// for the next start. Last the listener is closed and the workspaces left
+// behind are removed; unfinished tus and chunked uploads are kept so
+// clients can resume them after a restart.
+func (cfg *apiConfig) drain(ctx context.Context, srv *http.Server) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := cfg.uploads.drain(ctx); err != nil {
+			slog.Warn("uploads still running at the drain deadline", "err", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := cfg.jobs.Shutdown(ctx); err != nil {
+			slog.Warn("jobs still running at the drain deadline were requeued", "err", err)
+		}
+	}()
+	wg.Wait()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("closing requests still running at the drain deadline", "err", err)
+		srv.Close()
+	}
+
+	removeWorkspaces()
+}
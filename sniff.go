@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+var errContentMismatch = errors.New("file content doesn't match its declared type")
+
+// sniffVideoType detects the container of a video from its first bytes.
+func sniffVideoType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if string(head[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
+	// webm is a matroska profile, the EBML header names which one it is
+	if bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")) && bytes.Contains(head[:min(len(head), 64)], []byte("matroska")) {
+		return "video/x-matroska"
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return detected
+}
+
+// verifyVideoContent checks the first bytes of an upload against the media
+// type the client declared for it.
+func verifyVideoContent(head []byte, declared string) error {
+	m, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return err
+	}
+	if detected := sniffVideoType(head); detected != m {
+		return fmt.Errorf("%w: declared %s but looks like %s", errContentMismatch, m, detected)
+	}
+	return nil
+}
+
+// verifyImageContent decodes the image header to check it really is the
+// declared format.
+func verifyImageContent(r io.Reader, declared string) error {
+	m, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return err
+	}
+	if m == "image/avif" {
+		// there's no avif decoder to ask, check for the ftyp brand instead
+		head := make([]byte, 12)
+		if _, err := io.ReadFull(r, head); err != nil || string(head[4:8]) != "ftyp" ||
+			(string(head[8:12]) != "avif" && string(head[8:12]) != "avis") {
+			return fmt.Errorf("%w: declared %s but it isn't an avif file", errContentMismatch, m)
+		}
+		return nil
+	}
+	_, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return fmt.Errorf("%w: declared %s but can't be decoded: %v", errContentMismatch, m, err)
+	}
+	if detected := "image/" + format; detected != m {
+		return fmt.Errorf("%w: declared %s but looks like %s", errContentMismatch, m, detected)
+	}
+	return nil
+}
+
+// readHeadAt returns the first sniffLen bytes of a file without moving its
+// read offset.
+func readHeadAt(r io.ReaderAt) ([]byte, error) {
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// peekVideoContent verifies a video stream that can't be rewound. The
+// returned reader must be used in place of r.
+func peekVideoContent(r io.Reader, declared string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := verifyVideoContent(bytes.Clone(head), declared); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// While S3 is down, uploads spill into spilloverDir below the assets root,
+// see storage.Fallback. Videos stored there point at them with the
+// spillover provider until the reconciler has moved them to S3.
+
+const (
+	spilloverDir      = "spillover"
+	spilloverProvider = "spillover"
+)
+
+// reconcileSpillover puts spilled objects into the primary storage and
+// points the videos using them there. It stops at the first failure, the
+// storage is most likely still down, and returns how many objects it moved.
+func (cfg *apiConfig) reconcileSpillover(ctx context.Context) (int, error) {
+	spilled, err := cfg.spillover.Spill.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, object := range spilled {
+		if err := cfg.unspill(ctx, object); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+func (cfg *apiConfig) unspill(ctx context.Context, object storage.ObjectInfo) error {
+	body, err := cfg.spillover.Spill.Get(ctx, object.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// through cfg.storage, so cached URLs of the spilled copy are dropped;
+	// the Put removes the copy once the object is in the primary storage
+	if _, err := cfg.storage.Put(storage.WithoutSpill(ctx), object.Key, body, object.ContentType); err != nil {
+		return err
+	}
+	from := database.ObjectLocation{Provider: spilloverProvider, Key: object.Key}
+	return cfg.db.WithContext(ctx).RelocateObject(from, *cfg.objectLocation(ctx, object.Key))
+}
+
+// runSpilloverReconciler runs reconcileSpillover every interval until ctx is
+// done.
+func (cfg *apiConfig) runSpilloverReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		moved, err := cfg.reconcileSpillover(ctx)
+		if moved > 0 {
+			slog.Info("moved spilled objects to storage", "count", moved)
+		}
+		if err != nil {
+			slog.Warn("cannot move spilled objects to storage yet", "err", err)
+		}
+	}
+}
+
+// onSpill reports an object spilled to local disk.
+func onSpill(key string, err error) {
+	storageSpills.Inc()
+	slog.Warn("storage is failing, object spilled to local disk", "key", key, "err", err)
+}
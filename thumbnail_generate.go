@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const thumbnailSceneThreshold = 0.4
+
+func extractFrameArgs(input, outPath string, timestamp *float64) []string {
+	args := []string{"-y"}
+	if timestamp != nil {
+		// seeking before -i is fast and, for URLs, avoids reading the
+		// whole file up to the timestamp
+		args = append(args, "-ss", strconv.FormatFloat(*timestamp, 'f', 3, 64))
+	}
+	args = append(args, "-i", input)
+	if timestamp == nil {
+		args = append(args,
+			"-vf", fmt.Sprintf("select='gt(scene,%g)',scale='min(1280,iw)':-2", thumbnailSceneThreshold),
+			"-fps_mode", "vfr",
+		)
+	} else {
+		args = append(args, "-vf", "scale='min(1280,iw)':-2")
+	}
+	return append(args,
+		"-frames:v", "1",
+		"-q:v", "2",
+		outPath,
+	)
+}
+
+// extractFrame writes a single jpeg frame of the input to outPath. Without a
+// timestamp the first scene change is used, falling back to the frame at 10%
+// of the duration for videos without a clear cut.
+func extractFrame(ctx context.Context, input, outPath string, timestamp *float64) error {
+	run := func(ts *float64) error {
+		cmd := exec.CommandContext(ctx, ffmpegBin, extractFrameArgs(input, outPath, ts)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := runMediaCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("ffmpeg frame extraction failed: %w\nstderr: %s", err, stderr.String())
+		}
+		stat, err := os.Stat(outPath)
+		if err != nil || stat.Size() == 0 {
+			return fmt.Errorf("ffmpeg extracted no frame")
+		}
+		return nil
+	}
+
+	if timestamp != nil {
+		return run(timestamp)
+	}
+	if err := run(nil); err == nil {
+		return nil
+	}
+	info, err := probeVideoInfo(ctx, input)
+	if err != nil {
+		return err
+	}
+	fallback := info.Duration * 0.1
+	return run(&fallback)
+}
+
+// generateThumbnail extracts a frame of the input and stores it through the
+// regular thumbnail pipeline, returning its location and size.
+func (cfg *apiConfig) generateThumbnail(ctx context.Context, input string, timestamp *float64) (*database.ObjectLocation, int64, error) {
+	workDir, err := mkdirTemp(ctx, "thumbnail-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(workDir)
+
+	framePath := filepath.Join(workDir, "frame.jpg")
+	if err := extractFrame(ctx, input, framePath, timestamp); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(framePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return cfg.saveThumbnail(ctx, f, "image/jpeg")
+}
+
+// autoThumbnail generates a thumbnail from the video itself for videos
+// without an uploaded one, and returns the change setting it. Failures are
+// logged only, a missing thumbnail is no reason to fail processing.
+func (cfg *apiConfig) autoThumbnail(ctx context.Context, video database.Video, filePath string) func(*database.Video) {
+	if hasThumbnail(video) {
+		return func(*database.Video) {}
+	}
+	loc, size, err := cfg.generateThumbnail(ctx, filePath, nil)
+	if err != nil {
+		log.Printf("cannot generate thumbnail for video %s: %v", video.ID, err)
+		return func(*database.Video) {}
+	}
+	return func(video *database.Video) {
+		// a thumbnail uploaded during processing wins, the generated one is
+		// left to the orphan collector
+		if hasThumbnail(*video) {
+			return
+		}
+		video.ThumbnailURL = nil
+		video.ThumbnailObject = loc
+		video.ThumbnailBytes = size
+	}
+}
+
+func hasThumbnail(video database.Video) bool {
+	return video.ThumbnailObject != nil || (video.ThumbnailURL != nil && *video.ThumbnailURL != "")
+}
+
+func (cfg *apiConfig) handlerThumbnailRegenerate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp *float64 `json:"timestamp"`
+	}
+
+	video, ok := cfg.ownedVideoFromPath(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Timestamp != nil && *params.Timestamp < 0 {
+		respondWithError(w, http.StatusBadRequest, "timestamp must not be negative", nil)
+		return
+	}
+
+	key, err := videoKey(video)
+	if errors.Is(err, errVideoArchived) {
+		respondWithError(w, http.StatusConflict, "Video is archived, restore it first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Video has not been uploaded yet", err)
+		return
+	}
+	sourceURL, err := cfg.storage.Presign(r.Context(), key, sourceURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+
+	ctx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	loc, size, err := cfg.generateThumbnail(ctx, sourceURL, params.Timestamp)
+	if err != nil {
+		respondMediaError(w, "Couldn't generate thumbnail", err)
+		return
+	}
+	video, err = cfg.updateVideo(r.Context(), video.ID, func(video *database.Video) {
+		video.ThumbnailURL = nil
+		video.ThumbnailObject = loc
+		video.ThumbnailBytes = size
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cant update video thumbnail", err)
+		return
+	}
+
+	presignedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presing the video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, presignedVideo)
+}
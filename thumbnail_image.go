@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailMediaType is the format every thumbnail is stored in, whatever it
+// was uploaded as.
+const thumbnailMediaType = "image/jpeg"
+
+var errThumbnailTooSmall = errors.New("thumbnail is too small")
+
+type imageSize struct {
+	Width  int
+	Height int
+}
+
+func (s imageSize) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+type thumbnailOptions struct {
+	MaxSize imageSize
+	MinSize imageSize
+	Quality int
+}
+
+func (cfg *apiConfig) thumbnailOptions() thumbnailOptions {
+	return thumbnailOptions{
+		MaxSize: cfg.thumbnailMaxSize,
+		MinSize: cfg.thumbnailMinSize,
+		Quality: cfg.thumbnailQuality,
+	}
+}
+
+// decodeAVIF converts an AVIF image with ffmpeg, the standard library and
+// x/image have no decoder for it.
+func decodeAVIF(ctx context.Context, src io.Reader) (image.Image, error) {
+	workDir, err := mkdirTemp(ctx, "avif-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	inPath := filepath.Join(workDir, "in.avif")
+	in, err := os.Create(inPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(in, src)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	outPath := filepath.Join(workDir, "out.png")
+	cmd := exec.CommandContext(ctx, ffmpegBin, "-y", "-i", inPath, "-frames:v", "1", outPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("ffmpeg avif conversion failed: %w\nstderr: %s", err, stderr.String())
+	}
+	out, err := os.Open(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	img, _, err := image.Decode(out)
+	return img, err
+}
+
+// jpegOrientation returns the EXIF orientation of a jpeg, 1 (upright) when
+// it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			// image data starts, the metadata segments are all before it
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation returns img turned upright according to an EXIF
+// orientation value.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// 5 to 8 swap the axes
+	transposed := orientation >= 5
+	outW, outH := w, h
+	if transposed {
+		outW, outH = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			out.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// fitWithin scales a size down to fit inside max, keeping its aspect ratio.
+func fitWithin(size, max imageSize) imageSize {
+	if size.Width <= max.Width && size.Height <= max.Height {
+		return size
+	}
+	scale := math.Min(float64(max.Width)/float64(size.Width), float64(max.Height)/float64(size.Height))
+	return imageSize{
+		Width:  int(math.Max(1, math.Round(float64(size.Width)*scale))),
+		Height: int(math.Max(1, math.Round(float64(size.Height)*scale))),
+	}
+}
+
+// normalizeThumbnail decodes an uploaded image, turns it upright, scales it
+// down to fit opts.MaxSize and re-encodes it as a jpeg. Only the pixels
+// survive, so EXIF and any other metadata is dropped. Transparent areas are
+// flattened onto white.
+func normalizeThumbnail(ctx context.Context, src io.Reader, mediaType string, opts thumbnailOptions) ([]byte, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	var img image.Image
+	if mediaType == "image/avif" {
+		img, err = decodeAVIF(ctx, bytes.NewReader(data))
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode thumbnail: %w", err)
+	}
+	img = applyOrientation(img, jpegOrientation(data))
+
+	size := imageSize{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	if size.Width < opts.MinSize.Width || size.Height < opts.MinSize.Height {
+		return nil, fmt.Errorf("%w: %s is below the minimum of %s", errThumbnailTooSmall, size, opts.MinSize)
+	}
+	target := fitWithin(size, opts.MaxSize)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, target.Width, target.Height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(canvas, canvas.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
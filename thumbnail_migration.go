@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Thumbnails from before they were stored are files in the assets
+// directory the videos keep the URL of. Migrating them uploads each to the
+// video storage and points its video at the object, all videos in one
+// transaction.
+
+type thumbnailMigrationReport struct {
+	DryRun      bool                `json:"dry_run"`
+	DeleteLocal bool                `json:"delete_local"`
+	Migrated    []migratedThumbnail `json:"migrated"`
+	Skipped     []skippedThumbnail  `json:"skipped"`
+}
+
+type migratedThumbnail struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Asset   string    `json:"asset"`
+	// Key is where the thumbnail was stored, empty for dry runs.
+	Key  string `json:"key,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// skippedThumbnail is an asset that wasn't migrated: missing from the
+// assets directory, unreferenced by any video or replaced by its owner
+// while migrating.
+type skippedThumbnail struct {
+	VideoID *uuid.UUID `json:"video_id"`
+	Asset   string     `json:"asset"`
+	Reason  string     `json:"reason"`
+}
+
+// migrateThumbnails migrates the thumbnails of the assets directory, or
+// with dryRun only reports what it would do. With deleteLocal the files of
+// migrated thumbnails are removed afterwards. Unreferenced files are left
+// to the orphan collector.
+func (cfg *apiConfig) migrateThumbnails(ctx context.Context, dryRun, deleteLocal bool) (thumbnailMigrationReport, error) {
+	report := thumbnailMigrationReport{
+		DryRun:      dryRun,
+		DeleteLocal: deleteLocal,
+		Migrated:    []migratedThumbnail{},
+		Skipped:     []skippedThumbnail{},
+	}
+	db := cfg.db.WithContext(ctx)
+	videos, err := db.GetAllVideos()
+	if err != nil {
+		return report, err
+	}
+	byAsset := map[string][]database.Video{}
+	for _, video := range videos {
+		if video.ThumbnailObject != nil || video.ThumbnailURL == nil {
+			continue
+		}
+		if name, ok := assetName(*video.ThumbnailURL); ok {
+			byAsset[name] = append(byAsset[name], video)
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		if _, ok := byAsset[entry.Name()]; !ok && entry.Type().IsRegular() {
+			report.Skipped = append(report.Skipped, skippedThumbnail{Asset: entry.Name(), Reason: "unreferenced"})
+		}
+	}
+
+	names := make([]string, 0, len(byAsset))
+	for name := range byAsset {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var moves []database.ThumbnailMove
+	for _, name := range names {
+		filePath := filepath.Join(cfg.assetsRoot, filepath.FromSlash(name))
+		info, err := os.Stat(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			for _, video := range byAsset[name] {
+				report.Skipped = append(report.Skipped, skippedThumbnail{VideoID: &video.ID, Asset: name, Reason: "missing"})
+			}
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		for _, video := range byAsset[name] {
+			migrated := migratedThumbnail{VideoID: video.ID, Asset: name, Size: info.Size()}
+			if !dryRun {
+				// every video gets its own copy, deleting one deletes its objects
+				migrated.Key, err = cfg.putThumbnailAsset(ctx, filePath, name)
+				if err != nil {
+					cfg.deleteMovedThumbnails(ctx, moves)
+					return report, err
+				}
+				moves = append(moves, database.ThumbnailMove{
+					VideoID: video.ID,
+					FromURL: *video.ThumbnailURL,
+					To:      *cfg.objectLocation(ctx, migrated.Key),
+					Size:    info.Size(),
+				})
+			}
+			report.Migrated = append(report.Migrated, migrated)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	moved, err := db.MoveThumbnails(moves)
+	if err != nil {
+		cfg.deleteMovedThumbnails(ctx, moves)
+		return report, err
+	}
+	// videos whose owner replaced the thumbnail meanwhile keep the new one
+	replaced := map[string]bool{}
+	var stale []database.ThumbnailMove
+	migrated := []migratedThumbnail{}
+	for i, move := range moves {
+		if slices.Contains(moved, move.VideoID) {
+			migrated = append(migrated, report.Migrated[i])
+			continue
+		}
+		stale = append(stale, move)
+		replaced[report.Migrated[i].Asset] = true
+		report.Skipped = append(report.Skipped, skippedThumbnail{VideoID: &move.VideoID, Asset: report.Migrated[i].Asset, Reason: "replaced"})
+	}
+	report.Migrated = migrated
+	cfg.deleteMovedThumbnails(ctx, stale)
+
+	if deleteLocal {
+		for _, name := range names {
+			if replaced[name] {
+				continue
+			}
+			err := os.Remove(filepath.Join(cfg.assetsRoot, filepath.FromSlash(name)))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("cannot delete migrated thumbnail %s: %v", name, err)
+			}
+		}
+	}
+	log.Printf("migrated %d thumbnails to the video storage", len(report.Migrated))
+	return report, nil
+}
+
+// putThumbnailAsset uploads the thumbnail at filePath under a random key
+// below thumbnails/, keeping the extension of its asset name.
+func (cfg *apiConfig) putThumbnailAsset(ctx context.Context, filePath, name string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	randKey := make([]byte, 32)
+	rand.Read(randKey)
+	key := "thumbnails/" + base64.RawURLEncoding.EncodeToString(randKey) + path.Ext(name)
+	if _, err := cfg.storage.Put(ctx, key, f, mime.TypeByExtension(path.Ext(name))); err != nil {
+		return "", fmt.Errorf("cannot store thumbnail %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// deleteMovedThumbnails deletes the objects of moves that didn't happen,
+// also once ctx is canceled.
+func (cfg *apiConfig) deleteMovedThumbnails(ctx context.Context, moves []database.ThumbnailMove) {
+	ctx, cancel := detach(ctx, cleanupTimeout)
+	defer cancel()
+	for _, move := range moves {
+		if err := cfg.storage.Delete(ctx, move.To.Key); err != nil {
+			log.Printf("cannot delete thumbnail object %s: %v", move.To.Key, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerAdminThumbnailMigration(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	params := struct {
+		DryRun      bool `json:"dry_run"`
+		DeleteLocal bool `json:"delete_local"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	report, err := cfg.migrateThumbnails(r.Context(), params.DryRun, params.DeleteLocal)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't migrate thumbnails", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
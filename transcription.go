@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With TRANSCRIBER set, a job transcribes every stored video: its audio
+// goes to whisper.cpp or an OpenAI compatible API, and the segments that
+// come back are stored as a caption track and as a plain text transcript,
+// which the search covers. A track uploaded in the same language wins over
+// the transcribed one.
+
+const jobKindTranscribeVideo = "transcribe_video"
+
+type transcribeVideoPayload struct {
+	VideoID uuid.UUID `json:"video_id"`
+}
+
+// transcriptSegment is a stretch of speech, in seconds.
+type transcriptSegment struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+type transcript struct {
+	// Language is what the transcriber reported, empty when it didn't.
+	Language string
+	Segments []transcriptSegment
+}
+
+// transcriber transcribes the speech in a video file. An empty language
+// asks it to detect the language.
+type transcriber interface {
+	Transcribe(ctx context.Context, videoPath, language string) (transcript, error)
+}
+
+func newTranscriber(conf config.Config) transcriber {
+	switch conf.Transcriber {
+	case "whisper":
+		return whisperTranscriber{bin: conf.WhisperPath, model: conf.WhisperModel}
+	case "api":
+		return apiTranscriber{
+			url:    conf.TranscriptionAPIURL,
+			key:    conf.TranscriptionAPIKey,
+			model:  conf.TranscriptionModel,
+			client: &http.Client{},
+		}
+	}
+	return nil
+}
+
+// extractSpeechAudio writes the audio of a video downmixed to 16 kHz mono,
+// which is what speech recognition works on, encoded with codecArgs.
+func extractSpeechAudio(ctx context.Context, videoPath, outPath string, codecArgs ...string) error {
+	args := append([]string{"-y", "-i", videoPath, "-vn", "-ac", "1", "-ar", "16000"}, codecArgs...)
+	cmd := exec.CommandContext(ctx, ffmpegBin, append(args, outPath)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		os.Remove(outPath)
+		return fmt.Errorf("ffmpeg audio extraction failed: %w\nstderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// whisperTranscriber runs whisper.cpp, which reads 16-bit WAV files.
+type whisperTranscriber struct {
+	bin   string
+	model string
+}
+
+func (t whisperTranscriber) Transcribe(ctx context.Context, videoPath, language string) (transcript, error) {
+	dir, err := workspaceDir(ctx)
+	if err != nil {
+		return transcript{}, err
+	}
+	base := filepath.Join(dir, "speech")
+	if err := extractSpeechAudio(ctx, videoPath, base+".wav", "-c:a", "pcm_s16le"); err != nil {
+		return transcript{}, err
+	}
+	defer os.Remove(base + ".wav")
+
+	if language == "" {
+		language = "auto"
+	}
+	cmd := exec.CommandContext(ctx, t.bin, "-m", t.model, "-f", base+".wav", "-l", language, "-oj", "-of", base, "-np")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := runMediaCommand(ctx, cmd); err != nil {
+		return transcript{}, fmt.Errorf("whisper failed: %w\nstderr: %s", err, stderr.String())
+	}
+	defer os.Remove(base + ".json")
+
+	dat, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return transcript{}, err
+	}
+	var out struct {
+		Result struct {
+			Language string `json:"language"`
+		} `json:"result"`
+		Transcription []struct {
+			// milliseconds
+			Offsets struct {
+				From int64 `json:"from"`
+				To   int64 `json:"to"`
+			} `json:"offsets"`
+			Text string `json:"text"`
+		} `json:"transcription"`
+	}
+	if err := json.Unmarshal(dat, &out); err != nil {
+		return transcript{}, fmt.Errorf("cannot decode whisper output: %w", err)
+	}
+	tr := transcript{Language: out.Result.Language}
+	for _, seg := range out.Transcription {
+		tr.Segments = append(tr.Segments, transcriptSegment{
+			Start: float64(seg.Offsets.From) / 1000,
+			End:   float64(seg.Offsets.To) / 1000,
+			Text:  seg.Text,
+		})
+	}
+	return tr, nil
+}
+
+// apiTranscriber posts the audio to an OpenAI compatible transcription
+// endpoint. The audio is sent as AAC to stay below the upload limits.
+type apiTranscriber struct {
+	url    string
+	key    string
+	model  string
+	client *http.Client
+}
+
+func (t apiTranscriber) Transcribe(ctx context.Context, videoPath, language string) (transcript, error) {
+	dir, err := workspaceDir(ctx)
+	if err != nil {
+		return transcript{}, err
+	}
+	audioPath := filepath.Join(dir, "speech.m4a")
+	if err := extractSpeechAudio(ctx, videoPath, audioPath, "-c:a", "aac", "-b:a", "32k"); err != nil {
+		return transcript{}, err
+	}
+	defer os.Remove(audioPath)
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return transcript{}, err
+	}
+	defer audio.Close()
+
+	body, w := io.Pipe()
+	form := multipart.NewWriter(w)
+	go func() {
+		fields := map[string]string{
+			"model":                     t.model,
+			"response_format":           "verbose_json",
+			"timestamp_granularities[]": "segment",
+		}
+		if language != "" {
+			fields["language"] = language
+		}
+		for name, value := range fields {
+			if err := form.WriteField(name, value); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", "speech.m4a")
+		if err == nil {
+			_, err = io.Copy(part, audio)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, body)
+	if err != nil {
+		return transcript{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.key != "" {
+		req.Header.Set("Authorization", "Bearer "+t.key)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return transcript{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return transcript{}, fmt.Errorf("transcription API responded with %s: %s", resp.Status, msg)
+	}
+
+	var out struct {
+		Language string `json:"language"`
+		Segments []struct {
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+			Text  string  `json:"text"`
+		} `json:"segments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return transcript{}, fmt.Errorf("cannot decode transcription: %w", err)
+	}
+	tr := transcript{Language: out.Language}
+	for _, seg := range out.Segments {
+		tr.Segments = append(tr.Segments, transcriptSegment{Start: seg.Start, End: seg.End, Text: seg.Text})
+	}
+	return tr, nil
+}
+
+// whisperLanguages maps the language names OpenAI's API reports to tags,
+// for the most spoken ones.
+var whisperLanguages = map[string]string{
+	"arabic":     "ar",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"indonesian": "id",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"norwegian":  "no",
+	"persian":    "fa",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"thai":       "th",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+	"vietnamese": "vi",
+}
+
+// transcriptLanguage returns the tag of the language a transcript is in:
+// the one reported, the configured one, or und for undetermined.
+func (cfg *apiConfig) transcriptLanguage(reported string) string {
+	reported = strings.ToLower(strings.TrimSpace(reported))
+	if tag, ok := whisperLanguages[reported]; ok {
+		reported = tag
+	}
+	for _, tag := range []string{reported, cfg.transcriptionLanguage} {
+		if language, err := normalizeLanguage(tag); err == nil {
+			return language
+		}
+	}
+	return "und"
+}
+
+// transcriptToVTT returns the segments as a WebVTT document and as plain
+// text, both empty when nothing was said.
+func transcriptToVTT(segments []transcriptSegment) (string, string) {
+	var (
+		vtt   strings.Builder
+		words []string
+	)
+	for _, seg := range segments {
+		text := strings.Join(strings.Fields(seg.Text), " ")
+		if text == "" || seg.End <= seg.Start {
+			continue
+		}
+		// a cue's text ends at the first blank line, and can't have arrows
+		text = strings.ReplaceAll(text, "-->", "->")
+		if vtt.Len() == 0 {
+			vtt.WriteString("WEBVTT\n")
+		}
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s\n", formatVTTTimestamp(seg.Start), formatVTTTimestamp(seg.End), text)
+		words = append(words, text)
+	}
+	return vtt.String(), strings.Join(words, " ")
+}
+
+// queueTranscription queues transcribing a stored video, if a transcriber
+// is configured.
+func (cfg *apiConfig) queueTranscription(ctx context.Context, video database.Video) {
+	if cfg.transcriber == nil {
+		return
+	}
+	if _, err := cfg.jobs.Enqueue(jobKindTranscribeVideo, transcribeVideoPayload{VideoID: video.ID}); err != nil {
+		requestLogger(ctx).Warn("cannot queue transcription", "video_id", video.ID, "err", err)
+	}
+}
+
+// transcribeVideoJob transcribes the stored file of a video. Videos
+// without audio, or archived in the meantime, are skipped.
+func (cfg *apiConfig) transcribeVideoJob(ctx context.Context, job database.Job) error {
+	ctx, cancel := cfg.withMediaTimeout(ctx)
+	defer cancel()
+
+	var payload transcribeVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.VideoObject == nil || video.StorageTier != database.StorageTierHot {
+		return nil
+	}
+	if video.Metadata != nil && video.Metadata.AudioCodec == "" {
+		return nil
+	}
+
+	ctx, removeWorkspace, err := newWorkspace(ctx, "transcribe-"+video.ID.String())
+	if err != nil {
+		return err
+	}
+	defer removeWorkspace()
+	localPath, err := cfg.downloadObjectToTemp(ctx, video.VideoObject.Key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localPath)
+
+	tr, err := cfg.transcriber.Transcribe(ctx, localPath, cfg.transcriptionLanguage)
+	if err != nil {
+		return err
+	}
+	vtt, text := transcriptToVTT(tr.Segments)
+	if text == "" {
+		log.Printf("no speech found in video %s", video.ID)
+		return nil
+	}
+
+	language := cfg.transcriptLanguage(tr.Language)
+	key := newCaptionKey(video.ID, language)
+	if _, err := cfg.storage.Put(ctx, key, strings.NewReader(vtt), "text/vtt"); err != nil {
+		return err
+	}
+	replaced, captioned, err := cfg.db.WithContext(ctx).SetVideoTranscript(video.ID, text, database.Caption{
+		Language:  language,
+		Label:     language + " (auto-generated)",
+		Source:    database.CaptionSourceTranscription,
+		ObjectKey: key,
+	})
+	if err != nil || !captioned {
+		// the video is gone or has an uploaded track in the language
+		if err := cfg.storage.Delete(ctx, key); err != nil {
+			log.Printf("cannot delete unused captions %s: %v", key, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if replaced != "" {
+		if err := cfg.storage.Delete(ctx, replaced); err != nil {
+			log.Printf("cannot delete replaced captions %s: %v", replaced, err)
+		}
+	}
+	log.Printf("transcribed video %s in %s", video.ID, language)
+	return nil
+}
+
+// handlerVideoTranscript returns the transcript of a video.
+func (cfg *apiConfig) handlerVideoTranscript(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.playableVideoFromPath(w, r)
+	if !ok {
+		return
+	}
+	tr, err := cfg.db.WithContext(r.Context()).GetVideoTranscript(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transcript", err)
+		return
+	}
+	if tr.VideoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Transcript not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tr)
+}
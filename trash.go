@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Deleted videos go to the trash, where their owner can restore them until
+// they are purged cfg.trashRetention later. Their objects stay stored, and
+// count towards the quota, until then.
+
+// trashPurgeBatch is how many videos are purged per query, so a long
+// backlog doesn't load every trashed video at once.
+const trashPurgeBatch = 100
+
+// trashedVideo is a video in the trash with when it will be purged.
+type trashedVideo struct {
+	database.Video
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// trashVideo moves a video to the trash. For clients the video is gone, so
+// they get the same event as for a deleted one.
+func (cfg *apiConfig) trashVideo(ctx context.Context, video database.Video) error {
+	trashed, err := cfg.db.WithContext(ctx).TrashVideo(video.ID)
+	if err != nil || !trashed {
+		return err
+	}
+	cfg.emitVideoEvent(eventVideoDeleted, video)
+	return nil
+}
+
+// handlerVideosTrash lists the requesting user's trashed videos.
+func (cfg *apiConfig) handlerVideosTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	videos, err := cfg.db.WithContext(r.Context()).GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+	trashed := make([]trashedVideo, len(videos))
+	for i, video := range videos {
+		video, err := cfg.dbVideoToSignedVideo(r.Context(), video, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+			return
+		}
+		trashed[i] = trashedVideo{Video: video, PurgeAt: video.DeletedAt.Add(cfg.trashRetention)}
+	}
+	respondWithJSON(w, http.StatusOK, trashed)
+}
+
+// handlerVideoRestore takes a video out of the trash.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideoFromPathWith(w, r, auth.ScopeWrite, cfg.db.WithContext(r.Context()).GetTrashedVideo)
+	if !ok {
+		return
+	}
+	restored, err := cfg.db.WithContext(r.Context()).RestoreTrashedVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	video, err = cfg.db.WithContext(r.Context()).GetVideo(video.ID)
+	if err != nil || video.ID == uuid.Nil {
+		// purged or trashed again in the meantime
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if restored {
+		cfg.emitVideoEvent(eventVideoRestored, video)
+	}
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cannot presign the video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// purgeTrash deletes the videos that have been in the trash for longer
+// than cfg.trashRetention, returning how many it purged.
+func (cfg *apiConfig) purgeTrash(ctx context.Context) (int, error) {
+	purged := 0
+	for {
+		videos, err := cfg.db.WithContext(ctx).GetVideosTrashedBefore(time.Now().Add(-cfg.trashRetention), trashPurgeBatch)
+		if err != nil {
+			return purged, err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			if err := cfg.purgeVideo(ctx, video); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(videos) < trashPurgeBatch {
+			return purged, nil
+		}
+	}
+}
+
+// runTrashPurger runs purgeTrash every interval until ctx is done.
+func (cfg *apiConfig) runTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := cfg.purgeTrash(ctx)
+		if err != nil {
+			log.Printf("trash purge failed after %d videos: %v", purged, err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("purged %d videos from the trash", purged)
+		}
+	}
+}
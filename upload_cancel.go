@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Uploads can be cancelled by their ID while they are received and while
+// their video is processed. The requests receiving an upload and the
+// processing job register under it, cancelling stops them, which kills
+// ffmpeg and aborts S3 multipart uploads, and fails the video. Form
+// uploads get an ID once they are queued for processing.
+
+var errUploadCancelled = errors.New("upload cancelled")
+
+// cancelRegistry holds the cancel functions of the work running for an
+// upload on this server.
+type cancelRegistry struct {
+	mu      sync.Mutex
+	next    uint64
+	running map[uuid.UUID]map[uint64]context.CancelCauseFunc
+}
+
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{running: map[uuid.UUID]map[uint64]context.CancelCauseFunc{}}
+}
+
+// track returns a context cancelled when the upload is, and the function
+// to call once the work is done.
+func (c *cancelRegistry) track(ctx context.Context, uploadID uuid.UUID) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	c.mu.Lock()
+	c.next++
+	token := c.next
+	if c.running[uploadID] == nil {
+		c.running[uploadID] = map[uint64]context.CancelCauseFunc{}
+	}
+	c.running[uploadID][token] = cancel
+	c.mu.Unlock()
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.running[uploadID], token)
+		if len(c.running[uploadID]) == 0 {
+			delete(c.running, uploadID)
+		}
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel stops the work running for the upload and reports whether there
+// was any.
+func (c *cancelRegistry) cancel(uploadID uuid.UUID) bool {
+	c.mu.Lock()
+	cancels := c.running[uploadID]
+	delete(c.running, uploadID)
+	c.mu.Unlock()
+	for _, cancel := range cancels {
+		cancel(errUploadCancelled)
+	}
+	return len(cancels) > 0
+}
+
+// uploadCancelled reports whether ctx ended because its upload was
+// cancelled.
+func uploadCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errUploadCancelled)
+}
+
+// cancelledUpload reports whether the video failed because its upload was
+// cancelled.
+func cancelledUpload(video database.Video) bool {
+	return video.Status == database.VideoStatusFailed && video.FailureReason != nil &&
+		*video.FailureReason == errUploadCancelled.Error()
+}
+
+// checkCancelled returns errUploadCancelled when the upload of the video
+// was cancelled, here or on another server.
+func (cfg *apiConfig) checkCancelled(ctx context.Context, videoID uuid.UUID) error {
+	if uploadCancelled(ctx) {
+		return errUploadCancelled
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	if cancelledUpload(video) {
+		return errUploadCancelled
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx ends, so a cancelled upload stops
+// receiving without waiting for the client.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := context.Cause(c.ctx); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// trackUpload makes the request cancellable through the upload.
+func (cfg *apiConfig) trackUpload(r *http.Request, uploadID uuid.UUID) (*http.Request, func()) {
+	ctx, done := cfg.cancels.track(r.Context(), uploadID)
+	r = r.WithContext(ctx)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{contextReader{ctx: ctx, r: r.Body}, r.Body}
+	return r, done
+}
+
+// processingJobForUpload returns the unfinished processing job queued for
+// an upload, nil when there is none.
+func (cfg *apiConfig) processingJobForUpload(ctx context.Context, uploadID uuid.UUID) (*database.Job, *processVideoPayload, error) {
+	jobs, err := cfg.db.WithContext(ctx).GetUnfinishedJobs(jobKindProcessVideo)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, job := range jobs {
+		var payload processVideoPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			continue
+		}
+		if payload.UploadID == uploadID {
+			return &job, &payload, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// handlerUploadCancel cancels a tus or chunked upload, or the processing
+// of a finished upload, and marks its video failed. Processing running on
+// another server notices when it finishes and throws its results away.
+func (cfg *apiConfig) handlerUploadCancel(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := pathUUID(r, "uploadID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	upload, err := cfg.db.WithContext(r.Context()).GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	videoID := upload.VideoID
+	if upload.ID != uuid.Nil {
+		if upload.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't access this upload", errNotOwner)
+			return
+		}
+		cfg.cancels.cancel(uploadID)
+		if err := os.Remove(upload.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			requestLogger(r.Context()).Warn("cannot delete upload file", "upload_id", uploadID, "err", err)
+		}
+		if err := cfg.db.WithContext(r.Context()).DeleteUpload(uploadID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+			return
+		}
+	} else {
+		job, payload, err := cfg.processingJobForUpload(r.Context(), uploadID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get processing job", err)
+			return
+		}
+		if job == nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't get upload", nil)
+			return
+		}
+		video, err := cfg.db.WithContext(r.Context()).GetVideo(payload.VideoID)
+		if err != nil || video.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't get upload", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't access this upload", errNotOwner)
+			return
+		}
+		videoID = video.ID
+		queued, err := cfg.db.WithContext(r.Context()).FailQueuedJob(job.ID, errUploadCancelled.Error())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cancel processing job", err)
+			return
+		}
+		if queued {
+			ctx, cancel := detach(r.Context(), cleanupTimeout)
+			defer cancel()
+			cfg.deleteStagingObject(ctx, payload.StagingKey)
+		}
+		// a running job fails itself once stopped
+		cfg.cancels.cancel(uploadID)
+	}
+
+	if err := cfg.db.WithContext(r.Context()).SetVideoFailed(videoID, errUploadCancelled.Error()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+	cfg.progress.forget(videoID)
+	requestLogger(r.Context()).Info("upload cancelled", "upload_id", uploadID, "video_id", videoID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+)
+
+func TestCustomThumbnailFormField(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.thumbnailFormField = "cover"
+	userID := createTestUser(t, cfg, "a@example.com")
+	video := createTestVideo(t, cfg, userID, "custom field")
+	const pattern = "POST /api/thumbnail_upload/{videoID}"
+	path := "/api/thumbnail_upload/" + video.ID.String()
+
+	w := serveAs(t, cfg, userID, pattern, cfg.handlerUploadThumbnail, multipartUpload(t, path, "cover", "image/png", testPNG(t)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	w = serveAs(t, cfg, userID, pattern, cfg.handlerUploadThumbnail, multipartUpload(t, path, "thumbnail", "image/png", testPNG(t)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status for the default field = %d, want 400: %s", w.Code, w.Body)
+	}
+	if _, location := errorLocation(t, w); location != "form.cover" {
+		t.Errorf("location = %q, want form.cover", location)
+	}
+}
+
+func TestCustomVideoFormField(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		name := "buffered"
+		if streaming {
+			name = "streaming"
+		}
+		t.Run(name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.videoFormField = "media"
+			cfg.uploadStreaming = streaming
+			userID := createTestUser(t, cfg, "a@example.com")
+			video := createTestVideo(t, cfg, userID, "custom field")
+			const pattern = "POST /api/video_upload/{videoID}"
+			path := "/api/video_upload/" + video.ID.String()
+
+			// the part is found under the custom name, then rejected for its
+			// media type before anything runs ffmpeg
+			r := multipartUpload(t, path, "media", "text/plain", []byte("not a video"))
+			w := serveAs(t, cfg, userID, pattern, withWorkspace(cfg.handlerUploadVideo), r)
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Fatalf("status = %d, want 415: %s", w.Code, w.Body)
+			}
+			if code, location := errorLocation(t, w); code != apierror.CodeUnsupportedMediaType || location != "form.media" {
+				t.Errorf("error = %s at %q, want %s at form.media", code, location, apierror.CodeUnsupportedMediaType)
+			}
+
+			r = multipartUpload(t, path, "video", "video/mp4", []byte("not a video"))
+			w = serveAs(t, cfg, userID, pattern, withWorkspace(cfg.handlerUploadVideo), r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status for the default field = %d, want 400: %s", w.Code, w.Body)
+			}
+			if _, location := errorLocation(t, w); location != "form.media" {
+				t.Errorf("location = %q, want form.media", location)
+			}
+
+			data := testMP4("a video under a custom field")
+			r = multipartUpload(t, path, "media", "video/mp4", data)
+			w = serveAs(t, cfg, userID, pattern, withWorkspace(cfg.handlerUploadVideo), r)
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+			}
+			// the queued job finds the upload in storage
+			stored := finishTestProcessing(t, cfg, video.ID)
+			if stored.VideoBytes != int64(len(data)) {
+				t.Errorf("stored %d bytes, want %d", stored.VideoBytes, len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	uploadKindVideo     = "video"
+	uploadKindThumbnail = "thumbnail"
+
+	maxPlanNameLength = 64
+	// multipartOverhead is what a multipart form adds around the file in
+	// it, the request body may be that much larger than the limit.
+	multipartOverhead = 64 << 10
+)
+
+// uploadLimits are the largest uploads a user may make, in bytes.
+type uploadLimits struct {
+	Video     int64 `json:"max_video_bytes"`
+	Thumbnail int64 `json:"max_thumbnail_bytes"`
+}
+
+// uploadLimitsFor returns the limits of a user: their own, else their
+// plan's, else MAX_VIDEO_SIZE_MB and MAX_THUMBNAIL_SIZE_MB.
+func (cfg *apiConfig) uploadLimitsFor(ctx context.Context, userID uuid.UUID) (uploadLimits, error) {
+	limits := uploadLimits{Video: cfg.maxVideoSize, Thumbnail: cfg.maxThumbnailSize}
+	stored, err := cfg.db.WithContext(ctx).GetUploadLimits(userID)
+	if err != nil {
+		return uploadLimits{}, err
+	}
+	if stored.MaxVideoBytes != nil {
+		limits.Video = *stored.MaxVideoBytes
+	}
+	if stored.MaxThumbnailBytes != nil {
+		limits.Thumbnail = *stored.MaxThumbnailBytes
+	}
+	return limits, nil
+}
+
+var errMediaLimit = errors.New("video exceeds the upload limits")
+
+// checkMediaLimits returns an error wrapping errMediaLimit, saying which
+// limit, when a probed video is longer or of a higher resolution than
+// MAX_VIDEO_DURATION and MAX_VIDEO_RESOLUTION allow and its owner isn't
+// exempt.
+func (cfg *apiConfig) checkMediaLimits(ctx context.Context, userID uuid.UUID, info database.VideoMetadata) error {
+	var problem string
+	duration := time.Duration(info.Duration * float64(time.Second)).Round(time.Second)
+	res := cfg.maxVideoResolution
+	switch {
+	case cfg.maxVideoDuration > 0 && duration > cfg.maxVideoDuration:
+		problem = fmt.Sprintf("it is %s long, at most %s is allowed", duration, cfg.maxVideoDuration)
+	case max(info.Width, info.Height) > max(res.Width, res.Height) || min(info.Width, info.Height) > min(res.Width, res.Height):
+		problem = fmt.Sprintf("it is %dx%d, at most %s is allowed", info.Width, info.Height, res)
+	default:
+		return nil
+	}
+	limits, err := cfg.db.WithContext(ctx).GetUserLimits(userID)
+	if err != nil {
+		return err
+	}
+	if limits.MediaLimitsExempt {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errMediaLimit, problem)
+}
+
+// uploadLimitDetails are the details of uploads rejected for their size.
+type uploadLimitDetails struct {
+	Kind           string `json:"kind"`
+	LimitBytes     int64  `json:"limit_bytes"`
+	RequestedBytes int64  `json:"requested_bytes,omitempty"`
+}
+
+// respondUploadTooLarge tells the client the limit an upload of kind
+// exceeded. requested is 0 when the size isn't known.
+func respondUploadTooLarge(w http.ResponseWriter, kind string, limit, requested int64) {
+	respondWithAPIError(w, &apierror.Error{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    apierror.CodePayloadTooLarge,
+		Message: fmt.Sprintf("The %s is larger than the upload limit of %d bytes", kind, limit),
+		Details: uploadLimitDetails{
+			Kind:           kind,
+			LimitBytes:     limit,
+			RequestedBytes: requested,
+		},
+	})
+}
+
+// validateUploadLimits checks limits an admin sets, returning what is
+// wrong by field name.
+func validateUploadLimits(limits database.UploadLimits) map[string]string {
+	invalid := map[string]string{}
+	if limits.MaxVideoBytes != nil && *limits.MaxVideoBytes < 1 {
+		invalid["max_video_bytes"] = "max_video_bytes must be at least 1"
+	}
+	if limits.MaxThumbnailBytes != nil && *limits.MaxThumbnailBytes < 1 {
+		invalid["max_thumbnail_bytes"] = "max_thumbnail_bytes must be at least 1"
+	}
+	return invalid
+}
+
+func (cfg *apiConfig) handlerAdminPlansList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	plans, err := cfg.db.WithContext(r.Context()).GetPlans()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plans", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, plans)
+}
+
+// handlerAdminPlanPut creates a plan or replaces its limits. Limits left
+// null are the server's.
+func (cfg *apiConfig) handlerAdminPlanPut(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	name := strings.TrimSpace(r.PathValue("name"))
+	if name == "" || len(name) > maxPlanNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Plan names must be 1 to %d characters", maxPlanNameLength), nil)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := database.UploadLimits{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if invalid := validateUploadLimits(params); len(invalid) > 0 {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusBadRequest,
+			Code:    apierror.CodeInvalidRequest,
+			Message: "Some fields are invalid",
+			Details: map[string]map[string]string{"fields": invalid},
+		})
+		return
+	}
+	plan, err := cfg.db.WithContext(r.Context()).PutPlan(name, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save plan", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin saved plan", "plan", name)
+	respondWithJSON(w, http.StatusOK, plan)
+}
+
+// handlerAdminPlanDelete removes a plan, its users keep their own limits.
+func (cfg *apiConfig) handlerAdminPlanDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	deleted, err := cfg.db.WithContext(r.Context()).DeletePlan(r.PathValue("name"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete plan", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Plan not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithUserLimits writes what a user is put on and the limits that
+// apply to them in the end.
+func (cfg *apiConfig) respondWithUserLimits(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	type response struct {
+		database.UserLimits
+		Effective uploadLimits `json:"effective"`
+	}
+
+	limits, err := cfg.db.WithContext(r.Context()).GetUserLimits(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get limits", err)
+		return
+	}
+	effective, err := cfg.uploadLimitsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get limits", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{UserLimits: limits, Effective: effective})
+}
+
+// adminTargetUser returns the existing user named by the path.
+func (cfg *apiConfig) adminTargetUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := pathUUID(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return uuid.Nil, false
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return uuid.Nil, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return uuid.Nil, false
+	}
+	return user.ID, true
+}
+
+func (cfg *apiConfig) handlerAdminUserLimitsGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	cfg.respondWithUserLimits(w, r, userID)
+}
+
+// handlerAdminUserLimitsPut puts a user on a plan and sets their own
+// limits, both replaced as a whole.
+func (cfg *apiConfig) handlerAdminUserLimitsPut(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := database.UserLimits{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	invalid := validateUploadLimits(params.UploadLimits)
+	if params.Plan != nil {
+		plan, err := cfg.db.WithContext(r.Context()).GetPlan(*params.Plan)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+			return
+		}
+		if plan.Name == "" {
+			invalid["plan"] = "plan doesn't exist"
+		}
+	}
+	if len(invalid) > 0 {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusBadRequest,
+			Code:    apierror.CodeInvalidRequest,
+			Message: "Some fields are invalid",
+			Details: map[string]map[string]string{"fields": invalid},
+		})
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).SetUserLimits(userID, params); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save limits", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin changed user limits", "target_user_id", userID)
+	cfg.respondWithUserLimits(w, r, userID)
+}
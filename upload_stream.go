@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// maxStreamHeadSize bounds how much of an upload is buffered while looking
+// for the moov box. Anything with a bigger header goes through the temp file.
+const maxStreamHeadSize = 16 << 20
+
+// readFastStartHead reads the top level mp4 boxes from r up to and including
+// moov. It reports true when moov precedes mdat, meaning the upload can be
+// stored as is. The returned bytes must be replayed in front of r.
+func readFastStartHead(r io.Reader, limit int) ([]byte, bool, error) {
+	var head bytes.Buffer
+	header := make([]byte, 16)
+	for head.Len() < limit {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return head.Bytes(), false, nil
+			}
+			return head.Bytes(), false, err
+		}
+		head.Write(header[:8])
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return head.Bytes(), false, nil
+			}
+			head.Write(header[8:16])
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if boxType == "mdat" || size < headerLen {
+			return head.Bytes(), false, nil
+		}
+		if int64(head.Len())+size-headerLen > int64(limit) {
+			return head.Bytes(), false, nil
+		}
+		if _, err := io.CopyN(&head, r, size-headerLen); err != nil {
+			if errors.Is(err, io.EOF) {
+				return head.Bytes(), false, nil
+			}
+			return head.Bytes(), false, err
+		}
+		if boxType == "moov" {
+			return head.Bytes(), true, nil
+		}
+	}
+	return head.Bytes(), false, nil
+}
+
+func nextFormPart(r *http.Request, name string) (io.ReadCloser, string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errMissingUploadPart, err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", errMissingUploadPart
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errMissingUploadPart, err)
+		}
+		if part.FormName() == name {
+			return part, part.Header.Get("Content-Type"), nil
+		}
+		part.Close()
+	}
+}
+
+// streamUploadedVideo sends an already faststarted upload straight from the
+// multipart reader to the video storage. Only the mp4 header is buffered, to
+// probe the video; on S3 the body is uploaded in parts, so memory use
+// is bounded by UPLOAD_PART_SIZE_MB * UPLOAD_PARALLELISM. Uploads that still
+// need re-muxing are staged for the processing job instead. Videos larger
+// than maxSize fail with an *http.MaxBytesError.
+func (cfg *apiConfig) streamUploadedVideo(r *http.Request, video database.Video, maxSize int64) (videoUpload, error) {
+	part, mediaType, err := nextFormPart(r, cfg.videoFormField)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	defer part.Close()
+	if err := mimeCheckVideo(mediaType); err != nil {
+		return videoUpload{}, unsupportedInput("form."+cfg.videoFormField, mediaType, fmt.Errorf("%w: %v", errUnsupportedMediaType, err))
+	}
+
+	body, err := peekVideoContent(http.MaxBytesReader(nil, part, maxSize), mediaType)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	mark, err := cfg.watermarkFor(r.Context(), video.UserID)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	if !isMP4(mediaType) || mark.ID != "" || cfg.virusScanner != nil {
+		// other containers are always transcoded, watermarks burned in and
+		// uploads scanned by the processing job
+		return cfg.stageVideo(r.Context(), video.ID, body, mediaType)
+	}
+	head, fastStart, err := readFastStartHead(body, maxStreamHeadSize)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	src := io.MultiReader(bytes.NewReader(head), body)
+	if !fastStart {
+		return cfg.stageVideo(r.Context(), video.ID, src, mediaType)
+	}
+
+	headFile, err := createTemp(r.Context(), "stream-head-*.mp4")
+	if err != nil {
+		return videoUpload{}, err
+	}
+	defer os.Remove(headFile.Name())
+	_, err = headFile.Write(head)
+	headFile.Close()
+	if err != nil {
+		return videoUpload{}, err
+	}
+	probeCtx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	probe, err := probeVideoInfo(probeCtx, headFile.Name())
+	if err != nil {
+		return videoUpload{}, fmt.Errorf("cannot probe video: %w", err)
+	}
+	if err := cfg.checkMediaLimits(r.Context(), video.UserID, probe.VideoMetadata); err != nil {
+		return videoUpload{}, err
+	}
+	fileKey := newVideoKey(probe.aspectRatio(), mediaType)
+
+	// hanging up aborts the upload rather than storing a video for nobody
+	info, err := cfg.storage.Put(r.Context(), fileKey, src, mediaType)
+	if err != nil {
+		return videoUpload{}, fmt.Errorf("cannot stream to storage: %w", err)
+	}
+	// the hash is only known once the bytes are stored, keep the older copy
+	dup, ok, err := cfg.findDuplicateVideo(r.Context(), video, info.SHA256)
+	if err != nil {
+		return videoUpload{}, err
+	}
+	if ok {
+		ctx, cancel := detach(r.Context(), cleanupTimeout)
+		defer cancel()
+		if err := cfg.storage.Delete(ctx, fileKey); err != nil {
+			requestLogger(r.Context()).Warn("cannot delete duplicate upload", "key", fileKey, "err", err)
+		}
+		return videoUpload{
+			MediaType: mediaType,
+			Size:      info.Size,
+			Checksum:  info.SHA256,
+			Duplicate: &dup,
+		}, nil
+	}
+	// ffprobe only saw the header, the bit rate and size come from the
+	// whole upload
+	probe.BitRate = computeBitrate(info.Size, probe.Duration)
+	probe.Size = info.Size
+	return videoUpload{
+		Key:         fileKey,
+		MediaType:   mediaType,
+		Size:        info.Size,
+		AspectRatio: probe.aspectRatio(),
+		Checksum:    info.SHA256,
+		Metadata:    &probe.VideoMetadata,
+	}, nil
+}
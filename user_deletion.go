@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Users can delete their account, and admins any account but an admin's.
+// The user is locked out at once and a job deletes their videos with all
+// their objects, then every other row about them. Its progress is at
+// GET /api/user_deletions/{deletionID}, which needs no login since the
+// user has none left; the ID is only handed to whoever deleted the user.
+
+const jobKindDeleteUser = "delete_user"
+
+// userDeletionBatch is how many videos are loaded at a time.
+const userDeletionBatch = 100
+
+type deleteUserPayload struct {
+	DeletionID uuid.UUID `json:"deletion_id"`
+}
+
+// handlerUserDelete deletes the requesting user. API keys can't be used
+// for it.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	cfg.deleteUser(w, r, userID, nil)
+}
+
+// handlerAdminUserDelete deletes any user but an admin.
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, err := pathUUID(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	cfg.deleteUser(w, r, userID, moderatorID(r))
+}
+
+// deleteUser locks a user out and queues deleting them, answering with
+// the deletion.
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, r *http.Request, userID uuid.UUID, requestedBy *uuid.UUID) {
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	// there has to be an admin left, another one takes the role away first
+	if user.Role == database.RoleAdmin {
+		respondWithError(w, http.StatusConflict, "Admins can't be deleted, take the admin role away first", nil)
+		return
+	}
+
+	deletion, queued, err := cfg.db.WithContext(r.Context()).CreateUserDeletion(user.ID, requestedBy)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	if queued {
+		if _, err := cfg.jobs.Enqueue(jobKindDeleteUser, deleteUserPayload{DeletionID: deletion.ID}); err != nil {
+			if err := cfg.db.WithContext(r.Context()).FailUserDeletion(deletion.ID, err.Error()); err != nil {
+				requestLogger(r.Context()).Warn("cannot mark user deletion as failed", "deletion_id", deletion.ID, "err", err)
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue deleting the user, try again", err)
+			return
+		}
+	}
+	requestLogger(r.Context()).Info("user deletion requested", "target_user_id", user.ID, "deletion_id", deletion.ID)
+	w.Header().Set("Location", "/api/user_deletions/"+deletion.ID.String())
+	respondWithJSON(w, http.StatusAccepted, deletion)
+}
+
+// handlerUserDeletionGet reports the progress of a user deletion.
+func (cfg *apiConfig) handlerUserDeletionGet(w http.ResponseWriter, r *http.Request) {
+	id, err := pathUUID(r, "deletionID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid deletion ID", err)
+		return
+	}
+	deletion, err := cfg.db.WithContext(r.Context()).GetUserDeletion(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user deletion", err)
+		return
+	}
+	if deletion.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "User deletion not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deletion)
+}
+
+// deleteUserJob purges every video of a user, trashed ones included, their
+// exports, their watermark and then the user. A retry picks up with the videos that are
+// left.
+func (cfg *apiConfig) deleteUserJob(ctx context.Context, job database.Job) error {
+	var payload deleteUserPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	deletion, err := cfg.db.WithContext(ctx).GetUserDeletion(payload.DeletionID)
+	if err != nil {
+		return err
+	}
+	if deletion.ID == uuid.Nil || deletion.Status == database.UserDeletionStatusDone {
+		return nil
+	}
+	remaining, err := cfg.db.WithContext(ctx).CountUserVideos(deletion.UserID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.WithContext(ctx).StartUserDeletion(deletion.ID, remaining); err != nil {
+		return err
+	}
+
+	for {
+		videos, err := cfg.db.WithContext(ctx).GetUserVideosToDelete(deletion.UserID, userDeletionBatch)
+		if err != nil {
+			return err
+		}
+		for _, video := range videos {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := cfg.purgeVideo(ctx, video); err != nil {
+				return err
+			}
+			if err := cfg.db.WithContext(ctx).RecordUserVideoDeleted(deletion.ID); err != nil {
+				return err
+			}
+		}
+		if len(videos) < userDeletionBatch {
+			break
+		}
+	}
+	exports, err := cfg.db.WithContext(ctx).GetUserExports(deletion.UserID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.ObjectKey == nil {
+			continue
+		}
+		if err := cfg.storage.Delete(ctx, *export.ObjectKey); err != nil {
+			return err
+		}
+	}
+	wm, err := cfg.db.WithContext(ctx).GetUserWatermark(deletion.UserID)
+	if err != nil {
+		return err
+	}
+	if wm.ObjectKey != "" {
+		if err := cfg.storage.Delete(ctx, wm.ObjectKey); err != nil {
+			return err
+		}
+	}
+	if err := cfg.db.WithContext(ctx).CompleteUserDeletion(deletion.ID, deletion.UserID, userViewer(deletion.UserID)); err != nil {
+		return err
+	}
+	log.Printf("deleted user %s", deletion.UserID)
+	return nil
+}
+
+func (cfg *apiConfig) failDeleteUserJob(ctx context.Context, job database.Job, jobErr error) {
+	var payload deleteUserPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		log.Printf("cannot decode payload of job %s: %v", job.ID, err)
+		return
+	}
+	if err := cfg.db.WithContext(ctx).FailUserDeletion(payload.DeletionID, jobErr.Error()); err != nil {
+		log.Printf("cannot mark user deletion %s as failed: %v", payload.DeletionID, err)
+	}
+}
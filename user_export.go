@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Users can export everything stored about them: a job writes their
+// account, videos, playlists, webhooks, API keys and sessions as one JSON
+// object, with a presigned URL for every object of their videos. The
+// export and those URLs can be downloaded for cfg.exportExpiry, after that
+// the orphan collector removes the object. Webhooks get export.ready once
+// it is done.
+
+const jobKindExportUser = "export_user"
+
+type exportUserPayload struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+// userExportResponse is an export with the URL to download it from once
+// it is done.
+type userExportResponse struct {
+	database.UserExport
+	DownloadURL *string `json:"download_url"`
+}
+
+// userExportDocument is the content of an export.
+type userExportDocument struct {
+	ExportedAt time.Time `json:"exported_at"`
+	// URLsExpireAt is when the asset URLs stop working.
+	URLsExpireAt time.Time          `json:"urls_expire_at"`
+	User         exportedUser       `json:"user"`
+	Videos       []exportedVideo    `json:"videos"`
+	Playlists    []exportedPlaylist `json:"playlists"`
+	Webhooks     []database.Webhook `json:"webhooks"`
+	APIKeys      []database.APIKey  `json:"api_keys"`
+	Sessions     []database.Session `json:"sessions"`
+	// Watermark is set when the user registered one.
+	Watermark *database.UserWatermark `json:"watermark,omitempty"`
+}
+
+type exportedUser struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Email     string        `json:"email"`
+	Role      database.Role `json:"role"`
+}
+
+// exportedVideo is a video with its objects. Archived videos only come
+// with their thumbnail and captions until they are restored.
+type exportedVideo struct {
+	database.Video
+	Assets []exportedAsset `json:"assets"`
+}
+
+type exportedAsset struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+type exportedPlaylist struct {
+	database.Playlist
+	VideoIDs []uuid.UUID `json:"video_ids"`
+}
+
+// handlerUserExportCreate queues an export of the requesting user's data.
+// While one is queued it is returned instead of queueing another. API keys
+// can't be used for it.
+func (cfg *apiConfig) handlerUserExportCreate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	exports, err := cfg.db.WithContext(r.Context()).GetUserExports(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get exports", err)
+		return
+	}
+	for _, export := range exports {
+		if export.Status == database.UserExportStatusQueued {
+			cfg.respondWithUserExport(w, r, http.StatusAccepted, export)
+			return
+		}
+	}
+
+	export, err := cfg.db.WithContext(r.Context()).CreateUserExport(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+	if _, err := cfg.jobs.Enqueue(jobKindExportUser, exportUserPayload{ExportID: export.ID}); err != nil {
+		if err := cfg.db.WithContext(r.Context()).FailUserExport(export.ID, err.Error()); err != nil {
+			requestLogger(r.Context()).Warn("cannot mark export as failed", "export_id", export.ID, "err", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue export", err)
+		return
+	}
+	w.Header().Set("Location", "/api/users/me/exports/"+export.ID.String())
+	cfg.respondWithUserExport(w, r, http.StatusAccepted, export)
+}
+
+// handlerUserExportGet returns an export of the requesting user, with its
+// download URL once it is done.
+func (cfg *apiConfig) handlerUserExportGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, "")
+	if !ok {
+		return
+	}
+	id, err := pathUUID(r, "exportID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+	export, err := cfg.db.WithContext(r.Context()).GetUserExport(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export.ID == uuid.Nil || export.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return
+	}
+	cfg.respondWithUserExport(w, r, http.StatusOK, export)
+}
+
+func (cfg *apiConfig) respondWithUserExport(w http.ResponseWriter, r *http.Request, status int, export database.UserExport) {
+	resp := userExportResponse{UserExport: export}
+	if export.Status == database.UserExportStatusDone && export.ObjectKey != nil && !export.Expired(time.Now()) {
+		url, err := cfg.storage.Presign(r.Context(), *export.ObjectKey, time.Until(*export.ExpiresAt))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign the export", err)
+			return
+		}
+		resp.DownloadURL = &url
+	}
+	respondWithJSON(w, status, resp)
+}
+
+// exportUserJob writes an export and notifies the user's webhooks.
+func (cfg *apiConfig) exportUserJob(ctx context.Context, job database.Job) error {
+	var payload exportUserPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	export, err := cfg.db.WithContext(ctx).GetUserExport(payload.ExportID)
+	if err != nil {
+		return err
+	}
+	if export.ID == uuid.Nil || export.Status != database.UserExportStatusQueued {
+		return nil
+	}
+	user, err := cfg.db.WithContext(ctx).GetUser(export.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		// deleted in the meantime
+		return nil
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(cfg.exportExpiry)
+	doc, err := cfg.userExportDocument(ctx, *user, now, expiresAt)
+	if err != nil {
+		return err
+	}
+	dat, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%s/%s.json", user.ID, export.ID)
+	if _, err := cfg.storage.Put(ctx, key, bytes.NewReader(dat), "application/json"); err != nil {
+		return err
+	}
+	if err := cfg.db.WithContext(ctx).CompleteUserExport(export.ID, key, expiresAt); err != nil {
+		return err
+	}
+	cfg.emitUserEvent(user.ID, eventExportReady, webhookData{Export: &webhookExport{
+		ID:        export.ID,
+		UserID:    user.ID,
+		ExpiresAt: &expiresAt,
+	}})
+	return nil
+}
+
+func (cfg *apiConfig) failExportUserJob(ctx context.Context, job database.Job, jobErr error) {
+	var payload exportUserPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		log.Printf("cannot decode payload of job %s: %v", job.ID, err)
+		return
+	}
+	if err := cfg.db.WithContext(ctx).FailUserExport(payload.ExportID, jobErr.Error()); err != nil {
+		log.Printf("cannot mark export %s as failed: %v", payload.ExportID, err)
+	}
+}
+
+// userExportDocument gathers everything stored about user, presigning the
+// objects of their videos until expiresAt.
+func (cfg *apiConfig) userExportDocument(ctx context.Context, user database.User, now, expiresAt time.Time) (userExportDocument, error) {
+	doc := userExportDocument{
+		ExportedAt:   now,
+		URLsExpireAt: expiresAt,
+		User: exportedUser{
+			ID:        user.ID,
+			CreatedAt: user.CreatedAt,
+			Email:     user.Email,
+			Role:      user.Role,
+		},
+		Videos:    []exportedVideo{},
+		Playlists: []exportedPlaylist{},
+	}
+
+	videos, err := cfg.db.WithContext(ctx).GetAllUserVideos(user.ID)
+	if err != nil {
+		return doc, err
+	}
+	for _, video := range videos {
+		assets, err := cfg.exportVideoAssets(ctx, video, expiresAt)
+		if err != nil {
+			return doc, fmt.Errorf("cannot export video %s: %w", video.ID, err)
+		}
+		doc.Videos = append(doc.Videos, exportedVideo{Video: video, Assets: assets})
+	}
+
+	playlists, err := cfg.db.WithContext(ctx).GetPlaylists(user.ID)
+	if err != nil {
+		return doc, err
+	}
+	for _, playlist := range playlists {
+		playlistVideos, err := cfg.db.WithContext(ctx).GetPlaylistVideos(playlist.ID)
+		if err != nil {
+			return doc, err
+		}
+		ids := make([]uuid.UUID, len(playlistVideos))
+		for i, video := range playlistVideos {
+			ids[i] = video.ID
+		}
+		doc.Playlists = append(doc.Playlists, exportedPlaylist{Playlist: playlist, VideoIDs: ids})
+	}
+
+	if doc.Webhooks, err = cfg.db.WithContext(ctx).GetWebhooks(user.ID); err != nil {
+		return doc, err
+	}
+	if doc.APIKeys, err = cfg.db.WithContext(ctx).GetAPIKeys(user.ID); err != nil {
+		return doc, err
+	}
+	if doc.Sessions, err = cfg.db.WithContext(ctx).GetActiveSessions(user.ID, now); err != nil {
+		return doc, err
+	}
+	wm, err := cfg.db.WithContext(ctx).GetUserWatermark(user.ID)
+	if err != nil {
+		return doc, err
+	}
+	if wm.ObjectKey != "" {
+		url, err := cfg.storage.Presign(ctx, wm.ObjectKey, time.Until(expiresAt))
+		if err != nil {
+			return doc, err
+		}
+		wm.URL = &url
+		doc.Watermark = &wm
+	}
+	return doc, nil
+}
+
+// exportVideoAssets presigns the objects of a video until expiresAt.
+// Taken down videos keep theirs to themselves, like everywhere else.
+func (cfg *apiConfig) exportVideoAssets(ctx context.Context, video database.Video, expiresAt time.Time) ([]exportedAsset, error) {
+	assets := []exportedAsset{}
+	if video.ModerationStatus == database.ModerationStatusTakenDown {
+		return assets, nil
+	}
+	var keys []string
+	if video.ThumbnailObject != nil {
+		keys = append(keys, video.ThumbnailObject.Key)
+	}
+	for _, caption := range video.Captions {
+		keys = append(keys, caption.ObjectKey)
+	}
+	if video.StorageTier == database.StorageTierHot {
+		media, err := cfg.mediaKeys(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, media...)
+	}
+	for _, key := range keys {
+		url, err := cfg.storage.Presign(ctx, key, time.Until(expiresAt))
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, exportedAsset{Key: key, URL: url})
+	}
+	return assets, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+	"github.com/google/uuid"
+)
+
+// Handlers check the inputs the OpenAPI router can't, like form parts, or
+// doesn't see, with these helpers. Their errors are *apierror.Errors shaped
+// like the router's: the message starts with the location of the input,
+// like path.videoID, query.sort or form.thumbnail, which the details name
+// too, so respondWithError answers with them whatever status it is given.
+
+// invalidInput is the 400 for the input at location.
+func invalidInput(location, message string, cause error) error {
+	return inputError(http.StatusBadRequest, apierror.CodeInvalidRequest, location, message, cause)
+}
+
+// unsupportedInput is the 415 for a file part or upload of a media type
+// that isn't accepted.
+func unsupportedInput(location, mediaType string, cause error) error {
+	return inputError(http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, location, "media type "+strconv.Quote(mediaType)+" is not supported", cause)
+}
+
+func inputError(status int, code apierror.Code, location, message string, cause error) error {
+	return &apierror.Error{
+		Status:  status,
+		Code:    code,
+		Message: location + ": " + message,
+		Details: map[string]string{"location": location},
+		Err:     cause,
+	}
+}
+
+// pathUUID returns the path parameter name, which must be a UUID.
+func pathUUID(r *http.Request, name string) (uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		return uuid.Nil, invalidInput("path."+name, "must be a UUID", err)
+	}
+	return id, nil
+}
+
+// queryUUID returns the query parameter name, which must be a UUID.
+func queryUUID(r *http.Request, name string) (uuid.UUID, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return uuid.Nil, invalidInput("query."+name, "is required", nil)
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		return uuid.Nil, invalidInput("query."+name, "must be a UUID", err)
+	}
+	return id, nil
+}
+
+// checkOneOf checks the input at location is one of values.
+func checkOneOf(location, value string, values ...string) error {
+	if slices.Contains(values, value) {
+		return nil
+	}
+	return invalidInput(location, "must be one of "+strings.Join(values, ", "), nil)
+}
+
+// formFile parses the multipart form of r, keeping up to maxMemory of it in
+// memory, and returns its file part field. Bodies over the limit set with
+// http.MaxBytesReader fail with the *http.MaxBytesError.
+func formFile(r *http.Request, field string, maxMemory int64) (multipart.File, *multipart.FileHeader, error) {
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		if isBodyTooLarge(err) {
+			return nil, nil, err
+		}
+		return nil, nil, invalidInput("body", "must be a multipart form", err)
+	}
+	file, header, err := r.FormFile(field)
+	if isBodyTooLarge(err) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, invalidInput("form."+field, "must be a file", err)
+	}
+	return file, header, nil
+}
+
+// formFileMediaType returns the media type of the file part field, which
+// check must accept.
+func formFileMediaType(header *multipart.FileHeader, field string, check func(string) error) (string, error) {
+	mediaType := header.Header.Get("Content-Type")
+	if err := check(mediaType); err != nil {
+		return "", unsupportedInput("form."+field, mediaType, err)
+	}
+	return mediaType, nil
+}
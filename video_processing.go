@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const jobKindProcessVideo = "process_video"
+
+// videoUpload is an upload that has been put into the video storage.
+type videoUpload struct {
+	Key         string
+	MediaType   string
+	Size        int64
+	AspectRatio string
+	// Metadata is the probe of a video stored as is.
+	Metadata *database.VideoMetadata
+	// Staged uploads are raw client bytes that still have to go through
+	// the processing job before they can be played.
+	Staged bool
+	// Checksum is the hex SHA-256 of the bytes the client sent, empty when
+	// they didn't pass through the server.
+	Checksum string
+	// Duplicate is a ready video with the same content. Nothing was stored
+	// when it is set.
+	Duplicate *database.Video
+	// Reprocess is set when the upload is a video going through processing
+	// again, see reprocessVideo.
+	Reprocess bool
+	// Watermark identifies the watermark already burned into the upload,
+	// when it was cut or staged from a stored video.
+	Watermark string
+	// UploadID is what cancels processing the upload, see
+	// handlerUploadCancel.
+	UploadID uuid.UUID
+}
+
+type processVideoPayload struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	StagingKey string    `json:"staging_key"`
+	MediaType  string    `json:"media_type"`
+	Checksum   string    `json:"checksum,omitempty"`
+	// Reprocess skips deduplication, the video is processed again even
+	// when another one has the same content.
+	Reprocess bool `json:"reprocess,omitempty"`
+	// Watermark identifies the watermark already burned into the staged
+	// upload. Uploads without one get the owner's current watermark.
+	Watermark string `json:"watermark,omitempty"`
+	// Trace identifies the request that queued the job.
+	Trace map[string]string `json:"trace,omitempty"`
+	// UploadID cancels the job, see handlerUploadCancel.
+	UploadID uuid.UUID `json:"upload_id"`
+}
+
+// stageVideo uploads the raw upload under the staging prefix of the video,
+// leaving probing and ffmpeg work to the processing job.
+func (cfg *apiConfig) stageVideo(ctx context.Context, videoID uuid.UUID, src io.Reader, mediaType string) (videoUpload, error) {
+	key := stagingKeyPrefix(videoID) + uuid.New().String() + "." + mimeToExt(mediaType)
+	info, err := cfg.storage.Put(ctx, key, src, mediaType)
+	if err != nil {
+		return videoUpload{}, fmt.Errorf("cannot stage video: %w", err)
+	}
+	return videoUpload{
+		Key:       key,
+		MediaType: mediaType,
+		Staged:    true,
+		Size:      info.Size,
+		Checksum:  info.SHA256,
+	}, nil
+}
+
+// enqueueVideoProcessing marks the video as processing and queues the job
+// that turns its staged upload into the playable object.
+func (cfg *apiConfig) enqueueVideoProcessing(ctx context.Context, video database.Video, upload videoUpload) (database.Video, error) {
+	video.Status = database.VideoStatusProcessing
+	if err := cfg.db.WithContext(ctx).SetVideoStatus(video.ID, video.Status); err != nil {
+		return database.Video{}, err
+	}
+	_, err := cfg.jobs.Enqueue(jobKindProcessVideo, processVideoPayload{
+		VideoID:    video.ID,
+		StagingKey: upload.Key,
+		MediaType:  upload.MediaType,
+		Checksum:   upload.Checksum,
+		Reprocess:  upload.Reprocess,
+		Watermark:  upload.Watermark,
+		Trace:      traceCarrier(ctx),
+		UploadID:   upload.UploadID,
+	})
+	if err != nil {
+		return database.Video{}, err
+	}
+	if !upload.Reprocess {
+		cfg.emitVideoEvent(eventVideoUploaded, video)
+	}
+	return video, nil
+}
+
+var (
+	errVideoBusy     = errors.New("video is being uploaded or processed")
+	errNothingStaged = errors.New("video has no upload to process")
+)
+
+// reprocessVideo queues a video for processing again, from its stored
+// video or, when processing failed before storing one, from the upload
+// left in staging.
+func (cfg *apiConfig) reprocessVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+		return database.Video{}, errVideoBusy
+	}
+
+	if video.StorageTier != database.StorageTierHot {
+		return database.Video{}, errVideoArchived
+	}
+
+	var upload videoUpload
+	if video.VideoObject != nil {
+		body, err := cfg.storage.Get(ctx, video.VideoObject.Key)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("cannot get video object: %w", err)
+		}
+		// stored videos are always mp4
+		upload, err = cfg.stageVideo(ctx, video.ID, body, "video/mp4")
+		body.Close()
+		if err != nil {
+			return database.Video{}, err
+		}
+		upload.Watermark = video.Watermark
+	} else {
+		staged, err := cfg.storage.List(ctx, stagingKeyPrefix(video.ID))
+		if err != nil {
+			return database.Video{}, err
+		}
+		if len(staged) == 0 {
+			return database.Video{}, errNothingStaged
+		}
+		latest := slices.MaxFunc(staged, func(a, b storage.ObjectInfo) int {
+			return a.LastModified.Compare(b.LastModified)
+		})
+		upload = videoUpload{
+			Key:       latest.Key,
+			MediaType: latest.ContentType,
+			Size:      latest.Size,
+			Staged:    true,
+		}
+		if upload.MediaType == "" {
+			upload.MediaType = extToMime(strings.TrimPrefix(path.Ext(latest.Key), "."))
+		}
+	}
+	// the checksum is left for the job to compute
+	upload.Checksum = ""
+	upload.Reprocess = true
+	return cfg.enqueueVideoProcessing(ctx, video, upload)
+}
+
+// transcodeToMP4 re-encodes a video in any container ffmpeg reads into an
+// H.264/AAC mp4 next to the input and returns its path. ffmpeg applies the
+// rotation of the input to the frames, the result is upright.
+func transcodeToMP4(ctx context.Context, filePath string) (string, error) {
+	outPath := filePath + ".mp4"
+	err := encodeWithFallback(ctx, func(enc videoEncoder) error {
+		args := append(enc.args(filePath, 0),
+			"-c:a", "aac",
+			"-movflags", "faststart",
+			"-f", "mp4",
+			outPath,
+		)
+		cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+		trackFFmpegProgress(ctx, cmd, filePath)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := runMediaCommand(ctx, cmd); err != nil {
+			os.Remove(outPath)
+			return fmt.Errorf("ffmpeg transcode to mp4 with %s encoder failed: %w\nstderr: %s", enc.Name, err, stderr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+func (cfg *apiConfig) processVideoJob(ctx context.Context, job database.Job) (err error) {
+	ctx, cancel := cfg.withMediaTimeout(withUnboundedMediaQueue(ctx))
+	defer cancel()
+
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "video.process", linkedSpanOptions(payload.Trace,
+		attribute.String("video.id", payload.VideoID.String()),
+		attribute.String("job.id", job.ID.String()),
+		attribute.Int("job.attempt", job.Attempts),
+	)...)
+	defer func() { endSpan(span, err) }()
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		// the video was deleted in the meantime, nothing left to do
+		return nil
+	}
+	if payload.UploadID != uuid.Nil {
+		var done func()
+		ctx, done = cfg.cancels.track(ctx, payload.UploadID)
+		defer done()
+		defer func() {
+			if err == nil || !errors.Is(err, errUploadCancelled) && !uploadCancelled(ctx) {
+				return
+			}
+			cleanupCtx, cancel := detach(ctx, cleanupTimeout)
+			defer cancel()
+			cfg.deleteStagingObject(cleanupCtx, payload.StagingKey)
+			err = jobs.Permanent(errUploadCancelled)
+		}()
+		if cancelledUpload(video) {
+			return errUploadCancelled
+		}
+	}
+	ctx = cfg.withVideoProgress(ctx, video.ID)
+	if err := cfg.db.WithContext(ctx).SetVideoStatus(video.ID, database.VideoStatusProcessing); err != nil {
+		return err
+	}
+	ctx, removeWorkspace, err := newWorkspace(ctx, "process-"+video.ID.String())
+	if err != nil {
+		return err
+	}
+	defer removeWorkspace()
+
+	var localPath string
+	checksum := payload.Checksum
+	if checksum == "" {
+		// direct uploads go from the client to the bucket, hash them while
+		// downloading
+		hash := sha256.New()
+		localPath, err = cfg.downloadObjectToTempWith(ctx, payload.StagingKey, hash)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(localPath)
+		checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	dup, ok := database.Video{}, false
+	if !payload.Reprocess {
+		dup, ok, err = cfg.findDuplicateVideo(ctx, video, checksum)
+		if err != nil {
+			return err
+		}
+	}
+	if ok {
+		if _, err := cfg.shareVideoObjects(ctx, video, dup, payload.MediaType); err != nil && !errors.Is(err, errVideoDeleted) {
+			return err
+		}
+		cfg.deleteStagingObject(ctx, payload.StagingKey)
+		return nil
+	}
+
+	if localPath == "" {
+		localPath, err = cfg.downloadObjectToTemp(ctx, payload.StagingKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(localPath)
+	}
+
+	if cfg.virusScanner != nil {
+		if err := cfg.scanUpload(ctx, video, payload.StagingKey, localPath); err != nil {
+			if errors.Is(err, errUploadInfected) {
+				return jobs.Permanent(err)
+			}
+			return err
+		}
+	}
+
+	// check the limits before spending anything on the video
+	mediaType := payload.MediaType
+	probe, err := probeVideoInfo(ctx, localPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.checkMediaLimits(ctx, video.UserID, probe.VideoMetadata); err != nil {
+		// the upload stays staged, to be reprocessed once the user is
+		// exempted
+		if errors.Is(err, errMediaLimit) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+	ctx = withVideoProbe(ctx, localPath, probe)
+	rotated := isMP4(mediaType) && probe.Rotation != 0
+	// transcoding turns rotated phone videos upright, the HLS segments
+	// copied from the result can't carry the rotation
+	if !isMP4(mediaType) || rotated {
+		mp4Path, err := transcodeToMP4(ctx, localPath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(mp4Path)
+		localPath = mp4Path
+		mediaType = "video/mp4"
+	}
+
+	mark := watermark{ID: payload.Watermark}
+	if mark.ID == "" {
+		mark, err = cfg.watermarkFor(ctx, video.UserID)
+		if err != nil {
+			return err
+		}
+		if mark.ID != "" {
+			marked, err := cfg.applyWatermark(ctx, localPath, mark)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(marked)
+			localPath = marked
+		}
+	}
+
+	// every step below reads localPath, they share this probe
+	info, err := probeVideoInfo(ctx, localPath)
+	if err != nil {
+		return err
+	}
+	if cfg.audioNormalize && info.hasAudio() {
+		// and the loudness measured for normalizing
+		loudness, err := measureLoudness(ctx, localPath, cfg.audioTargetLUFS)
+		if err != nil {
+			return err
+		}
+		ctx = withLoudness(ctx, localPath, loudness)
+		info.Loudness = loudness.summary()
+	}
+	ctx = withVideoProbe(ctx, localPath, info)
+
+	if cfg.hlsEnabled {
+		if err := cfg.packageHLS(ctx, video.ID, localPath); err != nil {
+			return err
+		}
+	}
+
+	setThumbnail := cfg.autoThumbnail(ctx, video, localPath)
+
+	if _, err := cfg.generateStoryboard(ctx, video.ID, localPath); err != nil {
+		return err
+	}
+
+	renditions, err := cfg.transcodeRenditions(ctx, video.ID, localPath)
+	if err != nil {
+		return err
+	}
+
+	audio, err := cfg.extractAudio(ctx, video.ID, localPath)
+	if err != nil {
+		return err
+	}
+
+	previewKey, err := cfg.generateHoverPreview(ctx, video.ID, localPath)
+	if err != nil {
+		return err
+	}
+
+	fileKey, size, err := cfg.storeVideo(ctx, localPath, mediaType)
+	if err != nil {
+		return err
+	}
+	if payload.UploadID != uuid.Nil {
+		// storing isn't interrupted, whatever was cancelled meanwhile
+		if err := cfg.checkCancelled(ctx, video.ID); err != nil {
+			cleanupCtx, cancel := detach(ctx, cleanupTimeout)
+			defer cancel()
+			if err := cfg.storage.Delete(cleanupCtx, fileKey); err != nil {
+				log.Println("cannot delete video object of cancelled upload", path.Base(fileKey), err)
+			}
+			return err
+		}
+	}
+	// the faststart pass may have re-encoded the audio
+	info.Size = size
+	var previous *database.ObjectLocation
+	aspectRatio := info.aspectRatio()
+	_, err = cfg.setVideoObject(ctx, video.ID, fileKey, func(video *database.Video) {
+		previous = video.VideoObject
+		if !payload.Reprocess || video.OriginalFormat == nil {
+			video.OriginalFormat = &payload.MediaType
+		}
+		video.Checksum = &checksum
+		video.AspectRatio = &aspectRatio
+		video.Metadata = &info.VideoMetadata
+		video.Renditions = renditions
+		video.Audio = audio
+		video.PreviewKey = previewKey
+		video.Watermark = mark.ID
+		setThumbnail(video)
+	})
+	if errors.Is(err, errVideoDeleted) {
+		// deleted during processing, what was stored is left to the orphan
+		// collector
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if payload.Reprocess && previous != nil && previous.Key != fileKey {
+		cfg.deleteReplacedVideoObject(ctx, *previous)
+	}
+
+	cfg.deleteStagingObject(ctx, payload.StagingKey)
+	return nil
+}
+
+// deleteReplacedVideoObject removes a stored video that was replaced by
+// processing it again, unless other videos share it.
+func (cfg *apiConfig) deleteReplacedVideoObject(ctx context.Context, loc database.ObjectLocation) {
+	refs, err := cfg.db.WithContext(ctx).CountVideosWithObject(loc)
+	if err != nil || refs > 0 {
+		return
+	}
+	if err := cfg.storage.Delete(ctx, loc.Key); err != nil {
+		log.Println("cannot delete replaced video object", path.Base(loc.Key), err)
+	}
+}
+
+func (cfg *apiConfig) deleteStagingObject(ctx context.Context, key string) {
+	if err := cfg.storage.Delete(ctx, key); err != nil {
+		log.Println("cannot delete staging object", path.Base(key), err)
+	}
+}
+
+func (cfg *apiConfig) failVideoJob(ctx context.Context, job database.Job, jobErr error) {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		log.Printf("cannot decode payload of job %s: %v", job.ID, err)
+		return
+	}
+	var err error
+	switch {
+	case errors.Is(jobErr, errUploadInfected):
+		err = cfg.db.WithContext(ctx).RejectVideo(payload.VideoID, jobErr.Error())
+	case errors.Is(jobErr, errMediaLimit), errors.Is(jobErr, errUploadCancelled):
+		err = cfg.db.WithContext(ctx).SetVideoFailed(payload.VideoID, jobErr.Error())
+	default:
+		err = cfg.db.WithContext(ctx).SetVideoStatus(payload.VideoID, database.VideoStatusFailed)
+	}
+	if err != nil {
+		log.Printf("cannot mark video %s as failed: %v", payload.VideoID, err)
+		return
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(payload.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		return
+	}
+	cfg.emitVideoEvent(eventVideoFailed, video)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxVideoUpdateAttempts bounds how often updateVideo starts over after
+// another writer changed the video first.
+const maxVideoUpdateAttempts = 5
+
+var errVideoDeleted = errors.New("video was deleted")
+
+// updateVideo applies change to the current row of a video and stores it.
+// When another writer changed the video in between, it reads the video
+// again and reapplies change, so concurrent updates of different fields,
+// like an uploaded thumbnail and a processed video, don't undo each other.
+func (cfg *apiConfig) updateVideo(ctx context.Context, id uuid.UUID, change func(*database.Video)) (database.Video, error) {
+	db := cfg.db.WithContext(ctx)
+	for attempt := 1; ; attempt++ {
+		video, err := db.GetVideo(id)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if video.ID == uuid.Nil {
+			return database.Video{}, errVideoDeleted
+		}
+		change(&video)
+		err = db.UpdateVideo(video)
+		if err == nil {
+			video.Version++
+			video.UpdatedAt = time.Now()
+			return video, nil
+		}
+		if !errors.Is(err, database.ErrVersionMismatch) || attempt == maxVideoUpdateAttempts {
+			return database.Video{}, err
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// With VIRUS_SCANNER set, the processing job scans every upload once it is
+// downloaded from staging, before anything else reads it. Infected uploads
+// are moved to the quarantine prefix, their video is rejected and admins
+// get an upload.quarantined event.
+
+const clamdChunkSize = 64 << 10
+
+var errUploadInfected = errors.New("upload is infected")
+
+type scanResult struct {
+	Infected bool
+	// Signature names the infection found.
+	Signature string
+}
+
+// virusScanner scans a file for malware.
+type virusScanner interface {
+	Scan(ctx context.Context, filePath string) (scanResult, error)
+}
+
+func newVirusScanner(conf config.Config) virusScanner {
+	switch conf.VirusScanner {
+	case "clamd":
+		network, address, _ := config.ParseClamdAddress(conf.ClamdAddress)
+		return clamdScanner{network: network, address: address, timeout: conf.ClamdTimeout}
+	}
+	return nil
+}
+
+// clamdScanner streams files to a ClamAV daemon with the INSTREAM command,
+// so it needn't be able to read the server's files. Files larger than its
+// StreamMaxLength fail to scan.
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func (s clamdScanner) Scan(ctx context.Context, filePath string) (scanResult, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return scanResult{}, fmt.Errorf("cannot connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// the z prefix makes clamd delimit commands and replies with NUL
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return scanResult{}, err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := f.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return scanResult{}, fmt.Errorf("cannot stream to clamd: %w", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return scanResult{}, err
+		}
+	}
+	// a zero length chunk ends the stream
+	if _, err := conn.Write(make([]byte, 4)); err != nil {
+		return scanResult{}, fmt.Errorf("cannot stream to clamd: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return scanResult{}, fmt.Errorf("cannot read clamd reply: %w", err)
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply reads replies like "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (scanResult, error) {
+	result, ok := strings.CutPrefix(reply, "stream: ")
+	if !ok {
+		return scanResult{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	if result == "OK" {
+		return scanResult{}, nil
+	}
+	if signature, ok := strings.CutSuffix(result, " FOUND"); ok {
+		return scanResult{Infected: true, Signature: signature}, nil
+	}
+	return scanResult{}, fmt.Errorf("clamd failed to scan: %s", result)
+}
+
+func quarantineKey(videoID uuid.UUID, stagingKey string) string {
+	return fmt.Sprintf("quarantine/%s/%s", videoID, path.Base(stagingKey))
+}
+
+// scanUpload scans the upload of a video downloaded from stagingKey to
+// filePath. Infected uploads are quarantined and an error wrapping
+// errUploadInfected is returned.
+func (cfg *apiConfig) scanUpload(ctx context.Context, video database.Video, stagingKey, filePath string) error {
+	ctx, span := tracer.Start(ctx, "upload.scan")
+	result, err := cfg.virusScanner.Scan(ctx, filePath)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	key := quarantineKey(video.ID, stagingKey)
+	info, err := cfg.storage.Put(ctx, key, f, "application/octet-stream")
+	if err != nil {
+		return fmt.Errorf("cannot quarantine upload: %w", err)
+	}
+	quarantined, err := cfg.db.WithContext(ctx).CreateQuarantinedUpload(database.CreateQuarantinedUploadParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		ObjectKey: key,
+		Size:      info.Size,
+		Signature: result.Signature,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.deleteStagingObject(ctx, stagingKey)
+	cfg.alertAdmins(eventUploadQuarantined, webhookData{Quarantine: &quarantined})
+	return fmt.Errorf("%w with %s", errUploadInfected, result.Signature)
+}
+
+// alertAdmins logs event and sends it to the webhooks of every admin
+// subscribed to it.
+func (cfg *apiConfig) alertAdmins(event string, data webhookData) {
+	slog.Error("admin alert", "event", event, "data", data)
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		log.Printf("cannot look up admins for %s: %v", event, err)
+		return
+	}
+	for _, user := range users {
+		if user.Role == database.RoleAdmin {
+			cfg.emitUserEvent(user.ID, event, data)
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerAdminQuarantineList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), err)
+			return
+		}
+		limit = n
+	}
+	uploads, err := cfg.db.WithContext(r.Context()).GetQuarantinedUploads(limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quarantined uploads", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, uploads)
+}
+
+// handlerAdminQuarantineDelete deletes a quarantined upload for good.
+func (cfg *apiConfig) handlerAdminQuarantineDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	id, err := pathUUID(r, "uploadID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return
+	}
+	upload, err := cfg.db.WithContext(r.Context()).GetQuarantinedUpload(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quarantined upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Quarantined upload not found", nil)
+		return
+	}
+	if err := cfg.storage.Delete(r.Context(), upload.ObjectKey); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete quarantined upload", err)
+		return
+	}
+	if err := cfg.db.WithContext(r.Context()).DeleteQuarantinedUpload(id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete quarantined upload", err)
+		return
+	}
+	requestLogger(r.Context()).Info("admin deleted quarantined upload", "upload_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
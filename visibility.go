@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	errVideoPrivate   = errors.New("video is private")
+	errVideoTakenDown = errors.New("video was taken down")
+)
+
+var videoVisibilities = map[string]database.VideoVisibility{
+	string(database.VideoVisibilityPublic):   database.VideoVisibilityPublic,
+	string(database.VideoVisibilityUnlisted): database.VideoVisibilityUnlisted,
+	string(database.VideoVisibilityPrivate):  database.VideoVisibilityPrivate,
+}
+
+// parseVisibility checks a visibility sent by a client.
+func parseVisibility(v string) (database.VideoVisibility, error) {
+	visibility, ok := videoVisibilities[v]
+	if !ok {
+		return "", errors.New("visibility must be public, unlisted or private")
+	}
+	return visibility, nil
+}
+
+// canViewVideo reports whether viewerID, uuid.Nil for anonymous requests,
+// may get the URLs of a video.
+func canViewVideo(video database.Video, viewerID uuid.UUID) bool {
+	if viewerID != uuid.Nil && viewerID == video.UserID {
+		return true
+	}
+	return video.Visibility != database.VideoVisibilityPrivate && isLive(video, time.Now())
+}
+
+// isLive reports whether a video is within its schedule at now: published
+// and not expired. The scheduler catches up on the visibility, this holds
+// in between.
+func isLive(video database.Video, now time.Time) bool {
+	if video.PublishAt != nil && now.Before(*video.PublishAt) {
+		return false
+	}
+	return video.ExpiresAt == nil || now.Before(*video.ExpiresAt)
+}
+
+// optionalViewerID returns the user a request is made by, or uuid.Nil when
+// it carries no token or API key. One that doesn't validate, or an API key
+// that can't read videos, is still an error.
+func (cfg *apiConfig) optionalViewerID(r *http.Request) (uuid.UUID, error) {
+	p, err := auth.Authenticate(r)
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !p.Allows(auth.ScopeRead) {
+		return uuid.Nil, fmt.Errorf("API key doesn't have the %s scope", auth.ScopeRead)
+	}
+	return p.UserID, nil
+}
+
+// viewableVideoFromPath loads the video in the path for a request that
+// doesn't need to be authenticated, and checks the requester may see it.
+// Private videos of other users are reported as missing. It writes the
+// error response itself.
+func (cfg *apiConfig) viewableVideoFromPath(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	videoID, err := pathUUID(r, "videoID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	viewerID, err := cfg.optionalViewerID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if !canViewVideo(video, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", errVideoPrivate)
+		return database.Video{}, uuid.Nil, false
+	}
+	// only the owner learns their video was taken down
+	if video.ModerationStatus == database.ModerationStatusTakenDown && viewerID != video.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", errVideoTakenDown)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, viewerID, true
+}
+
+// playableVideoFromPath is viewableVideoFromPath for the endpoints serving
+// the media of a video, which taken down videos don't get, not even for
+// their owner.
+func (cfg *apiConfig) playableVideoFromPath(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	video, viewerID, ok := cfg.viewableVideoFromPath(w, r)
+	if !ok {
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ModerationStatus == database.ModerationStatusTakenDown {
+		respondWithError(w, http.StatusUnavailableForLegalReasons, "Video was taken down by a moderator", errVideoTakenDown)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, viewerID, true
+}
+
+// videoObjectURL returns the URL clients fetch an object of a video from.
+// Objects of public videos get long-lived URLs, or plain CloudFront URLs
+// with PUBLIC_CDN, others are presigned for expires. Long-lived URLs stay
+// valid after a video is made private.
+func (cfg *apiConfig) videoObjectURL(ctx context.Context, video database.Video, key string, expires time.Duration) (string, error) {
+	if video.Visibility != database.VideoVisibilityPublic {
+		return cfg.storage.Presign(ctx, key, expires)
+	}
+	if cfg.publicCDN {
+		u := url.URL{Scheme: "https", Host: cfg.s3CfDistribution, Path: "/" + key}
+		return u.String(), nil
+	}
+	return cfg.storage.Presign(ctx, key, cfg.publicURLExpiry)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// watermarkFormField is the multipart field a watermark is uploaded in.
+	watermarkFormField  = "watermark"
+	maxWatermarkUpload  = 5 << 20
+	watermarkMediaType  = "image/png"
+	watermarkWidthRatio = 0.2
+)
+
+// maxWatermarkSize is what uploaded watermarks are scaled down to fit, they
+// are scaled again to the width of each video.
+var maxWatermarkSize = imageSize{Width: 1024, Height: 1024}
+
+var errInvalidWatermarkPosition = errors.New("position must be top-left, top-right, bottom-left, bottom-right or center")
+
+// watermark is an image burned into processed videos, the operator's from
+// WATERMARK_PATH or one a user registered.
+type watermark struct {
+	// ID identifies the image and its position, videos record the one they
+	// got. It is empty when there is no watermark.
+	ID       string
+	Position database.WatermarkPosition
+	// Path is the operator's image on disk, Key the object of a user's.
+	Path string
+	Key  string
+}
+
+func parseWatermarkPosition(s string) (database.WatermarkPosition, error) {
+	switch p := database.WatermarkPosition(s); p {
+	case database.WatermarkTopLeft, database.WatermarkTopRight, database.WatermarkBottomLeft, database.WatermarkBottomRight, database.WatermarkCenter:
+		return p, nil
+	}
+	return "", errInvalidWatermarkPosition
+}
+
+// loadWatermark checks the operator's watermark is an image ffmpeg can
+// overlay. Its ID changes with the content of the file.
+func loadWatermark(path, position string) (*watermark, error) {
+	pos, err := parseWatermarkPosition(position)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("cannot decode watermark: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return &watermark{
+		ID:       "config:" + hex.EncodeToString(sum[:8]) + ":" + string(pos),
+		Position: pos,
+		Path:     path,
+	}, nil
+}
+
+// watermarkFor returns the watermark for the videos of a user: their own or
+// else the operator's.
+func (cfg *apiConfig) watermarkFor(ctx context.Context, userID uuid.UUID) (watermark, error) {
+	wm, err := cfg.db.WithContext(ctx).GetUserWatermark(userID)
+	if err != nil {
+		return watermark{}, err
+	}
+	if wm.ObjectKey != "" {
+		return watermark{
+			ID:       wm.ObjectKey + ":" + string(wm.Position),
+			Position: wm.Position,
+			Key:      wm.ObjectKey,
+		}, nil
+	}
+	if cfg.watermark != nil {
+		return *cfg.watermark, nil
+	}
+	return watermark{}, nil
+}
+
+// overlayPosition returns the overlay filter coordinates of a position,
+// keeping a margin of a fortieth of the video's width to its edges.
+func overlayPosition(position database.WatermarkPosition) (string, string) {
+	const margin = "main_w/40"
+	switch position {
+	case database.WatermarkTopLeft:
+		return margin, margin
+	case database.WatermarkTopRight:
+		return "main_w-overlay_w-" + margin, margin
+	case database.WatermarkBottomLeft:
+		return margin, "main_h-overlay_h-" + margin
+	case database.WatermarkCenter:
+		return "(main_w-overlay_w)/2", "(main_h-overlay_h)/2"
+	default:
+		return "main_w-overlay_w-" + margin, "main_h-overlay_h-" + margin
+	}
+}
+
+// applyWatermark re-encodes the mp4 at filePath with mark overlaid, scaled to
+// a fifth of the video's width, and returns the path of the result. Audio is
+// copied.
+func (cfg *apiConfig) applyWatermark(ctx context.Context, filePath string, mark watermark) (string, error) {
+	imagePath := mark.Path
+	if mark.Key != "" {
+		local, err := cfg.downloadObjectToTemp(ctx, mark.Key)
+		if err != nil {
+			return "", fmt.Errorf("cannot download watermark: %w", err)
+		}
+		defer os.Remove(local)
+		imagePath = local
+	}
+	info, err := probeVideoInfo(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	// even widths keep yuv420p encoders happy
+	width := max(2, int(float64(info.Width)*watermarkWidthRatio)/2*2)
+
+	outPath := filePath + ".watermarked.mp4"
+	err = encodeWithFallback(ctx, func(enc videoEncoder) error {
+		args := append(enc.overlayArgs(filePath, imagePath, width, mark.Position),
+			"-c:a", "copy",
+			"-movflags", "faststart",
+			"-f", "mp4",
+			outPath,
+		)
+		cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+		trackFFmpegProgress(ctx, cmd, filePath)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := runMediaCommand(ctx, cmd); err != nil {
+			os.Remove(outPath)
+			return fmt.Errorf("ffmpeg watermark with %s encoder failed: %w\nstderr: %s", enc.Name, err, stderr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// normalizeWatermark decodes an uploaded image, scales it down to fit
+// maxWatermarkSize and re-encodes it as a png, keeping transparency.
+func normalizeWatermark(ctx context.Context, src io.Reader, mediaType string) ([]byte, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	var img image.Image
+	if mediaType == "image/avif" {
+		img, err = decodeAVIF(ctx, bytes.NewReader(data))
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode watermark: %w", err)
+	}
+	img = applyOrientation(img, jpegOrientation(data))
+
+	size := imageSize{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	target := fitWithin(size, maxWatermarkSize)
+	canvas := image.NewNRGBA(image.Rect(0, 0, target.Width, target.Height))
+	draw.CatmullRom.Scale(canvas, canvas.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cfg *apiConfig) respondWithWatermark(w http.ResponseWriter, r *http.Request, status int, wm database.UserWatermark) {
+	url, err := cfg.storage.Presign(r.Context(), wm.ObjectKey, cfg.videoURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign watermark", err)
+		return
+	}
+	wm.URL = &url
+	respondWithJSON(w, status, wm)
+}
+
+// handlerWatermarkGet returns the watermark of the requesting user.
+func (cfg *apiConfig) handlerWatermarkGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeRead)
+	if !ok {
+		return
+	}
+	wm, err := cfg.db.WithContext(r.Context()).GetUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	if wm.ObjectKey == "" {
+		respondWithError(w, http.StatusNotFound, "No watermark registered", nil)
+		return
+	}
+	cfg.respondWithWatermark(w, r, http.StatusOK, wm)
+}
+
+// handlerWatermarkPut registers the watermark burned into the requesting
+// user's videos from then on, in place of the operator's. Videos already
+// processed keep the one they got.
+func (cfg *apiConfig) handlerWatermarkPut(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUpload)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkUpload)
+	file, header, err := formFile(r, watermarkFormField, maxWatermarkUpload)
+	if isBodyTooLarge(err) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Watermark is larger than 5 MiB", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse from file", err)
+		return
+	}
+	defer file.Close()
+	position := database.WatermarkBottomRight
+	if p := r.FormValue("position"); p != "" {
+		err := checkOneOf("form.position", p, string(database.WatermarkTopLeft), string(database.WatermarkTopRight),
+			string(database.WatermarkBottomLeft), string(database.WatermarkBottomRight), string(database.WatermarkCenter))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid position", err)
+			return
+		}
+		position = database.WatermarkPosition(p)
+	}
+	mediaType, err := formFileMediaType(header, watermarkFormField, mimeCheckImage)
+	if err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media type", err)
+		return
+	}
+	if err := verifyImageContent(io.NewSectionReader(file, 0, header.Size), mediaType); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
+
+	ctx, cancel := cfg.withMediaTimeout(r.Context())
+	defer cancel()
+	data, err := normalizeWatermark(ctx, file, mediaType)
+	if err != nil {
+		respondMediaError(w, "cannot read watermark", err)
+		return
+	}
+	randKey := make([]byte, 16)
+	rand.Read(randKey)
+	key := fmt.Sprintf("watermarks/%s/%s.png", userID, base64.RawURLEncoding.EncodeToString(randKey))
+	if _, err := cfg.storage.Put(ctx, key, bytes.NewReader(data), watermarkMediaType); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store watermark", err)
+		return
+	}
+	replaced, err := cfg.db.WithContext(r.Context()).PutUserWatermark(database.UserWatermark{
+		UserID:    userID,
+		Position:  position,
+		ObjectKey: key,
+	})
+	if err != nil {
+		if err := cfg.storage.Delete(ctx, key); err != nil {
+			requestLogger(ctx).Warn("cannot delete unused watermark", "key", key, "err", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watermark", err)
+		return
+	}
+	if replaced != "" {
+		if err := cfg.storage.Delete(ctx, replaced); err != nil {
+			requestLogger(ctx).Warn("cannot delete replaced watermark", "key", replaced, "err", err)
+		}
+	}
+	wm, err := cfg.db.WithContext(r.Context()).GetUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	cfg.respondWithWatermark(w, r, http.StatusOK, wm)
+}
+
+// handlerWatermarkDelete removes the watermark of the requesting user, their
+// videos get the operator's again, if there is one.
+func (cfg *apiConfig) handlerWatermarkDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeWrite)
+	if !ok {
+		return
+	}
+	key, err := cfg.db.WithContext(r.Context()).DeleteUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watermark", err)
+		return
+	}
+	if key == "" {
+		respondWithError(w, http.StatusNotFound, "No watermark registered", nil)
+		return
+	}
+	if err := cfg.storage.Delete(r.Context(), key); err != nil {
+		requestLogger(r.Context()).Warn("cannot delete watermark", "key", key, "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
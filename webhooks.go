@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const jobKindDeliverWebhook = "deliver_webhook"
+
+// Events sent to webhooks. A video is uploaded once the server has all of
+// its bytes, ready once it can be played and failed when processing gave
+// up. It is deleted when it goes to the trash, or is deleted for good
+// without passing it, and restored when it is taken out of the trash.
+// Published and expired follow the publish_at and expires_at of a video.
+// An export is ready once the user's data export can be downloaded.
+// Uploads are quarantined when the virus scanner finds them infected, only
+// admins get those.
+const (
+	eventVideoUploaded  = "video.uploaded"
+	eventVideoReady     = "video.ready"
+	eventVideoFailed    = "video.failed"
+	eventVideoDeleted   = "video.deleted"
+	eventVideoRestored  = "video.restored"
+	eventVideoPublished = "video.published"
+	eventVideoExpired   = "video.expired"
+	eventExportReady    = "export.ready"
+
+	eventUploadQuarantined = "upload.quarantined"
+)
+
+var webhookEvents = []string{
+	eventVideoUploaded,
+	eventVideoReady,
+	eventVideoFailed,
+	eventVideoDeleted,
+	eventVideoRestored,
+	eventVideoPublished,
+	eventVideoExpired,
+	eventExportReady,
+	eventUploadQuarantined,
+}
+
+var errPrivateAddress = errors.New("address is not public")
+
+// newOutboundDialer returns the dialer for connections to addresses users
+// choose. Unless allowPrivate is set it refuses to connect to loopback,
+// private and link-local addresses, so users can't reach into the server's
+// network. The check runs on every connection, redirects included.
+func newOutboundDialer(timeout time.Duration, allowPrivate bool) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+	return dialer
+}
+
+// newWebhookClient returns the client deliveries are sent with, see
+// newOutboundDialer. Redirects are not followed.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:       http.ProxyFromEnvironment,
+			DialContext: newOutboundDialer(timeout, allowPrivate).DialContext,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// webhookVideo is the part of a video sent with events. URLs are left out,
+// receivers fetch the video through the API when they need them.
+type webhookVideo struct {
+	ID          uuid.UUID                `json:"id"`
+	UserID      uuid.UUID                `json:"user_id"`
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
+	Status      database.VideoStatus     `json:"status"`
+	Visibility  database.VideoVisibility `json:"visibility"`
+	AspectRatio *string                  `json:"aspect_ratio,omitempty"`
+	CreatedAt   time.Time                `json:"created_at"`
+	UpdatedAt   time.Time                `json:"updated_at"`
+}
+
+// webhookExport is the part of a user data export sent with events, the
+// download URL is fetched through the API.
+type webhookExport struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// webhookData is what an event is about, one of the fields is set.
+type webhookData struct {
+	Video      *webhookVideo               `json:"video,omitempty"`
+	Export     *webhookExport              `json:"export,omitempty"`
+	Quarantine *database.QuarantinedUpload `json:"quarantine,omitempty"`
+}
+
+type webhookPayload struct {
+	ID        uuid.UUID   `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      webhookData `json:"data"`
+}
+
+type deliverWebhookPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// emitVideoEvent sends event to the video's progress streams and queues a
+// delivery of it to every webhook of the video's owner subscribed to it.
+// Failures are logged, they never fail the change the event is about.
+func (cfg *apiConfig) emitVideoEvent(event string, video database.Video) {
+	cfg.progress.publish(video.ID, progressEvent{Event: event, Status: video.Status})
+	cfg.emitUserEvent(video.UserID, event, webhookData{Video: &webhookVideo{
+		ID:          video.ID,
+		UserID:      video.UserID,
+		Title:       video.Title,
+		Description: video.Description,
+		Status:      video.Status,
+		Visibility:  video.Visibility,
+		AspectRatio: video.AspectRatio,
+		CreatedAt:   video.CreatedAt,
+		UpdatedAt:   video.UpdatedAt,
+	}})
+}
+
+// emitUserEvent queues a delivery of event to every webhook of a user
+// subscribed to it. Failures are logged.
+func (cfg *apiConfig) emitUserEvent(userID uuid.UUID, event string, data webhookData) {
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		log.Printf("cannot look up webhooks for %s of user %s: %v", event, userID, err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+		payload := webhookPayload{
+			ID:        uuid.New(),
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+			Data:      data,
+		}
+		dat, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("cannot encode %s event: %v", event, err)
+			return
+		}
+		if _, err := cfg.db.CreateWebhookDelivery(payload.ID, webhook.ID, event, string(dat)); err != nil {
+			log.Printf("cannot record %s delivery to webhook %s: %v", event, webhook.ID, err)
+			continue
+		}
+		if _, err := cfg.jobs.Enqueue(jobKindDeliverWebhook, deliverWebhookPayload{DeliveryID: payload.ID}); err != nil {
+			log.Printf("cannot queue %s delivery to webhook %s: %v", event, webhook.ID, err)
+		}
+	}
+}
+
+// signWebhook returns the X-Tubely-Signature header of a delivery: the
+// time it was signed and the hex HMAC-SHA256 of "<time>.<body>" under the
+// webhook's secret. Receivers recompute it and reject old timestamps to
+// stop replays.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhookJob POSTs a delivery to its webhook. Anything but a 2xx
+// response is an error and retried by the job pool.
+func (cfg *apiConfig) deliverWebhookJob(ctx context.Context, job database.Job) error {
+	var payload deliverWebhookPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	delivery, err := cfg.db.WithContext(ctx).GetWebhookDelivery(payload.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery.ID == uuid.Nil {
+		// the webhook was deleted in the meantime
+		return nil
+	}
+	webhook, err := cfg.db.WithContext(ctx).GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+	if webhook.ID == uuid.Nil {
+		return nil
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1")
+	req.Header.Set("X-Tubely-Event", delivery.Event)
+	req.Header.Set("X-Tubely-Delivery", delivery.ID.String())
+	req.Header.Set("X-Tubely-Signature", signWebhook(webhook.Secret, time.Now(), body))
+
+	var status *int
+	resp, err := cfg.webhookClient.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		status = &resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("webhook responded with %s", resp.Status)
+		}
+	}
+	if ctx.Err() != nil {
+		// shutting down, the attempt doesn't count
+		return ctx.Err()
+	}
+	if recErr := cfg.db.WithContext(ctx).RecordDeliveryAttempt(delivery.ID, status, err); recErr != nil {
+		log.Printf("cannot record attempt of delivery %s: %v", delivery.ID, recErr)
+	}
+	return err
+}
+
+func (cfg *apiConfig) failWebhookJob(ctx context.Context, job database.Job, jobErr error) {
+	var payload deliverWebhookPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		log.Printf("cannot decode payload of job %s: %v", job.ID, err)
+		return
+	}
+	if err := cfg.db.WithContext(ctx).FailWebhookDelivery(payload.DeliveryID); err != nil {
+		log.Printf("cannot mark delivery %s as failed: %v", payload.DeliveryID, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/apierror"
+)
+
+// Uploads and jobs keep their temp files, ffmpeg outputs included, in a
+// workspace: a directory of their own under the scratch root that is
+// removed when they are done. Workspaces a crash left behind are swept on
+// the next start.
+
+const workspacesDir = "tubely-workspaces"
+
+// scratchSpaceFactor is how many copies of an upload processing may keep
+// on disk at once: the upload, the faststart output and a transcode.
+const scratchSpaceFactor = 3
+
+// scratchRoot is set up by main from SCRATCH_ROOT.
+var scratchRoot = os.TempDir()
+
+var errLowScratchSpace = errors.New("not enough free space in the scratch root")
+
+type workspaceKey struct{}
+
+// newWorkspace creates a workspace named after the work it is for and
+// returns ctx carrying it. Temp files created with ctx go into it; the
+// returned function removes it with everything inside.
+func newWorkspace(ctx context.Context, name string) (context.Context, func(), error) {
+	root := filepath.Join(scratchRoot, workspacesDir)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return ctx, func() {}, err
+	}
+	dir, err := os.MkdirTemp(root, name+"-*")
+	if err != nil {
+		return ctx, func() {}, err
+	}
+	remove := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("cannot remove workspace", "dir", dir, "err", err)
+		}
+	}
+	return context.WithValue(ctx, workspaceKey{}, dir), remove, nil
+}
+
+// workspaceDir returns the workspace of ctx. Work without one uses the
+// directory holding the workspaces, so its files are still swept.
+func workspaceDir(ctx context.Context) (string, error) {
+	if dir, ok := ctx.Value(workspaceKey{}).(string); ok {
+		return dir, nil
+	}
+	root := filepath.Join(scratchRoot, workspacesDir)
+	return root, os.MkdirAll(root, 0755)
+}
+
+// createTemp is os.CreateTemp in the workspace of ctx.
+func createTemp(ctx context.Context, pattern string) (*os.File, error) {
+	dir, err := workspaceDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, pattern)
+}
+
+// mkdirTemp is os.MkdirTemp in the workspace of ctx.
+func mkdirTemp(ctx context.Context, pattern string) (string, error) {
+	dir, err := workspaceDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(dir, pattern)
+}
+
+// withWorkspace gives each request its own workspace, removed once the
+// response is written.
+func withWorkspace(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, remove, err := newWorkspace(r.Context(), "request")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create workspace", err)
+			return
+		}
+		defer remove()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// removeWorkspaces deletes every workspace. It is only safe while no
+// upload or job is running, at startup and after draining.
+func removeWorkspaces() {
+	root := filepath.Join(scratchRoot, workspacesDir)
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("cannot list workspaces", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			slog.Warn("cannot remove workspace", "name", entry.Name(), "err", err)
+		}
+	}
+	if len(entries) > 0 {
+		slog.Info("removed stale workspaces", "count", len(entries))
+	}
+}
+
+// checkScratchSpace writes a 507 response itself and returns false when
+// the scratch root can't hold an upload of size bytes through processing
+// and still keep cfg.scratchMinFree free. Sizes that aren't known are
+// passed as 0.
+func (cfg *apiConfig) checkScratchSpace(w http.ResponseWriter, size int64) bool {
+	free, err := freeDiskSpace(scratchRoot)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check free disk space", err)
+		return false
+	}
+	needed := max(size, 0)*scratchSpaceFactor + cfg.scratchMinFree
+	if free < needed {
+		respondWithAPIError(w, &apierror.Error{
+			Status:  http.StatusInsufficientStorage,
+			Code:    apierror.CodeInsufficientStorage,
+			Message: "Server is low on disk space, try again later",
+			Err:     fmt.Errorf("%w: %d bytes free, %d needed", errLowScratchSpace, free, needed),
+		})
+		return false
+	}
+	return true
+}